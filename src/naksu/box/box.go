@@ -7,30 +7,33 @@ import (
 	"fmt"
 	"math"
 	"os"
-	"regexp"
-	"strconv"
+	"sync"
 	"time"
 
-	semver "github.com/blang/semver/v4"
-
 	"naksu/box/vboxmanage"
 	"naksu/config"
 	"naksu/constants"
 	"naksu/host"
 	"naksu/log"
 	"naksu/mebroutines"
+	"naksu/reporter"
 	"naksu/xlate"
 )
 
 const (
-	boxName                 = "NaksuAbittiKTP"
-	boxOSType               = "Debian"
-	boxFinalImageSize       = 55 * 1024 // VDI disk size in megs
-	boxVRamSize             = 24        // Video RAM size in megs
+	boxFinalImageSize       = 55 * 1024   // VDI disk size in megs
+	boxMaximumImageSize     = 2048 * 1024 // Largest disk size allowed in naksu.ini
+	boxVRamSize             = 24          // Video RAM size in megs
+	boxMinimumVRamSize      = 16
+	boxMaximumVRamSize      = 128
 	boxSnapshotName         = "Installed"
 	boxMinimumNumberOfCores = 2
 	boxMemorySizePercentage = 0.74        // 0.74 = box RAM size will be 74% of the host RAM size
 	boxLowMemoryLimit       = 8192 - 1024 // 8G minus 1G for display adapter
+	boxHostReservedMemory   = 2048        // Memory left to the host when memory is set in naksu.ini
+
+	diskCloneProgressInterval = 2 * time.Second
+	diskCloneProgressFinished = 100
 )
 
 type boxStatus struct {
@@ -42,7 +45,20 @@ type boxStatus struct {
 // Setting initial installed value to true allows dependent getSomevalue() functions
 // to operate even before the real install status is detected
 var initialBoxStatus = boxStatus{true, false, ""}
+
+// lastBoxStatus is updated by the status polling goroutines and read by the
+// commands, so it is accessed with lastBoxStatusMutex held
 var lastBoxStatus = initialBoxStatus
+var lastBoxStatusMutex sync.Mutex
+
+// isLastInstalled returns true unless the VM was not installed when it was
+// last checked
+func isLastInstalled() bool {
+	lastBoxStatusMutex.Lock()
+	defer lastBoxStatusMutex.Unlock()
+
+	return lastBoxStatus.installed
+}
 
 func calculateBoxCPUs() (int, error) {
 	detectedCores, err := host.GetCPUCoreCount()
@@ -53,10 +69,40 @@ func calculateBoxCPUs() (int, error) {
 	calculatedCores := detectedCores - 1
 
 	if calculatedCores <= boxMinimumNumberOfCores {
-		return boxMinimumNumberOfCores, nil
+		calculatedCores = boxMinimumNumberOfCores
+	}
+
+	cpuSetting := config.GetVMCPUs()
+	if cpuSetting.Auto {
+		return calculatedCores, nil
+	}
+
+	maximumCores := max(detectedCores, boxMinimumNumberOfCores)
+	boxCores := limitVMResource("cpus", cpuSetting.Resolve(uint64(detectedCores), uint64(calculatedCores)), boxMinimumNumberOfCores, uint64(maximumCores))
+
+	return int(boxCores), nil
+}
+
+// limitVMResource keeps a resource set in the [vm] section of naksu.ini
+// between the limits. The maximum wins if the limits conflict.
+func limitVMResource(name string, value uint64, minimum uint64, maximum uint64) uint64 {
+	if value < minimum {
+		log.Warning("VM %s %d set in naksu.ini is less than the minimum %d, using %d", name, value, minimum, minimum)
+		value = minimum
+	}
+
+	if value > maximum {
+		log.Warning("VM %s %d set in naksu.ini is more than the maximum %d, using %d", name, value, maximum, maximum)
+		value = maximum
 	}
 
-	return calculatedCores, nil
+	return value
+}
+
+// GetLowMemoryLimit returns the minimum host memory (in megabytes) required
+// to create a new box
+func GetLowMemoryLimit() uint64 {
+	return boxLowMemoryLimit
 }
 
 func calculateBoxMemory() (uint64, error) {
@@ -69,6 +115,16 @@ func calculateBoxMemory() (uint64, error) {
 	freeVMMemory := uint64(math.Round(float64(hostMemory) * boxMemorySizePercentage))
 	lowVMMemoryLimit := uint64(math.Round(float64(boxLowMemoryLimit) * boxMemorySizePercentage))
 
+	memorySetting := config.GetVMMemory()
+	if !memorySetting.Auto {
+		maximumVMMemory := uint64(0)
+		if hostMemory > boxHostReservedMemory {
+			maximumVMMemory = hostMemory - boxHostReservedMemory
+		}
+
+		freeVMMemory = limitVMResource("memory", memorySetting.Resolve(hostMemory, freeVMMemory), lowVMMemoryLimit, maximumVMMemory)
+	}
+
 	if freeVMMemory < lowVMMemoryLimit {
 		return 0, fmt.Errorf("allocated vm memory %d is less than required minimum memory limit %d", freeVMMemory, lowVMMemoryLimit)
 	}
@@ -76,189 +132,189 @@ func calculateBoxMemory() (uint64, error) {
 	return freeVMMemory, nil
 }
 
-func getCreateNewBoxBasicCommands(boxName string, boxType string, boxVersion string, calculatedBoxCPUs int, calculatedBoxMemory uint64) []vboxmanage.VBoxCommand {
-	createCommands := []vboxmanage.VBoxCommand{
-		{"convertfromraw", mebroutines.GetImagePath(), mebroutines.GetVDIImagePath(), "--format", "VDI"},
-		{"modifyhd", mebroutines.GetVDIImagePath(), "--resize", fmt.Sprintf("%d", boxFinalImageSize)},
-		{"createvm", "--name", boxName, "--register"},
-		{
-			"modifyvm", boxName,
-			"--pae", "on",
-			"--cpus", fmt.Sprintf("%d", calculatedBoxCPUs),
-			"--memory", fmt.Sprintf("%d", calculatedBoxMemory),
-			"--vram", fmt.Sprintf("%d", boxVRamSize),
-			"--acpi", "on",
-			"--ioapic", "on",
-			"--ostype", boxOSType,
-			"--firmware", "efi",
-			"--audio", "none",
-		},
-		{
-			"guestproperty", "set", boxName,
-			"boxType", boxType,
-		},
-		{
-			"guestproperty", "set", boxName,
-			"boxVersion", boxVersion,
-		},
-		{
-			"sharedfolder", "add", boxName,
-			"--name", "media_usb1",
-			"--hostpath", mebroutines.GetMebshareDirectory(),
-		},
-		{
-			"storagectl", boxName,
-			"--add", "sata",
-			"--name", "SATA Controller",
-		},
-		{
-			"storageattach", boxName,
-			"--storagectl", "SATA Controller",
-			"--port", "0",
-			"--device", "0",
-			"--type", "hdd",
-			"--medium", mebroutines.GetVDIImagePath(),
-		},
-		{
-			"setextradata", boxName,
-			"GUI/RestrictedCloseActions",
-			"SaveState,PowerOffRestoringSnapshot",
-		},
-	}
-
-	return createCommands
+func calculateBoxDiskSize() uint64 {
+	return limitVMResource("disk", config.GetVMDiskSize().Resolve(0, boxFinalImageSize), boxFinalImageSize, boxMaximumImageSize)
 }
 
-func getCreateNewBoxClipboadCommand(vBoxVersion semver.Version) (vboxmanage.VBoxCommand, error) {
-	v6_1String := "6.1.0"
-	v6_1, err := semver.Make(v6_1String)
-	if err != nil {
-		return nil, fmt.Errorf("hard-coded version string %s could not be converted to sematic version object", v6_1String)
-	}
-
-	// Defaults to 6.1 or newer
-	clipboardCommand := vboxmanage.VBoxCommand{"modifyvm", boxName, "--clipboard-mode", "bidirectional"}
-
-	if vBoxVersion.LT(v6_1) {
-		clipboardCommand = vboxmanage.VBoxCommand{"modifyvm", boxName, "--clipboard", "bidirectional"}
-	}
-
-	return clipboardCommand, nil
+func calculateBoxVRamSize() uint64 {
+	return limitVMResource("vram", config.GetVMVRAMSize().Resolve(0, boxVRamSize), boxMinimumVRamSize, boxMaximumVRamSize)
 }
 
 // ResetCache resets all local box status caches
 func ResetCache() {
 	vboxmanage.ResetVBoxResponseCache()
+	resetServerVMNames()
+
+	lastBoxStatusMutex.Lock()
 	lastBoxStatus = initialBoxStatus
-}
+	lastBoxStatusMutex.Unlock()
 
-// CreateNewBox creates new VM using the given imagePath
-func CreateNewBox(boxType string, boxVersion string) error {
-	if mebroutines.ExistsFile(mebroutines.GetVDIImagePath()) {
-		err := os.Remove(mebroutines.GetVDIImagePath())
-		if err != nil {
-			return fmt.Errorf("could not remove old vdi file %s: %w", mebroutines.GetVDIImagePath(), err)
-		}
-		log.Debug("Removed existing VDI file %s", mebroutines.GetVDIImagePath())
-	}
+	stopExpected.Store(false)
+}
 
+// getVMSpec returns the VM of the server of the box type with the CPUs and
+// memory calculated for this computer
+func getVMSpec(boxType string, boxVersion string) (VMSpec, error) {
 	calculatedBoxCPUs, err := calculateBoxCPUs()
 	if err != nil {
-		return err
+		return VMSpec{}, err // nolint: exhaustruct
 	}
 
 	calculatedBoxMemory, errMemory := calculateBoxMemory()
 	if errMemory != nil {
-		return errMemory
+		return VMSpec{}, errMemory // nolint: exhaustruct
 	}
 
-	log.Debug("Calculated new VM specs - CPUs: %d, Memory: %d", calculatedBoxCPUs, calculatedBoxMemory)
+	calculatedBoxDiskSize := calculateBoxDiskSize()
+	calculatedBoxVRamSize := calculateBoxVRamSize()
 
-	createCommands := getCreateNewBoxBasicCommands(boxName, boxType, boxVersion, calculatedBoxCPUs, calculatedBoxMemory)
+	log.Debug("Calculated new VM specs - CPUs: %d, Memory: %d, Disk: %d, VRAM: %d", calculatedBoxCPUs, calculatedBoxMemory, calculatedBoxDiskSize, calculatedBoxVRamSize)
 
-	vBoxVersion, err := vboxmanage.GetVBoxManageVersion()
-	if err != nil {
-		log.Error("Could not get VBoxManage version: %v", err)
-
-		return err
+	vmName := getServerVMName(GetServerForBoxType(boxType))
+	if vmName == "" {
+		return VMSpec{}, fmt.Errorf("%w: %s", ErrServerConflict, legacyServerVMName) // nolint: exhaustruct
 	}
 
-	clipboardCommand, err := getCreateNewBoxClipboadCommand(vBoxVersion)
-	if err != nil {
-		log.Error("Could not get new box clipboard creation command: %v", err)
-
-		return err
-	}
-	createCommands = append(createCommands, clipboardCommand)
+	return VMSpec{
+		Name:         vmName,
+		BoxType:      boxType,
+		BoxVersion:   boxVersion,
+		ImagePath:    mebroutines.GetImagePath(),
+		CPUs:         calculatedBoxCPUs,
+		MemoryMB:     calculatedBoxMemory,
+		DiskSizeMB:   calculatedBoxDiskSize,
+		VRAMMB:       calculatedBoxVRamSize,
+		SharedFolder: mebroutines.GetMebshareDirectory(),
+	}, nil
+}
 
-	createCommands = append(createCommands, vboxmanage.VBoxCommand{"snapshot", boxName, "take", boxSnapshotName})
+// CreateNewBox creates new VM for the server of the box type using the
+// downloaded image. The hypervisor removes the partially created VM if the
+// creation fails.
+func CreateNewBox(boxType string, boxVersion string) error {
+	defer ResetCache()
 
-	err = vboxmanage.RunCommands(createCommands)
+	spec, err := getVMSpec(boxType, boxVersion)
 	if err != nil {
 		return err
 	}
 
-	ResetCache()
+	return getHypervisor().CreateVM(spec)
+}
 
-	return nil
+// StartOptions are the settings applied to the VM when it is started
+type StartOptions struct {
+	// ExtNic is the network device connected to the exam network
+	ExtNic string
+	// Nic is the networking hardware of the VM
+	Nic string
+	// Type is the startvm type (see constants.AvailableStartTypes)
+	Type string
+	// ConsolePort is the localhost port of the remote console. Zero disables
+	// the console.
+	ConsolePort uint16
+}
+
+// GetConfiguredStartOptions returns the start options stored in naksu.ini
+func GetConfiguredStartOptions() StartOptions {
+	return StartOptions{
+		ExtNic:      config.GetExtNic(),
+		Nic:         config.GetNic(),
+		Type:        config.GetStartType(),
+		ConsolePort: config.GetConsolePort(),
+	}
 }
 
 // StartCurrentBox starts currently installed VM
 func StartCurrentBox() error {
-	startCommands := []vboxmanage.VBoxCommand{
-		{"modifyvm", boxName, "--nic1", "bridged"},
-		{"modifyvm", boxName, "--bridgeadapter1", config.GetExtNic()},
-		{"modifyvm", boxName, "--nictype1", config.GetNic()},
-		{"startvm", boxName, "--type", "gui"},
+	defer ResetCache()
+
+	err := getHypervisor().StartVM(getBoxName(), GetConfiguredStartOptions())
+	if err == nil {
+		markBooted()
 	}
 
-	return vboxmanage.RunCommands(startCommands)
+	return err
+}
+
+// GetConsoleURL returns the URL of the remote console of the running VM or
+// an empty string if the console is not enabled
+func GetConsoleURL() (string, error) {
+	return getHypervisor().GetConsoleURL(getBoxName())
 }
 
 // RestoreSnapshot returns installed VM to fresh state (to the snapshot taken just after the install)
 func RestoreSnapshot() error {
-	restoreCommands := []vboxmanage.VBoxCommand{
-		{"snapshot", boxName, "restore", boxSnapshotName},
-	}
-
-	return vboxmanage.RunCommands(restoreCommands)
+	return RestoreNamedSnapshot(boxSnapshotName)
 }
 
 // RemoveCurrentBox deletes currently installed VM
 func RemoveCurrentBox() error {
-	removeCommands := []vboxmanage.VBoxCommand{
-		{"unregistervm", boxName, "--delete"},
-	}
+	defer ResetCache()
 
-	return vboxmanage.RunCommands(removeCommands)
+	return getHypervisor().RemoveVM(getBoxName())
 }
 
-// WriteDiskClone creates a disk clone of the first disk of the current VM
-func WriteDiskClone(clonePath string) error {
-	diskUUID := getDiskUUID()
-	if diskUUID == "" {
-		return fmt.Errorf("could not get disk uuid")
+// WriteDiskClone creates a disk clone of the first disk of the current VM.
+// The progress is estimated by comparing the size of the clone to the size
+// of the source disk image.
+func WriteDiskClone(clonePath string, progressReporter reporter.Reporter) error {
+	if !isLastInstalled() {
+		return errors.New("there is no vm installed")
 	}
 
-	vBoxManageOutput, err := vboxmanage.RunCommand(vboxmanage.VBoxCommand{"clonemedium", diskUUID, clonePath, "--format", "VMDK"})
+	stopProgress := make(chan struct{})
+	progressStopped := make(chan struct{})
+	go reportDiskCloneProgress(GetDiskLocation(), clonePath, progressReporter, stopProgress, progressStopped)
+
+	err := getHypervisor().CloneDisk(getBoxName(), clonePath)
+
+	close(stopProgress)
+	<-progressStopped
 
 	if err != nil {
 		return err
 	}
 
-	// Check whether clone was successful or not
-	matched, errRe := regexp.MatchString("Clone medium created in format 'VMDK'", vBoxManageOutput)
-	if errRe != nil || !matched {
-		// Failure
-		log.Debug("VBoxManage output does not report successful clone in format 'VMDK'")
+	progressReporter.Progress("", diskCloneProgressFinished)
+
+	return nil
+}
+
+// reportDiskCloneProgress reports the size of the clone relative to the size
+// of the source disk until stopProgress is closed. The progress stays below
+// 100 percent since the clone may be larger than the source.
+func reportDiskCloneProgress(sourcePath string, clonePath string, progressReporter reporter.Reporter, stopProgress chan struct{}, progressStopped chan struct{}) {
+	defer close(progressStopped)
+
+	sourceInfo, err := os.Stat(sourcePath)
+	if err != nil || sourceInfo.Size() == 0 {
+		log.Debug("Could not get the size of the disk image '%s', not reporting clone progress: %v", sourcePath, err)
 
-		return errors.New("could not get correct response from vboxmanage")
+		return
 	}
 
-	// Detach media from VirtualBox disk management
-	_, errCloseMedium := vboxmanage.RunCommand(vboxmanage.VBoxCommand{"closemedium", clonePath})
+	ticker := time.NewTicker(diskCloneProgressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopProgress:
+			return
+		case <-ticker.C:
+			cloneInfo, errClone := os.Stat(clonePath)
+			if errClone != nil {
+				continue
+			}
+
+			percentage := int(cloneInfo.Size() * diskCloneProgressFinished / sourceInfo.Size())
+			if percentage >= diskCloneProgressFinished {
+				percentage = diskCloneProgressFinished - 1
+			}
 
-	return errCloseMedium
+			progressReporter.Progress("", percentage)
+		}
+	}
 }
 
 // StartEnvironmentStatusUpdate starts periodically updating given
@@ -289,33 +345,48 @@ func StartEnvironmentStatusUpdate(environmentStatus *constants.EnvironmentStatus
 
 // Installed returns true if we have box installed, otherwise false
 func Installed() (bool, error) {
-	isInstalled, err := vboxmanage.IsVMInstalled(boxName)
+	isInstalled, err := getHypervisor().IsVMInstalled(getBoxName())
 
 	if err != nil {
 		log.Error("box.Installed() could not detect whether VM is installed: %v", err)
 	} else {
+		lastBoxStatusMutex.Lock()
 		lastBoxStatus.installed = isInstalled
+		lastBoxStatusMutex.Unlock()
 	}
 
 	return isInstalled, err
 }
 
 func Running() (bool, error) {
-	if !lastBoxStatus.installed {
+	if !isLastInstalled() {
 		return false, nil
 	}
 
-	isRunning, state, err := vboxmanage.IsVMRunning(boxName)
+	state, err := getHypervisor().GetVMState(getBoxName())
+	isRunning := state == VMStateRunning
 
 	if err != nil {
 		log.Error("box.Running() could not detect whether VM is running: %v", err)
 	}
 
-	if lastBoxStatus.state != state {
-		log.Debug("VM state changed from '%s' to '%s'", lastBoxStatus.state, state)
+	// The status is compared and updated at once so that a state change is
+	// observed only once when both the GUI and the watchdog poll the state
+	lastBoxStatusMutex.Lock()
+	previousStatus := lastBoxStatus
+	lastBoxStatus.running = isRunning
+	lastBoxStatus.state = state
+	lastBoxStatusMutex.Unlock()
+
+	if previousStatus.state != state {
+		log.Debug("VM state changed from '%s' to '%s'", previousStatus.state, state)
+
+		if err == nil {
+			observeStateChange(getBoxName(), previousStatus.state, state)
+		}
 	}
 
-	if isRunning != lastBoxStatus.running {
+	if isRunning != previousStatus.running {
 		if isRunning {
 			log.Debug("VM has been started")
 		} else {
@@ -323,24 +394,30 @@ func Running() (bool, error) {
 		}
 	}
 
-	lastBoxStatus.running = isRunning
-	lastBoxStatus.state = state
-
 	return isRunning, err
 }
 
+// GetState returns the state (e.g. "running", "poweroff") of the current VM
+func GetState() (string, error) {
+	if !isLastInstalled() {
+		return "", nil
+	}
+
+	return getHypervisor().GetVMState(getBoxName())
+}
+
 // GetType returns the box type (e.g. "digabi/ktp-qa") of the current VM
 func GetType() string {
-	if !lastBoxStatus.installed {
+	if !isLastInstalled() {
 		return ""
 	}
 
-	return vboxmanage.GetVMProperty(boxName, "boxType")
+	return getHypervisor().GetGuestProperty(getBoxName(), "boxType")
 }
 
 // GetTypeLegend returns an user-readable type legend of the current VM
 func GetTypeLegend() string {
-	if !lastBoxStatus.installed {
+	if !isLastInstalled() {
 		return "-"
 	}
 
@@ -374,62 +451,33 @@ func TypeIsMatriculationExam() bool {
 
 // GetVersion returns the version string (e.g. "SERVER7108X v69") of the current VM
 func GetVersion() string {
-	if !lastBoxStatus.installed {
+	if !isLastInstalled() {
 		return ""
 	}
 
-	return vboxmanage.GetVMProperty(boxName, "boxVersion")
-}
-
-// getDiskUUID returns the VirtualBox UUID for the image of the current VM
-func getDiskUUID() string {
-	if !lastBoxStatus.installed {
-		return ""
-	}
-
-	return vboxmanage.GetVMInfoByRegexp(boxName, "\"SATA Controller-ImageUUID-0-0\"=\"(.*?)\"")
+	return getHypervisor().GetGuestProperty(getBoxName(), "boxVersion")
 }
 
 // GetDiskLocation returns the full path of the current VM disk image.
 func GetDiskLocation() string {
-	if !lastBoxStatus.installed {
+	if !isLastInstalled() {
 		return ""
 	}
 
-	return vboxmanage.GetVMInfoByRegexp(boxName, "\"SATA Controller-0-0\"=\"(.*)\"")
+	return getHypervisor().GetDiskLocation(getBoxName())
 }
 
-// GetLogDir returns the full path of VirtualBox log directory
+// GetLogDir returns the full path of the log directory of the current VM
 func GetLogDir() string {
-	if !lastBoxStatus.installed {
+	if !isLastInstalled() {
 		return ""
 	}
 
-	return vboxmanage.GetVMInfoByRegexp(boxName, "LogFldr=\"(.*)\"")
+	return getHypervisor().GetLogDir(getBoxName())
 }
 
 // MediumSizeOnDisk returns the size of the current VM disk image on disk
 // (= the expected size of a VM backup) in megabytes.
 func MediumSizeOnDisk(location string) (uint64, error) {
-	// According to documentation, showmediuminfo should also accept a disk uuid
-	// as a parameter, but that doesn't seem to be the case. To be safe, we'll
-	// use the location of the disk instead.
-
-	mediumInfo, err := vboxmanage.RunCommand([]string{"showmediuminfo", location})
-
-	if err != nil {
-		log.Error("Could not get medium info to calculate its size: %v", err)
-
-		return 0, errors.New("failed to get medium size: could not execute vboxmanage")
-	}
-
-	sizeOnDiskRE := regexp.MustCompile(`Size on disk:\s+(\d+)\s+MBytes`)
-	result := sizeOnDiskRE.FindStringSubmatch(mediumInfo)
-	if len(result) > 1 {
-		size := result[1]
-
-		return strconv.ParseUint(size, 10, 64)
-	}
-
-	return 0, errors.New("failed to get medium size: no regex matches")
+	return getHypervisor().DiskSizeOnDisk(location)
 }
@@ -0,0 +1,227 @@
+package box
+
+// The server is shut down by pressing its ACPI power button so that the exam
+// server can close its database cleanly. Powering off is the last resort.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"naksu/box/vboxmanage"
+	"naksu/constants"
+	"naksu/log"
+)
+
+// VM states reported by the hypervisors
+const (
+	VMStateRunning  = "running"
+	VMStatePaused   = "paused"
+	VMStateStopping = "stopping"
+	VMStateSaved    = "saved"
+	VMStatePowerOff = "poweroff"
+	VMStateAborted  = "aborted"
+
+	VMStateStarting  = "starting"
+	VMStateSaving    = "saving"
+	VMStateRestoring = "restoring"
+)
+
+const vmStatePollInterval = 1 * time.Second
+
+// Known VM control errors
+var (
+	ErrVMNotRunning     = errors.New("the vm is not running")
+	ErrVMNotResumable   = errors.New("the vm is neither saved nor paused")
+	ErrVMStateTimeout   = errors.New("timed out waiting for the vm state")
+	ErrVMUnexpectedStop = errors.New("the vm stopped unexpectedly")
+)
+
+// StateListener is called with the state of the VM whenever it changes while
+// waiting for a shutdown, a save-state or a power-off to finish
+type StateListener func(state string)
+
+// IsResumable returns true if the VM in the given state is started by
+// resuming it instead of running GetStartCommands
+func IsResumable(state string) bool {
+	return state == VMStateSaved || state == VMStatePaused
+}
+
+// ShutdownCurrentBox presses the ACPI power button of the VM and waits until
+// it has powered off. If the VM is still running after the timeout it is
+// powered off and poweredOff is true.
+func ShutdownCurrentBox(timeout time.Duration, listener StateListener) (poweredOff bool, err error) {
+	defer ResetCache()
+
+	err = ensureVMState(VMStateRunning)
+	if err != nil {
+		return false, err
+	}
+
+	expectStop()
+
+	err = getHypervisor().ShutdownVM(getBoxName())
+	if err != nil {
+		return false, fmt.Errorf("could not press the power button: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	_, err = waitForVMState(ctx, listener, VMStatePowerOff, VMStateAborted)
+	if !errors.Is(err, ErrVMStateTimeout) {
+		return false, err
+	}
+
+	log.Warning("The VM did not shut down in %v, powering it off", timeout)
+
+	err = PowerOffCurrentBox(listener)
+	if errors.Is(err, ErrVMNotRunning) {
+		log.Debug("The VM shut down before it was powered off")
+
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// SaveStateCurrentBox saves the state of the running or paused VM to the
+// disk and stops it. The VM continues from the saved state when it is resumed.
+func SaveStateCurrentBox(listener StateListener) error {
+	defer ResetCache()
+
+	if err := ensureVMState(VMStateRunning, VMStatePaused); err != nil {
+		return err
+	}
+
+	expectStop()
+
+	err := getHypervisor().SaveStateVM(getBoxName())
+	if err != nil {
+		return fmt.Errorf("could not save the vm state: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), constants.VMSaveStateTimeout)
+	defer cancel()
+
+	state, err := waitForVMState(ctx, listener, VMStateSaved, VMStatePowerOff, VMStateAborted)
+	if err != nil {
+		return err
+	}
+
+	if state != VMStateSaved {
+		return fmt.Errorf("%w: %s", ErrVMUnexpectedStop, state)
+	}
+
+	return nil
+}
+
+// PowerOffCurrentBox powers off the VM like pulling the plug. The exams in
+// progress may be lost, so prefer ShutdownCurrentBox. A VM stuck in a guru
+// meditation must be powered off before it can be started again.
+func PowerOffCurrentBox(listener StateListener) error {
+	defer ResetCache()
+
+	if err := ensureVMState(VMStateRunning, VMStatePaused, VMStateStopping, VMStateGuruMeditation); err != nil {
+		return err
+	}
+
+	expectStop()
+
+	err := getHypervisor().PowerOffVM(getBoxName())
+	if errors.Is(err, vboxmanage.ErrInvalidObjectState) {
+		// The VM stopped after its state was checked
+		return fmt.Errorf("%w: %w", ErrVMNotRunning, err)
+	} else if err != nil {
+		return fmt.Errorf("could not power off the vm: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), constants.VMPowerOffTimeout)
+	defer cancel()
+
+	_, err = waitForVMState(ctx, listener, VMStatePowerOff, VMStateAborted)
+
+	return err
+}
+
+// ResumeCurrentBox continues the saved or paused VM
+func ResumeCurrentBox() error {
+	defer ResetCache()
+
+	state, err := getCurrentVMState()
+	if err != nil {
+		return err
+	}
+
+	if !IsResumable(state) {
+		return fmt.Errorf("%w: %s", ErrVMNotResumable, state)
+	}
+
+	return getHypervisor().ResumeVM(getBoxName(), state)
+}
+
+// ensureVMState returns ErrVMNotRunning if the current state of the VM is
+// not one of the given states
+func ensureVMState(states ...string) error {
+	state, err := getCurrentVMState()
+	if err != nil {
+		return err
+	}
+
+	if !slices.Contains(states, state) {
+		return fmt.Errorf("%w: the vm is %s", ErrVMNotRunning, state)
+	}
+
+	return nil
+}
+
+// getCurrentVMState returns the state of the VM bypassing the state cache
+func getCurrentVMState() (string, error) {
+	boxName := getBoxName()
+	getHypervisor().ResetVMStateCache(boxName)
+
+	state, err := getHypervisor().GetVMState(boxName)
+	if err != nil {
+		return state, fmt.Errorf("could not get vm state: %w", err)
+	}
+
+	return state, nil
+}
+
+// waitForVMState polls the state of the VM until it is one of the target
+// states or the context is done. The listener is called when the state
+// changes.
+func waitForVMState(ctx context.Context, listener StateListener, targetStates ...string) (string, error) {
+	lastState := ""
+
+	ticker := time.NewTicker(vmStatePollInterval)
+	defer ticker.Stop()
+
+	for {
+		state, err := getCurrentVMState()
+		if err != nil {
+			return state, err
+		}
+
+		if state != lastState {
+			log.Debug("VM state changed from '%s' to '%s'", lastState, state)
+			if listener != nil {
+				listener(state)
+			}
+			lastState = state
+		}
+
+		if slices.Contains(targetStates, state) {
+			return state, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return state, fmt.Errorf("%w %s, the vm is %s", ErrVMStateTimeout, strings.Join(targetStates, "/"), state)
+		case <-ticker.C:
+		}
+	}
+}
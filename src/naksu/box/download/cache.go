@@ -0,0 +1,176 @@
+package download
+
+// The downloaded server image zips are kept in the image cache
+// (~/ktp/naksu_image_cache) by the box type and the version, so that a server
+// of the same version can be reinstalled without downloading the image again.
+// The least recently used zips are pruned when the cache exceeds its count or
+// size limit.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+
+	"naksu/constants"
+	"naksu/log"
+	"naksu/mebroutines"
+	"naksu/reporter"
+)
+
+const (
+	imageCacheSuffix     = ".zip"
+	imageCacheTempSuffix = ".tmp"
+)
+
+// imageCacheEntry is a zip in the image cache
+type imageCacheEntry struct {
+	path    string
+	size    uint64
+	modTime time.Time
+}
+
+// GetCachedServerImagePath returns the path of the cached image zip of the
+// box type and the version (e.g. ~/ktp/naksu_image_cache/abitti-SERVER2025K.zip)
+func GetCachedServerImagePath(boxType string, version string) string {
+	return filepath.Join(mebroutines.GetImageCacheDirectory(), boxType+"-"+sanitizeBoxVersionString(version)+imageCacheSuffix)
+}
+
+// GetServerImageFromCache writes the server image in the cached zip to
+// mebroutines.GetImagePath(). The checksum and the signature are verified
+// again, and a zip which fails is removed from the cache.
+func GetServerImageFromCache(cachePath string, progressReporter reporter.Reporter) error {
+	log.Debug("Using cached server image '%s'", cachePath)
+
+	err := GetLocalServerImage(cachePath, progressReporter)
+	if err != nil {
+		log.Warning("Removing cached server image '%s': %v", cachePath, err)
+		removeCachedImage(cachePath)
+
+		return err
+	}
+
+	// The modification time tells the least recently used zips to prune
+	now := time.Now()
+
+	err = os.Chtimes(cachePath, now, now)
+	if err != nil {
+		log.Warning("Could not update the modification time of cached image '%s': %v", cachePath, err)
+	}
+
+	return nil
+}
+
+func removeCachedImage(path string) {
+	err := os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Warning("Could not remove cached image %s: %v", path, err)
+	}
+}
+
+// cacheDownloadedZip moves the downloaded zip to the image cache
+func cacheDownloadedZip(zipPath string, cachePath string) {
+	err := os.MkdirAll(filepath.Dir(cachePath), constants.FilePermissionsOwnerRWX)
+	if err == nil {
+		err = os.Rename(zipPath, cachePath)
+	}
+
+	if err != nil {
+		log.Warning("Could not move the downloaded image to the image cache: %v", err)
+	}
+}
+
+// getImageCacheEntries returns the zips in the image cache, the most recently
+// used first
+func getImageCacheEntries(cacheDirectory string) ([]imageCacheEntry, error) {
+	dirEntries, err := os.ReadDir(cacheDirectory)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not list image cache: %w", err)
+	}
+
+	entries := []imageCacheEntry{}
+
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() || !strings.HasSuffix(dirEntry.Name(), imageCacheSuffix) {
+			continue
+		}
+
+		fileInfo, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+
+		entries = append(entries, imageCacheEntry{
+			path:    filepath.Join(cacheDirectory, dirEntry.Name()),
+			size:    uint64(fileInfo.Size()),
+			modTime: fileInfo.ModTime(),
+		})
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].modTime.After(entries[j].modTime)
+	})
+
+	return entries, nil
+}
+
+// selectPrunedImages returns the entries exceeding the count or the size
+// limit. A zero size limit means no limit.
+func selectPrunedImages(entries []imageCacheEntry, maxCount int, maxSize uint64) []imageCacheEntry {
+	var totalSize uint64
+
+	for index, entry := range entries {
+		totalSize += entry.size
+
+		if index >= maxCount || (maxSize > 0 && totalSize > maxSize) {
+			return entries[index:]
+		}
+	}
+
+	return nil
+}
+
+// PruneImageCache removes the least recently used zips from the image cache
+// so that at most maxCount zips of at most maxSizeMegabytes in total are kept.
+// The zips in keptPaths (e.g. the zip about to be installed) are neither
+// removed nor counted to the limits. Incomplete zips of interrupted downloads
+// are removed as well.
+func PruneImageCache(maxCount int, maxSizeMegabytes uint64, keptPaths ...string) {
+	cacheDirectory := mebroutines.GetImageCacheDirectory()
+
+	entries, err := getImageCacheEntries(cacheDirectory)
+	if err != nil {
+		log.Error("Could not prune image cache: %v", err)
+
+		return
+	}
+
+	entries = slices.DeleteFunc(entries, func(entry imageCacheEntry) bool {
+		return slices.Contains(keptPaths, entry.path)
+	})
+
+	for _, entry := range selectPrunedImages(entries, maxCount, maxSizeMegabytes*humanize.MiByte) {
+		log.Debug("Pruning cached image %s (%s)", entry.path, humanize.Bytes(entry.size))
+		removeCachedImage(entry.path)
+	}
+
+	tempPaths, err := filepath.Glob(filepath.Join(cacheDirectory, "*"+imageCacheSuffix+imageCacheTempSuffix))
+	if err != nil {
+		return
+	}
+
+	for _, tempPath := range tempPaths {
+		log.Debug("Removing incomplete cached image %s", tempPath)
+		removeCachedImage(tempPath)
+	}
+}
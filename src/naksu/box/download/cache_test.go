@@ -0,0 +1,80 @@
+package download
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"naksu/reporter"
+)
+
+func TestStreamServerImageWritesCache(t *testing.T) {
+	image := getTestImageContent()
+	entries := getSignedChecksumEntries(t, fmt.Sprintf("%x  ktp.img\n", sha256.Sum256(image)))
+	zipContent := createTestZip(t, append(entries, testZipEntry{serverImageZipEntry, image, zip.Deflate, false}))
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		serveTestImage(writer, request, zipContent, `"v1"`)
+	}))
+	defer server.Close()
+
+	directory := t.TempDir()
+	cachePath := filepath.Join(directory, "cache", "abitti-SERVER2025K.zip")
+
+	err := streamServerImage(server.URL, filepath.Join(directory, "ktp.img"), filepath.Join(directory, "naksu_last_image.zip"), cachePath, reporter.Func(func(message string, value int) {}))
+	if err != nil {
+		t.Fatalf("Streaming image returned %v", err)
+	}
+
+	cached, err := os.ReadFile(cachePath)
+	if err != nil || !bytes.Equal(cached, zipContent) {
+		t.Errorf("Cached zip differs from the downloaded zip (%d bytes, error %v)", len(cached), err)
+	}
+
+	if _, err = os.Stat(cachePath + imageCacheTempSuffix); !os.IsNotExist(err) {
+		t.Errorf("Incomplete cached zip was left behind: %v", err)
+	}
+}
+
+func TestSelectPrunedImages(t *testing.T) {
+	now := time.Now()
+	entries := []imageCacheEntry{
+		{"abitti-SERVER2025K.zip", 5000, now},
+		{"exam-SERVER2025K.zip", 4000, now.Add(-time.Hour)},
+		{"abitti-SERVER2025S.zip", 3000, now.Add(-2 * time.Hour)},
+	}
+
+	testCases := []struct {
+		maxCount       int
+		maxSize        uint64
+		expectedPruned int
+	}{
+		{3, 0, 0},
+		{2, 0, 1},
+		{0, 0, 3},
+		{3, 9000, 1},
+		{3, 8999, 2},
+		{1, 20000, 2},
+		{3, 4999, 3},
+	}
+
+	for _, testCase := range testCases {
+		pruned := selectPrunedImages(entries, testCase.maxCount, testCase.maxSize)
+		if len(pruned) != testCase.expectedPruned {
+			t.Errorf("Limits %d / %d pruned %d images, expected %d", testCase.maxCount, testCase.maxSize, len(pruned), testCase.expectedPruned)
+		}
+
+		for index, entry := range pruned {
+			if entry != entries[len(entries)-len(pruned)+index] {
+				t.Errorf("Limits %d / %d pruned %s instead of a least recently used image", testCase.maxCount, testCase.maxSize, entry.path)
+			}
+		}
+	}
+}
@@ -7,6 +7,7 @@ package download
 import (
 	"archive/zip"
 	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
@@ -22,6 +23,7 @@ import (
 	"naksu/constants"
 	"naksu/log"
 	"naksu/mebroutines"
+	"naksu/reporter"
 	"naksu/xlate"
 )
 
@@ -37,9 +39,30 @@ const (
 	unzipProgressPercentageStarting = 1
 	unzipProgressPercentageFinished = 100
 
-	httpStatusOK = 200
+	httpStatusOK                  = 200
+	httpStatusPartialContent      = 206
+	httpStatusRangeNotSatisfiable = 416
+
+	// The server image and its checksum in the etcher zip
+	serverImageZipEntry         = "ytl/ktp.img"
+	serverImageChecksumZipEntry = "ytl/ktp.img.sha256"
+
+	// downloadAttempts is the number of times an interrupted download is
+	// resumed before giving up
+	downloadAttempts = 3
 )
 
+// downloadRetryDelay is the time to wait before resuming an interrupted download
+var downloadRetryDelay = 5 * time.Second
+
+// downloadIdleTimeout is the time to wait for data from the server before
+// the download is interrupted
+var downloadIdleTimeout = 60 * time.Second
+
+var errDownloadInterrupted = errors.New("download was interrupted")
+
+var errDownloadIdle = errors.New("no data received from the server")
+
 var ErrDownloadedDiskImageCorrupted = errors.New("downloaded image is corrupted")
 
 // writeCounter implements io.Writer interface, see
@@ -48,10 +71,10 @@ var ErrDownloadedDiskImageCorrupted = errors.New("downloaded image is corrupted"
 //   * GetSHA256ChecksumFromFile
 
 type writeCounter struct {
-	Total              uint64
-	FileSize           uint64
-	ProgressCallbackFn func(string, int)
-	ProgressString     string
+	Total            uint64
+	FileSize         uint64
+	ProgressReporter reporter.Reporter
+	ProgressString   string
 }
 
 var progressLastMessageTime = time.Now()
@@ -62,20 +85,22 @@ func (wc *writeCounter) Write(buffer []byte) (int, error) {
 	bufferLength := len(buffer)
 	wc.Total += uint64(bufferLength)
 
-	if time.Now().After(progressLastMessageTime.Add(progressLastMessageTimeout)) {
-		wc.ProgressCallbackFn(wc.ProgressString, int((100*wc.Total)/wc.FileSize)) // nolint:gomnd
+	if wc.FileSize > 0 && time.Now().After(progressLastMessageTime.Add(progressLastMessageTimeout)) {
+		wc.ProgressReporter.Progress(wc.ProgressString, int((100*wc.Total)/wc.FileSize)) // nolint:gomnd
 		progressLastMessageTime = time.Now()
 	}
 
 	return bufferLength, nil
 }
 
-// GetServerImagePath returns path to cached server image (~/ktp/naksu_last_image.zip)
-func GetServerImagePath() string {
-	return filepath.Join(mebroutines.GetKtpDirectory(), "naksu_last_image.zip")
+func makeHTTPGet(url string) (http.Response, error) {
+	return makeHTTPGetWithHeader(url, http.Header{})
 }
 
-func makeHTTPGet(url string) (http.Response, error) {
+// makeHTTPGetWithHeader makes a GET request without a total timeout as the
+// server images are large. The request is cancelled if the server does not
+// send any data in downloadIdleTimeout.
+func makeHTTPGetWithHeader(url string, header http.Header) (http.Response, error) {
 	client := http.Client{
 		Transport:     nil,
 		CheckRedirect: nil,
@@ -83,20 +108,36 @@ func makeHTTPGet(url string) (http.Response, error) {
 		Timeout:       0,
 	}
 
-	ctx := context.Background()
+	ctx, cancel := context.WithCancelCause(context.Background())
+	idleTimer := time.AfterFunc(downloadIdleTimeout, func() {
+		cancel(errDownloadIdle)
+	})
 
 	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		log.Error("Creating HTTP GET request to '%s' resulted an error: %v", url, err)
+		idleTimer.Stop()
+		cancel(nil)
 
 		var emptyResponse http.Response
 
 		return emptyResponse, err
 	}
 
+	for name, values := range header {
+		request.Header[name] = values
+	}
+
 	// response should be called by the caller
 	response, err := client.Do(request) // nolint:bodyclose
 	if err != nil {
+		idleTimer.Stop()
+		cancel(nil)
+
+		if errors.Is(context.Cause(ctx), errDownloadIdle) {
+			err = fmt.Errorf("%w in %v: %w", errDownloadIdle, downloadIdleTimeout, err)
+		}
+
 		log.Error("Making HTTP GET request to '%s' resulted an error: %v", url, err)
 
 		var emptyResponse http.Response
@@ -104,10 +145,43 @@ func makeHTTPGet(url string) (http.Response, error) {
 		return emptyResponse, err
 	}
 
+	response.Body = &idleTimeoutBody{body: response.Body, ctx: ctx, cancel: cancel, idleTimer: idleTimer}
+
 	return *response, nil
 }
 
-func downloadServerImage(url string, progressCallbackFn func(string, int)) error {
+// idleTimeoutBody restarts the idle timer of the request whenever data is
+// received
+type idleTimeoutBody struct {
+	body      io.ReadCloser
+	ctx       context.Context
+	cancel    context.CancelCauseFunc
+	idleTimer *time.Timer
+}
+
+func (idleTimeout *idleTimeoutBody) Read(buffer []byte) (int, error) {
+	n, err := idleTimeout.body.Read(buffer)
+	if n > 0 {
+		idleTimeout.idleTimer.Reset(downloadIdleTimeout)
+	}
+
+	if err != nil && errors.Is(context.Cause(idleTimeout.ctx), errDownloadIdle) {
+		err = fmt.Errorf("%w in %v: %w", errDownloadIdle, downloadIdleTimeout, err)
+	}
+
+	return n, err
+}
+
+func (idleTimeout *idleTimeoutBody) Close() error {
+	idleTimeout.idleTimer.Stop()
+	err := idleTimeout.body.Close()
+	idleTimeout.cancel(nil)
+
+	return err
+}
+
+// removeServerImageZip removes the zip of the previously downloaded image
+func removeServerImageZip() error {
 	if mebroutines.ExistsFile(mebroutines.GetZipImagePath()) {
 		err := os.Remove(mebroutines.GetZipImagePath())
 		if err != nil {
@@ -115,56 +189,159 @@ func downloadServerImage(url string, progressCallbackFn func(string, int)) error
 		}
 	}
 
-	progressCallbackFn(xlate.Get("Contacting server"), downloadProgressPercentageContactingServer)
-	log.Debug("Starting to download image from '%s'", url)
+	return nil
+}
 
-	response, err := makeHTTPGet(url)
+func downloadServerImage(url string, progressReporter reporter.Reporter) error {
+	err := removeServerImageZip()
 	if err != nil {
-		log.Error("Getting available version from '%s' resulted an error: %v", url, err)
+		return err
+	}
 
+	err = downloadFile(url, mebroutines.GetZipImagePath(), progressReporter)
+	if err != nil {
 		return err
 	}
 
+	progressReporter.Progress(xlate.Get("Server image downloaded"), downloadProgressPercentageFinished)
+
+	return nil
+}
+
+// downloadFile downloads the URL to the path. An interrupted download is
+// resumed at most downloadAttempts times. If all attempts fail, the partial
+// file is kept and the next call resumes the download.
+func downloadFile(url string, path string, progressReporter reporter.Reporter) error {
+	var err error
+
+	for attempt := 1; attempt <= downloadAttempts; attempt++ {
+		if attempt > 1 {
+			log.Warning("Download from '%s' failed, retrying in %v: %v", url, downloadRetryDelay, err)
+			time.Sleep(downloadRetryDelay)
+		}
+
+		err = downloadFileAttempt(url, path, progressReporter)
+		if !errors.Is(err, errDownloadInterrupted) {
+			return err
+		}
+	}
+
+	return err
+}
+
+// getDownloadRangeHeader returns the headers requesting the rest of the
+// partial file if it has not changed on the server
+func getDownloadRangeHeader(partial partialDownload, offset int64) http.Header {
+	header := http.Header{}
+	header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	header.Set("If-Range", partial.validator())
+
+	return header
+}
+
+func downloadFileAttempt(url string, path string, progressReporter reporter.Reporter) error {
+	progressReporter.Progress(xlate.Get("Contacting server"), downloadProgressPercentageContactingServer)
+
+	header := http.Header{}
+
+	partial, offset, resumable := getResumableDownload(path, url)
+	if resumable {
+		log.Debug("Resuming download from '%s' at %s", url, humanize.Bytes(uint64(offset)))
+		header = getDownloadRangeHeader(partial, offset)
+	} else {
+		log.Debug("Starting to download image from '%s'", url)
+		removePartialDownload(path)
+	}
+
+	response, err := makeHTTPGetWithHeader(url, header)
+	if err != nil {
+		log.Error("Downloading image from '%s' resulted an error: %v", url, err)
+
+		return fmt.Errorf("%w: %w", errDownloadInterrupted, err)
+	}
+
 	defer response.Body.Close()
 
-	if response.StatusCode != httpStatusOK {
+	switch {
+	case response.StatusCode == httpStatusPartialContent && resumable:
+		start, err := getContentRangeStart(&response)
+		if err != nil || start != offset {
+			removePartialDownload(path)
+
+			return fmt.Errorf("%w: server did not continue from byte %d: %v", errDownloadInterrupted, offset, err)
+		}
+	case response.StatusCode == httpStatusOK:
+		if resumable {
+			log.Debug("Image at '%s' has changed, starting the download from the beginning", url)
+		}
+
+		offset = 0
+		partial = newPartialDownload(url, &response, 0)
+
+		err = writePartialDownload(path, partial)
+		if err != nil {
+			return err
+		}
+	case response.StatusCode == httpStatusRangeNotSatisfiable && resumable:
+		removePartialDownload(path)
+
+		return fmt.Errorf("%w: server could not continue from byte %d", errDownloadInterrupted, offset)
+	default:
 		log.Error("HTTP GET from url '%s' gives a status code %d", url, response.StatusCode)
 
 		return fmt.Errorf("%d", response.StatusCode)
 	}
 
-	fileSize := uint64(response.ContentLength)
+	progressReporter.Progress(xlate.Get("Opening file"), downloadProgressPercentageOpeningFile)
 
-	progressCallbackFn(xlate.Get("Opening file"), downloadProgressPercentageOpeningFile)
-	zipFile, errFile := os.OpenFile(mebroutines.GetZipImagePath(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, constants.FilePermissionsOwnerRW)
+	fileFlags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 {
+		fileFlags = os.O_WRONLY | os.O_APPEND
+	}
+
+	partialFile, errFile := os.OpenFile(getPartialPath(path), fileFlags, constants.FilePermissionsOwnerRW)
 	if errFile != nil {
-		log.Error("Could not open file '%s' for server image zip: %v", mebroutines.GetZipImagePath(), errFile)
+		log.Error("Could not open file '%s' for server image zip: %v", getPartialPath(path), errFile)
 
 		return errFile
 	}
-	defer zipFile.Close()
+	defer partialFile.Close()
 
-	progressCallbackFn(xlate.Get("Downloading server image"), downloadProgressPercentageDownloading)
+	progressReporter.Progress(xlate.Get("Downloading server image"), downloadProgressPercentageDownloading)
 
 	serverImageWriteCounter := writeCounter{
-		ProgressCallbackFn: progressCallbackFn,
-		FileSize:           fileSize,
-		ProgressString:     xlate.GetRaw("Downloading server image"),
-		Total:              0,
+		ProgressReporter: progressReporter,
+		FileSize:         partial.Size,
+		ProgressString:   xlate.GetRaw("Downloading server image"),
+		Total:            uint64(offset),
 	}
 	counter := &serverImageWriteCounter
 
 	var errCopy error
-	if _, errCopy = io.Copy(zipFile, io.TeeReader(response.Body, counter)); errCopy != nil {
-		return errCopy
+	if _, errCopy = io.Copy(partialFile, io.TeeReader(response.Body, counter)); errCopy != nil {
+		log.Error("Download from '%s' was interrupted at %s: %v", url, humanize.Bytes(counter.Total), errCopy)
+
+		return fmt.Errorf("%w: %w", errDownloadInterrupted, errCopy)
+	}
+
+	err = partialFile.Close()
+	if err != nil {
+		return fmt.Errorf("could not close %s: %w", getPartialPath(path), err)
+	}
+
+	err = os.Rename(getPartialPath(path), path)
+	if err != nil {
+		return fmt.Errorf("could not move downloaded file to %s: %w", path, err)
 	}
 
-	progressCallbackFn(xlate.Get("Server image downloaded"), downloadProgressPercentageFinished)
+	removePartialDownload(path)
 
 	return nil
 }
 
-func unZipServerImageChecksum(file *zip.File) (string, error) {
+// unZipTextFile returns the content of a small file in the zip, such as the
+// image checksum or its signature
+func unZipTextFile(file *zip.File) (string, error) {
 	fZipped, err := file.Open()
 	if err != nil {
 		return "", fmt.Errorf("could not open file inside the zip: %w", err)
@@ -172,17 +349,17 @@ func unZipServerImageChecksum(file *zip.File) (string, error) {
 
 	defer fZipped.Close()
 
-	definedChecksumFileContent, err := io.ReadAll(fZipped)
+	content, err := io.ReadAll(fZipped)
 	if err != nil {
-		return "", fmt.Errorf("could not read image checksum file inside the zip: %w", err)
+		return "", fmt.Errorf("could not read %s inside the zip: %w", file.Name, err)
 	}
 
 	fZipped.Close()
 
-	return CleanSHA256ChecksumString(string(definedChecksumFileContent)), nil
+	return string(content), nil
 }
 
-func unZipServerImageFile(file *zip.File, progressCallbackFn func(string, int)) error {
+func unZipServerImageFile(file *zip.File, progressReporter reporter.Reporter) error {
 	fImage, err := os.OpenFile(mebroutines.GetImagePath(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, constants.FilePermissionsOwnerRW)
 	if err != nil {
 		return fmt.Errorf("could not create image file %s: %w", mebroutines.GetImagePath(), err)
@@ -197,13 +374,13 @@ func unZipServerImageFile(file *zip.File, progressCallbackFn func(string, int))
 
 	defer fZipped.Close()
 
-	progressCallbackFn(xlate.Get("Starting to uncompress raw image"), unzipProgressPercentageStarting)
+	progressReporter.Progress(xlate.Get("Starting to uncompress raw image"), unzipProgressPercentageStarting)
 
 	serverImageUnzipCounter := writeCounter{
-		ProgressCallbackFn: progressCallbackFn,
-		FileSize:           file.UncompressedSize64,
-		ProgressString:     xlate.GetRaw("Uncompressing image..."),
-		Total:              0,
+		ProgressReporter: progressReporter,
+		FileSize:         file.UncompressedSize64,
+		ProgressString:   xlate.GetRaw("Uncompressing image..."),
+		Total:            0,
 	}
 	counter := &serverImageUnzipCounter
 
@@ -211,41 +388,56 @@ func unZipServerImageFile(file *zip.File, progressCallbackFn func(string, int))
 		return err
 	}
 
-	progressCallbackFn(xlate.Get("Uncompressing finished"), unzipProgressPercentageFinished)
+	progressReporter.Progress(xlate.Get("Uncompressing finished"), unzipProgressPercentageFinished)
 
 	return nil
 }
 
-func unZipServerImage(progressCallbackFn func(string, int)) error {
-	definedChecksum := ""
+func unZipServerImage(zipPath string, progressReporter reporter.Reporter) error {
+	checksumFileContent := ""
+	signatureText := ""
 
-	zipReader, err := zip.OpenReader(mebroutines.GetZipImagePath())
+	zipReader, err := zip.OpenReader(zipPath)
 	if err != nil {
-		return fmt.Errorf("could not open zip %s: %w", mebroutines.GetZipImagePath(), err)
+		return fmt.Errorf("could not open zip %s: %w", zipPath, err)
 	}
 	defer zipReader.Close()
 
 	for _, file := range zipReader.File {
 		log.Debug("Etcher zip contains file %s, size %s", file.Name, humanize.Bytes(file.UncompressedSize64))
 
-		if file.Name == "ytl/ktp.img.sha256" {
-			definedChecksum, err = unZipServerImageChecksum(file)
+		if file.Name == serverImageChecksumZipEntry {
+			checksumFileContent, err = unZipTextFile(file)
+			if err != nil {
+				return err
+			}
+		}
+
+		if file.Name == serverImageChecksumSignatureZipEntry {
+			signatureText, err = unZipTextFile(file)
 			if err != nil {
 				return err
 			}
 		}
 
-		if file.Name == "ytl/ktp.img" {
-			err = unZipServerImageFile(file, progressCallbackFn)
+		if file.Name == serverImageZipEntry {
+			err = unZipServerImageFile(file, progressReporter)
 			if err != nil {
 				return err
 			}
 		}
 	}
+
+	err = verifyImageChecksumSignature(checksumFileContent, signatureText)
+	if err != nil {
+		return err
+	}
+
+	definedChecksum := CleanSHA256ChecksumString(checksumFileContent)
 	if definedChecksum != "" {
 		log.Debug("Checking that uncompressed image meets defined checksum '%s'", definedChecksum)
 
-		calculatedChecksum, err := GetSHA256ChecksumFromFile(mebroutines.GetImagePath(), progressCallbackFn)
+		calculatedChecksum, err := GetSHA256ChecksumFromFile(mebroutines.GetImagePath(), progressReporter)
 		if err != nil {
 			return fmt.Errorf("could not calculate sha256: %w", err)
 		}
@@ -262,56 +454,366 @@ func unZipServerImage(progressCallbackFn func(string, int)) error {
 	return nil
 }
 
-func GetServerImage(url string, progressCallbackFn func(string, int)) error {
-	err := downloadServerImage(url, progressCallbackFn)
+// streamServerImage downloads the zip and writes the server image to the
+// image path while calculating its checksum, so the image is read only once.
+// The zip is written to the partial file of the zip path, so that an
+// interrupted download or a zip which cannot be streamed is resumed by
+// downloadFile. A complete zip is moved to the cache path if it is given.
+func streamServerImage(url string, imagePath string, zipPath string, cachePath string, progressReporter reporter.Reporter) error {
+	progressReporter.Progress(xlate.Get("Contacting server"), downloadProgressPercentageContactingServer)
+	log.Debug("Starting to stream image from '%s'", url)
+
+	removePartialDownload(zipPath)
+
+	response, err := makeHTTPGet(url)
 	if err != nil {
-		log.Error("Failed to download server image from '%s': %v", url, err)
+		return fmt.Errorf("%w: %w", errDownloadInterrupted, err)
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != httpStatusOK {
+		log.Error("HTTP GET from url '%s' gives a status code %d", url, response.StatusCode)
+
+		return fmt.Errorf("%d", response.StatusCode)
+	}
+
+	partial := newPartialDownload(url, &response, 0)
 
+	err = writePartialDownload(zipPath, partial)
+	if err != nil {
 		return err
 	}
 
-	err = unZipServerImage(progressCallbackFn)
+	partialFile, err := os.OpenFile(getPartialPath(zipPath), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, constants.FilePermissionsOwnerRW)
 	if err != nil {
-		log.Error("Failed to unZipServerImage: %v", err)
+		removePartialDownload(zipPath)
+
+		return fmt.Errorf("could not create %s: %w", getPartialPath(zipPath), err)
+	}
+
+	defer partialFile.Close()
+
+	progressReporter.Progress(xlate.Get("Downloading server image"), downloadProgressPercentageDownloading)
+
+	counter := &writeCounter{
+		ProgressReporter: progressReporter,
+		FileSize:         partial.Size,
+		ProgressString:   xlate.GetRaw("Downloading server image"),
+		Total:            0,
+	}
+
+	zipReader := io.TeeReader(interruptibleReader{reader: response.Body}, partialFile)
+
+	err = extractServerImage(io.TeeReader(zipReader, counter), imagePath)
+	if err == nil {
+		// Read the rest of the zip (the central directory) to the partial file
+		_, err = io.Copy(io.Discard, zipReader)
+	}
+
+	switch {
+	case errors.Is(err, errDownloadInterrupted) || errors.Is(err, errZipNotStreamable):
+		// Keep the partial file for downloadFile
+		log.Debug("Streaming was stopped at %s of '%s'", humanize.Bytes(counter.Total), url)
+
+		return err
+	case err != nil:
+		partialFile.Close()
+		removePartialDownload(zipPath)
 
 		return err
 	}
 
+	err = partialFile.Close()
+	if err != nil {
+		removePartialDownload(zipPath)
+
+		return fmt.Errorf("could not close %s: %w", getPartialPath(zipPath), err)
+	}
+
+	if cachePath != "" {
+		cacheDownloadedZip(getPartialPath(zipPath), cachePath)
+	}
+
+	removePartialDownload(zipPath)
+
+	progressReporter.Progress(xlate.Get("Server image downloaded"), downloadProgressPercentageFinished)
+
 	return nil
 }
 
-func GetAvailableVersion(versionURL string) (string, error) {
-	ensureCloudStatusCacheInitialised()
+// extractServerImage reads the zip as a stream and writes the server image to
+// the image path while calculating its checksum. The checksum and its
+// signature are verified after the image has been written.
+func extractServerImage(zipReader io.Reader, imagePath string) error {
+	stream := newZipStream(zipReader)
 
-	var version string
+	checksumFileContent := ""
+	signatureText := ""
+	calculatedChecksum := ""
 
-	cachedVersion, err := cloudStatusCache.Get(versionURL)
+	for {
+		entry, err := stream.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
 
-	if err == nil {
-		version = fmt.Sprintf("%v", cachedVersion)
+		if err != nil {
+			return err
+		}
 
-		return version, nil
+		log.Debug("Etcher zip contains file %s, size %s", entry.Name, humanize.Bytes(entry.UncompressedSize))
+
+		switch entry.Name {
+		case serverImageChecksumZipEntry:
+			checksumFileContent, err = readStreamedTextFile(stream, entry.Name)
+		case serverImageChecksumSignatureZipEntry:
+			signatureText, err = readStreamedTextFile(stream, entry.Name)
+		case serverImageZipEntry:
+			calculatedChecksum, err = writeStreamedImage(stream, imagePath)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if calculatedChecksum == "" {
+		return fmt.Errorf("zip does not contain %s", serverImageZipEntry)
+	}
+
+	err := verifyImageChecksumSignature(checksumFileContent, signatureText)
+	if err != nil {
+		return err
+	}
+
+	definedChecksum := CleanSHA256ChecksumString(checksumFileContent)
+	if definedChecksum != "" && definedChecksum != calculatedChecksum {
+		log.Error("Image checksums differ, defined: %s, calculated: %s", definedChecksum, calculatedChecksum)
+
+		return ErrDownloadedDiskImageCorrupted
+	}
+
+	log.Debug("Streamed image with checksum '%s' (defined '%s')", calculatedChecksum, definedChecksum)
+
+	return nil
+}
+
+// readStreamedTextFile returns the content of the current zip entry, such as
+// the image checksum or its signature
+func readStreamedTextFile(stream io.Reader, name string) (string, error) {
+	content, err := io.ReadAll(stream)
+	if err != nil {
+		return "", fmt.Errorf("could not read %s inside the zip: %w", name, err)
 	}
 
-	response, err := makeHTTPGet(versionURL)
+	return string(content), nil
+}
+
+// writeStreamedImage writes the content of the current zip entry to the image
+// path and returns its SHA256 checksum
+func writeStreamedImage(stream io.Reader, imagePath string) (string, error) {
+	fImage, err := os.OpenFile(imagePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, constants.FilePermissionsOwnerRW)
 	if err != nil {
-		log.Error("Getting available version from '%s' resulted an error: %v", versionURL, err)
+		return "", fmt.Errorf("could not create image file %s: %w", imagePath, err)
+	}
+
+	defer fImage.Close()
+
+	checksumCalculator := sha256.New()
 
+	_, err = io.Copy(io.MultiWriter(fImage, checksumCalculator), stream)
+	if err != nil {
 		return "", err
 	}
 
+	err = fImage.Close()
+	if err != nil {
+		return "", fmt.Errorf("could not close image file %s: %w", imagePath, err)
+	}
+
+	return fmt.Sprintf("%x", checksumCalculator.Sum(nil)), nil
+}
+
+// interruptibleReader marks the read errors of the response body as
+// interrupted downloads
+type interruptibleReader struct {
+	reader io.Reader
+}
+
+func (interruptible interruptibleReader) Read(buffer []byte) (int, error) {
+	n, err := interruptible.reader.Read(buffer)
+	if err != nil && !errors.Is(err, io.EOF) {
+		err = fmt.Errorf("%w: %w", errDownloadInterrupted, err)
+	}
+
+	return n, err
+}
+
+// GetServerImage downloads the server image zip from the URL and writes the
+// server image to mebroutines.GetImagePath(). The image is uncompressed while
+// it is downloaded, and the zip is written to a partial file. If the zip
+// cannot be streamed or the download is interrupted, the download of the
+// partial file is resumed and the image is uncompressed from the complete
+// zip. The zip is kept in the cache path (see
+// GetCachedServerImagePath) unless it is empty.
+func GetServerImage(url string, cachePath string, progressReporter reporter.Reporter) error {
+	if !hasResumableDownload(mebroutines.GetZipImagePath(), url) {
+		err := removeServerImageZip()
+		if err != nil {
+			return err
+		}
+
+		err = streamServerImage(url, mebroutines.GetImagePath(), mebroutines.GetZipImagePath(), cachePath, progressReporter)
+		switch {
+		case err == nil:
+			return nil
+		case errors.Is(err, errZipNotStreamable) || errors.Is(err, errDownloadInterrupted):
+			log.Warning("Could not stream server image from '%s', resuming the download of the zip instead: %v", url, err)
+		default:
+			log.Error("Failed to stream server image from '%s': %v", url, err)
+
+			return err
+		}
+	}
+
+	err := downloadServerImage(url, progressReporter)
+	if err != nil {
+		log.Error("Failed to download server image from '%s': %v", url, err)
+
+		return err
+	}
+
+	err = unZipServerImage(mebroutines.GetZipImagePath(), progressReporter)
+	if err != nil {
+		log.Error("Failed to unZipServerImage: %v", err)
+
+		return err
+	}
+
+	if cachePath != "" {
+		cacheDownloadedZip(mebroutines.GetZipImagePath(), cachePath)
+	}
+
+	return nil
+}
+
+// getURLContent returns the content of a small file such as the version file.
+// The error of an unexpected status is the status code (e.g. "404").
+func getURLContent(url string) ([]byte, error) {
+	response, err := makeHTTPGet(url)
+	if err != nil {
+		log.Error("Getting '%s' resulted an error: %v", url, err)
+
+		return nil, err
+	}
+
 	defer response.Body.Close()
 
 	if response.StatusCode != httpStatusOK {
-		log.Error(fmt.Sprintf("Getting available version from '%s' gives a status code %d", versionURL, response.StatusCode))
+		log.Error("Getting '%s' gives a status code %d", url, response.StatusCode)
 
-		return "", fmt.Errorf("%d", response.StatusCode)
+		return nil, fmt.Errorf("%d", response.StatusCode)
 	}
 
 	body, err := io.ReadAll(response.Body)
 	if err != nil {
-		log.Error("Reading available version from '%s' resulted and error: %v", versionURL, err)
+		log.Error("Reading '%s' resulted and error: %v", url, err)
+
+		return nil, err
+	}
+
+	return body, nil
+}
+
+// GetLocalServerImage writes the server image in a local etcher zip (e.g. on
+// a USB stick) to mebroutines.GetImagePath(). The checksum and the signature
+// are verified like in the downloaded images.
+func GetLocalServerImage(zipPath string, progressReporter reporter.Reporter) error {
+	zipFile, err := os.Open(filepath.Clean(zipPath))
+	if err != nil {
+		return fmt.Errorf("could not open image file %s: %w", zipPath, err)
+	}
+
+	defer zipFile.Close()
+
+	fileInfo, err := zipFile.Stat()
+	if err != nil {
+		return fmt.Errorf("could not get file info of %s: %w", zipPath, err)
+	}
+
+	log.Debug("Reading image from '%s' (%s)", zipPath, humanize.Bytes(uint64(fileInfo.Size())))
+	progressReporter.Progress(xlate.Get("Uncompressing image..."), unzipProgressPercentageStarting)
+
+	counter := &writeCounter{
+		ProgressReporter: progressReporter,
+		FileSize:         uint64(fileInfo.Size()),
+		ProgressString:   xlate.GetRaw("Uncompressing image..."),
+		Total:            0,
+	}
+
+	err = extractServerImage(io.TeeReader(zipFile, counter), mebroutines.GetImagePath())
+	if errors.Is(err, errZipNotStreamable) {
+		log.Warning("Could not read '%s' as a stream, uncompressing it using its central directory: %v", zipPath, err)
+
+		err = unZipServerImage(zipPath, progressReporter)
+	}
+
+	if err != nil {
+		log.Error("Failed to get server image from '%s': %v", zipPath, err)
+
+		return err
+	}
 
+	progressReporter.Progress(xlate.Get("Uncompressing finished"), unzipProgressPercentageFinished)
+
+	return nil
+}
+
+// GetLocalVersion returns the version in a local version file (e.g.
+// ktp-etcher.ver). The signature of the file is verified like in the
+// downloaded version files.
+func GetLocalVersion(versionPath string) (string, error) {
+	versionFileContent, err := os.ReadFile(filepath.Clean(versionPath))
+	if err != nil {
+		return "", fmt.Errorf("could not read version file %s: %w", versionPath, err)
+	}
+
+	if IsSignatureVerified() {
+		signatureText, err := os.ReadFile(filepath.Clean(versionPath + signatureSuffix))
+		if err != nil {
+			return "", fmt.Errorf("%w: could not read the signature of %s: %w", ErrDownloadSignatureInvalid, versionPath, err)
+		}
+
+		err = verifySignature(versionFileContent, string(signatureText))
+		if err != nil {
+			log.Error("Version file '%s' has an invalid signature: %v", versionPath, err)
+
+			return "", err
+		}
+	}
+
+	version := sanitizeBoxVersionString(string(versionFileContent))
+	log.Debug("Box version from '%s' is '%s'", versionPath, version)
+
+	return version, nil
+}
+
+func GetAvailableVersion(versionURL string) (string, error) {
+	ensureCloudStatusCacheInitialised()
+
+	var version string
+
+	cachedVersion, err := cloudStatusCache.Get(versionURL)
+
+	if err == nil {
+		version = fmt.Sprintf("%v", cachedVersion)
+
+		return version, nil
+	}
+
+	body, err := getURLContent(versionURL)
+	if err != nil {
 		return "", err
 	}
 
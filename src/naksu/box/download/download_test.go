@@ -1,8 +1,21 @@
 package download
 
 import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"naksu/reporter"
 )
 
 func TestCleanSHA256ChecksumString(t *testing.T) {
@@ -58,7 +71,7 @@ func testOneGetSHA256ChecksumFromFile(t *testing.T, fileContent string, expected
 		// fmt.Println(message)
 	}
 
-	calculatedChecksum, err := GetSHA256ChecksumFromFile(tempFile.Name(), nilProgressCallbackFn)
+	calculatedChecksum, err := GetSHA256ChecksumFromFile(tempFile.Name(), reporter.Func(nilProgressCallbackFn))
 	if err != nil {
 		t.Errorf("Error while calculating checksum from file %s: %v", tempFile.Name(), err)
 	}
@@ -70,3 +83,234 @@ func testOneGetSHA256ChecksumFromFile(t *testing.T, fileContent string, expected
 		os.Remove(tempFile.Name())
 	}
 }
+
+func getTestImageContent() []byte {
+	return bytes.Repeat([]byte("naksu test image "), 64*1024)
+}
+
+func serveTestImage(writer http.ResponseWriter, request *http.Request, content []byte, etag string) {
+	writer.Header().Set("ETag", etag)
+	http.ServeContent(writer, request, "naksu_last_image.zip", time.Time{}, bytes.NewReader(content))
+}
+
+// setDownloadTimeouts overrides the download delays for the test
+func setDownloadTimeouts(t *testing.T, retryDelay time.Duration, idleTimeout time.Duration) {
+	t.Helper()
+
+	previousRetryDelay := downloadRetryDelay
+	previousIdleTimeout := downloadIdleTimeout
+
+	t.Cleanup(func() {
+		downloadRetryDelay = previousRetryDelay
+		downloadIdleTimeout = previousIdleTimeout
+	})
+
+	downloadRetryDelay = retryDelay
+	downloadIdleTimeout = idleTimeout
+}
+
+func TestDownloadFileResumesInterruptedDownload(t *testing.T) {
+	content := getTestImageContent()
+	rangeHeaders := []string{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		rangeHeaders = append(rangeHeaders, request.Header.Get("Range")+" "+request.Header.Get("If-Range"))
+
+		if request.Header.Get("Range") == "" {
+			// Send the first half and drop the connection
+			writer.Header().Set("ETag", `"v1"`)
+			writer.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			writer.WriteHeader(http.StatusOK)
+			_, _ = writer.Write(content[:len(content)/2])
+			writer.(http.Flusher).Flush()
+
+			panic(http.ErrAbortHandler)
+		}
+
+		serveTestImage(writer, request, content, `"v1"`)
+	}))
+	defer server.Close()
+
+	setDownloadTimeouts(t, 0, downloadIdleTimeout)
+	path := filepath.Join(t.TempDir(), "naksu_last_image.zip")
+
+	err := downloadFile(server.URL, path, reporter.Func(func(message string, value int) {}))
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+
+	downloaded, err := os.ReadFile(path)
+	if err != nil || !bytes.Equal(downloaded, content) {
+		t.Errorf("Downloaded file differs from the served file (%d bytes, error %v)", len(downloaded), err)
+	}
+
+	expectedRangeHeader := "bytes=" + strconv.Itoa(len(content)/2) + `- "v1"`
+	if len(rangeHeaders) != 2 || rangeHeaders[1] != expectedRangeHeader {
+		t.Errorf("Unexpected requests %q, expected the second one to be %q", rangeHeaders, expectedRangeHeader)
+	}
+
+	if _, err := os.Stat(getPartialMetadataPath(path)); !os.IsNotExist(err) {
+		t.Errorf("Partial download metadata was not removed: %v", err)
+	}
+}
+
+func TestDownloadFileResumesStalledDownload(t *testing.T) {
+	content := getTestImageContent()
+	requests := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requests++
+
+		if request.Header.Get("Range") == "" {
+			// Send the first half and stop sending until the client gives up
+			writer.Header().Set("ETag", `"v1"`)
+			writer.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			writer.WriteHeader(http.StatusOK)
+			_, _ = writer.Write(content[:len(content)/2])
+			writer.(http.Flusher).Flush()
+			<-request.Context().Done()
+
+			return
+		}
+
+		serveTestImage(writer, request, content, `"v1"`)
+	}))
+	defer server.Close()
+
+	setDownloadTimeouts(t, 0, 200*time.Millisecond)
+	path := filepath.Join(t.TempDir(), "naksu_last_image.zip")
+
+	err := downloadFile(server.URL, path, reporter.Func(func(message string, value int) {}))
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+
+	downloaded, err := os.ReadFile(path)
+	if err != nil || !bytes.Equal(downloaded, content) {
+		t.Errorf("Downloaded file differs from the served file (%d bytes, error %v)", len(downloaded), err)
+	}
+
+	if requests != 2 {
+		t.Errorf("Stalled download made %d requests, expected 2", requests)
+	}
+}
+
+func TestDownloadFileRestartsChangedDownload(t *testing.T) {
+	content := getTestImageContent()
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		serveTestImage(writer, request, content, `"v2"`)
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "naksu_last_image.zip")
+
+	err := os.WriteFile(getPartialPath(path), []byte(strings.Repeat("x", 1024)), 0o600)
+	if err != nil {
+		t.Fatalf("Could not write partial file: %v", err)
+	}
+
+	err = writePartialDownload(path, partialDownload{URL: server.URL, ETag: `"v1"`, LastModified: "", Size: uint64(len(content))})
+	if err != nil {
+		t.Fatalf("Could not write partial download metadata: %v", err)
+	}
+
+	err = downloadFile(server.URL, path, reporter.Func(func(message string, value int) {}))
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+
+	downloaded, err := os.ReadFile(path)
+	if err != nil || !bytes.Equal(downloaded, content) {
+		t.Errorf("Changed file was not downloaded from the beginning (%d bytes, error %v)", len(downloaded), err)
+	}
+}
+
+func TestStreamServerImage(t *testing.T) {
+	image := getTestImageContent()
+
+	testCases := []struct {
+		description   string
+		checksum      string
+		expectedError error
+	}{
+		{"valid checksum", fmt.Sprintf("%x", sha256.Sum256(image)), nil},
+		{"invalid checksum", "0000000000000000000000000000000000000000000000000000000000000000", ErrDownloadedDiskImageCorrupted},
+	}
+
+	for _, testCase := range testCases {
+		entries := getSignedChecksumEntries(t, testCase.checksum+"  ktp.img\n")
+		zipContent := createTestZip(t, append(entries, testZipEntry{serverImageZipEntry, image, zip.Deflate, false}))
+
+		server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			serveTestImage(writer, request, zipContent, `"v1"`)
+		}))
+
+		imagePath := filepath.Join(t.TempDir(), "ktp.img")
+
+		err := streamServerImage(server.URL, imagePath, filepath.Join(t.TempDir(), "naksu_last_image.zip"), "", reporter.Func(func(message string, value int) {}))
+		server.Close()
+
+		if !errors.Is(err, testCase.expectedError) {
+			t.Errorf("Streaming image with %s returned %v, expected %v", testCase.description, err, testCase.expectedError)
+		}
+
+		written, err := os.ReadFile(imagePath)
+		if err != nil || !bytes.Equal(written, image) {
+			t.Errorf("Streamed image with %s differs from the zipped image (%d bytes, error %v)", testCase.description, len(written), err)
+		}
+	}
+}
+
+func TestInterruptedStreamIsResumed(t *testing.T) {
+	image := getTestImageContent()
+	entries := getSignedChecksumEntries(t, fmt.Sprintf("%x  ktp.img\n", sha256.Sum256(image)))
+	zipContent := createTestZip(t, append(entries, testZipEntry{serverImageZipEntry, image, zip.Deflate, false}))
+	rangeHeaders := []string{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		rangeHeaders = append(rangeHeaders, request.Header.Get("Range"))
+
+		if request.Header.Get("Range") == "" {
+			// Send the first half and drop the connection
+			writer.Header().Set("ETag", `"v1"`)
+			writer.Header().Set("Content-Length", strconv.Itoa(len(zipContent)))
+			writer.WriteHeader(http.StatusOK)
+			_, _ = writer.Write(zipContent[:len(zipContent)/2])
+			writer.(http.Flusher).Flush()
+
+			panic(http.ErrAbortHandler)
+		}
+
+		serveTestImage(writer, request, zipContent, `"v1"`)
+	}))
+	defer server.Close()
+
+	directory := t.TempDir()
+	zipPath := filepath.Join(directory, "naksu_last_image.zip")
+
+	err := streamServerImage(server.URL, filepath.Join(directory, "ktp.img"), zipPath, "", reporter.Func(func(message string, value int) {}))
+	if !errors.Is(err, errDownloadInterrupted) {
+		t.Fatalf("Interrupted stream returned %v, expected errDownloadInterrupted", err)
+	}
+
+	_, offset, resumable := getResumableDownload(zipPath, server.URL)
+	if !resumable || offset == 0 {
+		t.Fatalf("Interrupted stream cannot be resumed (offset %d)", offset)
+	}
+
+	err = downloadFile(server.URL, zipPath, reporter.Func(func(message string, value int) {}))
+	if err != nil {
+		t.Fatalf("Resuming the download failed: %v", err)
+	}
+
+	downloaded, err := os.ReadFile(zipPath)
+	if err != nil || !bytes.Equal(downloaded, zipContent) {
+		t.Errorf("Resumed zip differs from the served zip (%d bytes, error %v)", len(downloaded), err)
+	}
+
+	expectedRangeHeader := "bytes=" + strconv.FormatInt(offset, 10) + "-"
+	if len(rangeHeaders) != 2 || rangeHeaders[1] != expectedRangeHeader {
+		t.Errorf("Unexpected requests %q, expected the second one to be %q", rangeHeaders, expectedRangeHeader)
+	}
+}
@@ -0,0 +1,139 @@
+package download
+
+// minisign public keys and signatures, see
+// https://jedisct1.github.io/minisign/#signature-format
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	minisignKeyIDLength          = 8
+	minisignUntrustedCommentLine = "untrusted comment:"
+	minisignTrustedCommentLine   = "trusted comment: "
+)
+
+var (
+	// minisignAlgorithmPure signs the message itself (legacy signatures)
+	minisignAlgorithmPure = []byte("Ed")
+	// minisignAlgorithmHashed signs the BLAKE2b-512 hash of the message
+	minisignAlgorithmHashed = []byte("ED")
+)
+
+type minisignPublicKey struct {
+	keyID []byte
+	key   ed25519.PublicKey
+}
+
+type minisignSignature struct {
+	algorithm       []byte
+	keyID           []byte
+	signature       []byte
+	trustedComment  string
+	globalSignature []byte
+}
+
+// getMinisignLines returns the lines of a minisign file without the
+// untrusted comment and the empty lines
+func getMinisignLines(text string) []string {
+	lines := []string{}
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, minisignUntrustedCommentLine) {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
+
+// parseMinisignPublicKey parses a public key file (minisign.pub) or the
+// base64 encoded key on its second line
+func parseMinisignPublicKey(text string) (minisignPublicKey, error) {
+	var publicKey minisignPublicKey
+
+	lines := getMinisignLines(text)
+	if len(lines) != 1 {
+		return publicKey, fmt.Errorf("public key has %d lines instead of 1", len(lines))
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil {
+		return publicKey, fmt.Errorf("could not decode public key: %w", err)
+	}
+
+	if len(decoded) != len(minisignAlgorithmPure)+minisignKeyIDLength+ed25519.PublicKeySize || !bytes.Equal(decoded[:2], minisignAlgorithmPure) {
+		return publicKey, fmt.Errorf("public key is not an ed25519 minisign key")
+	}
+
+	publicKey.keyID = decoded[2 : 2+minisignKeyIDLength]
+	publicKey.key = ed25519.PublicKey(decoded[2+minisignKeyIDLength:])
+
+	return publicKey, nil
+}
+
+// parseMinisignSignature parses a signature file (.minisig)
+func parseMinisignSignature(text string) (minisignSignature, error) {
+	var signature minisignSignature
+
+	lines := getMinisignLines(text)
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], minisignTrustedCommentLine) {
+		return signature, fmt.Errorf("signature is not a minisign signature")
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil {
+		return signature, fmt.Errorf("could not decode signature: %w", err)
+	}
+
+	if len(decoded) != 2+minisignKeyIDLength+ed25519.SignatureSize {
+		return signature, fmt.Errorf("signature has an unexpected length %d", len(decoded))
+	}
+
+	globalSignature, err := base64.StdEncoding.DecodeString(lines[2])
+	if err != nil || len(globalSignature) != ed25519.SignatureSize {
+		return signature, fmt.Errorf("could not decode global signature: %v", err)
+	}
+
+	signature.algorithm = decoded[:2]
+	signature.keyID = decoded[2 : 2+minisignKeyIDLength]
+	signature.signature = decoded[2+minisignKeyIDLength:]
+	signature.trustedComment = strings.TrimPrefix(lines[1], minisignTrustedCommentLine)
+	signature.globalSignature = globalSignature
+
+	return signature, nil
+}
+
+// verify returns an error if the message has not been signed with the key or
+// the trusted comment has been changed
+func (publicKey minisignPublicKey) verify(message []byte, signature minisignSignature) error {
+	if !bytes.Equal(publicKey.keyID, signature.keyID) {
+		return fmt.Errorf("signature was made with key %X instead of %X", signature.keyID, publicKey.keyID)
+	}
+
+	switch {
+	case bytes.Equal(signature.algorithm, minisignAlgorithmHashed):
+		hash := blake2b.Sum512(message)
+		message = hash[:]
+	case !bytes.Equal(signature.algorithm, minisignAlgorithmPure):
+		return fmt.Errorf("unknown signature algorithm %q", signature.algorithm)
+	}
+
+	if !ed25519.Verify(publicKey.key, message, signature.signature) {
+		return fmt.Errorf("signature does not match")
+	}
+
+	signedComment := append(append([]byte{}, signature.signature...), []byte(signature.trustedComment)...)
+	if !ed25519.Verify(publicKey.key, signedComment, signature.globalSignature) {
+		return fmt.Errorf("trusted comment does not match its signature")
+	}
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package download
+
+// An interrupted download is kept in a partial file (e.g.
+// naksu_last_image.zip.part) next to the metadata of the response. The
+// download is resumed with a Range request if the server still has the same
+// file, which is checked with the ETag or the Last-Modified of the response.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"naksu/constants"
+	"naksu/log"
+)
+
+// partialDownload is the metadata of an interrupted download
+type partialDownload struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag"`
+	LastModified string `json:"lastModified"`
+	// Size is the size of the complete file or 0 if it is not known
+	Size uint64 `json:"size"`
+}
+
+var contentRangeRegexp = regexp.MustCompile(`^bytes (\d+)-\d+/(\d+|\*)$`)
+
+func getPartialPath(path string) string {
+	return path + ".part"
+}
+
+func getPartialMetadataPath(path string) string {
+	return path + ".part.json"
+}
+
+// newPartialDownload returns the metadata of the response. The size is the
+// size of the complete file starting at the offset.
+func newPartialDownload(url string, response *http.Response, offset int64) partialDownload {
+	var size uint64
+	if response.ContentLength > 0 {
+		size = uint64(offset + response.ContentLength)
+	}
+
+	return partialDownload{
+		URL:          url,
+		ETag:         response.Header.Get("ETag"),
+		LastModified: response.Header.Get("Last-Modified"),
+		Size:         size,
+	}
+}
+
+// validator returns the value of the If-Range header or an empty string if
+// the download cannot be resumed. Weak ETags cannot be used with ranges.
+func (partial partialDownload) validator() string {
+	if partial.ETag != "" && !strings.HasPrefix(partial.ETag, "W/") {
+		return partial.ETag
+	}
+
+	return partial.LastModified
+}
+
+func writePartialDownload(path string, partial partialDownload) error {
+	content, err := json.Marshal(partial)
+	if err != nil {
+		return fmt.Errorf("could not encode partial download metadata: %w", err)
+	}
+
+	err = os.WriteFile(getPartialMetadataPath(path), content, constants.FilePermissionsOwnerRW)
+	if err != nil {
+		return fmt.Errorf("could not write partial download metadata: %w", err)
+	}
+
+	return nil
+}
+
+// getResumableDownload returns the metadata and the size of the partial file
+// if the download of the URL to the path can be resumed
+func getResumableDownload(path string, url string) (partialDownload, int64, bool) {
+	var partial partialDownload
+
+	content, err := os.ReadFile(getPartialMetadataPath(path))
+	if err != nil {
+		return partial, 0, false
+	}
+
+	err = json.Unmarshal(content, &partial)
+	if err != nil {
+		log.Warning("Could not parse partial download metadata: %v", err)
+
+		return partial, 0, false
+	}
+
+	fileInfo, err := os.Stat(getPartialPath(path))
+	if err != nil {
+		return partial, 0, false
+	}
+
+	switch {
+	case partial.URL != url:
+		log.Debug("Partial download is from '%s', not resuming", partial.URL)
+	case partial.validator() == "":
+		log.Debug("Partial download has no ETag or Last-Modified, not resuming")
+	case fileInfo.Size() == 0 || (partial.Size > 0 && uint64(fileInfo.Size()) >= partial.Size):
+		log.Debug("Partial download has an unexpected size %d, not resuming", fileInfo.Size())
+	default:
+		return partial, fileInfo.Size(), true
+	}
+
+	return partial, 0, false
+}
+
+// hasResumableDownload returns true if there is an interrupted download of the
+// URL to the path
+func hasResumableDownload(path string, url string) bool {
+	_, _, resumable := getResumableDownload(path, url)
+
+	return resumable
+}
+
+// removePartialDownload removes the partial file and its metadata
+func removePartialDownload(path string) {
+	for _, partialPath := range []string{getPartialPath(path), getPartialMetadataPath(path)} {
+		err := os.Remove(partialPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Warning("Could not remove partial download %s: %v", partialPath, err)
+		}
+	}
+}
+
+// getContentRangeStart returns the first byte of the partial content response
+func getContentRangeStart(response *http.Response) (int64, error) {
+	matches := contentRangeRegexp.FindStringSubmatch(response.Header.Get("Content-Range"))
+	if matches == nil {
+		return 0, fmt.Errorf("unexpected content range '%s'", response.Header.Get("Content-Range"))
+	}
+
+	return strconv.ParseInt(matches[1], 10, 64)
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"regexp"
 
+	"naksu/reporter"
 	"naksu/xlate"
 )
 
@@ -21,7 +22,7 @@ func CleanSHA256ChecksumString(checksumString string) string {
 
 // GetSHA256ChecksumFromFile reads given file, calculates it SHA256 hash
 // and returns it as a string
-func GetSHA256ChecksumFromFile(filePath string, progressCallbackFn func(string, int)) (string, error) {
+func GetSHA256ChecksumFromFile(filePath string, progressReporter reporter.Reporter) (string, error) {
 	checksumFile, err := os.Open(filePath)
 	if err != nil {
 		return "", fmt.Errorf("error while opening file to calculate sha256 from '%s': %w", filePath, err)
@@ -34,13 +35,13 @@ func GetSHA256ChecksumFromFile(filePath string, progressCallbackFn func(string,
 		return "", fmt.Errorf("error while trying to get file info for '%s': %w", filePath, err)
 	}
 
-	progressCallbackFn(xlate.Get("Checking disk image..."), 0)
+	progressReporter.Progress(xlate.Get("Checking disk image..."), 0)
 
 	counter := &writeCounter{
-		ProgressCallbackFn: progressCallbackFn,
-		FileSize:           uint64(fileStat.Size()),
-		ProgressString:     xlate.GetRaw("Checking disk image..."),
-		Total:              0,
+		ProgressReporter: progressReporter,
+		FileSize:         uint64(fileStat.Size()),
+		ProgressString:   xlate.GetRaw("Checking disk image..."),
+		Total:            0,
 	}
 	checksumCalculator := sha256.New()
 	if _, err = io.Copy(checksumCalculator, io.TeeReader(checksumFile, counter)); err != nil {
@@ -48,7 +49,7 @@ func GetSHA256ChecksumFromFile(filePath string, progressCallbackFn func(string,
 	}
 
 	const progressCheckFinished = 100
-	progressCallbackFn(xlate.Get("Disk image checked"), progressCheckFinished)
+	progressReporter.Progress(xlate.Get("Disk image checked"), progressCheckFinished)
 
 	return fmt.Sprintf("%x", checksumCalculator.Sum(nil)), nil
 }
@@ -0,0 +1,142 @@
+package download
+
+// The server image checksum (ytl/ktp.img.sha256 in the etcher zip) and the
+// version file are signed with minisign. The detached signatures are
+// ytl/ktp.img.sha256.minisig in the zip and the version URL with the
+// ".minisig" suffix. Together with the checksum of the image they make sure
+// the image comes from the holder of a trusted key. The signatures are
+// verified only by the builds with trusted keys.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+
+	"naksu/log"
+)
+
+// signatureSuffix is appended to the URL of a file to get its signature
+const signatureSuffix = ".minisig"
+
+// serverImageChecksumSignatureZipEntry is the signature of the image checksum
+const serverImageChecksumSignatureZipEntry = serverImageChecksumZipEntry + signatureSuffix
+
+// ErrDownloadSignatureInvalid is returned if the signature of the server image
+// or the version file is missing or has not been made with a trusted key
+var ErrDownloadSignatureInvalid = errors.New("signature of the download is invalid")
+
+// trustedPublicKeysFlag are the minisign public keys (the second line of
+// minisign.pub) trusted to sign the image checksums and the version files,
+// separated by commas or spaces. The keys are given at build time with
+// -ldflags "-X naksu/box/download.trustedPublicKeysFlag=<keys>" (see
+// MINISIGN_PUBLIC_KEYS in the Makefile). Add the new key a release before
+// the signing key is rotated.
+var trustedPublicKeysFlag = ""
+
+var trustedPublicKeys = strings.FieldsFunc(trustedPublicKeysFlag, func(r rune) bool {
+	return r == ',' || unicode.IsSpace(r)
+})
+
+var noTrustedKeysWarning sync.Once
+
+// IsSignatureVerified returns true if the signatures of the downloads are
+// verified. Builds without trusted keys do not verify the signatures.
+func IsSignatureVerified() bool {
+	if len(trustedPublicKeys) == 0 {
+		noTrustedKeysWarning.Do(func() {
+			log.Warning("This build has no trusted signing keys, signatures of the downloads are not verified")
+		})
+
+		return false
+	}
+
+	return true
+}
+
+// verifySignature returns an error wrapping ErrDownloadSignatureInvalid if
+// the message has not been signed with one of the trusted keys
+func verifySignature(message []byte, signatureText string) error {
+	if len(trustedPublicKeys) == 0 {
+		return fmt.Errorf("%w: no trusted signing keys", ErrDownloadSignatureInvalid)
+	}
+
+	signature, err := parseMinisignSignature(signatureText)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDownloadSignatureInvalid, err)
+	}
+
+	for _, trustedPublicKey := range trustedPublicKeys {
+		publicKey, err := parseMinisignPublicKey(trustedPublicKey)
+		if err != nil {
+			log.Error("Trusted public key '%s' is invalid: %v", trustedPublicKey, err)
+
+			continue
+		}
+
+		if !bytes.Equal(publicKey.keyID, signature.keyID) {
+			continue
+		}
+
+		err = publicKey.verify(message, signature)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrDownloadSignatureInvalid, err)
+		}
+
+		log.Debug("Signature verified with key %X, trusted comment: %s", publicKey.keyID, signature.trustedComment)
+
+		return nil
+	}
+
+	return fmt.Errorf("%w: signature was made with an unknown key %X", ErrDownloadSignatureInvalid, signature.keyID)
+}
+
+// verifyImageChecksumSignature verifies the signature of the image checksum
+// file. Empty contents mean that the file was not found in the zip.
+func verifyImageChecksumSignature(checksumFileContent string, signatureText string) error {
+	if !IsSignatureVerified() {
+		return nil
+	}
+
+	switch {
+	case checksumFileContent == "":
+		return fmt.Errorf("%w: zip does not contain %s", ErrDownloadSignatureInvalid, serverImageChecksumZipEntry)
+	case signatureText == "":
+		return fmt.Errorf("%w: zip does not contain %s", ErrDownloadSignatureInvalid, serverImageChecksumSignatureZipEntry)
+	}
+
+	return verifySignature([]byte(checksumFileContent), signatureText)
+}
+
+// GetVerifiedAvailableVersion returns the version like GetAvailableVersion
+// after verifying the signature of the version file. The version is not
+// cached.
+func GetVerifiedAvailableVersion(versionURL string) (string, error) {
+	if !IsSignatureVerified() {
+		return GetAvailableVersion(versionURL)
+	}
+
+	versionFileContent, err := getURLContent(versionURL)
+	if err != nil {
+		return "", err
+	}
+
+	signatureText, err := getURLContent(versionURL + signatureSuffix)
+	if err != nil {
+		return "", fmt.Errorf("%w: could not get the signature of %s: %w", ErrDownloadSignatureInvalid, versionURL, err)
+	}
+
+	err = verifySignature(versionFileContent, string(signatureText))
+	if err != nil {
+		log.Error("Version file '%s' has an invalid signature: %v", versionURL, err)
+
+		return "", err
+	}
+
+	version := sanitizeBoxVersionString(string(versionFileContent))
+	log.Debug("Verified box version from '%s' is '%s'", versionURL, version)
+
+	return version, nil
+}
@@ -0,0 +1,272 @@
+package download
+
+import (
+	"archive/zip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+
+	"naksu/reporter"
+)
+
+type testSigningKey struct {
+	keyID      []byte
+	privateKey ed25519.PrivateKey
+	publicKey  string
+}
+
+func newTestSigningKey(t *testing.T, keyID string) testSigningKey {
+	t.Helper()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Could not generate key: %v", err)
+	}
+
+	encodedPublicKey := base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), keyID...), publicKey...))
+
+	return testSigningKey{
+		keyID:      []byte(keyID),
+		privateKey: privateKey,
+		publicKey:  "untrusted comment: minisign public key\n" + encodedPublicKey + "\n",
+	}
+}
+
+// sign returns a minisign signature of the message. Hashed signatures are
+// the default of minisign 0.10 and newer.
+func (key testSigningKey) sign(message []byte, hashed bool, trustedComment string) string {
+	algorithm := []byte("Ed")
+	if hashed {
+		hash := blake2b.Sum512(message)
+		algorithm = []byte("ED")
+		message = hash[:]
+	}
+
+	signature := ed25519.Sign(key.privateKey, message)
+	globalSignature := ed25519.Sign(key.privateKey, append(append([]byte{}, signature...), []byte(trustedComment)...))
+
+	return strings.Join([]string{
+		"untrusted comment: signature from minisign secret key",
+		base64.StdEncoding.EncodeToString(append(append(algorithm, key.keyID...), signature...)),
+		"trusted comment: " + trustedComment,
+		base64.StdEncoding.EncodeToString(globalSignature),
+	}, "\n") + "\n"
+}
+
+func useTrustedKeys(t *testing.T, keys ...testSigningKey) {
+	t.Helper()
+
+	originalKeys := trustedPublicKeys
+	t.Cleanup(func() { trustedPublicKeys = originalKeys })
+
+	trustedPublicKeys = []string{}
+	for _, key := range keys {
+		trustedPublicKeys = append(trustedPublicKeys, key.publicKey)
+	}
+}
+
+// getSignedChecksumEntries returns the checksum file of the image and its
+// signature made with a key trusted during the test
+func getSignedChecksumEntries(t *testing.T, checksumFile string) []testZipEntry {
+	t.Helper()
+
+	key := newTestSigningKey(t, "trusted1")
+	useTrustedKeys(t, key)
+
+	return []testZipEntry{
+		{serverImageChecksumZipEntry, []byte(checksumFile), zip.Deflate, false},
+		{serverImageChecksumSignatureZipEntry, []byte(key.sign([]byte(checksumFile), true, "image")), zip.Deflate, false},
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	trustedKey := newTestSigningKey(t, "trusted1")
+	untrustedKey := newTestSigningKey(t, "unknown1")
+	useTrustedKeys(t, trustedKey)
+
+	message := []byte("aff72a2cd83323e21c48d8686f3bcb7469b5131eb678a1bdcdbef27ff4f05b94  ktp.img\n")
+	hashedSignature := trustedKey.sign(message, true, "timestamp:1760000000\tfile:ktp.img.sha256")
+
+	testCases := []struct {
+		description string
+		message     []byte
+		signature   string
+		expectValid bool
+	}{
+		{"hashed signature", message, hashedSignature, true},
+		{"legacy signature", message, trustedKey.sign(message, false, "legacy"), true},
+		{"changed message", []byte(strings.Replace(string(message), "aff7", "bff7", 1)), hashedSignature, false},
+		{"changed trusted comment", message, strings.Replace(hashedSignature, "timestamp:1760000000", "timestamp:1760000001", 1), false},
+		{"untrusted key", message, untrustedKey.sign(message, true, "untrusted"), false},
+		{"not a signature", message, "foo", false},
+	}
+
+	for _, testCase := range testCases {
+		err := verifySignature(testCase.message, testCase.signature)
+
+		switch {
+		case testCase.expectValid && err != nil:
+			t.Errorf("Verifying %s failed: %v", testCase.description, err)
+		case !testCase.expectValid && !errors.Is(err, ErrDownloadSignatureInvalid):
+			t.Errorf("Verifying %s returned %v, expected ErrDownloadSignatureInvalid", testCase.description, err)
+		}
+	}
+
+	// Builds without trusted keys do not verify the signatures but never
+	// accept one either
+	useTrustedKeys(t)
+
+	err := verifySignature(message, hashedSignature)
+	if IsSignatureVerified() || !errors.Is(err, ErrDownloadSignatureInvalid) {
+		t.Errorf("Verifying without trusted keys returned %v, expected ErrDownloadSignatureInvalid", err)
+	}
+}
+
+func TestStreamServerImageWithoutTrustedKeys(t *testing.T) {
+	useTrustedKeys(t)
+
+	image := getTestImageContent()
+	zipContent := createTestZip(t, []testZipEntry{
+		{serverImageChecksumZipEntry, []byte(fmt.Sprintf("%x  ktp.img\n", sha256.Sum256(image))), zip.Deflate, false},
+		{serverImageZipEntry, image, zip.Deflate, false},
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		serveTestImage(writer, request, zipContent, `"v1"`)
+	}))
+	defer server.Close()
+
+	err := streamServerImage(server.URL, filepath.Join(t.TempDir(), "ktp.img"), filepath.Join(t.TempDir(), "naksu_last_image.zip"), "", reporter.Func(func(message string, value int) {}))
+	if err != nil {
+		t.Errorf("Streaming an unsigned image without trusted keys failed: %v", err)
+	}
+}
+
+func TestStreamServerImageVerifiesSignature(t *testing.T) {
+	key := newTestSigningKey(t, "trusted1")
+	useTrustedKeys(t, key)
+
+	image := []byte("signed image")
+	checksumFile := "0d4d3a1e1f4b5cd0c4d9ee5f77fb4a4c8f3b6b4bd1b6d46ce4a4ff0e8bfb7d8e  ktp.img\n"
+
+	testCases := []struct {
+		description   string
+		signature     string
+		expectedError error
+	}{
+		{"missing signature", "", ErrDownloadSignatureInvalid},
+		{"signature of another checksum", key.sign([]byte("another checksum\n"), true, "image"), ErrDownloadSignatureInvalid},
+		{"valid signature", key.sign([]byte(checksumFile), true, "image"), ErrDownloadedDiskImageCorrupted},
+	}
+
+	for _, testCase := range testCases {
+		entries := []testZipEntry{
+			{serverImageChecksumZipEntry, []byte(checksumFile), zip.Deflate, false},
+			{serverImageZipEntry, image, zip.Deflate, false},
+		}
+		if testCase.signature != "" {
+			entries = append(entries, testZipEntry{serverImageChecksumSignatureZipEntry, []byte(testCase.signature), zip.Deflate, false})
+		}
+
+		zipContent := createTestZip(t, entries)
+
+		server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			serveTestImage(writer, request, zipContent, `"v1"`)
+		}))
+
+		err := streamServerImage(server.URL, filepath.Join(t.TempDir(), "ktp.img"), filepath.Join(t.TempDir(), "naksu_last_image.zip"), "", reporter.Func(func(message string, value int) {}))
+		server.Close()
+
+		// The checksum does not match the image, so a valid signature is
+		// followed by the checksum check
+		if !errors.Is(err, testCase.expectedError) {
+			t.Errorf("Streaming image with %s returned %v, expected %v", testCase.description, err, testCase.expectedError)
+		}
+	}
+}
+
+func TestGetVerifiedAvailableVersion(t *testing.T) {
+	key := newTestSigningKey(t, "trusted1")
+	useTrustedKeys(t, key)
+
+	versionFile := "SERVER2025K\n"
+	signatures := map[string]string{
+		"/signed.ver.minisig":   key.sign([]byte(versionFile), true, "version"),
+		"/tampered.ver.minisig": key.sign([]byte("SERVER2024K\n"), true, "version"),
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if strings.HasSuffix(request.URL.Path, ".ver") {
+			_, _ = writer.Write([]byte(versionFile))
+
+			return
+		}
+
+		signature, ok := signatures[request.URL.Path]
+		if !ok {
+			http.NotFound(writer, request)
+
+			return
+		}
+
+		_, _ = writer.Write([]byte(signature))
+	}))
+	defer server.Close()
+
+	version, err := GetVerifiedAvailableVersion(server.URL + "/signed.ver")
+	if err != nil || version != "SERVER2025K" {
+		t.Errorf("Signed version is '%s' with error %v", version, err)
+	}
+
+	for _, path := range []string{"/tampered.ver", "/unsigned.ver"} {
+		_, err = GetVerifiedAvailableVersion(server.URL + path)
+		if !errors.Is(err, ErrDownloadSignatureInvalid) {
+			t.Errorf("Version %s returned %v, expected ErrDownloadSignatureInvalid", path, err)
+		}
+	}
+}
+
+func TestGetLocalVersion(t *testing.T) {
+	key := newTestSigningKey(t, "trusted1")
+	useTrustedKeys(t, key)
+
+	versionFile := "SERVER2025K\n"
+	directory := t.TempDir()
+	files := map[string]string{
+		"signed.ver":           versionFile,
+		"signed.ver.minisig":   key.sign([]byte(versionFile), true, "version"),
+		"tampered.ver":         versionFile,
+		"tampered.ver.minisig": key.sign([]byte("SERVER2024K\n"), true, "version"),
+		"unsigned.ver":         versionFile,
+	}
+
+	for name, content := range files {
+		err := os.WriteFile(filepath.Join(directory, name), []byte(content), 0o600)
+		if err != nil {
+			t.Fatalf("Could not write %s: %v", name, err)
+		}
+	}
+
+	version, err := GetLocalVersion(filepath.Join(directory, "signed.ver"))
+	if err != nil || version != "SERVER2025K" {
+		t.Errorf("Signed version is '%s' with error %v", version, err)
+	}
+
+	for _, name := range []string{"tampered.ver", "unsigned.ver"} {
+		_, err = GetLocalVersion(filepath.Join(directory, name))
+		if !errors.Is(err, ErrDownloadSignatureInvalid) {
+			t.Errorf("Version %s returned %v, expected ErrDownloadSignatureInvalid", name, err)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package download
+
+// zipStream reads the entries of a zip file in the order they are stored, so
+// that the server image can be uncompressed while it is downloaded. Unlike
+// archive/zip it does not need the central directory at the end of the file.
+// Entries which cannot be read without the central directory (stored entries
+// with the sizes in a data descriptor) return errZipNotStreamable.
+
+import (
+	"bufio"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	zipLocalFileHeaderSignature  = 0x04034b50
+	zipCentralDirectorySignature = 0x02014b50
+	zipEndOfCentralDirSignature  = 0x06054b50
+	zipDataDescriptorSignature   = 0x08074b50
+
+	zipLocalFileHeaderLength = 26 // without the signature
+	zipFlagEncrypted         = 0x1
+	zipFlagDataDescriptor    = 0x8
+	zipMethodStore           = 0
+	zipMethodDeflate         = 8
+	zip64ExtraFieldID        = 0x0001
+	zip64SizePlaceholder     = 0xffffffff
+
+	zipStreamBufferSize = 1024 * 1024
+)
+
+var errZipNotStreamable = errors.New("zip cannot be read as a stream")
+
+// zipStreamEntry is the local file header of an entry
+type zipStreamEntry struct {
+	Name             string
+	Flags            uint16
+	Method           uint16
+	CRC32            uint32
+	CompressedSize   uint64
+	UncompressedSize uint64
+}
+
+func (entry *zipStreamEntry) hasDataDescriptor() bool {
+	return entry.Flags&zipFlagDataDescriptor != 0
+}
+
+// countingReader counts the bytes read from the buffered reader. It
+// implements io.ByteReader so that flate does not read past the compressed
+// data.
+type countingReader struct {
+	reader *bufio.Reader
+	count  uint64
+}
+
+func (counting *countingReader) Read(buffer []byte) (int, error) {
+	n, err := counting.reader.Read(buffer)
+	counting.count += uint64(n)
+
+	return n, err
+}
+
+func (counting *countingReader) ReadByte() (byte, error) {
+	b, err := counting.reader.ReadByte()
+	if err == nil {
+		counting.count++
+	}
+
+	return b, err
+}
+
+func (counting *countingReader) discard(n int) error {
+	discarded, err := counting.reader.Discard(n)
+	counting.count += uint64(discarded)
+
+	return err
+}
+
+type zipStream struct {
+	reader *countingReader
+
+	entry           *zipStreamEntry
+	content         io.Reader
+	contentStart    uint64
+	contentCRC32    hash.Hash32
+	contentSize     uint64
+	contentFinished bool
+}
+
+func newZipStream(reader io.Reader) *zipStream {
+	return &zipStream{
+		reader:          &countingReader{reader: bufio.NewReaderSize(reader, zipStreamBufferSize), count: 0},
+		entry:           nil,
+		content:         nil,
+		contentStart:    0,
+		contentCRC32:    nil,
+		contentSize:     0,
+		contentFinished: false,
+	}
+}
+
+// Next skips the rest of the current entry and returns the next entry. The
+// content of the entry is read with Read. io.EOF is returned when the central
+// directory is reached.
+func (stream *zipStream) Next() (*zipStreamEntry, error) {
+	if stream.entry != nil {
+		_, err := io.Copy(io.Discard, stream)
+		if err != nil {
+			return nil, err
+		}
+
+		err = stream.finishEntry()
+		if err != nil {
+			return nil, err
+		}
+
+		stream.entry = nil
+	}
+
+	var signature uint32
+
+	err := binary.Read(stream.reader, binary.LittleEndian, &signature)
+	if err != nil {
+		return nil, fmt.Errorf("could not read zip header: %w", err)
+	}
+
+	switch signature {
+	case zipLocalFileHeaderSignature:
+	case zipCentralDirectorySignature, zipEndOfCentralDirSignature:
+		return nil, io.EOF
+	default:
+		return nil, fmt.Errorf("unexpected zip header signature %#x", signature)
+	}
+
+	entry, err := stream.readLocalFileHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	err = stream.openEntry(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func (stream *zipStream) readLocalFileHeader() (*zipStreamEntry, error) {
+	header := make([]byte, zipLocalFileHeaderLength)
+
+	_, err := io.ReadFull(stream.reader, header)
+	if err != nil {
+		return nil, fmt.Errorf("could not read zip local file header: %w", err)
+	}
+
+	// Skip the version needed to extract and the modification time
+	entry := &zipStreamEntry{
+		Name:             "",
+		Flags:            binary.LittleEndian.Uint16(header[2:4]),
+		Method:           binary.LittleEndian.Uint16(header[4:6]),
+		CRC32:            binary.LittleEndian.Uint32(header[10:14]),
+		CompressedSize:   uint64(binary.LittleEndian.Uint32(header[14:18])),
+		UncompressedSize: uint64(binary.LittleEndian.Uint32(header[18:22])),
+	}
+
+	name := make([]byte, binary.LittleEndian.Uint16(header[22:24]))
+	extra := make([]byte, binary.LittleEndian.Uint16(header[24:26]))
+
+	_, err = io.ReadFull(stream.reader, name)
+	if err == nil {
+		_, err = io.ReadFull(stream.reader, extra)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not read zip local file header: %w", err)
+	}
+
+	entry.Name = string(name)
+	readZip64ExtraField(entry, extra)
+
+	return entry, nil
+}
+
+// readZip64ExtraField replaces the placeholder sizes of the local file header
+// with the sizes in the zip64 extra field
+func readZip64ExtraField(entry *zipStreamEntry, extra []byte) {
+	for len(extra) >= 4 {
+		fieldID := binary.LittleEndian.Uint16(extra[0:2])
+		fieldLength := int(binary.LittleEndian.Uint16(extra[2:4]))
+		extra = extra[4:]
+
+		if fieldLength > len(extra) {
+			return
+		}
+
+		field := extra[:fieldLength]
+		extra = extra[fieldLength:]
+
+		if fieldID != zip64ExtraFieldID {
+			continue
+		}
+
+		if entry.UncompressedSize == zip64SizePlaceholder && len(field) >= 8 {
+			entry.UncompressedSize = binary.LittleEndian.Uint64(field[:8])
+			field = field[8:]
+		}
+
+		if entry.CompressedSize == zip64SizePlaceholder && len(field) >= 8 {
+			entry.CompressedSize = binary.LittleEndian.Uint64(field[:8])
+		}
+	}
+}
+
+func (stream *zipStream) openEntry(entry *zipStreamEntry) error {
+	if entry.Flags&zipFlagEncrypted != 0 {
+		return fmt.Errorf("%w: %s is encrypted", errZipNotStreamable, entry.Name)
+	}
+
+	switch {
+	case entry.Method == zipMethodDeflate:
+		// The reader implements io.ByteReader, so flate stops at the end of
+		// the compressed data
+		stream.content = flate.NewReader(stream.reader)
+	case entry.Method == zipMethodStore && !entry.hasDataDescriptor():
+		stream.content = io.LimitReader(stream.reader, int64(entry.CompressedSize)) // #nosec
+	default:
+		return fmt.Errorf("%w: %s uses method %d with flags %#x", errZipNotStreamable, entry.Name, entry.Method, entry.Flags)
+	}
+
+	stream.entry = entry
+	stream.contentStart = stream.reader.count
+	stream.contentCRC32 = crc32.NewIEEE()
+	stream.contentSize = 0
+	stream.contentFinished = false
+
+	return nil
+}
+
+// Read reads the uncompressed content of the current entry
+func (stream *zipStream) Read(buffer []byte) (int, error) {
+	if stream.entry == nil || stream.contentFinished {
+		return 0, io.EOF
+	}
+
+	n, err := stream.content.Read(buffer)
+	stream.contentCRC32.Write(buffer[:n])
+	stream.contentSize += uint64(n)
+
+	if errors.Is(err, io.EOF) {
+		stream.contentFinished = true
+	}
+
+	return n, err
+}
+
+// finishEntry reads the data descriptor of the entry and checks the sizes and
+// the CRC-32 of the content
+func (stream *zipStream) finishEntry() error {
+	entry := stream.entry
+	compressedSize := stream.reader.count - stream.contentStart
+
+	if entry.hasDataDescriptor() {
+		err := stream.readDataDescriptor(entry, compressedSize)
+		if err != nil {
+			return err
+		}
+	}
+
+	if compressedSize != entry.CompressedSize || stream.contentSize != entry.UncompressedSize {
+		return fmt.Errorf("%w: %s has %d bytes (%d compressed), expected %d (%d compressed)", ErrDownloadedDiskImageCorrupted, entry.Name, stream.contentSize, compressedSize, entry.UncompressedSize, entry.CompressedSize)
+	}
+
+	if stream.contentCRC32.Sum32() != entry.CRC32 {
+		return fmt.Errorf("%w: CRC-32 of %s differs", ErrDownloadedDiskImageCorrupted, entry.Name)
+	}
+
+	return nil
+}
+
+// readDataDescriptor reads the CRC-32 and the sizes following the content.
+// The sizes are 8 bytes long for zip64 entries, which is detected by
+// comparing them with the number of bytes read.
+func (stream *zipStream) readDataDescriptor(entry *zipStreamEntry, compressedSize uint64) error {
+	signature, err := stream.reader.reader.Peek(4)
+	if err != nil {
+		return fmt.Errorf("could not read zip data descriptor: %w", err)
+	}
+
+	if binary.LittleEndian.Uint32(signature) == zipDataDescriptorSignature {
+		err = stream.reader.discard(len(signature))
+		if err != nil {
+			return fmt.Errorf("could not read zip data descriptor: %w", err)
+		}
+	}
+
+	descriptor, err := stream.reader.reader.Peek(20)
+	if err != nil && len(descriptor) < 12 {
+		return fmt.Errorf("could not read zip data descriptor: %w", err)
+	}
+
+	entry.CRC32 = binary.LittleEndian.Uint32(descriptor[0:4])
+	entry.CompressedSize = uint64(binary.LittleEndian.Uint32(descriptor[4:8]))
+	entry.UncompressedSize = uint64(binary.LittleEndian.Uint32(descriptor[8:12]))
+	descriptorLength := 12
+
+	if (entry.CompressedSize != compressedSize || entry.UncompressedSize != stream.contentSize) && len(descriptor) == 20 {
+		entry.CompressedSize = binary.LittleEndian.Uint64(descriptor[4:12])
+		entry.UncompressedSize = binary.LittleEndian.Uint64(descriptor[12:20])
+		descriptorLength = 20
+	}
+
+	err = stream.reader.discard(descriptorLength)
+	if err != nil {
+		return fmt.Errorf("could not read zip data descriptor: %w", err)
+	}
+
+	return nil
+}
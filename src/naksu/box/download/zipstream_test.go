@@ -0,0 +1,123 @@
+package download
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"io"
+	"testing"
+)
+
+type testZipEntry struct {
+	name    string
+	content []byte
+	method  uint16
+	raw     bool
+}
+
+// createTestZip returns a zip of the entries. Raw entries are stored without
+// a data descriptor.
+func createTestZip(t *testing.T, entries []testZipEntry) []byte {
+	t.Helper()
+
+	var buffer bytes.Buffer
+
+	writer := zip.NewWriter(&buffer)
+
+	for _, entry := range entries {
+		var (
+			entryWriter io.Writer
+			err         error
+		)
+
+		if entry.raw {
+			entryWriter, err = writer.CreateRaw(&zip.FileHeader{ // nolint: exhaustruct
+				Name:               entry.name,
+				Method:             zip.Store,
+				CRC32:              crc32.ChecksumIEEE(entry.content),
+				CompressedSize64:   uint64(len(entry.content)),
+				UncompressedSize64: uint64(len(entry.content)),
+			})
+		} else {
+			entryWriter, err = writer.CreateHeader(&zip.FileHeader{Name: entry.name, Method: entry.method}) // nolint: exhaustruct
+		}
+
+		if err != nil {
+			t.Fatalf("Could not create zip entry %s: %v", entry.name, err)
+		}
+
+		_, err = entryWriter.Write(entry.content)
+		if err != nil {
+			t.Fatalf("Could not write zip entry %s: %v", entry.name, err)
+		}
+	}
+
+	err := writer.Close()
+	if err != nil {
+		t.Fatalf("Could not close zip: %v", err)
+	}
+
+	return buffer.Bytes()
+}
+
+func TestZipStreamReadsEntries(t *testing.T) {
+	entries := []testZipEntry{
+		{"ytl/ktp.img.sha256", []byte("checksum\n"), zip.Deflate, false},
+		{"ytl/ktp.img", bytes.Repeat([]byte("image"), 100000), zip.Deflate, false},
+		{"ytl/README", []byte("stored without a data descriptor"), zip.Store, true},
+	}
+
+	stream := newZipStream(bytes.NewReader(createTestZip(t, entries)))
+
+	for _, expected := range entries {
+		entry, err := stream.Next()
+		if err != nil {
+			t.Fatalf("Could not read entry %s: %v", expected.name, err)
+		}
+
+		content, err := io.ReadAll(stream)
+		if err != nil {
+			t.Fatalf("Could not read content of %s: %v", expected.name, err)
+		}
+
+		if entry.Name != expected.name || !bytes.Equal(content, expected.content) {
+			t.Errorf("Read entry %s with %d bytes, expected %s with %d bytes", entry.Name, len(content), expected.name, len(expected.content))
+		}
+	}
+
+	_, err := stream.Next()
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("Expected io.EOF at the central directory, got %v", err)
+	}
+}
+
+func TestZipStreamStoredWithDataDescriptor(t *testing.T) {
+	stream := newZipStream(bytes.NewReader(createTestZip(t, []testZipEntry{
+		{"ytl/ktp.img", []byte("image"), zip.Store, false},
+	})))
+
+	_, err := stream.Next()
+	if !errors.Is(err, errZipNotStreamable) {
+		t.Errorf("Expected errZipNotStreamable, got %v", err)
+	}
+}
+
+func TestZipStreamDetectsCorruption(t *testing.T) {
+	zipContent := createTestZip(t, []testZipEntry{
+		{"ytl/ktp.img", []byte("uncorrupted image"), zip.Store, true},
+	})
+	corruptedZip := bytes.Replace(zipContent, []byte("uncorrupted"), []byte("_corrupted_"), 1)
+
+	stream := newZipStream(bytes.NewReader(corruptedZip))
+
+	_, err := stream.Next()
+	if err != nil {
+		t.Fatalf("Could not read entry: %v", err)
+	}
+
+	_, err = stream.Next()
+	if !errors.Is(err, ErrDownloadedDiskImageCorrupted) {
+		t.Errorf("Expected ErrDownloadedDiskImageCorrupted, got %v", err)
+	}
+}
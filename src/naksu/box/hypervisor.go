@@ -0,0 +1,135 @@
+package box
+
+// The VMs are run by the hypervisor selected in naksu.ini ([environment]
+// hypervisor). VirtualBox is the default and available on all platforms. The
+// QEMU/KVM backend is registered on Linux where KVM is part of the kernel
+// and does not break on kernel updates like the VirtualBox modules do.
+
+import (
+	"sync"
+
+	"naksu/config"
+	"naksu/constants"
+	"naksu/log"
+	"naksu/xlate"
+)
+
+// VMSpec is the VM a hypervisor creates for a server
+type VMSpec struct {
+	Name       string
+	BoxType    string
+	BoxVersion string
+	// ImagePath is the raw disk image the disk of the VM is converted from
+	ImagePath  string
+	CPUs       int
+	MemoryMB   uint64
+	DiskSizeMB uint64
+	VRAMMB     uint64
+	// SharedFolder is the host directory shared to the VM as media_usb1
+	SharedFolder string
+}
+
+// Hypervisor creates and runs the VMs of the servers. The VMs are identified
+// by their names (see GetVMName).
+type Hypervisor interface {
+	// Name returns the config value of the hypervisor (see
+	// constants.AvailableHypervisors)
+	Name() string
+	// IsInstalled returns true if the hypervisor can be executed
+	IsInstalled() bool
+
+	IsVMInstalled(vmName string) (bool, error)
+	CreateVM(spec VMSpec) error
+	RemoveVM(vmName string) error
+
+	StartVM(vmName string, options StartOptions) error
+	// ResumeVM continues the VM from the saved or paused state
+	ResumeVM(vmName string, state string) error
+	// ShutdownVM presses the ACPI power button without waiting for the VM to
+	// power off
+	ShutdownVM(vmName string) error
+	// SaveStateVM saves the state of the VM to the disk and stops it
+	SaveStateVM(vmName string) error
+	PowerOffVM(vmName string) error
+	// GetVMState returns the state of the VM (e.g. VMStateRunning). The state
+	// may be cached until ResetVMStateCache is called.
+	GetVMState(vmName string) (string, error)
+	ResetVMStateCache(vmName string)
+
+	TakeSnapshot(vmName string, name string, description string) error
+	// RestoreSnapshot and DeleteSnapshot take the UUID of the snapshot (see
+	// SnapshotInfo)
+	RestoreSnapshot(vmName string, snapshotID string) error
+	DeleteSnapshot(vmName string, snapshotID string) error
+	GetSnapshots(vmName string) ([]SnapshotInfo, error)
+
+	// CloneDisk writes a VMDK copy of the disk of the VM
+	CloneDisk(vmName string, clonePath string) error
+	GetDiskLocation(vmName string) string
+	// DiskSizeOnDisk returns the size of the disk image in megabytes
+	DiskSizeOnDisk(location string) (uint64, error)
+
+	GetGuestProperty(vmName string, property string) string
+	GetLogDir(vmName string) string
+	// GetConsoleURL returns the URL of the remote console of the running VM
+	// or an empty string if the console is not enabled
+	GetConsoleURL(vmName string) (string, error)
+}
+
+// hypervisors are the hypervisors available on this platform by their names
+var hypervisors = map[string]Hypervisor{
+	constants.HypervisorVirtualBox: virtualBox{},
+}
+
+var unavailableHypervisorWarning sync.Once
+
+// registerHypervisor makes a platform-specific hypervisor available
+func registerHypervisor(hypervisor Hypervisor) {
+	hypervisors[hypervisor.Name()] = hypervisor
+}
+
+// getHypervisor returns the hypervisor set in naksu.ini or VirtualBox if the
+// hypervisor is not available on this platform
+func getHypervisor() Hypervisor {
+	name := config.GetHypervisor()
+
+	hypervisor, ok := hypervisors[name]
+	if !ok {
+		unavailableHypervisorWarning.Do(func() {
+			log.Warning("Hypervisor %s set in naksu.ini is not available on this platform, using %s", name, constants.HypervisorVirtualBox)
+		})
+
+		return hypervisors[constants.HypervisorVirtualBox]
+	}
+
+	return hypervisor
+}
+
+// IsHypervisorAvailable returns true if the hypervisor (see
+// constants.AvailableHypervisors) is available on this platform
+func IsHypervisorAvailable(name string) bool {
+	_, ok := hypervisors[name]
+
+	return ok
+}
+
+// GetHypervisorName returns the name of the hypervisor running the VMs
+func GetHypervisorName() string {
+	return getHypervisor().Name()
+}
+
+// IsHypervisorInstalled returns true if the hypervisor running the VMs can be
+// executed
+func IsHypervisorInstalled() bool {
+	return getHypervisor().IsInstalled()
+}
+
+// GetTranslatedHypervisorMissingMessage returns the error message shown when
+// the hypervisor cannot be executed
+func GetTranslatedHypervisorMissingMessage() string {
+	if GetHypervisorName() == constants.HypervisorQEMU {
+		return xlate.Get("Could not execute qemu-system-x86_64 or qemu-img. Are you sure you have installed QEMU?")
+	}
+
+	return xlate.Get("Could not execute VBoxManage. Are you sure you have installed Oracle VirtualBox?")
+}
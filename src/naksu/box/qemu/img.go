@@ -0,0 +1,144 @@
+package qemu
+
+// qemu-img creates, converts and snapshots the disk images while the VM is
+// not running
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"naksu/log"
+)
+
+// The QEMU executables which must be in the PATH
+const (
+	ImgBinary    = "qemu-img"
+	SystemBinary = "qemu-system-x86_64"
+)
+
+// ImageInfo is the information qemu-img info prints of a disk image
+type ImageInfo struct {
+	Filename    string         `json:"filename"`
+	Format      string         `json:"format"`
+	VirtualSize uint64         `json:"virtual-size"`
+	ActualSize  uint64         `json:"actual-size"`
+	Snapshots   []SnapshotInfo `json:"snapshots"`
+}
+
+// SnapshotInfo is an internal snapshot of a qcow2 disk image
+type SnapshotInfo struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// VMStateSize is the size of the saved RAM and device state of a
+	// snapshot taken from a running VM
+	VMStateSize uint64 `json:"vm-state-size"`
+	DateSec     int64  `json:"date-sec"`
+	DateNsec    int64  `json:"date-nsec"`
+}
+
+// TakenAt returns the time the snapshot was taken
+func (snapshot SnapshotInfo) TakenAt() time.Time {
+	return time.Unix(snapshot.DateSec, snapshot.DateNsec)
+}
+
+// IsInstalled returns true if qemu-img and qemu-system-x86_64 are in the PATH
+func IsInstalled() bool {
+	for _, binary := range []string{ImgBinary, SystemBinary} {
+		path, err := exec.LookPath(binary)
+		if err != nil {
+			log.Debug("%s was not found: %v", binary, err)
+
+			return false
+		}
+
+		log.Debug("%s: %s", binary, path)
+	}
+
+	return true
+}
+
+// RunImg runs qemu-img with the arguments and returns its standard output.
+// The error contains the standard error of qemu-img.
+func RunImg(ctx context.Context, args ...string) (string, error) {
+	log.Debug("qemu-img: %s", strings.Join(args, " "))
+
+	var stdout, stderr bytes.Buffer
+
+	/* #nosec */
+	cmd := exec.CommandContext(ctx, ImgBinary, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return stdout.String(), fmt.Errorf("qemu-img %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.String(), nil
+}
+
+// ConvertImage converts the source image to the target image of the given
+// format (e.g. "qcow2" or "vmdk")
+func ConvertImage(ctx context.Context, sourcePath string, sourceFormat string, targetPath string, targetFormat string) error {
+	_, err := RunImg(ctx, "convert", "-f", sourceFormat, "-O", targetFormat, sourcePath, targetPath)
+
+	return err
+}
+
+// ResizeImage sets the virtual size of the qcow2 image in megabytes
+func ResizeImage(ctx context.Context, path string, sizeMegabytes uint64) error {
+	_, err := RunImg(ctx, "resize", "-f", "qcow2", path, fmt.Sprintf("%dM", sizeMegabytes))
+
+	return err
+}
+
+// CreateSnapshot takes an internal snapshot of the qcow2 image
+func CreateSnapshot(ctx context.Context, path string, name string) error {
+	_, err := RunImg(ctx, "snapshot", "-c", name, path)
+
+	return err
+}
+
+// ApplySnapshot reverts the qcow2 image to the snapshot with the given ID or
+// name
+func ApplySnapshot(ctx context.Context, path string, snapshot string) error {
+	_, err := RunImg(ctx, "snapshot", "-a", snapshot, path)
+
+	return err
+}
+
+// DeleteSnapshot deletes the snapshot with the given ID or name from the
+// qcow2 image
+func DeleteSnapshot(ctx context.Context, path string, snapshot string) error {
+	_, err := RunImg(ctx, "snapshot", "-d", snapshot, path)
+
+	return err
+}
+
+// GetImageInfo returns the information of the image. The image may be in use
+// by a running VM.
+func GetImageInfo(ctx context.Context, path string) (ImageInfo, error) {
+	output, err := RunImg(ctx, "info", "--output=json", "--force-share", path)
+	if err != nil {
+		return ImageInfo{}, err // nolint: exhaustruct
+	}
+
+	return ParseImageInfo(output)
+}
+
+// ParseImageInfo parses the JSON output of qemu-img info
+func ParseImageInfo(output string) (ImageInfo, error) {
+	var info ImageInfo
+
+	err := json.Unmarshal([]byte(output), &info)
+	if err != nil {
+		return info, fmt.Errorf("could not parse qemu-img info: %w", err)
+	}
+
+	return info, nil
+}
@@ -0,0 +1,31 @@
+package qemu
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseImageInfo(t *testing.T) {
+	info, err := ParseImageInfo(`{
+		"virtual-size": 59055800320,
+		"filename": "disk.qcow2",
+		"format": "qcow2",
+		"actual-size": 5368709120,
+		"snapshots": [
+			{"icount": 0, "vm-clock-nsec": 0, "name": "Installed", "date-sec": 1760000000, "date-nsec": 5000, "vm-clock-sec": 0, "id": "1", "vm-state-size": 0},
+			{"vm-clock-nsec": 1, "name": "Before exam", "date-sec": 1760003600, "date-nsec": 0, "vm-clock-sec": 42, "id": "2", "vm-state-size": 1048576}
+		]
+	}`)
+	if err != nil {
+		t.Fatalf("Could not parse image info: %v", err)
+	}
+
+	if info.ActualSize != 5368709120 || len(info.Snapshots) != 2 {
+		t.Fatalf("Unexpected image info %+v", info)
+	}
+
+	snapshot := info.Snapshots[1]
+	if snapshot.ID != "2" || snapshot.Name != "Before exam" || snapshot.VMStateSize != 1048576 || !snapshot.TakenAt().Equal(time.Unix(1760003600, 0)) {
+		t.Errorf("Unexpected snapshot %+v", snapshot)
+	}
+}
@@ -0,0 +1,222 @@
+package qemu
+
+// QMP (QEMU Machine Protocol) is the JSON protocol for controlling a running
+// QEMU. The server greets the client, the client enables the commands with
+// qmp_capabilities and then sends one command at a time. Asynchronous events
+// may arrive between the responses.
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"naksu/log"
+)
+
+// ErrQMP is returned when QEMU responds to a command with an error
+var ErrQMP = errors.New("qmp command failed")
+
+// Monitor is a connection to the QMP socket of a running QEMU
+type Monitor struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+}
+
+type qmpCommand struct {
+	Execute   string `json:"execute"`
+	Arguments any    `json:"arguments,omitempty"`
+}
+
+type qmpError struct {
+	Class       string `json:"class"`
+	Description string `json:"desc"`
+}
+
+type qmpResponse struct {
+	Greeting json.RawMessage `json:"QMP"`
+	Return   json.RawMessage `json:"return"`
+	Error    *qmpError       `json:"error"`
+	Event    string          `json:"event"`
+}
+
+// Status is the response of query-status
+type Status struct {
+	Running bool   `json:"running"`
+	Status  string `json:"status"`
+}
+
+// MigrationInfo is the response of query-migrate
+type MigrationInfo struct {
+	Status       string `json:"status"`
+	ErrorMessage string `json:"error-desc"`
+}
+
+// VNCInfo is the response of query-vnc
+type VNCInfo struct {
+	Enabled bool   `json:"enabled"`
+	Host    string `json:"host"`
+	Service string `json:"service"`
+}
+
+// Connect connects to the QMP socket and negotiates the capabilities. Each
+// command and its response must complete within the timeout.
+func Connect(socketPath string, timeout time.Duration) (*Monitor, error) {
+	conn, err := net.DialTimeout("unix", socketPath, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to qmp socket %s: %w", socketPath, err)
+	}
+
+	monitor := newMonitor(conn, timeout)
+
+	err = monitor.negotiate()
+	if err != nil {
+		_ = monitor.Close()
+
+		return nil, err
+	}
+
+	return monitor, nil
+}
+
+// newMonitor returns a monitor using an open connection
+func newMonitor(conn net.Conn, timeout time.Duration) *Monitor {
+	return &Monitor{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		timeout: timeout,
+	}
+}
+
+// negotiate reads the greeting and leaves the capabilities negotiation mode
+func (monitor *Monitor) negotiate() error {
+	err := monitor.conn.SetDeadline(time.Now().Add(monitor.timeout))
+	if err != nil {
+		return fmt.Errorf("could not set qmp deadline: %w", err)
+	}
+
+	response, err := monitor.readResponse()
+	if err != nil {
+		return fmt.Errorf("could not read qmp greeting: %w", err)
+	}
+
+	if response.Greeting == nil {
+		return errors.New("qmp socket did not send a greeting")
+	}
+
+	return monitor.Execute("qmp_capabilities", nil, nil)
+}
+
+// Close closes the connection
+func (monitor *Monitor) Close() error {
+	return monitor.conn.Close()
+}
+
+// Execute runs the command with the arguments (nil for none) and decodes the
+// return value to result (nil to ignore it)
+func (monitor *Monitor) Execute(command string, arguments any, result any) error {
+	err := monitor.conn.SetDeadline(time.Now().Add(monitor.timeout))
+	if err != nil {
+		return fmt.Errorf("could not set qmp deadline: %w", err)
+	}
+
+	request, err := json.Marshal(qmpCommand{Execute: command, Arguments: arguments})
+	if err != nil {
+		return fmt.Errorf("could not encode qmp command %s: %w", command, err)
+	}
+
+	log.Debug("QMP: %s", request)
+
+	_, err = monitor.conn.Write(append(request, '\n'))
+	if err != nil {
+		return fmt.Errorf("could not send qmp command %s: %w", command, err)
+	}
+
+	for {
+		response, err := monitor.readResponse()
+		if err != nil {
+			return fmt.Errorf("could not read response to qmp command %s: %w", command, err)
+		}
+
+		switch {
+		case response.Event != "":
+			log.Debug("QMP event: %s", response.Event)
+
+			continue
+		case response.Error != nil:
+			return fmt.Errorf("%w: %s: %s (%s)", ErrQMP, command, response.Error.Description, response.Error.Class)
+		case response.Return == nil:
+			return fmt.Errorf("unexpected response to qmp command %s", command)
+		}
+
+		if result == nil {
+			return nil
+		}
+
+		err = json.Unmarshal(response.Return, result)
+		if err != nil {
+			return fmt.Errorf("could not decode response to qmp command %s: %w", command, err)
+		}
+
+		return nil
+	}
+}
+
+// HumanMonitorCommand runs a command of the human monitor (HMP) which has
+// no QMP equivalent, e.g. savevm. HMP reports errors only in the output.
+func (monitor *Monitor) HumanMonitorCommand(commandLine string) (string, error) {
+	var output string
+
+	err := monitor.Execute("human-monitor-command", map[string]string{"command-line": commandLine}, &output)
+	if err != nil {
+		return "", err
+	}
+
+	return output, nil
+}
+
+// QueryStatus returns the run status of the VM
+func (monitor *Monitor) QueryStatus() (Status, error) {
+	var status Status
+
+	err := monitor.Execute("query-status", nil, &status)
+
+	return status, err
+}
+
+// QueryMigrate returns the status of the migration (e.g. saving the state)
+func (monitor *Monitor) QueryMigrate() (MigrationInfo, error) {
+	var info MigrationInfo
+
+	err := monitor.Execute("query-migrate", nil, &info)
+
+	return info, err
+}
+
+// QueryVNC returns the VNC server of the VM
+func (monitor *Monitor) QueryVNC() (VNCInfo, error) {
+	var info VNCInfo
+
+	err := monitor.Execute("query-vnc", nil, &info)
+
+	return info, err
+}
+
+func (monitor *Monitor) readResponse() (qmpResponse, error) {
+	var response qmpResponse
+
+	line, err := monitor.reader.ReadBytes('\n')
+	if err != nil {
+		return response, err
+	}
+
+	err = json.Unmarshal(line, &response)
+	if err != nil {
+		return response, fmt.Errorf("could not decode qmp response '%s': %w", line, err)
+	}
+
+	return response, nil
+}
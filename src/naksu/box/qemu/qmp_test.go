@@ -0,0 +1,98 @@
+package qemu
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveQMP accepts one connection to the socket, greets the client and
+// answers each command with the response of the command name
+func serveQMP(t *testing.T, responses map[string]string) string {
+	t.Helper()
+
+	socketPath := filepath.Join(t.TempDir(), "qmp.sock")
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Could not listen to %s: %v", socketPath, err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		fmt.Fprintln(conn, `{"QMP": {"version": {"qemu": {"major": 8, "minor": 2, "micro": 2}}, "capabilities": []}}`)
+
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			request := scanner.Text()
+			for command, response := range responses {
+				if strings.Contains(request, fmt.Sprintf(`"execute":"%s"`, command)) {
+					fmt.Fprintln(conn, response)
+				}
+			}
+		}
+	}()
+
+	return socketPath
+}
+
+func TestMonitorQueryStatus(t *testing.T) {
+	socketPath := serveQMP(t, map[string]string{
+		"qmp_capabilities": `{"return": {}}`,
+		"query-status": `{"timestamp": {"seconds": 1, "microseconds": 2}, "event": "RESUME"}` + "\n" +
+			`{"return": {"status": "running", "singlestep": false, "running": true}}`,
+	})
+
+	monitor, err := Connect(socketPath, time.Second)
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer monitor.Close()
+
+	status, err := monitor.QueryStatus()
+	if err != nil {
+		t.Fatalf("Could not query status: %v", err)
+	}
+
+	if status.Status != "running" || !status.Running {
+		t.Errorf("Unexpected status %+v", status)
+	}
+}
+
+func TestMonitorCommandError(t *testing.T) {
+	socketPath := serveQMP(t, map[string]string{
+		"qmp_capabilities": `{"return": {}}`,
+		"cont":             `{"error": {"class": "GenericError", "desc": "Migration is not finalized yet"}}`,
+	})
+
+	monitor, err := Connect(socketPath, time.Second)
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer monitor.Close()
+
+	err = monitor.Execute("cont", nil, nil)
+	if !errors.Is(err, ErrQMP) || !strings.Contains(err.Error(), "Migration is not finalized yet") {
+		t.Errorf("Expected a QMP error, got %v", err)
+	}
+}
+
+func TestMonitorTimesOut(t *testing.T) {
+	socketPath := serveQMP(t, map[string]string{})
+
+	_, err := Connect(socketPath, 100*time.Millisecond)
+	if err == nil {
+		t.Error("Connect succeeded although the capabilities were not negotiated")
+	}
+}
@@ -0,0 +1,1071 @@
+package box
+
+// qemuKVM runs the VMs with QEMU/KVM. Each VM is a directory under ktp/qemu
+// holding the qcow2 disk, the settings (vm.json), the EFI variables, the QMP
+// socket and the logs. The snapshots are internal snapshots of the qcow2
+// disk. QEMU is started with -no-shutdown, so naksu sees the guest powering
+// off and quits QEMU itself. A QEMU which exits without naksu removing its
+// pidfile has crashed.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"naksu/box/qemu"
+	"naksu/config"
+	"naksu/constants"
+	"naksu/host"
+	"naksu/log"
+	"naksu/mebroutines"
+)
+
+const (
+	qemuDiskFile       = "disk.qcow2"
+	qemuSettingsFile   = "vm.json"
+	qemuEFIVarsFile    = "efivars.fd"
+	qemuSocketFile     = "qmp.sock"
+	qemuPIDFile        = "qemu.pid"
+	qemuSavedStateFile = "saved.state"
+	qemuLogDirectory   = "logs"
+	qemuLogFile        = "qemu.log"
+	qemuSerialLogFile  = "serial.log"
+
+	qemuVNCBasePort  = 5900
+	qemuPollInterval = 500 * time.Millisecond
+	qemuLogTailLines = 5
+	bytesInMegabyte  = 1024 * 1024
+)
+
+// Known QEMU errors
+var (
+	ErrQEMUFirmwareNotFound = errors.New("could not find the OVMF EFI firmware, install the ovmf package")
+	ErrQEMUConsolePort      = fmt.Errorf("the console port must be at least %d with qemu", qemuVNCBasePort)
+	ErrQEMUVMRunning        = errors.New("the vm must be stopped")
+	ErrQEMUVMExists         = errors.New("the vm already exists")
+	ErrQEMUNotBridge        = errors.New("qemu can connect the server only to a network bridge")
+	ErrQEMUBridgeNotAllowed = fmt.Errorf("the network bridge is not allowed in %s", host.QEMUBridgeConfPath)
+)
+
+// qemuFirmwares are the OVMF code and variable store files of the Linux
+// distributions
+var qemuFirmwares = [][2]string{
+	{"/usr/share/OVMF/OVMF_CODE_4M.fd", "/usr/share/OVMF/OVMF_VARS_4M.fd"},
+	{"/usr/share/OVMF/OVMF_CODE.fd", "/usr/share/OVMF/OVMF_VARS.fd"},
+	{"/usr/share/edk2/ovmf/OVMF_CODE.fd", "/usr/share/edk2/ovmf/OVMF_VARS.fd"},
+	{"/usr/share/edk2/x64/OVMF_CODE.4m.fd", "/usr/share/edk2/x64/OVMF_VARS.4m.fd"},
+	{"/usr/share/qemu/ovmf-x86_64-code.bin", "/usr/share/qemu/ovmf-x86_64-vars.bin"},
+}
+
+// qemuNicModels are the QEMU devices of the networking hardware (see
+// constants.AvailableNics)
+var qemuNicModels = map[string]string{
+	"virtio":    "virtio-net-pci",
+	"Am79C970A": "pcnet",
+	"Am79C973":  "pcnet",
+	"82540EM":   "e1000",
+	"82543GC":   "e1000",
+	"82545EM":   "e1000",
+}
+
+// qemuStates are the VM states of the QMP run states
+var qemuStates = map[string]string{
+	"running":        VMStateRunning,
+	"paused":         VMStatePaused,
+	"suspended":      VMStatePaused,
+	"io-error":       VMStatePaused,
+	"debug":          VMStatePaused,
+	"prelaunch":      VMStateStarting,
+	"inmigrate":      VMStateRestoring,
+	"restore-vm":     VMStateRestoring,
+	"finish-migrate": VMStateSaving,
+	"postmigrate":    VMStateSaving,
+	"save-vm":        VMStateSaving,
+	"shutdown":       VMStatePowerOff,
+	"guest-panicked": VMStateGuruMeditation,
+	"internal-error": VMStateGuruMeditation,
+	"watchdog":       VMStateGuruMeditation,
+}
+
+// qemuMonitorMutexes serialise the QMP connections of naksu to each VM since
+// QEMU serves one connection at a time
+var (
+	qemuMonitorMutexes     = map[string]*sync.Mutex{}
+	qemuMonitorMutexesLock sync.Mutex
+)
+
+// qemuSettings are the settings of a VM stored in vm.json
+type qemuSettings struct {
+	CPUs         int    `json:"cpus"`
+	MemoryMB     uint64 `json:"memoryMB"`
+	VRAMMB       uint64 `json:"vramMB"`
+	SharedFolder string `json:"sharedFolder"`
+	FirmwareCode string `json:"firmwareCode"`
+	// GuestProperties are the boxType and boxVersion of the VM
+	GuestProperties      map[string]string `json:"guestProperties"`
+	SnapshotDescriptions map[string]string `json:"snapshotDescriptions"`
+	CurrentSnapshot      string            `json:"currentSnapshot"`
+	// LoadSnapshot is the snapshot with a saved VM state which the VM is
+	// resumed from when it is started the next time
+	LoadSnapshot string `json:"loadSnapshot"`
+}
+
+type qemuKVM struct{}
+
+func init() {
+	registerHypervisor(qemuKVM{})
+}
+
+func getQEMUPath(vmName string, filename string) string {
+	return filepath.Join(mebroutines.GetKtpDirectory(), "qemu", vmName, filename)
+}
+
+func readQEMUSettings(vmName string) (qemuSettings, error) {
+	var settings qemuSettings
+
+	content, err := os.ReadFile(getQEMUPath(vmName, qemuSettingsFile))
+	if err != nil {
+		return settings, fmt.Errorf("could not read qemu vm settings: %w", err)
+	}
+
+	err = json.Unmarshal(content, &settings)
+	if err != nil {
+		return settings, fmt.Errorf("could not parse qemu vm settings: %w", err)
+	}
+
+	if settings.GuestProperties == nil {
+		settings.GuestProperties = map[string]string{}
+	}
+
+	if settings.SnapshotDescriptions == nil {
+		settings.SnapshotDescriptions = map[string]string{}
+	}
+
+	return settings, nil
+}
+
+// writeQEMUSettings replaces vm.json so that a crash does not leave a
+// partial file behind
+func writeQEMUSettings(vmName string, settings qemuSettings) error {
+	content, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode qemu vm settings: %w", err)
+	}
+
+	settingsPath := getQEMUPath(vmName, qemuSettingsFile)
+
+	err = os.WriteFile(settingsPath+".tmp", content, constants.FilePermissionsOwnerRW)
+	if err != nil {
+		return fmt.Errorf("could not write qemu vm settings: %w", err)
+	}
+
+	return os.Rename(settingsPath+".tmp", settingsPath)
+}
+
+// updateQEMUSettings changes the settings of the VM with the update function
+func updateQEMUSettings(vmName string, update func(settings *qemuSettings)) error {
+	settings, err := readQEMUSettings(vmName)
+	if err != nil {
+		return err
+	}
+
+	update(&settings)
+
+	return writeQEMUSettings(vmName, settings)
+}
+
+// getQEMUMonitorMutex returns the mutex of the QMP connections to the VM
+func getQEMUMonitorMutex(vmName string) *sync.Mutex {
+	qemuMonitorMutexesLock.Lock()
+	defer qemuMonitorMutexesLock.Unlock()
+
+	monitorMutex, ok := qemuMonitorMutexes[vmName]
+	if !ok {
+		monitorMutex = &sync.Mutex{}
+		qemuMonitorMutexes[vmName] = monitorMutex
+	}
+
+	return monitorMutex
+}
+
+// withQEMUMonitor calls the function with a QMP connection to the running VM
+func withQEMUMonitor(vmName string, function func(monitor *qemu.Monitor) error) error {
+	monitorMutex := getQEMUMonitorMutex(vmName)
+	monitorMutex.Lock()
+	defer monitorMutex.Unlock()
+
+	monitor, err := qemu.Connect(getQEMUPath(vmName, qemuSocketFile), constants.QMPTimeout)
+	if err != nil {
+		return err
+	}
+	defer monitor.Close()
+
+	return function(monitor)
+}
+
+func (qemuKVM) Name() string {
+	return constants.HypervisorQEMU
+}
+
+func (qemuKVM) IsInstalled() bool {
+	return qemu.IsInstalled()
+}
+
+func (qemuKVM) IsVMInstalled(vmName string) (bool, error) {
+	return mebroutines.ExistsFile(getQEMUPath(vmName, qemuSettingsFile)), nil
+}
+
+func (qemuKVM) CreateVM(spec VMSpec) error {
+	vmDirectory := getQEMUPath(spec.Name, "")
+
+	if mebroutines.ExistsFile(getQEMUPath(spec.Name, qemuSettingsFile)) {
+		return fmt.Errorf("%w: %s", ErrQEMUVMExists, spec.Name)
+	}
+
+	firmwareCode, firmwareVars, err := findQEMUFirmware()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(getQEMUPath(spec.Name, qemuLogDirectory), constants.FilePermissionsOwnerRWX)
+	if err != nil {
+		return fmt.Errorf("could not create qemu vm directory: %w", err)
+	}
+
+	err = createQEMUVM(spec, firmwareCode, firmwareVars)
+	if err != nil {
+		log.Error("Creating new VM failed, rolling back: %v", err)
+
+		rollbackErr := os.RemoveAll(vmDirectory)
+		if rollbackErr != nil {
+			return fmt.Errorf("could not create new vm (rollback failed: %v): %w", rollbackErr, err)
+		}
+
+		return fmt.Errorf("could not create new vm: %w", err)
+	}
+
+	return nil
+}
+
+func createQEMUVM(spec VMSpec, firmwareCode string, firmwareVars string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), constants.QEMUImgTimeout)
+	defer cancel()
+
+	diskPath := getQEMUPath(spec.Name, qemuDiskFile)
+
+	err := qemu.ConvertImage(ctx, spec.ImagePath, "raw", diskPath, "qcow2")
+	if err != nil {
+		return err
+	}
+
+	err = qemu.ResizeImage(ctx, diskPath, spec.DiskSizeMB)
+	if err != nil {
+		return err
+	}
+
+	err = mebroutines.CopyFile(firmwareVars, getQEMUPath(spec.Name, qemuEFIVarsFile))
+	if err != nil {
+		return fmt.Errorf("could not copy efi variables: %w", err)
+	}
+
+	err = qemu.CreateSnapshot(ctx, diskPath, boxSnapshotName)
+	if err != nil {
+		return err
+	}
+
+	return writeQEMUSettings(spec.Name, qemuSettings{
+		CPUs:         spec.CPUs,
+		MemoryMB:     spec.MemoryMB,
+		VRAMMB:       spec.VRAMMB,
+		SharedFolder: spec.SharedFolder,
+		FirmwareCode: firmwareCode,
+		GuestProperties: map[string]string{
+			"boxType":    spec.BoxType,
+			"boxVersion": spec.BoxVersion,
+		},
+		SnapshotDescriptions: map[string]string{},
+		CurrentSnapshot:      boxSnapshotName,
+		LoadSnapshot:         "",
+	})
+}
+
+// findQEMUFirmware returns the first OVMF code and variable store found
+func findQEMUFirmware() (string, string, error) {
+	for _, firmware := range qemuFirmwares {
+		if mebroutines.ExistsFile(firmware[0]) && mebroutines.ExistsFile(firmware[1]) {
+			return firmware[0], firmware[1], nil
+		}
+	}
+
+	return "", "", ErrQEMUFirmwareNotFound
+}
+
+func (qemuKVM) RemoveVM(vmName string) error {
+	err := ensureQEMUStopped(vmName)
+	if err != nil {
+		return err
+	}
+
+	return os.RemoveAll(getQEMUPath(vmName, ""))
+}
+
+// ensureQEMUStopped returns ErrQEMUVMRunning if the QEMU of the VM is running
+func ensureQEMUStopped(vmName string) error {
+	state, err := qemuKVM{}.GetVMState(vmName)
+	if err != nil {
+		return err
+	}
+
+	switch state {
+	case VMStatePowerOff, VMStateSaved, VMStateAborted, "":
+		return nil
+	}
+
+	return fmt.Errorf("%w: the vm is %s", ErrQEMUVMRunning, state)
+}
+
+func (qemuKVM) StartVM(vmName string, options StartOptions) error {
+	return startQEMU(vmName, options)
+}
+
+func (qemuKVM) ResumeVM(vmName string, state string) error {
+	if state == VMStatePaused {
+		return withQEMUMonitor(vmName, func(monitor *qemu.Monitor) error {
+			return monitor.Execute("cont", nil, nil)
+		})
+	}
+
+	if state != VMStateSaved {
+		return fmt.Errorf("%w: %s", ErrVMNotResumable, state)
+	}
+
+	settings, err := readQEMUSettings(vmName)
+	if err != nil {
+		return err
+	}
+
+	if settings.LoadSnapshot != "" {
+		err = startQEMU(vmName, GetConfiguredStartOptions(), "-loadvm", settings.LoadSnapshot)
+		if err != nil {
+			return err
+		}
+
+		return updateQEMUSettings(vmName, func(settings *qemuSettings) {
+			settings.LoadSnapshot = ""
+		})
+	}
+
+	// The devices must match the saved state, so the start options must not
+	// have changed since saving the state
+	savedStatePath := getQEMUPath(vmName, qemuSavedStateFile)
+	err = startQEMU(vmName, GetConfiguredStartOptions(), "-incoming", "exec:cat "+shellQuote(savedStatePath))
+	if err != nil {
+		return err
+	}
+
+	err = waitForQEMUIncomingMigration(vmName)
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(savedStatePath)
+}
+
+// waitForQEMUIncomingMigration waits until the VM has loaded the saved state
+func waitForQEMUIncomingMigration(vmName string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), constants.VMSaveStateTimeout)
+	defer cancel()
+
+	for {
+		var status qemu.Status
+
+		err := withQEMUMonitor(vmName, func(monitor *qemu.Monitor) error {
+			var err error
+			status, err = monitor.QueryStatus()
+
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("could not restore the saved state: %w", err)
+		}
+
+		if status.Status != "inmigrate" {
+			log.Debug("VM restored from the saved state, status is '%s'", status.Status)
+
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w restoring, the vm is %s", ErrVMStateTimeout, status.Status)
+		case <-time.After(qemuPollInterval):
+		}
+	}
+}
+
+// startQEMU starts QEMU in a session of its own so that it keeps running
+// after naksu exits. Returns when the QMP socket accepts connections.
+func startQEMU(vmName string, options StartOptions, extraArgs ...string) error {
+	settings, err := readQEMUSettings(vmName)
+	if err != nil {
+		return err
+	}
+
+	err = checkKVM()
+	if err != nil {
+		return err
+	}
+
+	err = checkQEMUBridge(options.ExtNic)
+	if err != nil {
+		return err
+	}
+
+	args, err := getQEMUStartArgs(vmName, settings, options)
+	if err != nil {
+		return err
+	}
+	args = append(args, extraArgs...)
+
+	// A crashed QEMU leaves its socket and pidfile behind
+	for _, staleFile := range []string{qemuSocketFile, qemuPIDFile} {
+		err = os.Remove(getQEMUPath(vmName, staleFile))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("could not remove %s: %w", staleFile, err)
+		}
+	}
+
+	logPath := getQEMUPath(vmName, filepath.Join(qemuLogDirectory, qemuLogFile))
+	logFile, err := os.OpenFile(filepath.Clean(logPath), os.O_CREATE|os.O_APPEND|os.O_WRONLY, constants.FilePermissionsOwnerRW)
+	if err != nil {
+		return fmt.Errorf("could not open qemu log: %w", err)
+	}
+	defer logFile.Close()
+
+	fmt.Fprintf(logFile, "%s Starting %s %s\n", time.Now().Format(time.RFC3339), qemu.SystemBinary, strings.Join(args, " "))
+	log.Debug("Starting %s %s", qemu.SystemBinary, strings.Join(args, " "))
+
+	logOffset, err := logFile.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("could not seek qemu log: %w", err)
+	}
+
+	/* #nosec */
+	cmd := exec.Command(qemu.SystemBinary, args...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true} // nolint: exhaustruct
+
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("could not start qemu: %w", err)
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	return waitForQEMUSocket(vmName, exited, logOffset)
+}
+
+// waitForQEMUSocket waits until the started QEMU accepts QMP connections or
+// exits. The error of an exited QEMU contains its output from the log offset.
+func waitForQEMUSocket(vmName string, exited chan error, logOffset int64) error {
+	timeout := time.After(constants.QEMUStartTimeout)
+
+	for {
+		err := withQEMUMonitor(vmName, func(monitor *qemu.Monitor) error {
+			return nil
+		})
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case exitErr := <-exited:
+			return fmt.Errorf("qemu exited (%v): %s", exitErr, getQEMULogTail(vmName, logOffset))
+		case <-timeout:
+			return fmt.Errorf("qemu did not open its qmp socket in %v: %w", constants.QEMUStartTimeout, err)
+		case <-time.After(qemuPollInterval):
+		}
+	}
+}
+
+// getQEMULogTail returns the last lines QEMU has written to its log after
+// the offset
+func getQEMULogTail(vmName string, offset int64) string {
+	content, err := os.ReadFile(getQEMUPath(vmName, filepath.Join(qemuLogDirectory, qemuLogFile)))
+	if err != nil || offset > int64(len(content)) {
+		return ""
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(content[offset:])), "\n")
+	if len(lines) > qemuLogTailLines {
+		lines = lines[len(lines)-qemuLogTailLines:]
+	}
+
+	return strings.Join(lines, " / ")
+}
+
+// checkKVM returns an error if this user cannot use KVM
+func checkKVM() error {
+	kvmDevice, err := os.OpenFile("/dev/kvm", os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("kvm is not available, check that virtualisation is enabled and you are in the kvm group: %w", err)
+	}
+
+	return kvmDevice.Close()
+}
+
+// checkQEMUBridge returns an error if qemu-bridge-helper cannot add the
+// server to the network device. The doctor explains how to fix it.
+func checkQEMUBridge(extNic string) error {
+	switch host.GetQEMUBridgeStatus(extNic) {
+	case host.QEMUBridgeNotBridge:
+		return fmt.Errorf("%w: %s is not a bridge", ErrQEMUNotBridge, extNic)
+	case host.QEMUBridgeNotAllowed:
+		return fmt.Errorf("%w: %s", ErrQEMUBridgeNotAllowed, extNic)
+	}
+
+	return nil
+}
+
+// getQEMUStartArgs returns the qemu-system-x86_64 arguments of the VM. The
+// resources set in the [vm] section of naksu.ini override the ones
+// calculated at install like they do with VirtualBox.
+func getQEMUStartArgs(vmName string, settings qemuSettings, options StartOptions) ([]string, error) {
+	cpus := settings.CPUs
+	memory := settings.MemoryMB
+	vram := settings.VRAMMB
+
+	var err error
+
+	if !config.GetVMCPUs().Auto {
+		cpus, err = calculateBoxCPUs()
+		if err != nil {
+			return nil, fmt.Errorf("could not calculate vm resources: %w", err)
+		}
+	}
+
+	if !config.GetVMMemory().Auto {
+		memory, err = calculateBoxMemory()
+		if err != nil {
+			return nil, fmt.Errorf("could not calculate vm resources: %w", err)
+		}
+	}
+
+	if !config.GetVMVRAMSize().Auto {
+		vram = calculateBoxVRamSize()
+	}
+
+	nicModel, ok := qemuNicModels[options.Nic]
+	if !ok {
+		nicModel = qemuNicModels[constants.AvailableNics[0].ConfigValue]
+	}
+
+	args := []string{
+		"-name", vmName,
+		"-machine", "q35,accel=kvm",
+		"-cpu", "host",
+		"-smp", strconv.Itoa(cpus),
+		"-m", strconv.FormatUint(memory, 10),
+		"-rtc", "base=localtime",
+		"-drive", "if=pflash,format=raw,readonly=on,file=" + escapeQEMUOption(settings.FirmwareCode),
+		"-drive", "if=pflash,format=raw,file=" + escapeQEMUOption(getQEMUPath(vmName, qemuEFIVarsFile)),
+		"-drive", "if=none,id=disk0,format=qcow2,file=" + escapeQEMUOption(getQEMUPath(vmName, qemuDiskFile)),
+		"-device", "ide-hd,drive=disk0,bus=ide.0",
+		"-netdev", "bridge,id=net0,br=" + escapeQEMUOption(options.ExtNic),
+		"-device", nicModel + ",netdev=net0",
+		"-vga", "none",
+		"-device", fmt.Sprintf("VGA,vgamem_mb=%d", vram),
+		"-usb", "-device", "usb-tablet",
+		"-device", "pvpanic",
+		"-virtfs", "local,id=media_usb1,mount_tag=media_usb1,security_model=none,path=" + escapeQEMUOption(settings.SharedFolder),
+		"-serial", "file:" + getQEMUPath(vmName, filepath.Join(qemuLogDirectory, qemuSerialLogFile)),
+		"-qmp", "unix:" + escapeQEMUOption(getQEMUPath(vmName, qemuSocketFile)) + ",server=on,wait=off",
+		"-pidfile", getQEMUPath(vmName, qemuPIDFile),
+		"-no-shutdown",
+	}
+
+	if options.Type == "headless" {
+		args = append(args, "-display", "none")
+	}
+
+	if options.ConsolePort != 0 {
+		if options.ConsolePort < qemuVNCBasePort {
+			return nil, ErrQEMUConsolePort
+		}
+
+		args = append(args, "-vnc", fmt.Sprintf("%s:%d", constants.ConsoleAddress, options.ConsolePort-qemuVNCBasePort))
+	}
+
+	return args, nil
+}
+
+// escapeQEMUOption escapes the commas of an option value
+func escapeQEMUOption(value string) string {
+	return strings.ReplaceAll(value, ",", ",,")
+}
+
+// shellQuote quotes the value for /bin/sh which runs the exec: migrations
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// hmpQuote quotes the value for a human monitor command
+func hmpQuote(value string) string {
+	return strconv.Quote(value)
+}
+
+func (qemuKVM) ShutdownVM(vmName string) error {
+	return withQEMUMonitor(vmName, func(monitor *qemu.Monitor) error {
+		return monitor.Execute("system_powerdown", nil, nil)
+	})
+}
+
+// SaveStateVM stops the VM and migrates its state to a file. The file is
+// renamed when the migration has completed, so a partial state is never
+// resumed.
+func (qemuKVM) SaveStateVM(vmName string) error {
+	savedStatePath := getQEMUPath(vmName, qemuSavedStateFile)
+	partialStatePath := savedStatePath + ".tmp"
+
+	err := withQEMUMonitor(vmName, func(monitor *qemu.Monitor) error {
+		err := monitor.Execute("stop", nil, nil)
+		if err != nil {
+			return err
+		}
+
+		return monitor.Execute("migrate", map[string]string{"uri": "exec:cat > " + shellQuote(partialStatePath)}, nil)
+	})
+	if err == nil {
+		err = waitForQEMUMigration(vmName)
+	}
+
+	if err != nil {
+		log.Warning("Saving the VM state failed, continuing the VM: %v", err)
+		_ = os.Remove(partialStatePath)
+
+		errCont := withQEMUMonitor(vmName, func(monitor *qemu.Monitor) error {
+			return monitor.Execute("cont", nil, nil)
+		})
+		if errCont != nil {
+			log.Error("Could not continue the VM: %v", errCont)
+		}
+
+		return fmt.Errorf("could not save the vm state: %w", err)
+	}
+
+	err = os.Rename(partialStatePath, savedStatePath)
+	if err != nil {
+		return fmt.Errorf("could not save the vm state: %w", err)
+	}
+
+	return quitQEMU(vmName)
+}
+
+// waitForQEMUMigration polls the migration until it has completed. The
+// monitor is connected for each poll so that the other monitor commands,
+// such as the state queries of the watchdog, are not blocked while the
+// state is being saved.
+func waitForQEMUMigration(vmName string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), constants.VMSaveStateTimeout)
+	defer cancel()
+
+	for {
+		var info qemu.MigrationInfo
+
+		err := withQEMUMonitor(vmName, func(monitor *qemu.Monitor) error {
+			var err error
+			info, err = monitor.QueryMigrate()
+
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		switch info.Status {
+		case "completed":
+			return nil
+		case "failed", "cancelled":
+			return fmt.Errorf("migration %s: %s", info.Status, info.ErrorMessage)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w saved, the migration is %s", ErrVMStateTimeout, info.Status)
+		case <-time.After(qemuPollInterval):
+		}
+	}
+}
+
+func (qemuKVM) PowerOffVM(vmName string) error {
+	return quitQEMU(vmName)
+}
+
+// quitQEMU quits QEMU and waits until the process has exited. The pidfile
+// is removed first so that the exit is not seen as a crash.
+func quitQEMU(vmName string) error {
+	pid := readQEMUPID(vmName)
+
+	err := os.Remove(getQEMUPath(vmName, qemuPIDFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not remove qemu pidfile: %w", err)
+	}
+
+	err = withQEMUMonitor(vmName, func(monitor *qemu.Monitor) error {
+		return monitor.Execute("quit", nil, nil)
+	})
+	// QEMU may close the connection before responding
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("could not quit qemu: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), constants.VMPowerOffTimeout)
+	defer cancel()
+
+	for pid > 0 && isProcessAlive(pid) {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: qemu process %d did not exit", ErrVMStateTimeout, pid)
+		case <-time.After(qemuPollInterval):
+		}
+	}
+
+	return nil
+}
+
+// readQEMUPID returns the process ID in the pidfile or zero if the pidfile
+// cannot be read
+func readQEMUPID(vmName string) int {
+	content, err := os.ReadFile(getQEMUPath(vmName, qemuPIDFile))
+	if err != nil {
+		return 0
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		log.Debug("Malformed qemu pidfile: %v", err)
+
+		return 0
+	}
+
+	return pid
+}
+
+func isProcessAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// GetVMState returns the state QMP reports while QEMU is running. Otherwise
+// the state is saved if there is a saved state to resume from, aborted if
+// QEMU left its pidfile behind and poweroff if it did not.
+func (qemuKVM) GetVMState(vmName string) (string, error) {
+	settings, err := readQEMUSettings(vmName)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	var status qemu.Status
+
+	err = withQEMUMonitor(vmName, func(monitor *qemu.Monitor) error {
+		var err error
+		status, err = monitor.QueryStatus()
+
+		return err
+	})
+	if err == nil {
+		return getQEMUVMState(vmName, status.Status)
+	}
+
+	pid := readQEMUPID(vmName)
+	switch {
+	case pid > 0 && isProcessAlive(pid):
+		return "", fmt.Errorf("qemu is running but its qmp socket does not respond: %w", err)
+	case pid > 0:
+		return VMStateAborted, nil
+	case settings.LoadSnapshot != "" || mebroutines.ExistsFile(getQEMUPath(vmName, qemuSavedStateFile)):
+		return VMStateSaved, nil
+	}
+
+	return VMStatePowerOff, nil
+}
+
+// getQEMUVMState returns the VM state of the QMP run state. A guest which
+// powered itself off is quit.
+func getQEMUVMState(vmName string, status string) (string, error) {
+	if status == "shutdown" {
+		log.Debug("The guest of VM %s has powered off, quitting qemu", vmName)
+
+		err := quitQEMU(vmName)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return getQEMUStatusVMState(status), nil
+}
+
+// getQEMUStatusVMState returns the VM state of the QMP run state or the run
+// state itself if it is unknown
+func getQEMUStatusVMState(status string) string {
+	state, ok := qemuStates[status]
+	if !ok {
+		return status
+	}
+
+	return state
+}
+
+// ResetVMStateCache does nothing as the QEMU states are not cached
+func (qemuKVM) ResetVMStateCache(string) {}
+
+func (qemuKVM) TakeSnapshot(vmName string, name string, description string) error {
+	err := ensureQEMUStopped(vmName)
+
+	switch {
+	case err == nil:
+		ctx, cancel := context.WithTimeout(context.Background(), constants.QEMUImgTimeout)
+		defer cancel()
+
+		err = qemu.CreateSnapshot(ctx, getQEMUPath(vmName, qemuDiskFile), name)
+	case errors.Is(err, ErrQEMUVMRunning):
+		err = runQEMUHumanMonitorCommand(vmName, "savevm "+hmpQuote(name))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return updateQEMUSettings(vmName, func(settings *qemuSettings) {
+		settings.SnapshotDescriptions[name] = description
+		settings.CurrentSnapshot = name
+	})
+}
+
+// runQEMUHumanMonitorCommand runs a human monitor command which prints
+// nothing unless it fails
+func runQEMUHumanMonitorCommand(vmName string, commandLine string) error {
+	return withQEMUMonitor(vmName, func(monitor *qemu.Monitor) error {
+		output, err := monitor.HumanMonitorCommand(commandLine)
+		if err != nil {
+			return err
+		}
+
+		if strings.TrimSpace(output) != "" {
+			return fmt.Errorf("%w: %s: %s", qemu.ErrQMP, commandLine, strings.TrimSpace(output))
+		}
+
+		return nil
+	})
+}
+
+// RestoreSnapshot reverts the disk to the snapshot. A snapshot taken from a
+// running VM is loaded when the VM is started next time, so the VM is saved
+// until then like with VirtualBox.
+func (qemuKVM) RestoreSnapshot(vmName string, snapshotID string) error {
+	err := ensureQEMUStopped(vmName)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), constants.QEMUImgTimeout)
+	defer cancel()
+
+	diskPath := getQEMUPath(vmName, qemuDiskFile)
+
+	snapshotInfo, err := findQEMUSnapshot(ctx, diskPath, snapshotID)
+	if err != nil {
+		return err
+	}
+
+	err = qemu.ApplySnapshot(ctx, diskPath, snapshotInfo.ID)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(getQEMUPath(vmName, qemuSavedStateFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not discard the saved state: %w", err)
+	}
+
+	return updateQEMUSettings(vmName, func(settings *qemuSettings) {
+		settings.CurrentSnapshot = snapshotInfo.Name
+		settings.LoadSnapshot = ""
+		if snapshotInfo.VMStateSize > 0 {
+			settings.LoadSnapshot = snapshotInfo.Name
+		}
+	})
+}
+
+// findQEMUSnapshot returns the snapshot with the given ID. The names are not
+// matched as a snapshot may be named like the ID of another one.
+func findQEMUSnapshot(ctx context.Context, diskPath string, snapshotID string) (qemu.SnapshotInfo, error) {
+	info, err := qemu.GetImageInfo(ctx, diskPath)
+	if err != nil {
+		return qemu.SnapshotInfo{}, err // nolint: exhaustruct
+	}
+
+	for _, snapshotInfo := range info.Snapshots {
+		if snapshotInfo.ID == snapshotID {
+			return snapshotInfo, nil
+		}
+	}
+
+	return qemu.SnapshotInfo{}, fmt.Errorf("%w: %s", ErrSnapshotNotFound, snapshotID) // nolint: exhaustruct
+}
+
+func (qemuKVM) DeleteSnapshot(vmName string, snapshotID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), constants.QEMUImgTimeout)
+	defer cancel()
+
+	diskPath := getQEMUPath(vmName, qemuDiskFile)
+
+	snapshotInfo, err := findQEMUSnapshot(ctx, diskPath, snapshotID)
+	if err != nil {
+		return err
+	}
+
+	err = ensureQEMUStopped(vmName)
+
+	switch {
+	case err == nil:
+		err = qemu.DeleteSnapshot(ctx, diskPath, snapshotInfo.ID)
+	case errors.Is(err, ErrQEMUVMRunning):
+		err = runQEMUHumanMonitorCommand(vmName, "delvm "+hmpQuote(snapshotInfo.Name))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return updateQEMUSettings(vmName, func(settings *qemuSettings) {
+		delete(settings.SnapshotDescriptions, snapshotInfo.Name)
+		if settings.CurrentSnapshot == snapshotInfo.Name {
+			settings.CurrentSnapshot = ""
+		}
+		if settings.LoadSnapshot == snapshotInfo.Name {
+			settings.LoadSnapshot = ""
+		}
+	})
+}
+
+// GetSnapshots returns the snapshots in the order they were taken. The size
+// of a snapshot is the size of its saved VM state as the disk clusters are
+// shared between the snapshots.
+func (qemuKVM) GetSnapshots(vmName string) ([]SnapshotInfo, error) {
+	settings, err := readQEMUSettings(vmName)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), constants.QMPTimeout)
+	defer cancel()
+
+	info, err := qemu.GetImageInfo(ctx, getQEMUPath(vmName, qemuDiskFile))
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := []SnapshotInfo{}
+	for _, snapshot := range info.Snapshots {
+		snapshots = append(snapshots, SnapshotInfo{
+			Name:        snapshot.Name,
+			UUID:        snapshot.ID,
+			Description: settings.SnapshotDescriptions[snapshot.Name],
+			TakenAt:     snapshot.TakenAt(),
+			SizeBytes:   snapshot.VMStateSize,
+			Current:     snapshot.Name == settings.CurrentSnapshot,
+			Protected:   false,
+		})
+	}
+
+	return snapshots, nil
+}
+
+func (qemuKVM) CloneDisk(vmName string, clonePath string) error {
+	err := ensureQEMUStopped(vmName)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), constants.QEMUImgTimeout)
+	defer cancel()
+
+	return qemu.ConvertImage(ctx, getQEMUPath(vmName, qemuDiskFile), "qcow2", clonePath, "vmdk")
+}
+
+func (qemuKVM) GetDiskLocation(vmName string) string {
+	diskPath := getQEMUPath(vmName, qemuDiskFile)
+	if !mebroutines.ExistsFile(diskPath) {
+		return ""
+	}
+
+	return diskPath
+}
+
+func (qemuKVM) DiskSizeOnDisk(location string) (uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), constants.QMPTimeout)
+	defer cancel()
+
+	info, err := qemu.GetImageInfo(ctx, location)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get disk size: %w", err)
+	}
+
+	return info.ActualSize / bytesInMegabyte, nil
+}
+
+func (qemuKVM) GetGuestProperty(vmName string, property string) string {
+	settings, err := readQEMUSettings(vmName)
+	if err != nil {
+		log.Debug("Could not get guest property %s: %v", property, err)
+
+		return ""
+	}
+
+	return settings.GuestProperties[property]
+}
+
+func (qemuKVM) GetLogDir(vmName string) string {
+	return getQEMUPath(vmName, qemuLogDirectory)
+}
+
+func (qemuKVM) GetConsoleURL(vmName string) (string, error) {
+	var vncInfo qemu.VNCInfo
+
+	err := withQEMUMonitor(vmName, func(monitor *qemu.Monitor) error {
+		var err error
+		vncInfo, err = monitor.QueryVNC()
+
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not get the vnc server: %w", err)
+	}
+
+	if !vncInfo.Enabled || vncInfo.Service == "" {
+		return "", nil
+	}
+
+	return fmt.Sprintf("vnc://%s", net.JoinHostPort(vncInfo.Host, vncInfo.Service)), nil
+}
@@ -0,0 +1,88 @@
+package box
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"naksu/config"
+)
+
+func TestGetQEMUStartArgs(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	config.Load()
+
+	settings := qemuSettings{
+		CPUs:                 2,
+		MemoryMB:             8192,
+		VRAMMB:               24,
+		SharedFolder:         "/home/teacher/ktp-jako",
+		FirmwareCode:         "/usr/share/OVMF/OVMF_CODE.fd",
+		GuestProperties:      map[string]string{},
+		SnapshotDescriptions: map[string]string{},
+		CurrentSnapshot:      "",
+		LoadSnapshot:         "",
+	}
+
+	testCases := []struct {
+		description  string
+		options      StartOptions
+		expectedArgs []string
+	}{
+		{
+			"gui with console",
+			StartOptions{ExtNic: "br0", Nic: "82540EM", Type: "gui", ConsolePort: 5901},
+			[]string{"-smp 2", "-m 8192", "-netdev bridge,id=net0,br=br0", "-device e1000,netdev=net0", "-vnc 127.0.0.1:1"},
+		},
+		{
+			"headless with an unknown nic",
+			StartOptions{ExtNic: "br,exam", Nic: "foo", Type: "headless", ConsolePort: 0},
+			[]string{"-netdev bridge,id=net0,br=br,,exam", "-device virtio-net-pci,netdev=net0", "-display none"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		args, err := getQEMUStartArgs("NaksuAbittiKTP", settings, testCase.options)
+		if err != nil {
+			t.Fatalf("Getting arguments for %s failed: %v", testCase.description, err)
+		}
+
+		argPairs := []string{}
+		for i := 0; i+1 < len(args); i++ {
+			argPairs = append(argPairs, args[i]+" "+args[i+1])
+		}
+
+		for _, expectedArg := range testCase.expectedArgs {
+			if !slices.Contains(argPairs, expectedArg) {
+				t.Errorf("Arguments for %s do not contain '%s': %s", testCase.description, expectedArg, strings.Join(args, " "))
+			}
+		}
+	}
+
+	_, err := getQEMUStartArgs("NaksuAbittiKTP", settings, StartOptions{ExtNic: "br0", Nic: "virtio", Type: "gui", ConsolePort: 3389})
+	if !errors.Is(err, ErrQEMUConsolePort) {
+		t.Errorf("Console port below the VNC ports gave %v, expected ErrQEMUConsolePort", err)
+	}
+}
+
+func TestGetQEMUStatusVMState(t *testing.T) {
+	testCases := []struct {
+		status   string
+		expected string
+	}{
+		{"running", VMStateRunning},
+		{"paused", VMStatePaused},
+		{"inmigrate", VMStateRestoring},
+		{"postmigrate", VMStateSaving},
+		{"shutdown", VMStatePowerOff},
+		{"guest-panicked", VMStateGuruMeditation},
+		{"colo", "colo"},
+	}
+
+	for _, testCase := range testCases {
+		if state := getQEMUStatusVMState(testCase.status); state != testCase.expected {
+			t.Errorf("QMP status %s gave %s, expected %s", testCase.status, state, testCase.expected)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package box
+
+// Naksu can have one server of each box type side by side, e.g. an Abitti
+// server for practice exams and a matriculation exam server. Each server is a
+// VM of its own with its own disk image, snapshots and guest properties. The
+// other functions of this package operate on the selected server.
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"naksu/constants"
+	"naksu/log"
+	"naksu/mebroutines"
+)
+
+// ErrUnknownServer is returned when selecting a server which is not in
+// constants.AvailableServers
+var ErrUnknownServer = errors.New("unknown server")
+
+// ErrServerConflict is returned if the VM of a server holds another server
+var ErrServerConflict = errors.New("the vm of the server holds another server")
+
+// legacyServerVMName is the name of the single VM of the older Naksu
+// versions. It can hold a server of any type.
+const legacyServerVMName = "NaksuAbittiKTP"
+
+// serverVMNames are the VM names of the servers. The Abitti server has the
+// name of the single VM of the older Naksu versions, see getServerVMNames.
+var serverVMNames = map[string]string{
+	constants.AbittiBoxType:            legacyServerVMName,
+	constants.MatriculationExamBoxType: "NaksuExamKTP",
+}
+
+// resolvedServerVMNames caches getServerVMNames until ResetCache
+var resolvedServerVMNames map[string]string
+var resolvedServerVMNamesMutex sync.Mutex
+
+var selectedServer = constants.AvailableServers[0].ConfigValue
+var selectedServerMutex sync.RWMutex
+
+// SelectServer selects the server (see constants.AvailableServers) the other
+// functions of this package operate on
+func SelectServer(server string) error {
+	if _, ok := serverVMNames[server]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownServer, server)
+	}
+
+	selectedServerMutex.Lock()
+	changed := selectedServer != server
+	selectedServer = server
+	selectedServerMutex.Unlock()
+
+	if changed {
+		log.Debug("Selected server %s (VM %s)", server, getServerVMName(server))
+		ResetCache()
+	}
+
+	return nil
+}
+
+// GetSelectedServer returns the selected server
+func GetSelectedServer() string {
+	selectedServerMutex.RLock()
+	defer selectedServerMutex.RUnlock()
+
+	return selectedServer
+}
+
+// GetServerForBoxType returns the server a new box of the given type is
+// installed to
+func GetServerForBoxType(boxType string) string {
+	if _, ok := serverVMNames[boxType]; ok {
+		return boxType
+	}
+
+	return constants.AvailableServers[0].ConfigValue
+}
+
+// GetVMName returns the VM name of the selected server
+func GetVMName() string {
+	return getBoxName()
+}
+
+// getServerVMName returns the VM name of the server or an empty string if
+// the VM of the server holds another server
+func getServerVMName(server string) string {
+	resolvedServerVMNamesMutex.Lock()
+	defer resolvedServerVMNamesMutex.Unlock()
+
+	if resolvedServerVMNames == nil {
+		vmNames, err := resolveServerVMNames()
+		if err != nil {
+			log.Debug("Could not resolve the VMs of the servers, using the default VM names: %v", err)
+
+			return serverVMNames[server]
+		}
+
+		resolvedServerVMNames = vmNames
+	}
+
+	return resolvedServerVMNames[server]
+}
+
+func resetServerVMNames() {
+	resolvedServerVMNamesMutex.Lock()
+	resolvedServerVMNames = nil
+	resolvedServerVMNamesMutex.Unlock()
+}
+
+// resolveServerVMNames detects the server in the VM of the older Naksu
+// versions by its boxType guest property. A VM with an unknown box type is
+// kept as the Abitti server.
+func resolveServerVMNames() (map[string]string, error) {
+	installedVMNames := map[string]bool{}
+
+	for _, vmName := range serverVMNames {
+		isInstalled, err := getHypervisor().IsVMInstalled(vmName)
+		if err != nil {
+			return nil, fmt.Errorf("could not detect whether VM %s is installed: %w", vmName, err)
+		}
+
+		installedVMNames[vmName] = isInstalled
+	}
+
+	legacyVMServer := ""
+	if installedVMNames[legacyServerVMName] {
+		legacyVMServer = GetServerForBoxType(getHypervisor().GetGuestProperty(legacyServerVMName, "boxType"))
+	}
+
+	return getServerVMNames(legacyVMServer, installedVMNames), nil
+}
+
+// getServerVMNames returns the VM names of the servers. The VM of the older
+// Naksu versions is the VM of the server in it (legacyVMServer) unless that
+// server has a VM of its own. The server which would use the VM of the older
+// versions while it holds another server gets an empty VM name.
+func getServerVMNames(legacyVMServer string, installedVMNames map[string]bool) map[string]string {
+	vmNames := map[string]string{}
+
+	for server, vmName := range serverVMNames {
+		switch {
+		case vmName == legacyServerVMName && legacyVMServer != "" && legacyVMServer != server:
+			vmName = ""
+		case legacyVMServer == server && !installedVMNames[vmName]:
+			vmName = legacyServerVMName
+		}
+
+		vmNames[server] = vmName
+	}
+
+	return vmNames
+}
+
+// CheckSelectedServerVM returns ErrServerConflict if the VM of the selected
+// server holds another server, e.g. the VM of the older Naksu versions
+// holds a matriculation exam server when the Abitti server is selected
+func CheckSelectedServerVM() error {
+	if getBoxName() == "" {
+		return fmt.Errorf("%w: %s", ErrServerConflict, legacyServerVMName)
+	}
+
+	return nil
+}
+
+func getBoxName() string {
+	return getServerVMName(GetSelectedServer())
+}
+
+// getVDIImagePath returns the disk image path of the given VM. The VM of the
+// older Naksu versions keeps its disk image name.
+func getVDIImagePath(vmName string) string {
+	if vmName == legacyServerVMName {
+		return mebroutines.GetVDIImagePath()
+	}
+
+	return filepath.Join(mebroutines.GetKtpDirectory(), fmt.Sprintf("naksu_ktp_disk_%s.vdi", vmName))
+}
+
+// GetInstalledServers returns the installed servers in the order of
+// constants.AvailableServers
+func GetInstalledServers() ([]string, error) {
+	installedServers := []string{}
+
+	for _, server := range constants.AvailableServers {
+		vmName := getServerVMName(server.ConfigValue)
+		if vmName == "" {
+			continue
+		}
+
+		isInstalled, err := getHypervisor().IsVMInstalled(vmName)
+		if err != nil {
+			return nil, fmt.Errorf("could not detect whether server %s is installed: %w", server.ConfigValue, err)
+		}
+
+		if isInstalled {
+			installedServers = append(installedServers, server.ConfigValue)
+		}
+	}
+
+	return installedServers, nil
+}
+
+// GetOtherRunningServer returns a running server other than the selected one
+// or an empty string if the other servers are not running
+func GetOtherRunningServer() (string, error) {
+	for _, server := range constants.AvailableServers {
+		if server.ConfigValue == GetSelectedServer() {
+			continue
+		}
+
+		vmName := getServerVMName(server.ConfigValue)
+		if vmName == "" {
+			continue
+		}
+
+		state, err := getHypervisor().GetVMState(vmName)
+		if err != nil {
+			return "", fmt.Errorf("could not detect whether server %s is running: %w", server.ConfigValue, err)
+		}
+
+		if state == VMStateRunning {
+			return server.ConfigValue, nil
+		}
+	}
+
+	return "", nil
+}
@@ -0,0 +1,32 @@
+package box
+
+import (
+	"reflect"
+	"testing"
+
+	"naksu/constants"
+)
+
+func TestGetServerVMNames(t *testing.T) {
+	abitti := constants.AbittiBoxType
+	exam := constants.MatriculationExamBoxType
+
+	testCases := []struct {
+		description      string
+		legacyVMServer   string
+		installedVMNames map[string]bool
+		expectedVMNames  map[string]string
+	}{
+		{"no vms", "", map[string]bool{}, map[string]string{abitti: "NaksuAbittiKTP", exam: "NaksuExamKTP"}},
+		{"abitti server in the legacy vm", abitti, map[string]bool{"NaksuAbittiKTP": true}, map[string]string{abitti: "NaksuAbittiKTP", exam: "NaksuExamKTP"}},
+		{"exam server in the legacy vm", exam, map[string]bool{"NaksuAbittiKTP": true}, map[string]string{abitti: "", exam: "NaksuAbittiKTP"}},
+		{"exam servers in both vms", exam, map[string]bool{"NaksuAbittiKTP": true, "NaksuExamKTP": true}, map[string]string{abitti: "", exam: "NaksuExamKTP"}},
+	}
+
+	for _, testCase := range testCases {
+		vmNames := getServerVMNames(testCase.legacyVMServer, testCase.installedVMNames)
+		if !reflect.DeepEqual(vmNames, testCase.expectedVMNames) {
+			t.Errorf("VM names with %s are %v, expected %v", testCase.description, vmNames, testCase.expectedVMNames)
+		}
+	}
+}
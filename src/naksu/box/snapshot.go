@@ -0,0 +1,129 @@
+package box
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Known snapshot errors
+var (
+	ErrSnapshotNotFound  = errors.New("snapshot not found")
+	ErrSnapshotExists    = errors.New("snapshot with the same name already exists")
+	ErrProtectedSnapshot = errors.New("the snapshot taken at install cannot be deleted")
+	ErrEmptySnapshotName = errors.New("snapshot name is empty")
+)
+
+// SnapshotInfo is a snapshot of the current VM
+type SnapshotInfo struct {
+	Name        string    `json:"name"`
+	UUID        string    `json:"uuid"`
+	Description string    `json:"description"`
+	TakenAt     time.Time `json:"takenAt"`
+	// SizeBytes is the size of the disk images and the saved state frozen by
+	// the snapshot. The size of the first snapshot includes the base disk.
+	SizeBytes uint64 `json:"sizeBytes"`
+	// Current is true for the snapshot the current state is based on
+	Current bool `json:"current"`
+	// Protected is true for the snapshot taken at install which is used to
+	// remove the exams
+	Protected bool `json:"protected"`
+}
+
+// GetSnapshots returns the snapshots of the current VM. VirtualBox lists
+// the parents before their children, QEMU in the order they were taken.
+func GetSnapshots() ([]SnapshotInfo, error) {
+	snapshots, err := getHypervisor().GetSnapshots(getBoxName())
+	if err != nil {
+		return nil, err
+	}
+
+	for index := range snapshots {
+		snapshots[index].Protected = snapshots[index].Name == boxSnapshotName
+	}
+
+	return snapshots, nil
+}
+
+// findSnapshot returns the snapshot with the given name
+func findSnapshot(name string) (SnapshotInfo, error) {
+	snapshots, err := GetSnapshots()
+	if err != nil {
+		return SnapshotInfo{}, err // nolint: exhaustruct
+	}
+
+	for _, snapshot := range snapshots {
+		if snapshot.Name == name {
+			return snapshot, nil
+		}
+	}
+
+	return SnapshotInfo{}, fmt.Errorf("%w: %s", ErrSnapshotNotFound, name) // nolint: exhaustruct
+}
+
+// TakeSnapshot takes a named snapshot of the current VM. The names must be
+// unique so that the snapshots can be restored and deleted by name.
+func TakeSnapshot(name string, description string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return ErrEmptySnapshotName
+	}
+
+	_, err := findSnapshot(name)
+	switch {
+	case err == nil:
+		return fmt.Errorf("%w: %s", ErrSnapshotExists, name)
+	case !errors.Is(err, ErrSnapshotNotFound):
+		return err
+	}
+
+	defer ResetCache()
+
+	err = getHypervisor().TakeSnapshot(getBoxName(), name, description)
+	if err != nil {
+		return fmt.Errorf("could not take snapshot %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// RestoreNamedSnapshot returns the VM to the state of the given snapshot. The
+// VM must not be running.
+func RestoreNamedSnapshot(name string) error {
+	snapshot, err := findSnapshot(name)
+	if err != nil {
+		return err
+	}
+
+	defer ResetCache()
+
+	err = getHypervisor().RestoreSnapshot(getBoxName(), snapshot.UUID)
+	if err != nil {
+		return fmt.Errorf("could not restore snapshot %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// DeleteSnapshot deletes the given snapshot. The data of the snapshot is
+// merged to its child. The snapshot taken at install cannot be deleted.
+func DeleteSnapshot(name string) error {
+	snapshot, err := findSnapshot(name)
+	if err != nil {
+		return err
+	}
+
+	if snapshot.Protected {
+		return ErrProtectedSnapshot
+	}
+
+	defer ResetCache()
+
+	err = getHypervisor().DeleteSnapshot(getBoxName(), snapshot.UUID)
+	if err != nil {
+		return fmt.Errorf("could not delete snapshot %s: %w", name, err)
+	}
+
+	return nil
+}
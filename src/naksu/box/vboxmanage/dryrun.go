@@ -0,0 +1,44 @@
+package vboxmanage
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// FormatCommand returns the full VBoxManage command line. Arguments with
+// spaces or quotes are quoted so that the line can be copied to a terminal.
+func FormatCommand(command VBoxCommand) string {
+	vBoxManagePath := getVBoxManagePath()
+	if vBoxManagePath == "" {
+		vBoxManagePath = "VBoxManage"
+	}
+
+	quotedArgs := []string{quoteCommandArg(vBoxManagePath)}
+	for _, arg := range command {
+		quotedArgs = append(quotedArgs, quoteCommandArg(arg))
+	}
+
+	return strings.Join(quotedArgs, " ")
+}
+
+func quoteCommandArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'") {
+		return arg
+	}
+
+	return `"` + strings.ReplaceAll(arg, `"`, `\"`) + `"`
+}
+
+// DryRunCommands writes the command lines RunCommands would run to the writer
+// without running them
+func DryRunCommands(writer io.Writer, commands []VBoxCommand) error {
+	for _, command := range commands {
+		_, err := fmt.Fprintln(writer, FormatCommand(command))
+		if err != nil {
+			return fmt.Errorf("could not write vboxmanage command: %w", err)
+		}
+	}
+
+	return nil
+}
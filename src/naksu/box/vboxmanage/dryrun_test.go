@@ -0,0 +1,18 @@
+package vboxmanage
+
+import "testing"
+
+func TestQuoteCommandArg(t *testing.T) {
+	testCases := map[string]string{
+		"showvminfo":                   "showvminfo",
+		"":                             `""`,
+		"Intel(R) Ethernet Connection": `"Intel(R) Ethernet Connection"`,
+		`Exam "Physics"`:               `"Exam \"Physics\""`,
+	}
+
+	for arg, expected := range testCases {
+		if quoted := quoteCommandArg(arg); quoted != expected {
+			t.Errorf("Quoting '%s' gave '%s', expected '%s'", arg, quoted, expected)
+		}
+	}
+}
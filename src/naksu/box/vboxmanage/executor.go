@@ -0,0 +1,174 @@
+package vboxmanage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"naksu/constants"
+	"naksu/log"
+)
+
+// Calling VBoxManage simultaneously tends to cause E_ACCESSDENIED errors from
+// VBoxManage. The executor runs one VBoxManage command at a time. The other
+// callers wait in a queue until the command is done or their context is done.
+// The wait in the queue and the run of the command have timeouts of their own,
+// so a long wait does not leave the command less time to run.
+
+// slowQueueWait is the queue wait time which is logged
+const slowQueueWait = 1 * time.Second
+
+// diskCommands may copy whole disk images so they get VBoxManageDiskTimeout
+var diskCommands = map[string]bool{
+	"clonemedium":    true,
+	"clonehd":        true,
+	"convertfromraw": true,
+	"modifyhd":       true,
+	"modifymedium":   true,
+	"snapshot":       true,
+}
+
+// Metrics contains statistics of the VBoxManage calls
+type Metrics struct {
+	// Commands is the number of executed commands including the failed ones
+	Commands uint64 `json:"commands"`
+	Failed   uint64 `json:"failed"`
+	// TimedOut and Canceled are the commands which were not started or were
+	// killed because their context was done
+	TimedOut uint64 `json:"timedOut"`
+	Canceled uint64 `json:"canceled"`
+	// Waiting is the number of commands currently waiting in the queue
+	Waiting        int           `json:"waiting"`
+	QueueWaitTotal time.Duration `json:"queueWaitTotalNs"`
+	QueueWaitMax   time.Duration `json:"queueWaitMaxNs"`
+	// LastCommand is the running or the previously run command
+	LastCommand string `json:"lastCommand"`
+}
+
+type runFunction func(ctx context.Context, args VBoxCommand, logOutput bool) (string, error)
+
+type executor struct {
+	queue   chan struct{}
+	run     runFunction
+	timeout func(args VBoxCommand) time.Duration
+
+	metricsMutex sync.Mutex
+	metrics      Metrics
+}
+
+var defaultExecutor = newExecutor(runVBoxManage)
+
+func newExecutor(run runFunction) *executor {
+	return &executor{
+		queue:        make(chan struct{}, 1),
+		run:          run,
+		timeout:      commandTimeout,
+		metricsMutex: sync.Mutex{},
+		metrics:      Metrics{}, // nolint: exhaustruct
+	}
+}
+
+// commandTimeout returns the time the command may wait in the queue and the
+// time it may run
+func commandTimeout(args VBoxCommand) time.Duration {
+	if len(args) > 0 && diskCommands[args[0]] {
+		return constants.VBoxManageDiskTimeout
+	}
+
+	return constants.VBoxManageTimeout
+}
+
+// execute waits for the previous commands to finish and runs the command
+func (executor *executor) execute(ctx context.Context, args VBoxCommand, logOutput bool) (string, error) {
+	queueCtx, cancelQueue := context.WithTimeout(ctx, executor.timeout(args))
+	defer cancelQueue()
+
+	command := strings.Join(args, " ")
+	queued := time.Now()
+
+	executor.updateMetrics(func(metrics *Metrics) {
+		metrics.Waiting++
+	})
+
+	select {
+	case executor.queue <- struct{}{}:
+	case <-queueCtx.Done():
+		executor.updateMetrics(func(metrics *Metrics) {
+			metrics.Waiting--
+			metrics.addQueueWait(time.Since(queued))
+			metrics.addError(queueCtx.Err())
+		})
+
+		return "", fmt.Errorf("vboxmanage %s was not started: %w", command, queueCtx.Err())
+	}
+
+	defer func() {
+		<-executor.queue
+	}()
+
+	queueWait := time.Since(queued)
+	if queueWait > slowQueueWait {
+		log.Debug("VBoxManage %s waited %v for the previous commands", command, queueWait)
+	}
+
+	executor.updateMetrics(func(metrics *Metrics) {
+		metrics.Waiting--
+		metrics.Commands++
+		metrics.LastCommand = command
+		metrics.addQueueWait(queueWait)
+	})
+
+	ctx, cancel := context.WithTimeout(ctx, executor.timeout(args))
+	defer cancel()
+
+	output, err := executor.run(ctx, args, logOutput)
+	if err != nil && ctx.Err() != nil {
+		err = fmt.Errorf("vboxmanage %s was killed: %w", command, ctx.Err())
+	}
+
+	executor.updateMetrics(func(metrics *Metrics) {
+		metrics.addError(err)
+	})
+
+	return output, err
+}
+
+func (metrics *Metrics) addQueueWait(queueWait time.Duration) {
+	metrics.QueueWaitTotal += queueWait
+	if queueWait > metrics.QueueWaitMax {
+		metrics.QueueWaitMax = queueWait
+	}
+}
+
+func (metrics *Metrics) addError(err error) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		metrics.TimedOut++
+	case errors.Is(err, context.Canceled):
+		metrics.Canceled++
+	case err != nil:
+		metrics.Failed++
+	}
+}
+
+func (executor *executor) updateMetrics(update func(metrics *Metrics)) {
+	executor.metricsMutex.Lock()
+	defer executor.metricsMutex.Unlock()
+
+	update(&executor.metrics)
+}
+
+func (executor *executor) getMetrics() Metrics {
+	executor.metricsMutex.Lock()
+	defer executor.metricsMutex.Unlock()
+
+	return executor.metrics
+}
+
+// GetMetrics returns the statistics of the VBoxManage calls made by this process
+func GetMetrics() Metrics {
+	return defaultExecutor.getMetrics()
+}
@@ -0,0 +1,147 @@
+package vboxmanage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExecutorSerializesCommands(t *testing.T) {
+	var running int32
+	var overlapped int32
+
+	testExecutor := newExecutor(func(ctx context.Context, args VBoxCommand, logOutput bool) (string, error) {
+		if atomic.AddInt32(&running, 1) > 1 {
+			atomic.StoreInt32(&overlapped, 1)
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+
+		return args[0], nil
+	})
+
+	var waitGroup sync.WaitGroup
+	for index := 0; index < 10; index++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+
+			output, err := testExecutor.execute(context.Background(), VBoxCommand{"showvminfo"}, false)
+			if err != nil || output != "showvminfo" {
+				t.Errorf("Command returned '%s', %v", output, err)
+			}
+		}()
+	}
+	waitGroup.Wait()
+
+	if atomic.LoadInt32(&overlapped) != 0 {
+		t.Error("Executor ran VBoxManage commands simultaneously")
+	}
+
+	metrics := testExecutor.getMetrics()
+	if metrics.Commands != 10 || metrics.Failed != 0 || metrics.Waiting != 0 || metrics.QueueWaitMax == 0 {
+		t.Errorf("Unexpected metrics after 10 commands: %+v", metrics)
+	}
+}
+
+func TestExecutorCancelsQueuedCommand(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	testExecutor := newExecutor(func(ctx context.Context, args VBoxCommand, logOutput bool) (string, error) {
+		close(started)
+		<-release
+
+		return "", nil
+	})
+
+	go func() {
+		_, _ = testExecutor.execute(context.Background(), VBoxCommand{"clonemedium"}, false)
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := testExecutor.execute(ctx, VBoxCommand{"showvminfo"}, false)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Queued command returned %v, expected a deadline error", err)
+	}
+
+	close(release)
+
+	metrics := testExecutor.getMetrics()
+	if metrics.TimedOut != 1 || metrics.LastCommand != "clonemedium" {
+		t.Errorf("Unexpected metrics after a timed out command: %+v", metrics)
+	}
+}
+
+func TestExecutorTimesOutRunAfterQueue(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	testExecutor := newExecutor(func(ctx context.Context, args VBoxCommand, logOutput bool) (string, error) {
+		if args[0] == "clonemedium" {
+			close(started)
+			<-release
+
+			return "", nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", errors.New("signal: killed")
+		case <-time.After(150 * time.Millisecond):
+			return "ok", nil
+		}
+	})
+	testExecutor.timeout = func(args VBoxCommand) time.Duration {
+		return 250 * time.Millisecond
+	}
+
+	go func() {
+		_, _ = testExecutor.execute(context.Background(), VBoxCommand{"clonemedium"}, false)
+	}()
+	<-started
+
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		close(release)
+	}()
+
+	// The command waits 150 ms and runs 150 ms, which is more than the timeout
+	// but less than the timeout of the queue and the run each
+	output, err := testExecutor.execute(context.Background(), VBoxCommand{"showvminfo"}, false)
+	if err != nil || output != "ok" {
+		t.Errorf("Command after a queue wait returned '%s', %v", output, err)
+	}
+}
+
+func TestExecutorKilledCommand(t *testing.T) {
+	testExecutor := newExecutor(func(ctx context.Context, args VBoxCommand, logOutput bool) (string, error) {
+		<-ctx.Done()
+
+		return "", errors.New("signal: killed")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := testExecutor.execute(ctx, VBoxCommand{"showvminfo"}, false)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Canceled command returned %v, expected a cancel error", err)
+	}
+
+	if testExecutor.getMetrics().Canceled != 1 {
+		t.Errorf("Unexpected metrics after a canceled command: %+v", testExecutor.getMetrics())
+	}
+}
+
+func TestCommandTimeout(t *testing.T) {
+	if commandTimeout(VBoxCommand{"clonemedium", "disk", "backup.vmdk"}) <= commandTimeout(VBoxCommand{"showvminfo", "vm"}) {
+		t.Error("Disk commands should have a longer timeout than the other commands")
+	}
+}
@@ -0,0 +1,198 @@
+package vboxmanage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+
+	"naksu/xlate"
+)
+
+// Known VBoxManage failures which the callers handle. Use errors.Is to check
+// whether a VBoxManage command failed because of one of these.
+var (
+	ErrInvalidObjectState = errors.New("vboxmanage invalid object state (VBOX_E_INVALID_OBJECT_STATE)")
+	ErrSessionLocked      = errors.New("vm is locked by another session")
+)
+
+// Known VBoxManage failures which are only recovered from or explained to the
+// user with their guidance
+var (
+	errAccessDenied        = errors.New("vboxmanage access denied (E_ACCESSDENIED)")
+	errInaccessibleMedium  = errors.New("medium is not accessible")
+	errKernelDriverMissing = errors.New("virtualbox kernel driver is not loaded")
+	errKVMConflict         = errors.New("hardware virtualisation is in use by kvm")
+	errDuplicateHardDisk   = errors.New("duplicate hard disk in virtualbox configuration")
+)
+
+// Error is a classified VBoxManage failure
+type Error struct {
+	Command string
+	Output  string
+	// Failure is one of the known failures above, e.g. ErrSessionLocked
+	Failure error
+	// Err is the error from executing VBoxManage
+	Err error
+
+	guidance func() string
+}
+
+func (vBoxError *Error) Error() string {
+	return fmt.Sprintf("failed to execute %s: %v: %v", vBoxError.Command, vBoxError.Failure, vBoxError.Err)
+}
+
+// Unwrap makes errors.Is work with both the known failure and the execution error
+func (vBoxError *Error) Unwrap() []error {
+	return []error{vBoxError.Failure, vBoxError.Err}
+}
+
+// TranslatedGuidance returns a translated message telling the user how to
+// fix the problem
+func (vBoxError *Error) TranslatedGuidance() string {
+	if vBoxError.guidance == nil {
+		return ""
+	}
+
+	return vBoxError.guidance()
+}
+
+// failureSignature recognises a known failure from the VBoxManage output. If
+// the failure has a recovery action, the recovery is done and the command is
+// retried at most maxRetries times. A failure which may happen after the
+// command has changed the VM is retried only with readOnly commands.
+type failureSignature struct {
+	failure      error
+	pattern      *regexp.Regexp
+	guidance     func() string
+	recover      func(ctx context.Context, output string) error
+	maxRetries   int
+	readOnlyOnly bool
+}
+
+// readOnlyCommands are the VBoxManage commands which do not change anything
+// and can be run again after any failure
+var readOnlyCommands = []string{"--version", "list", "showvminfo", "showmediuminfo", "showhdinfo", "getextradata"}
+
+// isReadOnlyCommand returns true if the VBoxManage command with the given
+// arguments does not change anything
+func isReadOnlyCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	switch args[0] {
+	case "guestproperty":
+		return len(args) > 1 && (args[1] == "get" || args[1] == "enumerate")
+	case "snapshot":
+		return len(args) > 2 && (args[2] == "list" || args[2] == "showvminfo")
+	}
+
+	return slices.Contains(readOnlyCommands, args[0])
+}
+
+// failureSignatures are matched in this order, so more specific signatures
+// (e.g. a session lock reported as VBOX_E_INVALID_OBJECT_STATE) come first
+var failureSignatures = []failureSignature{
+	{
+		failure:    errDuplicateHardDisk,
+		pattern:    duplicateHardDiskRegexp,
+		guidance:   nil,
+		recover:    fixDuplicateHardDisk,
+		maxRetries: 1,
+	},
+	{
+		failure: errKernelDriverMissing,
+		pattern: regexp.MustCompile(`VERR_VM_DRIVER_NOT_INSTALLED|Kernel driver not installed|rc=-1908`),
+		guidance: func() string {
+			return xlate.Get("The VirtualBox kernel driver is not loaded. Reinstall VirtualBox and restart the computer. If the computer uses Secure Boot, the VirtualBox kernel modules must be signed.")
+		},
+		recover:    nil,
+		maxRetries: 0,
+	},
+	{
+		failure: errKVMConflict,
+		pattern: regexp.MustCompile(`VERR_VMX_IN_VMX_ROOT_MODE|VERR_SVM_IN_USE`),
+		guidance: func() string {
+			return xlate.Get("VirtualBox cannot use the hardware virtualisation as it is reserved by the KVM kernel modules. Close the other virtual machines and unload KVM (e.g. 'sudo modprobe -r kvm_intel kvm' or 'sudo modprobe -r kvm_amd kvm'). See the startup checks for details.")
+		},
+		recover:    nil,
+		maxRetries: 0,
+	},
+	{
+		failure: ErrSessionLocked,
+		pattern: regexp.MustCompile(`is already locked (by|for) a session|is locked by a session`),
+		guidance: func() string {
+			return xlate.Get("The server is being used by another program. Close the VirtualBox windows and try again.")
+		},
+		recover:    waitBeforeRetry(3 * time.Second),
+		maxRetries: 5,
+	},
+	{
+		failure: errAccessDenied,
+		pattern: regexp.MustCompile(`E_ACCESSDENIED`),
+		guidance: func() string {
+			return xlate.Get("VirtualBox denied the access to the server. Close the VirtualBox windows and try again. If the problem persists, restart the computer.")
+		},
+		recover:      waitBeforeRetry(2 * time.Second),
+		maxRetries:   3,
+		readOnlyOnly: true,
+	},
+	{
+		failure: errInaccessibleMedium,
+		pattern: regexp.MustCompile(`(?i)(medium|hard disk) '[^']*' is not accessible|could not open the medium|VERR_FILE_NOT_FOUND`),
+		guidance: func() string {
+			return xlate.Get("The server disk image cannot be opened. Check that the disk containing the server is connected. If the disk image has been removed, remove the server and install a new one.")
+		},
+		recover:    nil,
+		maxRetries: 0,
+	},
+	{
+		failure: ErrInvalidObjectState,
+		pattern: regexp.MustCompile(`VBOX_E_INVALID_OBJECT_STATE`),
+		guidance: func() string {
+			return xlate.Get("The server is not in a state which allows this operation. Wait a moment and try again.")
+		},
+		recover:      waitBeforeRetry(2 * time.Second),
+		maxRetries:   2,
+		readOnlyOnly: true,
+	},
+}
+
+// classifyFailure returns the signature of the known failure in the
+// VBoxManage output or nil
+func classifyFailure(output string) *failureSignature {
+	for index := range failureSignatures {
+		if failureSignatures[index].pattern.MatchString(output) {
+			return &failureSignatures[index]
+		}
+	}
+
+	return nil
+}
+
+func waitBeforeRetry(delay time.Duration) func(ctx context.Context, output string) error {
+	return func(ctx context.Context, output string) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+			return nil
+		}
+	}
+}
+
+func fixDuplicateHardDisk(ctx context.Context, output string) error {
+	fixed, err := detectAndFixDuplicateHardDiskProblem(output)
+	if err != nil {
+		return err
+	}
+
+	if !fixed {
+		return errors.New("duplicate hard disk was not fixed")
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package vboxmanage
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	testCases := []struct {
+		output          string
+		expectedFailure error
+	}{
+		{"VBoxManage: error: The machine 'NaksuAbittiKTP' is already locked for a session (or being unlocked)\nVBoxManage: error: Details: code VBOX_E_INVALID_OBJECT_STATE (0x80bb0007)", ErrSessionLocked},
+		{"VBoxManage: error: Details: code E_ACCESSDENIED (0x80070005), component SessionMachine", errAccessDenied},
+		{"VBoxManage: error: Snapshot operation failed\nVBoxManage: error: Details: code VBOX_E_INVALID_OBJECT_STATE (0x80bb0007)", ErrInvalidObjectState},
+		{"VBoxManage: error: Could not open the medium '/home/opettaja/ktp/naksu_ktp_disk.vdi'.\nVBoxManage: error: VD: error VERR_FILE_NOT_FOUND opening image file", errInaccessibleMedium},
+		{"VBoxManage: error: The virtual machine 'NaksuAbittiKTP' has terminated unexpectedly during startup with exit code 1 (0x1)\nVBoxManage: error: Details: Kernel driver not installed (rc=-1908)", errKernelDriverMissing},
+		{"VBoxManage: error: VirtualBox can't operate in VMX root mode. Please disable the KVM kernel extension, recompile your kernel and reboot (VERR_VMX_IN_VMX_ROOT_MODE)", errKVMConflict},
+		{"Failed to open/create the internal network 'HostInterfaceNetworking-eth0' (VERR_VM_DRIVER_NOT_INSTALLED)", errKernelDriverMissing},
+		{"Cannot register the hard disk '/home/opettaja/ktp/naksu_ktp_disk.vdi' {9a4c2b6e-0f51-4d6e-8a3b-2f8e1c5d7a90} because a hard disk '/home/opettaja/ktp/naksu_ktp_disk.vdi' with UUID {5d2e8f1a-7c3b-4e9d-a6f0-1b2c3d4e5f60} already exists", errDuplicateHardDisk},
+		{"VBoxManage: error: Unknown option: --foo", nil},
+	}
+
+	for _, testCase := range testCases {
+		signature := classifyFailure(testCase.output)
+
+		switch {
+		case testCase.expectedFailure == nil && signature != nil:
+			t.Errorf("Output '%s' was classified as %v, expected no failure", testCase.output, signature.failure)
+		case testCase.expectedFailure != nil && (signature == nil || signature.failure != testCase.expectedFailure):
+			t.Errorf("Output '%s' was classified as %+v, expected %v", testCase.output, signature, testCase.expectedFailure)
+		}
+	}
+}
+
+func TestRunWithRecovery(t *testing.T) {
+	originalSignatures := failureSignatures
+	defer func() {
+		failureSignatures = originalSignatures
+	}()
+
+	errFlaky := errors.New("flaky")
+	failureSignatures = []failureSignature{
+		{
+			failure:    errFlaky,
+			pattern:    regexp.MustCompile("FLAKY"),
+			guidance:   func() string { return "Try again" },
+			recover:    func(ctx context.Context, output string) error { return nil },
+			maxRetries: 2,
+		},
+	}
+
+	runs := 0
+	output, err := runWithRecovery(context.Background(), "VBoxManage startvm", false, func() (string, error) {
+		runs++
+		if runs < 3 {
+			return "FLAKY", errors.New("exit status 1")
+		}
+
+		return "ok", nil
+	})
+	if err != nil || output != "ok" || runs != 3 {
+		t.Errorf("Recovered command returned '%s', %v after %d runs", output, err, runs)
+	}
+
+	runs = 0
+	_, err = runWithRecovery(context.Background(), "VBoxManage startvm", false, func() (string, error) {
+		runs++
+
+		return "FLAKY", errors.New("exit status 1")
+	})
+
+	var vBoxError *Error
+	if !errors.Is(err, errFlaky) || !errors.As(err, &vBoxError) || vBoxError.TranslatedGuidance() != "Try again" || runs != 3 {
+		t.Errorf("Failing command returned %v after %d runs, expected a classified error after 3 runs", err, runs)
+	}
+
+	_, err = runWithRecovery(context.Background(), "VBoxManage startvm", false, func() (string, error) {
+		return "something else", errors.New("exit status 1")
+	})
+	if err == nil || errors.As(err, &vBoxError) {
+		t.Errorf("Unknown failure returned %v, expected an unclassified error", err)
+	}
+}
+
+func TestRunWithRecoveryReadOnly(t *testing.T) {
+	originalSignatures := failureSignatures
+	defer func() {
+		failureSignatures = originalSignatures
+	}()
+
+	errDenied := errors.New("denied")
+	failureSignatures = []failureSignature{
+		{
+			failure:      errDenied,
+			pattern:      regexp.MustCompile("DENIED"),
+			guidance:     nil,
+			recover:      func(ctx context.Context, output string) error { return nil },
+			maxRetries:   2,
+			readOnlyOnly: true,
+		},
+	}
+
+	testCases := []struct {
+		args         []string
+		expectedRuns int
+	}{
+		{[]string{"showvminfo", "NaksuAbittiKTP", "--machinereadable"}, 3},
+		{[]string{"guestproperty", "get", "NaksuAbittiKTP", "boxType"}, 3},
+		{[]string{"snapshot", "NaksuAbittiKTP", "list", "--machinereadable"}, 3},
+		{[]string{"snapshot", "NaksuAbittiKTP", "take", "Installed"}, 1},
+		{[]string{"createvm", "--name", "NaksuAbittiKTP", "--register"}, 1},
+		{[]string{"storageattach", "NaksuAbittiKTP", "--storagectl", "SATA"}, 1},
+	}
+
+	for _, testCase := range testCases {
+		runs := 0
+		_, err := runWithRecovery(context.Background(), "VBoxManage "+testCase.args[0], isReadOnlyCommand(testCase.args), func() (string, error) {
+			runs++
+
+			return "DENIED", errors.New("exit status 1")
+		})
+
+		if !errors.Is(err, errDenied) || runs != testCase.expectedRuns {
+			t.Errorf("Command %v returned %v after %d runs, expected %d runs", testCase.args, err, runs, testCase.expectedRuns)
+		}
+	}
+}
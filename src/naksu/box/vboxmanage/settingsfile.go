@@ -0,0 +1,148 @@
+package vboxmanage
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// VBoxManage does not print the creation times of the snapshots or the disk
+// images belonging to them. They are read from the settings file (.vbox) of
+// the VM instead.
+
+// SnapshotDetails are the details of a snapshot stored in the settings file
+type SnapshotDetails struct {
+	UUID    string
+	Name    string
+	TakenAt time.Time
+	// StateFile is the saved state of a snapshot taken from a running VM
+	StateFile string
+	// DiskImages are the disk images frozen by the snapshot
+	DiskImages []string
+}
+
+type settingsFileImage struct {
+	UUID string `xml:"uuid,attr"`
+}
+
+type settingsFileStorageController struct {
+	AttachedDevices []struct {
+		Type  string            `xml:"type,attr"`
+		Image settingsFileImage `xml:"Image"`
+	} `xml:"AttachedDevice"`
+}
+
+type settingsFileSnapshot struct {
+	UUID      string `xml:"uuid,attr"`
+	Name      string `xml:"name,attr"`
+	TimeStamp string `xml:"timeStamp,attr"`
+	StateFile string `xml:"stateFile,attr"`
+	// Older settings files have the storage controllers next to the hardware
+	StorageControllers         []settingsFileStorageController `xml:"StorageControllers>StorageController"`
+	HardwareStorageControllers []settingsFileStorageController `xml:"Hardware>StorageControllers>StorageController"`
+	Snapshots                  []settingsFileSnapshot          `xml:"Snapshots>Snapshot"`
+}
+
+type settingsFileHardDisk struct {
+	UUID      string                 `xml:"uuid,attr"`
+	Location  string                 `xml:"location,attr"`
+	HardDisks []settingsFileHardDisk `xml:"HardDisk"`
+}
+
+type settingsFile struct {
+	Machine struct {
+		HardDisks []settingsFileHardDisk `xml:"MediaRegistry>HardDisks>HardDisk"`
+		Snapshots []settingsFileSnapshot `xml:"Snapshot"`
+	} `xml:"Machine"`
+}
+
+// ReadSnapshotDetails reads the snapshot details from the settings file of the
+// VM (see CfgFile of VMInfo)
+func ReadSnapshotDetails(settingsFilePath string) ([]SnapshotDetails, error) {
+	content, err := os.ReadFile(filepath.Clean(settingsFilePath))
+	if err != nil {
+		return nil, fmt.Errorf("could not read vm settings file: %w", err)
+	}
+
+	return parseSnapshotDetails(content, filepath.Dir(settingsFilePath))
+}
+
+// parseSnapshotDetails parses the settings file. The relative disk image
+// locations are relative to the machine folder.
+func parseSnapshotDetails(content []byte, machineFolder string) ([]SnapshotDetails, error) {
+	var settings settingsFile
+
+	err := xml.Unmarshal(content, &settings)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse vm settings file: %w", err)
+	}
+
+	diskLocations := map[string]string{}
+	collectDiskLocations(settings.Machine.HardDisks, machineFolder, diskLocations)
+
+	details := []SnapshotDetails{}
+	collectSnapshotDetails(settings.Machine.Snapshots, machineFolder, diskLocations, &details)
+
+	return details, nil
+}
+
+func collectDiskLocations(hardDisks []settingsFileHardDisk, machineFolder string, diskLocations map[string]string) {
+	for _, hardDisk := range hardDisks {
+		diskLocations[trimSettingsFileUUID(hardDisk.UUID)] = getSettingsFilePath(hardDisk.Location, machineFolder)
+		collectDiskLocations(hardDisk.HardDisks, machineFolder, diskLocations)
+	}
+}
+
+// collectSnapshotDetails collects the snapshots depth-first like VBoxManage
+// lists them
+func collectSnapshotDetails(snapshots []settingsFileSnapshot, machineFolder string, diskLocations map[string]string, details *[]SnapshotDetails) {
+	for _, snapshot := range snapshots {
+		// An unparseable time stamp is left as the zero time
+		takenAt, _ := time.Parse(time.RFC3339, snapshot.TimeStamp)
+
+		diskImages := []string{}
+		storageControllers := []settingsFileStorageController{}
+		storageControllers = append(storageControllers, snapshot.StorageControllers...)
+		storageControllers = append(storageControllers, snapshot.HardwareStorageControllers...)
+		for _, storageController := range storageControllers {
+			for _, attachedDevice := range storageController.AttachedDevices {
+				location, ok := diskLocations[trimSettingsFileUUID(attachedDevice.Image.UUID)]
+				if attachedDevice.Type == "HardDisk" && ok {
+					diskImages = append(diskImages, location)
+				}
+			}
+		}
+
+		stateFile := ""
+		if snapshot.StateFile != "" {
+			stateFile = getSettingsFilePath(snapshot.StateFile, machineFolder)
+		}
+
+		*details = append(*details, SnapshotDetails{
+			UUID:       trimSettingsFileUUID(snapshot.UUID),
+			Name:       snapshot.Name,
+			TakenAt:    takenAt,
+			StateFile:  stateFile,
+			DiskImages: diskImages,
+		})
+
+		collectSnapshotDetails(snapshot.Snapshots, machineFolder, diskLocations, details)
+	}
+}
+
+// trimSettingsFileUUID removes the braces around the UUIDs of the settings
+// file so that they match the UUIDs printed by VBoxManage
+func trimSettingsFileUUID(uuid string) string {
+	return strings.Trim(uuid, "{}")
+}
+
+func getSettingsFilePath(location string, machineFolder string) string {
+	if filepath.IsAbs(location) || strings.HasPrefix(location, `\\`) || (len(location) > 1 && location[1] == ':') {
+		return location
+	}
+
+	return filepath.Join(machineFolder, filepath.FromSlash(location))
+}
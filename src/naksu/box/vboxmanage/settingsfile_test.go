@@ -0,0 +1,35 @@
+package vboxmanage
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSnapshotDetails(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "NaksuAbittiKTP.vbox"))
+	if err != nil {
+		t.Fatalf("Could not read fixture: %v", err)
+	}
+
+	machineFolder := filepath.Join("home", "VirtualBox VMs", "NaksuAbittiKTP")
+	snapshotDisk := filepath.Join(machineFolder, "Snapshots", "{f2a3b4c5-d6e7-4f80-91a2-b3c4d5e6f708}.vdi")
+
+	details, err := parseSnapshotDetails(content, machineFolder)
+	if err != nil {
+		t.Fatalf("Could not parse fixture: %v", err)
+	}
+
+	expected := []SnapshotDetails{
+		{"8a9b0c1d-2e3f-4a5b-9c6d-7e8f9a0b1c2d", "Installed", time.Date(2025, 5, 30, 8, 12, 44, 0, time.UTC), "", []string{"/home/opettaja/ktp/naksu_ktp_disk.vdi"}},
+		{"9b0c1d2e-3f4a-4b5c-8d7e-8f9a0b1c2d3e", `Exam "Physics" ready`, time.Date(2025, 6, 1, 7, 45, 10, 0, time.UTC), filepath.Join(machineFolder, "Snapshots", "2025-06-01T07-45-10-118Z.sav"), []string{snapshotDisk}},
+		{"0c1d2e3f-4a5b-4c6d-9e8f-9a0b1c2d3e4f", "After exam", time.Date(2025, 6, 1, 13, 2, 51, 0, time.UTC), "", []string{filepath.Join(machineFolder, "Snapshots", "{a3b4c5d6-e7f8-4091-a2b3-c4d5e6f70819}.vdi")}},
+		{"1d2e3f4a-5b6c-4d7e-8f90-a0b1c2d3e4f5", "Spare", time.Date(2025, 5, 31, 9, 0, 0, 0, time.UTC), "", []string{snapshotDisk}},
+	}
+
+	if !reflect.DeepEqual(details, expected) {
+		t.Errorf("Got snapshot details\n%+v\nexpected\n%+v", details, expected)
+	}
+}
@@ -0,0 +1,78 @@
+package vboxmanage
+
+import (
+	"errors"
+	"fmt"
+
+	"naksu/log"
+)
+
+// UndoAction reverts a completed step of a Transaction
+type UndoAction struct {
+	Description string
+	Undo        func() error
+}
+
+// UndoCommand returns an UndoAction running the given VBoxManage command
+func UndoCommand(command VBoxCommand) UndoAction {
+	return UndoAction{
+		Description: fmt.Sprintf("VBoxManage %v", command),
+		Undo: func() error {
+			_, err := RunCommand(command)
+
+			return err
+		},
+	}
+}
+
+// Transaction runs VBoxManage commands and records how to undo them. If a
+// command fails, the completed commands are undone in the reverse order so
+// that no half-created VM or orphaned disk image is left behind.
+type Transaction struct {
+	undoActions []UndoAction
+}
+
+// NewTransaction returns a new empty transaction
+func NewTransaction() *Transaction {
+	return &Transaction{undoActions: []UndoAction{}}
+}
+
+// Record records undo actions which are run on rollback. Use this before
+// running a command which may leave something behind even if it fails (e.g.
+// a partially written disk image).
+func (transaction *Transaction) Record(undoActions ...UndoAction) {
+	transaction.undoActions = append(transaction.undoActions, undoActions...)
+}
+
+// Run runs the command and records the undo actions if the command succeeds
+func (transaction *Transaction) Run(command VBoxCommand, undoActions ...UndoAction) error {
+	_, err := RunCommand(command)
+	if err != nil {
+		return err
+	}
+
+	transaction.Record(undoActions...)
+
+	return nil
+}
+
+// Rollback runs the recorded undo actions in the reverse order. All actions
+// are tried even if some of them fail.
+func (transaction *Transaction) Rollback() error {
+	var rollbackErrors []error
+
+	for index := len(transaction.undoActions) - 1; index >= 0; index-- {
+		undoAction := transaction.undoActions[index]
+
+		log.Debug("Rolling back: %s", undoAction.Description)
+		err := undoAction.Undo()
+		if err != nil {
+			log.Error("Rollback action '%s' failed: %v", undoAction.Description, err)
+			rollbackErrors = append(rollbackErrors, fmt.Errorf("%s: %w", undoAction.Description, err))
+		}
+	}
+
+	transaction.undoActions = []UndoAction{}
+
+	return errors.Join(rollbackErrors...)
+}
@@ -0,0 +1,104 @@
+package vboxmanage
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTransactionRollback(t *testing.T) {
+	originalExecutor := defaultExecutor
+	defer func() {
+		defaultExecutor = originalExecutor
+	}()
+
+	executed := []string{}
+	defaultExecutor = newExecutor(func(ctx context.Context, args VBoxCommand, logOutput bool) (string, error) {
+		executed = append(executed, strings.Join(args, " "))
+		if args[0] == "storageattach" {
+			return "VBoxManage: error: Could not find file for the medium", errors.New("exit status 1")
+		}
+
+		return "", nil
+	})
+
+	removedFile := false
+	removeFile := UndoAction{Description: "remove disk image", Undo: func() error {
+		removedFile = true
+
+		return nil
+	}}
+
+	transaction := NewTransaction()
+	transaction.Record(removeFile)
+	commands := []VBoxCommand{
+		{"convertfromraw", "image.dd", "image.vdi"},
+		{"createvm", "--name", "vm", "--register"},
+		{"storageattach", "vm"},
+		{"snapshot", "vm", "take", "Installed"},
+	}
+
+	var err error
+	for _, command := range commands {
+		if command[0] == "createvm" {
+			err = transaction.Run(command, UndoCommand(VBoxCommand{"unregistervm", "vm", "--delete"}))
+		} else {
+			err = transaction.Run(command)
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		t.Fatal("Transaction should fail when storageattach fails")
+	}
+
+	if err := transaction.Rollback(); err != nil {
+		t.Errorf("Rollback failed: %v", err)
+	}
+
+	expectedExecuted := []string{
+		"convertfromraw image.dd image.vdi",
+		"createvm --name vm --register",
+		"storageattach vm",
+		"unregistervm vm --delete",
+	}
+	if !reflect.DeepEqual(executed, expectedExecuted) {
+		t.Errorf("Executed %v, expected %v", executed, expectedExecuted)
+	}
+
+	if !removedFile {
+		t.Error("Rollback did not run the recorded undo action")
+	}
+}
+
+func TestTransactionRollbackContinuesAfterError(t *testing.T) {
+	undone := []string{}
+	undoAction := func(name string, err error) UndoAction {
+		return UndoAction{Description: name, Undo: func() error {
+			undone = append(undone, name)
+
+			return err
+		}}
+	}
+
+	transaction := NewTransaction()
+	transaction.Record(undoAction("first", nil), undoAction("second", errors.New("failed")), undoAction("third", nil))
+
+	err := transaction.Rollback()
+	if err == nil || !strings.Contains(err.Error(), "second") {
+		t.Errorf("Rollback returned %v, expected the error of the second action", err)
+	}
+
+	if !reflect.DeepEqual(undone, []string{"third", "second", "first"}) {
+		t.Errorf("Undo actions were run in order %v", undone)
+	}
+
+	if err := transaction.Rollback(); err != nil || len(undone) != 3 {
+		t.Error("Second rollback should not run the undo actions again")
+	}
+}
@@ -1,11 +1,11 @@
 package vboxmanage
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"regexp"
 	"strings"
-	"time"
 
 	semver "github.com/blang/semver/v4"
 	memory_cache "github.com/paulusrobin/go-memory-cache/memory-cache"
@@ -17,9 +17,12 @@ import (
 
 const vBoxManageOutputNoVMInstalled string = "Could not find a registered machine named"
 
+// vBoxEscapedVMInfoMajorVersion is the first VirtualBox major version which
+// escapes the quoted values of the machine-readable vm info
+const vBoxEscapedVMInfoMajorVersion = 7
+
 // vBoxResponseCache is initialised by init() -> ensureVBoxResponseCacheInitialised()
 var vBoxResponseCache memory_cache.Cache
-var vBoxManageStarted int64
 
 type VBoxCommand = []string
 
@@ -29,31 +32,18 @@ func init() {
 }
 
 func RunCommand(args VBoxCommand) (string, error) {
-	return runCommand(args, true)
+	return defaultExecutor.execute(context.Background(), args, true)
 }
 
-func RunCommandWithoutLogging(args VBoxCommand) (string, error) {
-	return runCommand(args, false)
+// RunCommandContext runs a VBoxManage command after the previous commands
+// have finished. The command is not started or it is killed if the context
+// is done or the command timeout passes.
+func RunCommandContext(ctx context.Context, args VBoxCommand) (string, error) {
+	return defaultExecutor.execute(ctx, args, true)
 }
 
-func runCommand(args VBoxCommand, logOutput bool) (string, error) {
-	// There is an ongoing VBoxManage call (break free after 240 loops)
-	// This locking avoids executing multiple instances of VBoxManage at the same time. Calling
-	// VBoxManage simulaneously tends to cause E_ACCESSDENIED errors from VBoxManage.
-	tryCounter := 0
-	const waitBetweenTries = 500 * time.Millisecond
-	const maximumTries = 240
-	for (vBoxManageStarted != 0) && (tryCounter < maximumTries) {
-		time.Sleep(waitBetweenTries)
-		tryCounter++
-		log.Debug("RunCommand is waiting VBoxManage to exit (race condition lock count %d)", tryCounter)
-	}
-
-	vBoxManageStarted = time.Now().Unix()
-	vBoxManageOutput, err := runVBoxManage(args, logOutput)
-	vBoxManageStarted = 0
-
-	return vBoxManageOutput, err
+func RunCommandWithoutLogging(args VBoxCommand) (string, error) {
+	return defaultExecutor.execute(context.Background(), args, false)
 }
 
 func RunCommands(commands []VBoxCommand) error {
@@ -68,37 +58,51 @@ func RunCommands(commands []VBoxCommand) error {
 }
 
 // runVBoxManage runs vboxmanage command with given arguments
-func runVBoxManage(args []string, logOutput bool) (string, error) {
+func runVBoxManage(ctx context.Context, args []string, logOutput bool) (string, error) {
 	runArgs := []string{getVBoxManagePath()}
 	runArgs = append(runArgs, args...)
-	vBoxManageOutput, err := mebroutines.RunAndGetOutput(runArgs, logOutput)
-	if err != nil {
-		command := strings.Join(runArgs, " ")
-		logError := func(output string, err error) {
-			log.Error("Failed to execute %s (%v), complete output:", command, err)
-			log.Error(output)
+
+	return runWithRecovery(ctx, strings.Join(runArgs, " "), isReadOnlyCommand(args), func() (string, error) {
+		return mebroutines.RunAndGetOutputContext(ctx, runArgs, logOutput)
+	})
+}
+
+// runWithRecovery runs the command. Known failures are returned as *Error and
+// recovered from by retrying the command if the failure has a recovery action.
+// Commands which are not readOnly are retried only after the failures which
+// leave the VM unchanged.
+func runWithRecovery(ctx context.Context, command string, readOnly bool, run func() (string, error)) (string, error) {
+	retries := map[error]int{}
+
+	for {
+		vBoxManageOutput, err := run()
+		if err == nil {
+			return vBoxManageOutput, nil
 		}
 
-		logError(vBoxManageOutput, err)
+		log.Error("Failed to execute %s (%v), complete output:", command, err)
+		log.Error(vBoxManageOutput)
+
+		signature := classifyFailure(vBoxManageOutput)
+		if signature == nil {
+			return vBoxManageOutput, fmt.Errorf("failed to execute %s: %w", command, err)
+		}
 
-		fixed, fixErr := detectAndFixDuplicateHardDiskProblem(vBoxManageOutput)
-		if !fixed && fixErr != nil {
-			log.Error("Failed to fix duplicate hard disk problem with command %s: (%v)", command, fixErr)
+		vBoxError := &Error{Command: command, Output: vBoxManageOutput, Failure: signature.failure, Err: err, guidance: signature.guidance}
 
-			return "", fmt.Errorf("failed to execute %s: %w", command, err)
+		if signature.recover == nil || (signature.readOnlyOnly && !readOnly) || retries[signature.failure] >= signature.maxRetries || ctx.Err() != nil {
+			return vBoxManageOutput, vBoxError
 		}
+		retries[signature.failure]++
 
-		// We need to re-run the command only if problem was fixed
-		if fixed {
-			log.Debug("Retrying '%s' after fixing problem", command)
-			vBoxManageOutput, err = mebroutines.RunAndGetOutput(runArgs, logOutput)
-			if err != nil {
-				logError(vBoxManageOutput, err)
-			}
+		if recoverErr := signature.recover(ctx, vBoxManageOutput); recoverErr != nil {
+			log.Error("Failed to recover from '%v' with command %s: %v", signature.failure, command, recoverErr)
+
+			return vBoxManageOutput, vBoxError
 		}
-	}
 
-	return vBoxManageOutput, err
+		log.Debug("Retrying '%s' after '%v' (retry %d/%d)", command, signature.failure, retries[signature.failure], signature.maxRetries)
+	}
 }
 
 func ensureVBoxResponseCacheInitialised() {
@@ -118,27 +122,39 @@ func ResetVBoxResponseCache() {
 	ensureVBoxResponseCacheInitialised()
 }
 
-// GetVMInfoByRegexp returns result of the given vmRegexp from the current VBoxManage showvminfo
-// output. This function gets the output either from the cache or calls getVBoxManageOutput()
-func GetVMInfoByRegexp(vmName string, vmRegexp string) string {
-	rawVMInfo := getVMInfo(vmName)
+// getVMCacheKey returns the vBoxResponseCache key of a VM specific response
+func getVMCacheKey(vmName string, response string) string {
+	return vmName + "/" + response
+}
 
-	// Extract server name
-	pattern := regexp.MustCompile(vmRegexp)
-	result := pattern.FindStringSubmatch(rawVMInfo)
+// GetVMInfo returns the parsed VBoxManage showvminfo output of the given VM.
+// This function gets the output either from the cache or calls VBoxManage.
+func GetVMInfo(vmName string) (VMInfo, error) {
+	info, err := ParseVMInfo(getVMInfo(vmName), isVMInfoEscaped())
+	if err != nil {
+		return VMInfo{}, fmt.Errorf("could not parse vm info of %s: %w", vmName, err)
+	}
 
-	if len(result) > 1 {
-		return result[1]
+	return info, nil
+}
+
+// isVMInfoEscaped returns true if VBoxManage escapes the quoted values of
+// the machine-readable vm info. Naksu requires VirtualBox 7, so an unknown
+// version is expected to escape the values.
+func isVMInfoEscaped() bool {
+	version, err := GetVBoxManageVersion()
+	if err != nil {
+		return true
 	}
 
-	return ""
+	return version.Major >= vBoxEscapedVMInfoMajorVersion
 }
 
 // Get "showvminfo" output from vBoxResponseCache (if present) or VBoxManage
 func getVMInfo(vmName string) string {
 	var rawVMInfo string
 
-	rawVMInfoInterface, err := vBoxResponseCache.Get("showvminfo")
+	rawVMInfoInterface, err := vBoxResponseCache.Get(getVMCacheKey(vmName, "showvminfo"))
 	if err != nil {
 		rawVMInfo, err = RunCommandWithoutLogging([]string{"showvminfo", "--machinereadable", vmName})
 		if err != nil {
@@ -146,7 +162,7 @@ func getVMInfo(vmName string) string {
 			rawVMInfo = ""
 		}
 
-		errCache := vBoxResponseCache.Set("showvminfo", rawVMInfo, constants.VBoxManageCacheTimeout)
+		errCache := vBoxResponseCache.Set(getVMCacheKey(vmName, "showvminfo"), rawVMInfo, constants.VBoxManageCacheTimeout)
 		if errCache != nil {
 			log.Warning("Could not store VM info to cache: %v", errCache)
 		}
@@ -224,7 +240,7 @@ func getVMPropertyByExecutingVBoxManage(vmName string, property string) string {
 		propertyValue = propMatches[1]
 	}
 
-	err = vBoxResponseCache.Set(property, propertyValue, constants.VBoxManageCacheTimeout)
+	err = vBoxResponseCache.Set(getVMCacheKey(vmName, property), propertyValue, constants.VBoxManageCacheTimeout)
 	if err == nil {
 		log.Debug("Stored VM guest property '%s' value '%s' to cache", property, propertyValue)
 	} else {
@@ -237,7 +253,7 @@ func getVMPropertyByExecutingVBoxManage(vmName string, property string) string {
 func GetVMProperty(vmName string, property string) string {
 	propertyValue := ""
 
-	propertyValueInterface, err := vBoxResponseCache.Get(property)
+	propertyValueInterface, err := vBoxResponseCache.Get(getVMCacheKey(vmName, property))
 	if err != nil {
 		propertyValue = getVMPropertyByExecutingVBoxManage(vmName, property)
 	} else {
@@ -248,19 +264,8 @@ func GetVMProperty(vmName string, property string) string {
 	return propertyValue
 }
 
-func getVMStateFromOutput(output string) string {
-	re := regexp.MustCompile(`VMState="(.+)"`)
-	result := re.FindStringSubmatch(output)
-
-	if len(result) > 1 {
-		return result[1]
-	}
-
-	return ""
-}
-
 func getVMState(vmName string) (string, error) {
-	vmState, err := vBoxResponseCache.Get("vmstate")
+	vmState, err := vBoxResponseCache.Get(getVMCacheKey(vmName, "vmstate"))
 	if err != nil {
 		rawVMInfo, err := RunCommandWithoutLogging([]string{"showvminfo", "--machinereadable", vmName})
 
@@ -279,14 +284,15 @@ func getVMState(vmName string) (string, error) {
 		}
 
 		// Extract state string
-		vmState = getVMStateFromOutput(rawVMInfo)
-		if vmState == "" {
+		vmInfo, errParse := ParseVMInfo(rawVMInfo, isVMInfoEscaped())
+		if errParse != nil || vmInfo.State == "" {
 			log.Debug("Could not find VM state from the VM info")
 
 			return "", errors.New("could not find vm state from the vm info")
 		}
+		vmState = vmInfo.State
 
-		errCache := vBoxResponseCache.Set("vmstate", vmState, constants.VBoxRunningCacheTimeout)
+		errCache := vBoxResponseCache.Set(getVMCacheKey(vmName, "vmstate"), vmState, constants.VBoxRunningCacheTimeout)
 		if errCache != nil {
 			log.Warning("Could not store VM state to cache: %v", errCache)
 		}
@@ -295,6 +301,15 @@ func getVMState(vmName string) (string, error) {
 	return fmt.Sprintf("%v", vmState), nil
 }
 
+// ResetVMStateCache forgets the cached state and VM info of the given VM so
+// that the next IsVMRunning call sees state changes immediately
+func ResetVMStateCache(vmName string) {
+	for _, response := range []string{"vmstate", "showvminfo"} {
+		// Remove returns an error if the response was not cached
+		_ = vBoxResponseCache.Remove(getVMCacheKey(vmName, response))
+	}
+}
+
 // IsVMRunning returns true if given VM is currently running
 func IsVMRunning(vmName string) (bool, string, error) {
 	vmState, err := getVMState(vmName)
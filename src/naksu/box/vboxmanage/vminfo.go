@@ -0,0 +1,328 @@
+package vboxmanage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrNotVMInfo is returned by ParseVMInfo if the output does not look like
+// the output of "VBoxManage showvminfo --machinereadable"
+var ErrNotVMInfo = errors.New("not machine-readable vm info")
+
+// VMInfo is the parsed output of "VBoxManage showvminfo --machinereadable"
+type VMInfo struct {
+	Name      string
+	UUID      string
+	OSType    string
+	State     string
+	LogFolder string
+	// MemoryMB is the size of the VM memory in megabytes
+	MemoryMB uint64
+	// VRAMMB is the size of the video memory in megabytes
+	VRAMMB uint64
+	CPUs   int
+
+	NICs               []NIC
+	StorageAttachments []StorageAttachment
+	SharedFolders      []SharedFolder
+	// Snapshots are in the order VBoxManage lists them (depth-first)
+	Snapshots       []Snapshot
+	CurrentSnapshot string
+
+	properties map[string]string
+}
+
+// NIC is an enabled network adapter of the VM
+type NIC struct {
+	// Index is the number of the adapter starting from 1
+	Index int
+	// Attachment is the attachment type, e.g. "nat", "bridged" or "intnet"
+	Attachment string
+	// Adapter is the host interface or network the NIC is attached to
+	Adapter        string
+	Type           string
+	MACAddress     string
+	CableConnected bool
+}
+
+// StorageAttachment is a medium attached to a storage controller port
+type StorageAttachment struct {
+	Controller string
+	Port       int
+	Device     int
+	// Medium is the path of the attached medium or "emptydrive"
+	Medium    string
+	ImageUUID string
+}
+
+// SharedFolder is a folder shared from the host to the VM
+type SharedFolder struct {
+	Name      string
+	Path      string
+	Transient bool
+}
+
+// Snapshot is a snapshot of the VM
+type Snapshot struct {
+	Name        string
+	UUID        string
+	Description string
+	// Node is the position of the snapshot in the snapshot tree, e.g. "" for
+	// the root snapshot or "1-2" for the second child of its first child
+	Node string
+}
+
+var vmInfoStorageAttachmentRegexp = regexp.MustCompile(`^(.+)-(\d+)-(\d+)$`)
+var vmInfoNICRegexp = regexp.MustCompile(`^nic(\d+)$`)
+var vmInfoSharedFolderRegexp = regexp.MustCompile(`^SharedFolderName(Machine|Transient)Mapping(\d+)$`)
+var vmInfoSnapshotRegexp = regexp.MustCompile(`^SnapshotName(-[\d-]+)?$`)
+
+// ParseVMInfo parses the output of "VBoxManage showvminfo --machinereadable".
+// The format is the same in VirtualBox 6.1 and 7.x: one key=value pair per
+// line where the keys and strings may be quoted. VirtualBox 7.x escapes the
+// quotes and backslashes inside the quoted values (isEscaped) while
+// VirtualBox 6.1 does not.
+func ParseVMInfo(output string, isEscaped bool) (VMInfo, error) {
+	properties, keys := parseVMInfoProperties(output, isEscaped)
+
+	if _, ok := properties["name"]; !ok {
+		return VMInfo{}, ErrNotVMInfo
+	}
+
+	info := VMInfo{
+		Name:               properties["name"],
+		UUID:               properties["UUID"],
+		OSType:             properties["ostype"],
+		State:              properties["VMState"],
+		LogFolder:          properties["LogFldr"],
+		MemoryMB:           parseVMInfoUint(properties["memory"]),
+		VRAMMB:             parseVMInfoUint(properties["vram"]),
+		CPUs:               int(parseVMInfoUint(properties["cpus"])),
+		NICs:               []NIC{},
+		StorageAttachments: []StorageAttachment{},
+		SharedFolders:      []SharedFolder{},
+		Snapshots:          []Snapshot{},
+		CurrentSnapshot:    properties["CurrentSnapshotName"],
+		properties:         properties,
+	}
+
+	storageControllers := map[string]bool{}
+	for _, key := range keys {
+		if strings.HasPrefix(key, "storagecontrollername") {
+			storageControllers[properties[key]] = true
+		}
+	}
+
+	for _, key := range keys {
+		value := properties[key]
+
+		if match := vmInfoStorageAttachmentRegexp.FindStringSubmatch(key); match != nil && storageControllers[match[1]] {
+			if value != "none" {
+				info.StorageAttachments = append(info.StorageAttachments, StorageAttachment{
+					Controller: match[1],
+					Port:       int(parseVMInfoUint(match[2])),
+					Device:     int(parseVMInfoUint(match[3])),
+					Medium:     value,
+					ImageUUID:  properties[fmt.Sprintf("%s-ImageUUID-%s-%s", match[1], match[2], match[3])],
+				})
+			}
+
+			continue
+		}
+
+		if match := vmInfoNICRegexp.FindStringSubmatch(key); match != nil {
+			if value != "none" {
+				info.NICs = append(info.NICs, parseVMInfoNIC(properties, match[1], value))
+			}
+
+			continue
+		}
+
+		if match := vmInfoSharedFolderRegexp.FindStringSubmatch(key); match != nil {
+			info.SharedFolders = append(info.SharedFolders, SharedFolder{
+				Name:      value,
+				Path:      properties[fmt.Sprintf("SharedFolderPath%sMapping%s", match[1], match[2])],
+				Transient: match[1] == "Transient",
+			})
+
+			continue
+		}
+
+		if match := vmInfoSnapshotRegexp.FindStringSubmatch(key); match != nil {
+			info.Snapshots = append(info.Snapshots, Snapshot{
+				Name:        value,
+				UUID:        properties["SnapshotUUID"+match[1]],
+				Description: properties["SnapshotDescription"+match[1]],
+				Node:        strings.TrimPrefix(match[1], "-"),
+			})
+		}
+	}
+
+	return info, nil
+}
+
+// Get returns the raw value of the given key, e.g. "firmware"
+func (info VMInfo) Get(key string) string {
+	return info.properties[key]
+}
+
+// StorageAttachment returns the medium attached to the given controller port
+// and device
+func (info VMInfo) StorageAttachment(controller string, port int, device int) (StorageAttachment, bool) {
+	for _, attachment := range info.StorageAttachments {
+		if attachment.Controller == controller && attachment.Port == port && attachment.Device == device {
+			return attachment, true
+		}
+	}
+
+	return StorageAttachment{}, false
+}
+
+// HasSnapshot returns true if the VM has a snapshot with the given name
+func (info VMInfo) HasSnapshot(name string) bool {
+	for _, snapshot := range info.Snapshots {
+		if snapshot.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseVMInfoNIC(properties map[string]string, index string, attachment string) NIC {
+	adapterKeys := map[string]string{
+		"bridged":    "bridgeadapter",
+		"hostonly":   "hostonlyadapter",
+		"intnet":     "intnet",
+		"natnetwork": "nat-network",
+		"nat":        "natnet",
+	}
+
+	adapter := ""
+	if adapterKey, ok := adapterKeys[attachment]; ok {
+		adapter = properties[adapterKey+index]
+	}
+
+	return NIC{
+		Index:          int(parseVMInfoUint(index)),
+		Attachment:     attachment,
+		Adapter:        adapter,
+		Type:           properties["nictype"+index],
+		MACAddress:     properties["macaddress"+index],
+		CableConnected: properties["cableconnected"+index] == "on",
+	}
+}
+
+// parseVMInfoProperties returns the key-value pairs and the keys in the order
+// of the output. Quoted values may span several lines (e.g. snapshot
+// descriptions).
+func parseVMInfoProperties(output string, isEscaped bool) (map[string]string, []string) {
+	properties := map[string]string{}
+	keys := []string{}
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		key, value, ok := splitVMInfoLine(line)
+		if !ok {
+			continue
+		}
+
+		for isUnterminatedVMInfoValue(value, isEscaped) && scanner.Scan() {
+			value += "\n" + strings.TrimRight(scanner.Text(), "\r")
+		}
+
+		if _, exists := properties[key]; !exists {
+			keys = append(keys, key)
+		}
+		properties[key] = unquoteVMInfoValue(value, isEscaped)
+	}
+
+	return properties, keys
+}
+
+// splitVMInfoLine splits a line to a key and a raw value. The key may be
+// quoted since storage attachment keys contain the controller name, e.g.
+// "SATA Controller-0-0"="/path/to/disk.vdi".
+func splitVMInfoLine(line string) (string, string, bool) {
+	if strings.HasPrefix(line, `"`) {
+		keyEnd := strings.Index(line[1:], `"=`)
+		if keyEnd == -1 {
+			return "", "", false
+		}
+
+		return line[1 : keyEnd+1], line[keyEnd+3:], true
+	}
+
+	key, value, found := strings.Cut(line, "=")
+	if !found || key == "" {
+		return "", "", false
+	}
+
+	return key, value, true
+}
+
+// isUnterminatedVMInfoValue returns true if a quoted value continues on the
+// next line. Escaped values end at the first quote which is not escaped.
+// VirtualBox 6.1 does not escape the values, so there counting the quotes
+// keeps values such as "E:\" (a shared folder) on a single line. Either way
+// VideoMode="1280,800,32"@0,0 1 is a single line.
+func isUnterminatedVMInfoValue(value string, isEscaped bool) bool {
+	if !strings.HasPrefix(value, `"`) {
+		return false
+	}
+
+	if !isEscaped {
+		return strings.Count(value, `"`)%2 == 1
+	}
+
+	for index := 1; index < len(value); index++ {
+		switch value[index] {
+		case '\\':
+			index++
+		case '"':
+			return false
+		}
+	}
+
+	return true
+}
+
+// unquoteVMInfoValue removes the quotes around a value and, if the value is
+// escaped, the escapes of the quotes and backslashes inside it
+func unquoteVMInfoValue(value string, isEscaped bool) string {
+	if len(value) < 2 || !strings.HasPrefix(value, `"`) || !strings.HasSuffix(value, `"`) {
+		return value
+	}
+
+	inner := value[1 : len(value)-1]
+	if !isEscaped {
+		return inner
+	}
+
+	var builder strings.Builder
+
+	for index := 0; index < len(inner); index++ {
+		if inner[index] == '\\' && index+1 < len(inner) && (inner[index+1] == '"' || inner[index+1] == '\\') {
+			index++
+		}
+
+		builder.WriteByte(inner[index])
+	}
+
+	return builder.String()
+}
+
+func parseVMInfoUint(value string) uint64 {
+	number, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return number
+}
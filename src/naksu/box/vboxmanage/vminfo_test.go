@@ -0,0 +1,200 @@
+package vboxmanage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func readVMInfoFixture(t *testing.T, filename string) VMInfo {
+	t.Helper()
+
+	output, err := os.ReadFile(filepath.Join("testdata", filename))
+	if err != nil {
+		t.Fatalf("Could not read fixture %s: %v", filename, err)
+	}
+
+	// The machine-readable output of VirtualBox 7.x is escaped
+	info, err := ParseVMInfo(string(output), !strings.HasPrefix(filename, "showvminfo-6."))
+	if err != nil {
+		t.Fatalf("Could not parse fixture %s: %v", filename, err)
+	}
+
+	return info
+}
+
+func TestParseVMInfoFixtures(t *testing.T) {
+	testCases := []struct {
+		filename              string
+		expectedState         string
+		expectedMemoryMB      uint64
+		expectedCPUs          int
+		expectedLogFolder     string
+		expectedDisk          StorageAttachment
+		expectedNICs          []NIC
+		expectedSharedFolders []SharedFolder
+		expectedSnapshots     []Snapshot
+		expectedCurrent       string
+	}{
+		{
+			filename:          "showvminfo-6.1.txt",
+			expectedState:     "poweroff",
+			expectedMemoryMB:  5878,
+			expectedCPUs:      3,
+			expectedLogFolder: "/home/opettaja/VirtualBox VMs/NaksuAbittiKTP/Logs",
+			expectedDisk:      StorageAttachment{"SATA Controller", 0, 0, "/home/opettaja/ktp/naksu_ktp_disk.vdi", "9a4c2b6e-0f51-4d6e-8a3b-2f8e1c5d7a90"},
+			expectedNICs: []NIC{
+				{1, "nat", "nat", "virtio", "080027A1B2C3", true},
+			},
+			expectedSharedFolders: []SharedFolder{
+				{"media_usb1", "/home/opettaja/ktp-jako", false},
+			},
+			expectedSnapshots: []Snapshot{
+				{"Installed", "5d2e8f1a-7c3b-4e9d-a6f0-1b2c3d4e5f60", "Server installed by Naksu\nbefore the first exam", ""},
+				{"Before exam", "6e3f9a2b-8d4c-4fae-b7a1-2c3d4e5f6071", "", "1"},
+			},
+			expectedCurrent: "Before exam",
+		},
+		{
+			filename:          "showvminfo-7.0.txt",
+			expectedState:     "running",
+			expectedMemoryMB:  11878,
+			expectedCPUs:      7,
+			expectedLogFolder: `C:\Users\opettaja\VirtualBox VMs\NaksuAbittiKTP\Logs`,
+			expectedDisk:      StorageAttachment{"SATA Controller", 0, 0, `C:\Users\opettaja\ktp\naksu_ktp_disk.vdi`, "c4d5e6f7-0819-4a2b-9c3d-4e5f60718293"},
+			expectedNICs: []NIC{
+				{1, "bridged", "Intel(R) Ethernet Connection (7) I219-LM", "virtio", "0800271A2B3C", true},
+			},
+			expectedSharedFolders: []SharedFolder{
+				{"media_usb1", `C:\Users\opettaja\ktp-jako`, false},
+				{"usb_stick", `E:\`, true},
+			},
+			expectedSnapshots: []Snapshot{
+				{"Installed", "d5e6f708-192a-4b3c-8d4e-5f6071829304", "", ""},
+			},
+			expectedCurrent: "Installed",
+		},
+		{
+			filename:          "showvminfo-7.1.txt",
+			expectedState:     "saved",
+			expectedMemoryMB:  23808,
+			expectedCPUs:      15,
+			expectedLogFolder: "/home/opettaja/VirtualBox VMs/NaksuAbittiKTP/Logs",
+			expectedDisk:      StorageAttachment{"SATA Controller", 0, 0, "/home/opettaja/VirtualBox VMs/NaksuAbittiKTP/Snapshots/{1a2b3c4d-5e6f-4a7b-8c9d-0e1f2a3b4c5d}.vdi", "1a2b3c4d-5e6f-4a7b-8c9d-0e1f2a3b4c5d"},
+			expectedNICs: []NIC{
+				{1, "intnet", "abitti", "82540EM", "08002733CC44", true},
+				{2, "nat", "nat", "virtio", "08002755EE66", false},
+			},
+			expectedSharedFolders: []SharedFolder{
+				{"media_usb1", "/home/opettaja/ktp-jako", false},
+			},
+			expectedSnapshots: []Snapshot{
+				{"Installed", "8a9b0c1d-2e3f-4a5b-9c6d-7e8f9a0b1c2d", "", ""},
+				{`Exam "Physics" ready`, "9b0c1d2e-3f4a-4b5c-8d7e-8f9a0b1c2d3e", "Keys loaded", "1"},
+				{"After exam", "0c1d2e3f-4a5b-4c6d-9e8f-9a0b1c2d3e4f", "", "1-1"},
+				{"Spare", "1d2e3f4a-5b6c-4d7e-8f90-a0b1c2d3e4f5", "", "2"},
+			},
+			expectedCurrent: "After exam",
+		},
+	}
+
+	for _, testCase := range testCases {
+		info := readVMInfoFixture(t, testCase.filename)
+
+		if info.Name != "NaksuAbittiKTP" || info.State != testCase.expectedState || info.MemoryMB != testCase.expectedMemoryMB || info.CPUs != testCase.expectedCPUs || info.VRAMMB != 24 {
+			t.Errorf("%s: got name %s, state %s, memory %d, cpus %d, vram %d", testCase.filename, info.Name, info.State, info.MemoryMB, info.CPUs, info.VRAMMB)
+		}
+
+		if info.LogFolder != testCase.expectedLogFolder {
+			t.Errorf("%s: got log folder '%s', expected '%s'", testCase.filename, info.LogFolder, testCase.expectedLogFolder)
+		}
+
+		disk, ok := info.StorageAttachment("SATA Controller", 0, 0)
+		if !ok || disk != testCase.expectedDisk {
+			t.Errorf("%s: got disk %+v, expected %+v", testCase.filename, disk, testCase.expectedDisk)
+		}
+
+		if !reflect.DeepEqual(info.NICs, testCase.expectedNICs) {
+			t.Errorf("%s: got nics %+v, expected %+v", testCase.filename, info.NICs, testCase.expectedNICs)
+		}
+
+		if !reflect.DeepEqual(info.SharedFolders, testCase.expectedSharedFolders) {
+			t.Errorf("%s: got shared folders %+v, expected %+v", testCase.filename, info.SharedFolders, testCase.expectedSharedFolders)
+		}
+
+		if !reflect.DeepEqual(info.Snapshots, testCase.expectedSnapshots) {
+			t.Errorf("%s: got snapshots %+v, expected %+v", testCase.filename, info.Snapshots, testCase.expectedSnapshots)
+		}
+
+		if info.CurrentSnapshot != testCase.expectedCurrent || !info.HasSnapshot("Installed") {
+			t.Errorf("%s: got current snapshot '%s', expected '%s'", testCase.filename, info.CurrentSnapshot, testCase.expectedCurrent)
+		}
+
+		if info.Get("firmware") != "EFI" {
+			t.Errorf("%s: got firmware '%s', expected EFI", testCase.filename, info.Get("firmware"))
+		}
+	}
+}
+
+func TestParseVMInfoEscapes(t *testing.T) {
+	output := "name=\"NaksuAbittiKTP\"\r\nLogFldr=\"C:\\\\Users\\\\opettaja\\\\Logs\"\r\nmemory=abc\r\n"
+
+	testCases := []struct {
+		description       string
+		isEscaped         bool
+		expectedLogFolder string
+	}{
+		{"VirtualBox 7.x", true, `C:\Users\opettaja\Logs`},
+		{"VirtualBox 6.1", false, `C:\\Users\\opettaja\\Logs`},
+	}
+
+	for _, testCase := range testCases {
+		info, err := ParseVMInfo(output, testCase.isEscaped)
+		if err != nil {
+			t.Fatalf("Could not parse %s vm info: %v", testCase.description, err)
+		}
+
+		if info.LogFolder != testCase.expectedLogFolder {
+			t.Errorf("Got log folder '%s' from %s output, expected '%s'", info.LogFolder, testCase.description, testCase.expectedLogFolder)
+		}
+
+		if info.MemoryMB != 0 {
+			t.Errorf("Got memory %d from a non-numeric value, expected 0", info.MemoryMB)
+		}
+	}
+}
+
+func TestParseVMInfoEscapedQuoteInSnapshotName(t *testing.T) {
+	output := strings.Join([]string{
+		`name="NaksuAbittiKTP"`,
+		`SnapshotName="before \"x"`,
+		`SnapshotUUID="0b5f2b7c-7e8a-4c57-9a39-5e6b1f3a8c11"`,
+		`VMState="poweroff"`,
+		`LogFldr="/home/opettaja/VirtualBox VMs/NaksuAbittiKTP/Logs"`,
+	}, "\n") + "\n"
+
+	info, err := ParseVMInfo(output, true)
+	if err != nil {
+		t.Fatalf("Could not parse vm info: %v", err)
+	}
+
+	if len(info.Snapshots) != 1 || info.Snapshots[0].Name != `before "x` {
+		t.Errorf("Got snapshots %v, expected a snapshot named 'before \"x'", info.Snapshots)
+	}
+
+	if info.State != "poweroff" || info.LogFolder != "/home/opettaja/VirtualBox VMs/NaksuAbittiKTP/Logs" {
+		t.Errorf("Got state '%s' and log folder '%s' after the snapshot name", info.State, info.LogFolder)
+	}
+}
+
+func TestParseVMInfoErrors(t *testing.T) {
+	for _, output := range []string{"", "VBoxManage: error: Could not find a registered machine named 'NaksuAbittiKTP'\n"} {
+		_, err := ParseVMInfo(output, true)
+		if !errors.Is(err, ErrNotVMInfo) {
+			t.Errorf("Parsing '%s' gave error %v, expected ErrNotVMInfo", output, err)
+		}
+	}
+}
@@ -0,0 +1,581 @@
+package box
+
+// virtualBox runs the VMs with Oracle VirtualBox. The exported Get*Commands
+// functions return the VBoxManage commands of the selected server for the
+// dry-run plans.
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+
+	semver "github.com/blang/semver/v4"
+
+	"naksu/box/vboxmanage"
+	"naksu/config"
+	"naksu/constants"
+	"naksu/log"
+	"naksu/mebroutines"
+)
+
+const (
+	boxOSType                = "Debian"
+	boxStorageControllerName = "SATA Controller"
+)
+
+type virtualBox struct{}
+
+func (virtualBox) Name() string {
+	return constants.HypervisorVirtualBox
+}
+
+func (virtualBox) IsInstalled() bool {
+	return vboxmanage.IsInstalled()
+}
+
+func (virtualBox) IsVMInstalled(vmName string) (bool, error) {
+	return vboxmanage.IsVMInstalled(vmName)
+}
+
+func (virtualBox) CreateVM(spec VMSpec) error {
+	vdiImagePath := getVDIImagePath(spec.Name)
+
+	if mebroutines.ExistsFile(vdiImagePath) {
+		err := os.Remove(vdiImagePath)
+		if err != nil {
+			return fmt.Errorf("could not remove old vdi file %s: %w", vdiImagePath, err)
+		}
+		log.Debug("Removed existing VDI file %s", vdiImagePath)
+	}
+
+	createCommands, err := getCreateVMCommands(spec)
+	if err != nil {
+		return err
+	}
+
+	transaction := vboxmanage.NewTransaction()
+	for _, command := range createCommands {
+		undoBefore, undoAfter := getCreateNewBoxUndoActions(spec.Name, command)
+		transaction.Record(undoBefore...)
+
+		err = transaction.Run(command, undoAfter...)
+		if err != nil {
+			log.Error("Creating new VM failed, rolling back: %v", err)
+			rollbackErr := transaction.Rollback()
+
+			if rollbackErr != nil {
+				return fmt.Errorf("could not create new vm (rollback failed: %v): %w", rollbackErr, err)
+			}
+
+			return fmt.Errorf("could not create new vm: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (virtualBox) RemoveVM(vmName string) error {
+	return vboxmanage.RunCommands(getRemoveVMCommands(vmName))
+}
+
+func (virtualBox) StartVM(vmName string, options StartOptions) error {
+	startCommands, err := getStartVMCommands(vmName, options)
+	if err != nil {
+		return err
+	}
+
+	return vboxmanage.RunCommands(startCommands)
+}
+
+func (virtualBox) ResumeVM(vmName string, state string) error {
+	resumeCommands, err := getResumeVMCommands(vmName, state)
+	if err != nil {
+		return err
+	}
+
+	return vboxmanage.RunCommands(resumeCommands)
+}
+
+func (virtualBox) ShutdownVM(vmName string) error {
+	return vboxmanage.RunCommands(getControlVMCommands(vmName, "acpipowerbutton"))
+}
+
+func (virtualBox) SaveStateVM(vmName string) error {
+	return vboxmanage.RunCommands(getControlVMCommands(vmName, "savestate"))
+}
+
+func (virtualBox) PowerOffVM(vmName string) error {
+	return vboxmanage.RunCommands(getControlVMCommands(vmName, "poweroff"))
+}
+
+func (virtualBox) GetVMState(vmName string) (string, error) {
+	_, state, err := vboxmanage.IsVMRunning(vmName)
+
+	return state, err
+}
+
+func (virtualBox) ResetVMStateCache(vmName string) {
+	vboxmanage.ResetVMStateCache(vmName)
+}
+
+func (virtualBox) TakeSnapshot(vmName string, name string, description string) error {
+	_, err := vboxmanage.RunCommand(vboxmanage.VBoxCommand{"snapshot", vmName, "take", name, "--description", description})
+
+	return err
+}
+
+func (virtualBox) RestoreSnapshot(vmName string, snapshot string) error {
+	return vboxmanage.RunCommands(getRestoreSnapshotCommands(vmName, snapshot))
+}
+
+func (virtualBox) DeleteSnapshot(vmName string, snapshot string) error {
+	_, err := vboxmanage.RunCommand(vboxmanage.VBoxCommand{"snapshot", vmName, "delete", snapshot})
+
+	return err
+}
+
+// GetSnapshots returns the snapshots in the order VirtualBox lists them
+// (parents before their children)
+func (virtualBox) GetSnapshots(vmName string) ([]SnapshotInfo, error) {
+	vmInfo, err := vboxmanage.GetVMInfo(vmName)
+	if err != nil {
+		return nil, fmt.Errorf("could not get vm info: %w", err)
+	}
+
+	detailsByUUID := map[string]vboxmanage.SnapshotDetails{}
+	details, err := vboxmanage.ReadSnapshotDetails(vmInfo.Get("CfgFile"))
+	if err != nil {
+		log.Warning("Could not read the times and the sizes of the snapshots: %v", err)
+	}
+	for _, snapshotDetails := range details {
+		detailsByUUID[snapshotDetails.UUID] = snapshotDetails
+	}
+
+	snapshots := []SnapshotInfo{}
+	for _, snapshot := range vmInfo.Snapshots {
+		snapshotDetails := detailsByUUID[snapshot.UUID]
+
+		snapshots = append(snapshots, SnapshotInfo{
+			Name:        snapshot.Name,
+			UUID:        snapshot.UUID,
+			Description: snapshot.Description,
+			TakenAt:     snapshotDetails.TakenAt,
+			SizeBytes:   getSnapshotSize(snapshotDetails),
+			Current:     snapshot.UUID == vmInfo.Get("CurrentSnapshotUUID"),
+			Protected:   false,
+		})
+	}
+
+	return snapshots, nil
+}
+
+func getSnapshotSize(details vboxmanage.SnapshotDetails) uint64 {
+	var size uint64
+
+	for _, path := range append([]string{details.StateFile}, details.DiskImages...) {
+		if path == "" {
+			continue
+		}
+
+		fileInfo, err := os.Stat(path)
+		if err != nil {
+			log.Debug("Could not get the size of snapshot file %s: %v", path, err)
+
+			continue
+		}
+
+		size += uint64(fileInfo.Size()) // nolint: gosec
+	}
+
+	return size
+}
+
+func (virtualBox) CloneDisk(vmName string, clonePath string) error {
+	disk, ok := getDiskAttachment(vmName)
+	if !ok || disk.ImageUUID == "" {
+		return fmt.Errorf("could not get disk uuid")
+	}
+
+	vBoxManageOutput, err := vboxmanage.RunCommand(vboxmanage.VBoxCommand{"clonemedium", disk.ImageUUID, clonePath, "--format", "VMDK"})
+	if err != nil {
+		return err
+	}
+
+	// Check whether clone was successful or not
+	matched, errRe := regexp.MatchString("Clone medium created in format 'VMDK'", vBoxManageOutput)
+	if errRe != nil || !matched {
+		// Failure
+		log.Debug("VBoxManage output does not report successful clone in format 'VMDK'")
+
+		return errors.New("could not get correct response from vboxmanage")
+	}
+
+	// Detach media from VirtualBox disk management
+	_, errCloseMedium := vboxmanage.RunCommand(vboxmanage.VBoxCommand{"closemedium", clonePath})
+
+	return errCloseMedium
+}
+
+func (virtualBox) GetDiskLocation(vmName string) string {
+	disk, ok := getDiskAttachment(vmName)
+	if !ok {
+		return ""
+	}
+
+	return disk.Medium
+}
+
+// getDiskAttachment returns the disk image attached by CreateVM
+func getDiskAttachment(vmName string) (vboxmanage.StorageAttachment, bool) {
+	vmInfo, err := vboxmanage.GetVMInfo(vmName)
+	if err != nil {
+		log.Debug("Could not get disk attachment: %v", err)
+
+		return vboxmanage.StorageAttachment{}, false
+	}
+
+	return vmInfo.StorageAttachment(boxStorageControllerName, 0, 0)
+}
+
+func (virtualBox) DiskSizeOnDisk(location string) (uint64, error) {
+	// According to documentation, showmediuminfo should also accept a disk uuid
+	// as a parameter, but that doesn't seem to be the case. To be safe, we'll
+	// use the location of the disk instead.
+
+	mediumInfo, err := vboxmanage.RunCommand([]string{"showmediuminfo", location})
+
+	if err != nil {
+		log.Error("Could not get medium info to calculate its size: %v", err)
+
+		return 0, errors.New("failed to get medium size: could not execute vboxmanage")
+	}
+
+	sizeOnDiskRE := regexp.MustCompile(`Size on disk:\s+(\d+)\s+MBytes`)
+	result := sizeOnDiskRE.FindStringSubmatch(mediumInfo)
+	if len(result) > 1 {
+		size := result[1]
+
+		return strconv.ParseUint(size, 10, 64)
+	}
+
+	return 0, errors.New("failed to get medium size: no regex matches")
+}
+
+func (virtualBox) GetGuestProperty(vmName string, property string) string {
+	return vboxmanage.GetVMProperty(vmName, property)
+}
+
+func (virtualBox) GetLogDir(vmName string) string {
+	vmInfo, err := vboxmanage.GetVMInfo(vmName)
+	if err != nil {
+		log.Debug("Could not get log directory: %v", err)
+
+		return ""
+	}
+
+	return vmInfo.LogFolder
+}
+
+func (virtualBox) GetConsoleURL(vmName string) (string, error) {
+	vmInfo, err := vboxmanage.GetVMInfo(vmName)
+	if err != nil {
+		return "", fmt.Errorf("could not get vm info: %w", err)
+	}
+
+	// VirtualBox reports the port only when the console is listening
+	consolePort, err := strconv.Atoi(vmInfo.Get("vrdeport"))
+	if vmInfo.Get("vrde") != "on" || err != nil || consolePort <= 0 {
+		return "", nil
+	}
+
+	consoleAddress := vmInfo.Get("vrdeaddress")
+	if consoleAddress == "" {
+		consoleAddress = constants.ConsoleAddress
+	}
+
+	return fmt.Sprintf("rdp://%s", net.JoinHostPort(consoleAddress, strconv.Itoa(consolePort))), nil
+}
+
+// getVMResourceCommands returns the command applying the CPUs, memory and video
+// RAM set in the [vm] section of naksu.ini to an existing VM. The automatic
+// resources are not changed, so no command is returned if all of them are
+// automatic.
+func getVMResourceCommands(vmName string) ([]vboxmanage.VBoxCommand, error) {
+	resourceArgs := []string{}
+
+	if !config.GetVMCPUs().Auto {
+		boxCPUs, err := calculateBoxCPUs()
+		if err != nil {
+			return nil, err
+		}
+		resourceArgs = append(resourceArgs, "--cpus", fmt.Sprintf("%d", boxCPUs))
+	}
+
+	if !config.GetVMMemory().Auto {
+		boxMemory, err := calculateBoxMemory()
+		if err != nil {
+			return nil, err
+		}
+		resourceArgs = append(resourceArgs, "--memory", fmt.Sprintf("%d", boxMemory))
+	}
+
+	if !config.GetVMVRAMSize().Auto {
+		resourceArgs = append(resourceArgs, "--vram", fmt.Sprintf("%d", calculateBoxVRamSize()))
+	}
+
+	if len(resourceArgs) == 0 {
+		return []vboxmanage.VBoxCommand{}, nil
+	}
+
+	return []vboxmanage.VBoxCommand{append(vboxmanage.VBoxCommand{"modifyvm", vmName}, resourceArgs...)}, nil
+}
+
+func getCreateNewBoxBasicCommands(spec VMSpec) []vboxmanage.VBoxCommand {
+	createCommands := []vboxmanage.VBoxCommand{
+		{"convertfromraw", spec.ImagePath, getVDIImagePath(spec.Name), "--format", "VDI"},
+		{"modifyhd", getVDIImagePath(spec.Name), "--resize", fmt.Sprintf("%d", spec.DiskSizeMB)},
+		{"createvm", "--name", spec.Name, "--register"},
+		{
+			"modifyvm", spec.Name,
+			"--pae", "on",
+			"--cpus", fmt.Sprintf("%d", spec.CPUs),
+			"--memory", fmt.Sprintf("%d", spec.MemoryMB),
+			"--vram", fmt.Sprintf("%d", spec.VRAMMB),
+			"--acpi", "on",
+			"--ioapic", "on",
+			"--ostype", boxOSType,
+			"--firmware", "efi",
+			"--audio", "none",
+		},
+		{
+			"guestproperty", "set", spec.Name,
+			"boxType", spec.BoxType,
+		},
+		{
+			"guestproperty", "set", spec.Name,
+			"boxVersion", spec.BoxVersion,
+		},
+		{
+			"sharedfolder", "add", spec.Name,
+			"--name", "media_usb1",
+			"--hostpath", spec.SharedFolder,
+		},
+		{
+			"storagectl", spec.Name,
+			"--add", "sata",
+			"--name", boxStorageControllerName,
+		},
+		{
+			"storageattach", spec.Name,
+			"--storagectl", boxStorageControllerName,
+			"--port", "0",
+			"--device", "0",
+			"--type", "hdd",
+			"--medium", getVDIImagePath(spec.Name),
+		},
+		{
+			"setextradata", spec.Name,
+			"GUI/RestrictedCloseActions",
+			"SaveState,PowerOffRestoringSnapshot",
+		},
+	}
+
+	return createCommands
+}
+
+func getCreateNewBoxClipboadCommand(boxName string, vBoxVersion semver.Version) (vboxmanage.VBoxCommand, error) {
+	v6_1String := "6.1.0"
+	v6_1, err := semver.Make(v6_1String)
+	if err != nil {
+		return nil, fmt.Errorf("hard-coded version string %s could not be converted to sematic version object", v6_1String)
+	}
+
+	// Defaults to 6.1 or newer
+	clipboardCommand := vboxmanage.VBoxCommand{"modifyvm", boxName, "--clipboard-mode", "bidirectional"}
+
+	if vBoxVersion.LT(v6_1) {
+		clipboardCommand = vboxmanage.VBoxCommand{"modifyvm", boxName, "--clipboard", "bidirectional"}
+	}
+
+	return clipboardCommand, nil
+}
+
+// GetCreateNewBoxCommands returns the VBoxManage commands CreateNewBox runs
+// with the CPUs and memory calculated for this computer. The VM is created for
+// the server of the box type.
+func GetCreateNewBoxCommands(boxType string, boxVersion string) ([]vboxmanage.VBoxCommand, error) {
+	spec, err := getVMSpec(boxType, boxVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	return getCreateVMCommands(spec)
+}
+
+func getCreateVMCommands(spec VMSpec) ([]vboxmanage.VBoxCommand, error) {
+	createCommands := getCreateNewBoxBasicCommands(spec)
+
+	vBoxVersion, err := vboxmanage.GetVBoxManageVersion()
+	if err != nil {
+		log.Error("Could not get VBoxManage version: %v", err)
+
+		return nil, err
+	}
+
+	clipboardCommand, err := getCreateNewBoxClipboadCommand(spec.Name, vBoxVersion)
+	if err != nil {
+		log.Error("Could not get new box clipboard creation command: %v", err)
+
+		return nil, err
+	}
+	createCommands = append(createCommands, clipboardCommand)
+
+	createCommands = append(createCommands, vboxmanage.VBoxCommand{"snapshot", spec.Name, "take", boxSnapshotName})
+
+	return createCommands, nil
+}
+
+// getCreateNewBoxUndoActions returns the actions undoing a command of
+// CreateVM. The undoBefore actions are recorded before running the command
+// since the command may leave a partial result behind when it fails.
+func getCreateNewBoxUndoActions(boxName string, command vboxmanage.VBoxCommand) ([]vboxmanage.UndoAction, []vboxmanage.UndoAction) {
+	switch command[0] {
+	case "convertfromraw":
+		return []vboxmanage.UndoAction{getRemoveVDIImageUndoAction(getVDIImagePath(boxName))}, nil
+	case "createvm":
+		// Deletes also the attached disk image and the snapshots
+		return nil, []vboxmanage.UndoAction{vboxmanage.UndoCommand(vboxmanage.VBoxCommand{"unregistervm", boxName, "--delete"})}
+	}
+
+	return nil, nil
+}
+
+func getRemoveVDIImageUndoAction(vdiImagePath string) vboxmanage.UndoAction {
+	return vboxmanage.UndoAction{
+		Description: fmt.Sprintf("remove disk image %s", vdiImagePath),
+		Undo: func() error {
+			if !mebroutines.ExistsFile(vdiImagePath) {
+				return nil
+			}
+
+			// modifyhd registers the disk image to VirtualBox
+			_, err := vboxmanage.RunCommand(vboxmanage.VBoxCommand{"closemedium", "disk", vdiImagePath})
+			if err != nil {
+				log.Debug("Could not close disk image %s, probably it was not registered: %v", vdiImagePath, err)
+			}
+
+			return os.Remove(vdiImagePath)
+		},
+	}
+}
+
+// GetStartCommands returns the VBoxManage commands starting the VM with the
+// given options. The resources set in the [vm] section of naksu.ini are
+// applied before starting.
+func GetStartCommands(options StartOptions) ([]vboxmanage.VBoxCommand, error) {
+	return getStartVMCommands(getBoxName(), options)
+}
+
+func getStartVMCommands(vmName string, options StartOptions) ([]vboxmanage.VBoxCommand, error) {
+	startCommands := []vboxmanage.VBoxCommand{
+		{"modifyvm", vmName, "--nic1", "bridged"},
+		{"modifyvm", vmName, "--bridgeadapter1", options.ExtNic},
+		{"modifyvm", vmName, "--nictype1", options.Nic},
+		getConsoleCommand(vmName, options.ConsolePort),
+	}
+
+	resourceCommands, err := getVMResourceCommands(vmName)
+	if err != nil {
+		return nil, fmt.Errorf("could not calculate vm resources: %w", err)
+	}
+	startCommands = append(startCommands, resourceCommands...)
+
+	return append(startCommands, vboxmanage.VBoxCommand{"startvm", vmName, "--type", options.Type}), nil
+}
+
+// getConsoleCommand returns the VBoxManage command enabling the remote
+// console (VRDE) on the given localhost port or disabling it if the port is
+// zero. The console needs the VirtualBox Extension Pack.
+func getConsoleCommand(boxName string, consolePort uint16) vboxmanage.VBoxCommand {
+	if consolePort == 0 {
+		return vboxmanage.VBoxCommand{"modifyvm", boxName, "--vrde", "off"}
+	}
+
+	return vboxmanage.VBoxCommand{
+		"modifyvm", boxName,
+		"--vrde", "on",
+		"--vrde-address", constants.ConsoleAddress,
+		"--vrde-port", strconv.FormatUint(uint64(consolePort), 10),
+	}
+}
+
+// GetShutdownCommands returns the VBoxManage commands ShutdownCurrentBox runs
+// before waiting for the VM to power off
+func GetShutdownCommands() []vboxmanage.VBoxCommand {
+	return getControlVMCommands(getBoxName(), "acpipowerbutton")
+}
+
+// GetSaveStateCommands returns the VBoxManage commands SaveStateCurrentBox runs
+func GetSaveStateCommands() []vboxmanage.VBoxCommand {
+	return getControlVMCommands(getBoxName(), "savestate")
+}
+
+// GetPowerOffCommands returns the VBoxManage commands PowerOffCurrentBox runs
+func GetPowerOffCommands() []vboxmanage.VBoxCommand {
+	return getControlVMCommands(getBoxName(), "poweroff")
+}
+
+func getControlVMCommands(vmName string, action string) []vboxmanage.VBoxCommand {
+	return []vboxmanage.VBoxCommand{
+		{"controlvm", vmName, action},
+	}
+}
+
+// GetResumeCommands returns the VBoxManage commands resuming the VM from the
+// given state. The settings of a saved VM cannot be changed, so the network,
+// console and resource settings of GetStartCommands are not applied.
+func GetResumeCommands(state string) ([]vboxmanage.VBoxCommand, error) {
+	return getResumeVMCommands(getBoxName(), state)
+}
+
+func getResumeVMCommands(vmName string, state string) ([]vboxmanage.VBoxCommand, error) {
+	switch state {
+	case VMStateSaved:
+		return []vboxmanage.VBoxCommand{{"startvm", vmName, "--type", config.GetStartType()}}, nil
+	case VMStatePaused:
+		return []vboxmanage.VBoxCommand{{"controlvm", vmName, "resume"}}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrVMNotResumable, state)
+}
+
+// GetRestoreSnapshotCommands returns the VBoxManage commands RestoreSnapshot runs
+func GetRestoreSnapshotCommands() []vboxmanage.VBoxCommand {
+	return getRestoreSnapshotCommands(getBoxName(), boxSnapshotName)
+}
+
+func getRestoreSnapshotCommands(vmName string, snapshot string) []vboxmanage.VBoxCommand {
+	return []vboxmanage.VBoxCommand{
+		{"snapshot", vmName, "restore", snapshot},
+	}
+}
+
+// GetRemoveCommands returns the VBoxManage commands RemoveCurrentBox runs
+func GetRemoveCommands() []vboxmanage.VBoxCommand {
+	return GetRemoveServerCommands(GetSelectedServer())
+}
+
+// GetRemoveServerCommands returns the VBoxManage commands removing the VM of
+// the given server
+func GetRemoveServerCommands(server string) []vboxmanage.VBoxCommand {
+	return getRemoveVMCommands(getServerVMName(server))
+}
+
+func getRemoveVMCommands(vmName string) []vboxmanage.VBoxCommand {
+	return []vboxmanage.VBoxCommand{
+		{"unregistervm", vmName, "--delete"},
+	}
+}
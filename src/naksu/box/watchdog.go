@@ -0,0 +1,150 @@
+package box
+
+// The watchdog follows the state transitions Running() sees and reports the
+// ones which mean that the server crashed. A power-off is usually not a
+// crash, as the server also powers off when it is shut down from the server
+// itself or the VirtualBox window. The teacher cannot shut down the server
+// before it has booted, so a power-off soon after the boot is reported as a
+// crash.
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"naksu/log"
+)
+
+// Abnormal VM states reported by the hypervisors
+const (
+	VMStateAbortedSaved   = "aborted-saved"
+	VMStateGuruMeditation = "gurumeditation"
+)
+
+// Crash is an abnormal state transition of the VM
+type Crash struct {
+	VMName        string
+	PreviousState string
+	State         string
+	DetectedAt    time.Time
+}
+
+// minimumShutdownUptime is the time the server takes to boot. A power-off
+// before this is not a shutdown started from the server.
+const minimumShutdownUptime = 3 * time.Minute
+
+var crashStates = []string{VMStateAborted, VMStateAbortedSaved, VMStateGuruMeditation}
+
+// coldStates are the states from which the VM starts by booting
+var coldStates = []string{VMStatePowerOff, VMStateAborted}
+
+var crashHandler func(Crash)
+var crashHandlerMutex sync.RWMutex
+
+// stopExpected is true while naksu itself is stopping the VM (see expectStop)
+var stopExpected atomic.Bool
+
+// bootedAt is the time in Unix nanoseconds when naksu saw the VM boot or
+// zero if the VM has not booted after starting naksu
+var bootedAt atomic.Int64
+
+// SetCrashHandler sets the function called when the VM crashes. The handler
+// is called in a goroutine of its own.
+func SetCrashHandler(handler func(Crash)) {
+	crashHandlerMutex.Lock()
+	defer crashHandlerMutex.Unlock()
+
+	crashHandler = handler
+}
+
+// expectStop tells the watchdog that naksu is stopping the VM, so an aborted
+// VM (e.g. after a forced power-off) is not a crash. ResetCache clears the
+// expectation.
+func expectStop() {
+	stopExpected.Store(true)
+}
+
+// markBooted tells the watchdog that the VM is booting
+func markBooted() {
+	bootedAt.Store(time.Now().UnixNano())
+}
+
+// getUptime returns the time since the VM booted or zero if it is not known
+func getUptime() time.Duration {
+	booted := bootedAt.Load()
+	if booted == 0 {
+		return 0
+	}
+
+	return time.Since(time.Unix(0, booted))
+}
+
+// isCrash returns true if the VM changed to a crash state while naksu was not
+// stopping it. The first state seen after starting naksu or resetting the
+// cache is not a transition. A running VM powering off is a crash if it
+// happened before the server could have been shut down from the server. The
+// uptime is zero if the boot time is not known.
+func isCrash(previousState string, state string, stopIsExpected bool, uptime time.Duration) bool {
+	if previousState == "" || previousState == state || stopIsExpected {
+		return false
+	}
+
+	if previousState == VMStateRunning && state == VMStatePowerOff {
+		return uptime > 0 && uptime < minimumShutdownUptime
+	}
+
+	return slices.Contains(crashStates, state)
+}
+
+// observeStateChange reports the state change to the crash handler if the
+// transition is abnormal
+func observeStateChange(vmName string, previousState string, state string) {
+	// The VM was started outside naksu, e.g. from the VirtualBox window
+	if state == VMStateRunning && slices.Contains(coldStates, previousState) {
+		markBooted()
+	}
+
+	if !isCrash(previousState, state, stopExpected.Load(), getUptime()) {
+		return
+	}
+
+	log.Error("VM %s stopped unexpectedly, state changed from '%s' to '%s'", vmName, previousState, state)
+
+	crash := Crash{
+		VMName:        vmName,
+		PreviousState: previousState,
+		State:         state,
+		DetectedAt:    time.Now(),
+	}
+
+	crashHandlerMutex.RLock()
+	handler := crashHandler
+	crashHandlerMutex.RUnlock()
+
+	if handler != nil {
+		go handler(crash)
+	}
+}
+
+// StartWatchdog starts polling the state of the VM for the crash handler. The
+// GUI does not need this as the environment status update polls the state.
+func StartWatchdog(tickerDuration time.Duration) {
+	ticker := time.NewTicker(tickerDuration)
+
+	go func() {
+		for {
+			<-ticker.C
+
+			isInstalled, err := Installed()
+			if err != nil || !isInstalled {
+				continue
+			}
+
+			_, err = Running()
+			if err != nil {
+				log.Debug("Watchdog could not get the VM state: %v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,40 @@
+package box
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsCrash(t *testing.T) {
+	testCases := []struct {
+		previousState  string
+		state          string
+		stopIsExpected bool
+		uptime         time.Duration
+		expected       bool
+	}{
+		{VMStateRunning, VMStateAborted, false, time.Hour, true},
+		{VMStateRunning, VMStateGuruMeditation, false, time.Hour, true},
+		{VMStateSaved, VMStateAbortedSaved, false, time.Hour, true},
+		// Shut down from the server or the VirtualBox window between two polls
+		{VMStateRunning, VMStatePowerOff, false, time.Hour, false},
+		{VMStateRunning, VMStatePowerOff, false, 0, false},
+		// Powered off while booting
+		{VMStateRunning, VMStatePowerOff, false, time.Minute, true},
+		{VMStateRunning, VMStatePowerOff, true, time.Minute, false},
+		{VMStatePaused, VMStatePowerOff, false, time.Hour, false},
+		{VMStateRunning, VMStatePowerOff, true, time.Hour, false},
+		{VMStateRunning, VMStateAborted, true, time.Hour, false},
+		{VMStateStopping, VMStatePowerOff, false, time.Hour, false},
+		{VMStateRunning, VMStateSaved, false, time.Hour, false},
+		{VMStatePowerOff, VMStateRunning, false, time.Hour, false},
+		{"", VMStateAborted, false, time.Hour, false},
+		{VMStateAborted, VMStateAborted, false, time.Hour, false},
+	}
+
+	for _, testCase := range testCases {
+		if crash := isCrash(testCase.previousState, testCase.state, testCase.stopIsExpected, testCase.uptime); crash != testCase.expected {
+			t.Errorf("Transition '%s' -> '%s' (stop expected: %t, uptime %v) gave %t, expected %t", testCase.previousState, testCase.state, testCase.stopIsExpected, testCase.uptime, crash, testCase.expected)
+		}
+	}
+}
@@ -0,0 +1,490 @@
+package main
+
+// Headless commands (e.g. "naksu install abitti") drive the same server operations
+// as the GUI buttons without opening any windows. This makes it possible to
+// prepare servers over SSH or from scripts.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"naksu/box"
+	"naksu/config"
+	"naksu/constants"
+	"naksu/doctor"
+	"naksu/log"
+	"naksu/mebroutines"
+	"naksu/mebroutines/backup"
+	"naksu/mebroutines/destroy"
+	"naksu/mebroutines/install"
+	"naksu/mebroutines/remove"
+	"naksu/mebroutines/start"
+	"naksu/mebroutines/stop"
+	"naksu/network"
+	"naksu/notifier"
+	"naksu/reporter"
+	"naksu/xlate"
+
+	flags "github.com/jessevdk/go-flags"
+)
+
+// terminalNotifier prints the messages and progress of the server operations
+// for the command line user
+var terminalNotifier = newTerminalNotifier("auto")
+
+// newTerminalNotifier returns the terminal notifier using the progress
+// reporter selected with the --progress option. The JSON progress events
+// keep the standard output to themselves so the status messages go to the
+// standard error.
+func newTerminalNotifier(progressMode string) *notifier.Terminal {
+	switch progressMode {
+	case "bar":
+		return notifier.NewTerminal(os.Stdout, reporter.NewBar(os.Stdout))
+	case "lines":
+		return notifier.NewTerminal(os.Stdout, reporter.NewLines(os.Stdout))
+	case "json":
+		return notifier.NewTerminal(os.Stderr, reporter.NewJSONLines(os.Stdout))
+	}
+
+	return notifier.NewTerminal(os.Stdout, reporter.NewForFile(os.Stdout))
+}
+
+type installCommand struct {
+	Abitti installAbittiCommand `command:"abitti" description:"Download and install a new Abitti server"`
+	Exam   installExamCommand   `command:"exam" description:"Download and install a new matriculation exam server"`
+	File   installFileCommand   `command:"file" description:"Install a new server from a local image file (e.g. ktp-etcher.zip on a USB stick)"`
+}
+
+type installAbittiCommand struct {
+	dryRunOption
+}
+
+type installExamCommand struct {
+	dryRunOption
+	PassphraseFile string `long:"passphrase-file" description:"Read the exam server install passphrase from this file (use - for standard input)" required:"true"`
+}
+
+type installFileCommand struct {
+	Type        string `long:"type" description:"Type of the server in the image file" choice:"abitti" choice:"exam" required:"true"`
+	VersionFile string `long:"version-file" description:"Version file of the image (default: the .ver file next to the image file)"`
+	Args        struct {
+		Path string `positional-arg-name:"path" description:"Image file (ktp-etcher.zip)"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+type startCommand struct {
+	dryRunOption
+	ExtNic string `long:"ext-nic" description:"Network device connected to the exam network (e.g. eth0). This flag will store the setting to ini-file."`
+	Nic    string `long:"nic" description:"Server networking hardware (e.g. virtio). This flag will store the setting to ini-file."`
+	Type   string `long:"type" description:"Show the server window (gui) or run without a window (headless). This flag will store the setting to ini-file." choice:"gui" choice:"headless"`
+	// ConsolePort is a pointer to tell the port 0 (disable) from a missing flag
+	ConsolePort *uint16 `long:"console-port" description:"Localhost port of the remote console (VRDE), 0 disables the console. This flag will store the setting to ini-file."`
+}
+
+type stopCommand struct {
+	dryRunOption
+	SaveState bool          `long:"save-state" description:"Save the state of the server instead of shutting it down"`
+	PowerOff  bool          `long:"poweroff" description:"Power off the server without shutting it down. The exams in progress may be lost."`
+	Timeout   time.Duration `long:"timeout" description:"Power off the server if it has not shut down in this time (e.g. 5m)" default:"2m"`
+}
+
+type backupCommand struct {
+	Args struct {
+		Path string `positional-arg-name:"path" description:"Backup file or an existing directory for the backup file"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+type destroyCommand struct {
+	dryRunOption
+}
+
+type removeCommand struct {
+	dryRunOption
+}
+
+type statusCommand struct {
+	JSON bool `long:"json" description:"Print the status as a JSON document" optional:"true"`
+}
+
+type doctorCommand struct {
+	JSON bool `long:"json" description:"Print the checks as a JSON document" optional:"true"`
+}
+
+// doctorReport is printed by "naksu doctor --json"
+type doctorReport struct {
+	Verdict doctor.Verdict `json:"verdict"`
+	Checks  []doctor.Check `json:"checks"`
+}
+
+// ensureHypervisor returns an error if the hypervisor set in naksu.ini
+// cannot be executed
+func ensureHypervisor(n notifier.Notifier) error {
+	if !box.IsHypervisorInstalled() {
+		n.Error(box.GetTranslatedHypervisorMissingMessage())
+
+		return fmt.Errorf("could not execute hypervisor %s", box.GetHypervisorName())
+	}
+
+	return nil
+}
+
+// isServerOperation returns true if the headless command operates on the
+// server. naksu serve takes the operation lock for each request instead.
+func isServerOperation(command flags.Commander) bool {
+	switch command.(type) {
+	case *statusCommand, *doctorCommand, *serveCommand, *snapshotListCommand:
+		return false
+	}
+
+	return true
+}
+
+// printInstallDryRunPlan prints the commands installing a new server
+func printInstallDryRunPlan(boxType string, versionURL string) error {
+	plan, err := getInstallDryRunPlan(boxType, versionURL)
+	if err != nil {
+		return err
+	}
+
+	return writeDryRunPlans(os.Stdout, plan)
+}
+
+// readPassphrase reads the passphrase from the given file or from the standard
+// input if the filename is "-"
+func readPassphrase(passphraseFile string) (string, error) {
+	var content []byte
+	var err error
+
+	if passphraseFile == "-" {
+		content, err = io.ReadAll(os.Stdin)
+	} else {
+		content, err = os.ReadFile(filepath.Clean(passphraseFile))
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("could not read passphrase from '%s': %w", passphraseFile, err)
+	}
+
+	passphrase := strings.TrimSpace(string(content))
+	if passphrase == "" {
+		return "", fmt.Errorf("passphrase file '%s' is empty", passphraseFile)
+	}
+
+	return passphrase, nil
+}
+
+func (command *installAbittiCommand) Execute(args []string) error {
+	log.Action("Starting Abitti box update from the command line")
+
+	if err := ensureHypervisor(terminalNotifier); err != nil {
+		return err
+	}
+
+	if command.DryRun {
+		return printInstallDryRunPlan(constants.AbittiBoxType, constants.AbittiVersionURL)
+	}
+
+	err := install.NewAbittiServer(terminalNotifier)
+	if err != nil {
+		return fmt.Errorf("failed to install an abitti server: %w", err)
+	}
+
+	terminalNotifier.Message(xlate.Get("A new Abitti server was created"))
+	log.Debug("Finished Abitti box update, version is: %s", box.GetVersion())
+
+	return nil
+}
+
+func (command *installExamCommand) Execute(args []string) error {
+	log.Action("Starting Exam box update from the command line")
+
+	passphrase, err := readPassphrase(command.PassphraseFile)
+	if err != nil {
+		terminalNotifier.Error(xlate.Get("Please enter install passphrase to install the exam server"))
+
+		return err
+	}
+
+	if err := ensureHypervisor(terminalNotifier); err != nil {
+		return err
+	}
+
+	if command.DryRun {
+		return printInstallDryRunPlan(constants.MatriculationExamBoxType, install.GetExamVersionURL(passphrase))
+	}
+
+	err = install.NewExamServer(passphrase, terminalNotifier)
+	if err != nil {
+		return fmt.Errorf("failed to install an exam server: %w", err)
+	}
+
+	terminalNotifier.Message(xlate.Get("A new exam server was created"))
+	log.Debug("Finished Exam box update, version is: %s", box.GetVersion())
+
+	return nil
+}
+
+func (command *installFileCommand) Execute(args []string) error {
+	log.Action("Starting %s box update from file %s from the command line", command.Type, command.Args.Path)
+
+	if err := ensureHypervisor(terminalNotifier); err != nil {
+		return err
+	}
+
+	err := install.NewServerFromFile(command.Type, command.Args.Path, command.VersionFile, terminalNotifier)
+	if err != nil {
+		return fmt.Errorf("failed to install a server from file: %w", err)
+	}
+
+	terminalNotifier.Message(xlate.Get("A new server was created from file %s", command.Args.Path))
+	log.Debug("Finished box update from file, version is: %s", box.GetVersion())
+
+	return nil
+}
+
+// applyNetworkOptions stores the given network settings and checks that the
+// configured external network device is available
+func (command *startCommand) applyNetworkOptions() error {
+	if command.Nic != "" {
+		if constants.GetAvailableSelectionID(command.Nic, constants.AvailableNics, -1) < 0 {
+			return fmt.Errorf("unknown server networking hardware '%s'", command.Nic)
+		}
+
+		log.Action("Changing server networking hardware to %s", command.Nic)
+		config.SetNic(command.Nic)
+	}
+
+	if command.ExtNic != "" {
+		log.Action("Changing external network to %s", command.ExtNic)
+		config.SetExtNic(command.ExtNic)
+	}
+
+	if config.GetExtNic() == "" {
+		terminalNotifier.Error(xlate.Get("Please select the network device which is connected to your exam network."))
+
+		return errors.New("network device has not been selected")
+	}
+
+	if !network.IsExtInterface(config.GetExtNic()) {
+		terminalNotifier.Error(xlate.Get("You have selected network device '%s' which is not available.", config.GetExtNic()))
+
+		return fmt.Errorf("network device '%s' is not available", config.GetExtNic())
+	}
+
+	return nil
+}
+
+// applyVMOptions stores the given window and console settings
+func (command *startCommand) applyVMOptions() {
+	if command.Type != "" {
+		log.Action("Changing server start type to %s", command.Type)
+		config.SetStartType(command.Type)
+	}
+
+	if command.ConsolePort != nil {
+		log.Action("Changing server console port to %d", *command.ConsolePort)
+		config.SetConsolePort(*command.ConsolePort)
+	}
+}
+
+// printDryRunPlan prints the start commands with the given options without
+// storing them
+func (command *startCommand) printDryRunPlan() error {
+	options := box.GetConfiguredStartOptions()
+
+	if command.ExtNic != "" {
+		options.ExtNic = command.ExtNic
+	}
+
+	if command.Nic != "" {
+		if constants.GetAvailableSelectionID(command.Nic, constants.AvailableNics, -1) < 0 {
+			return fmt.Errorf("unknown server networking hardware '%s'", command.Nic)
+		}
+		options.Nic = command.Nic
+	}
+
+	if command.Type != "" {
+		options.Type = command.Type
+	}
+
+	if command.ConsolePort != nil {
+		options.ConsolePort = *command.ConsolePort
+	}
+
+	plan, err := getStartDryRunPlan(options)
+	if err != nil {
+		return err
+	}
+
+	return writeDryRunPlans(os.Stdout, plan)
+}
+
+func (command *startCommand) Execute(args []string) error {
+	log.Action("Starting server from the command line")
+
+	if err := ensureHypervisor(terminalNotifier); err != nil {
+		return err
+	}
+
+	if command.DryRun {
+		return command.printDryRunPlan()
+	}
+
+	if err := command.applyNetworkOptions(); err != nil {
+		return err
+	}
+
+	command.applyVMOptions()
+
+	if box.TypeIsMatriculationExam() && network.CheckIfNetworkAvailable() {
+		terminalNotifier.Warning(xlate.Get("You are starting Matriculation Examination server with an Internet connection."))
+	}
+
+	err := start.Server(terminalNotifier)
+	if err != nil {
+		return fmt.Errorf("failed to start server: %w", err)
+	}
+
+	terminalNotifier.Message(xlate.Get("Virtual machine was started"))
+
+	consoleURL, err := box.GetConsoleURL()
+	if err != nil {
+		log.Warning("Could not get the remote console address: %v", err)
+	} else if consoleURL != "" {
+		terminalNotifier.Message(xlate.Get("Remote console: %s", consoleURL))
+	}
+
+	return nil
+}
+
+func (command *stopCommand) Execute(args []string) error {
+	log.Action("Stopping server from the command line")
+
+	if command.SaveState && command.PowerOff {
+		return errors.New("--save-state and --poweroff cannot be used together")
+	}
+
+	if command.Timeout <= 0 {
+		return fmt.Errorf("--timeout must be positive, got %v", command.Timeout)
+	}
+
+	if err := ensureHypervisor(terminalNotifier); err != nil {
+		return err
+	}
+
+	switch {
+	case command.SaveState && command.DryRun:
+		return writeDryRunPlans(os.Stdout, getSaveStateDryRunPlan())
+	case command.SaveState:
+		if err := stop.SaveState(terminalNotifier); err != nil {
+			return fmt.Errorf("failed to save server state: %w", err)
+		}
+
+		terminalNotifier.Message(xlate.Get("Server state was saved."))
+	case command.PowerOff && command.DryRun:
+		return writeDryRunPlans(os.Stdout, getPowerOffDryRunPlan())
+	case command.PowerOff:
+		if err := stop.PowerOff(terminalNotifier); err != nil {
+			return fmt.Errorf("failed to power off server: %w", err)
+		}
+
+		terminalNotifier.Message(xlate.Get("Server was powered off."))
+	case command.DryRun:
+		return writeDryRunPlans(os.Stdout, getShutdownDryRunPlan(command.Timeout))
+	default:
+		if err := stop.Shutdown(command.Timeout, terminalNotifier); err != nil {
+			return fmt.Errorf("failed to shut down server: %w", err)
+		}
+
+		terminalNotifier.Message(xlate.Get("Server was shut down."))
+	}
+
+	return nil
+}
+
+func (command *backupCommand) Execute(args []string) error {
+	pathBackup := command.Args.Path
+	if mebroutines.ExistsDir(pathBackup) {
+		pathBackup = filepath.Join(pathBackup, backup.GetBackupFilename(time.Now()))
+	}
+
+	log.Action("Starting backup from the command line to: %s", pathBackup)
+
+	if err := ensureHypervisor(terminalNotifier); err != nil {
+		return err
+	}
+
+	err := backup.MakeBackup(pathBackup, terminalNotifier)
+	if err != nil {
+		return fmt.Errorf("backup failed: %w", err)
+	}
+
+	terminalNotifier.Message(xlate.Get("Backup done: %s", pathBackup))
+
+	return nil
+}
+
+func (command *destroyCommand) Execute(args []string) error {
+	log.Action("Starting server destroy from the command line")
+
+	if err := ensureHypervisor(terminalNotifier); err != nil {
+		return err
+	}
+
+	if command.DryRun {
+		return writeDryRunPlans(os.Stdout, getDestroyDryRunPlan())
+	}
+
+	err := destroy.Server(terminalNotifier)
+	if err != nil {
+		return fmt.Errorf("failed to remove exams: %w", err)
+	}
+
+	terminalNotifier.Message(xlate.Get("Exams were removed successfully."))
+
+	return nil
+}
+
+func (command *removeCommand) Execute(args []string) error {
+	log.Action("Starting server remove from the command line")
+
+	if command.DryRun {
+		return writeDryRunPlans(os.Stdout, getRemoveDryRunPlan())
+	}
+
+	err := remove.Server(terminalNotifier)
+	if err != nil {
+		return fmt.Errorf("failed to remove server: %w", err)
+	}
+
+	terminalNotifier.Message(xlate.Get("Server was removed successfully."))
+
+	return nil
+}
+
+func (command *doctorCommand) Execute(args []string) error {
+	checks := doctor.Run()
+	verdict := doctor.Worst(checks)
+
+	if command.JSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(doctorReport{Verdict: verdict, Checks: checks}); err != nil {
+			return fmt.Errorf("could not encode checks: %w", err)
+		}
+	} else {
+		fmt.Print(doctor.Format(checks))
+	}
+
+	if verdict == doctor.Fail {
+		return errors.New("some of the checks failed")
+	}
+
+	return nil
+}
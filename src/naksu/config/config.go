@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"path/filepath"
+	"regexp"
 	"strconv"
 
 	"naksu/constants"
@@ -23,6 +24,12 @@ func getIniFilePath() string {
 	return filepath.Join(homeDir, "naksu.ini")
 }
 
+// GetControlAPITokenPath returns the path of the control API token file. The
+// token is stored next to naksu.ini.
+func GetControlAPITokenPath() string {
+	return filepath.Join(filepath.Dir(getIniFilePath()), "naksu-api.token")
+}
+
 func setIfMissing(section string, key string, defaultValue string) {
 	if !cfg.Section(section).HasKey(key) {
 		cfg.Section(section).Key(key).SetValue(defaultValue)
@@ -39,6 +46,16 @@ var defaults = []defaultValue{
 	{"selfupdate", "disabled", strconv.FormatBool(false)},
 	{"environment", "nic", constants.AvailableNics[0].ConfigValue},
 	{"environment", "extnic", ""},
+	{"environment", "server", constants.AvailableServers[0].ConfigValue},
+	{"environment", "hypervisor", constants.AvailableHypervisors[0].ConfigValue},
+	{"vm", "cpus", vmResourceAuto},
+	{"vm", "memory", vmResourceAuto},
+	{"vm", "disk", vmResourceAuto},
+	{"vm", "vram", vmResourceAuto},
+	{"vm", "starttype", constants.AvailableStartTypes[0].ConfigValue},
+	{"vm", "consoleport", strconv.FormatInt(0, 10)},
+	{"imagecache", "count", strconv.FormatInt(2, 10)},
+	{"imagecache", "size", strconv.FormatInt(10240, 10)},
 }
 
 func fillDefaults() {
@@ -162,6 +179,36 @@ func SetNic(nic string) {
 	}
 }
 
+// GetServer returns the selected server (see constants.AvailableServers).
+// Defaults to the Abitti server.
+func GetServer() string {
+	return validateStringChoice("environment", "server", constants.AvailableServers)
+}
+
+// SetServer stores the selected server
+func SetServer(server string) {
+	if constants.GetAvailableSelectionID(server, constants.AvailableServers, -1) < 0 {
+		setValue("environment", "server", getDefault("environment", "server"))
+	} else {
+		setValue("environment", "server", server)
+	}
+}
+
+// GetHypervisor returns the hypervisor running the VMs (see
+// constants.AvailableHypervisors). Defaults to VirtualBox.
+func GetHypervisor() string {
+	return validateStringChoice("environment", "hypervisor", constants.AvailableHypervisors)
+}
+
+// SetHypervisor stores the hypervisor running the VMs
+func SetHypervisor(hypervisor string) {
+	if constants.GetAvailableSelectionID(hypervisor, constants.AvailableHypervisors, -1) < 0 {
+		setValue("environment", "hypervisor", getDefault("environment", "hypervisor"))
+	} else {
+		setValue("environment", "hypervisor", hypervisor)
+	}
+}
+
 // GetExtNic returns current host network device value
 func GetExtNic() string {
 	// Since there are no pre-set selection of variables we dont use validateStringChoice() here
@@ -173,3 +220,152 @@ func GetExtNic() string {
 func SetExtNic(nic string) {
 	setValue("environment", "extnic", nic)
 }
+
+// GetStartType returns the startvm type of the VM (see
+// constants.AvailableStartTypes). Defaults to "gui".
+func GetStartType() string {
+	return validateStringChoice("vm", "starttype", constants.AvailableStartTypes)
+}
+
+// SetStartType stores the startvm type of the VM
+func SetStartType(startType string) {
+	if constants.GetAvailableSelectionID(startType, constants.AvailableStartTypes, -1) < 0 {
+		setValue("vm", "starttype", getDefault("vm", "starttype"))
+	} else {
+		setValue("vm", "starttype", startType)
+	}
+}
+
+// GetConsolePort returns the localhost port of the remote console (VRDE) of
+// the VM. Zero disables the console.
+func GetConsolePort() uint16 {
+	port, err := strconv.ParseUint(getString("vm", "consoleport"), 10, 16)
+	if err != nil {
+		defaultValue := getDefault("vm", "consoleport")
+		log.Warning("Correcting malformed ini-key vm / consoleport to default value %v: %v", defaultValue, err)
+		setValue("vm", "consoleport", defaultValue)
+
+		return 0
+	}
+
+	return uint16(port)
+}
+
+// SetConsolePort stores the localhost port of the remote console. Zero
+// disables the console.
+func SetConsolePort(port uint16) {
+	setValue("vm", "consoleport", strconv.FormatUint(uint64(port), 10))
+}
+
+// getUint returns the value of an unsigned integer key. A malformed value is
+// reset to the default.
+func getUint(section string, key string) uint64 {
+	value, err := strconv.ParseUint(getString(section, key), 10, 64)
+	if err != nil {
+		defaultValue := getDefault(section, key)
+		log.Warning("Correcting malformed ini-key %v / %v to default value %v: %v", section, key, defaultValue, err)
+		setValue(section, key, defaultValue)
+
+		value, err = strconv.ParseUint(defaultValue, 10, 64)
+		if err != nil {
+			panic(fmt.Sprintf("Default for %v / %v (%v) is not an unsigned integer!", section, key, defaultValue))
+		}
+	}
+
+	return value
+}
+
+// GetImageCacheCount returns the number of downloaded server images kept in
+// the image cache. Zero disables the cache.
+func GetImageCacheCount() int {
+	return int(getUint("imagecache", "count")) // #nosec
+}
+
+// GetImageCacheSize returns the maximum size of the image cache in megabytes.
+// Zero means no size limit.
+func GetImageCacheSize() uint64 {
+	return getUint("imagecache", "size")
+}
+
+const (
+	vmResourceAuto = "auto"
+	percent        = 100
+)
+
+var vmResourceRegexp = regexp.MustCompile(`^(\d+)(%?)$`)
+
+// VMResource is a VM resource setting of the [vm] section. The setting is
+// "auto", an explicit value (e.g. 4 CPUs or 8192 megabytes) or a percentage of
+// the host resource (e.g. 50%).
+type VMResource struct {
+	// Auto is true if naksu calculates the value
+	Auto bool
+	// Value is the explicit value or the percentage
+	Value uint64
+	// Percentage is true if Value is a percentage of the host resource
+	Percentage bool
+}
+
+// Resolve returns the value of the setting on a host having hostTotal of the
+// resource. Automatic settings return the given automatic value.
+func (resource VMResource) Resolve(hostTotal uint64, automatic uint64) uint64 {
+	switch {
+	case resource.Auto:
+		return automatic
+	case resource.Percentage:
+		return hostTotal * resource.Value / percent
+	}
+
+	return resource.Value
+}
+
+func parseVMResource(value string, allowPercentage bool) (VMResource, error) {
+	if value == "" || value == vmResourceAuto {
+		return VMResource{Auto: true, Value: 0, Percentage: false}, nil
+	}
+
+	result := vmResourceRegexp.FindStringSubmatch(value)
+	if result == nil || (result[2] == "%" && !allowPercentage) {
+		return VMResource{}, fmt.Errorf("malformed vm resource value '%s'", value) // nolint: exhaustruct
+	}
+
+	number, err := strconv.ParseUint(result[1], 10, 64)
+	if err != nil {
+		return VMResource{}, fmt.Errorf("malformed vm resource value '%s': %w", value, err) // nolint: exhaustruct
+	}
+
+	return VMResource{Auto: false, Value: number, Percentage: result[2] == "%"}, nil
+}
+
+func getVMResource(key string, allowPercentage bool) VMResource {
+	resource, err := parseVMResource(getString("vm", key), allowPercentage)
+	if err != nil {
+		defaultValue := getDefault("vm", key)
+		log.Warning("Correcting malformed ini-key vm / %v to default value %v: %v", key, defaultValue, err)
+		setValue("vm", key, defaultValue)
+
+		return VMResource{Auto: true, Value: 0, Percentage: false}
+	}
+
+	return resource
+}
+
+// GetVMCPUs returns the number of VM CPUs or a percentage of the host CPU cores
+func GetVMCPUs() VMResource {
+	return getVMResource("cpus", true)
+}
+
+// GetVMMemory returns the VM memory in megabytes or a percentage of the host memory
+func GetVMMemory() VMResource {
+	return getVMResource("memory", true)
+}
+
+// GetVMDiskSize returns the VM disk size in megabytes
+func GetVMDiskSize() VMResource {
+	return getVMResource("disk", false)
+}
+
+// GetVMVRAMSize returns the VM video memory in megabytes
+func GetVMVRAMSize() VMResource {
+	return getVMResource("vram", false)
+}
@@ -0,0 +1,44 @@
+package config
+
+import "testing"
+
+func TestParseVMResource(t *testing.T) {
+	testCases := []struct {
+		value           string
+		allowPercentage bool
+		expected        VMResource
+		expectedError   bool
+	}{
+		{"auto", true, VMResource{Auto: true, Value: 0, Percentage: false}, false},
+		{"", false, VMResource{Auto: true, Value: 0, Percentage: false}, false},
+		{"8192", true, VMResource{Auto: false, Value: 8192, Percentage: false}, false},
+		{"50%", true, VMResource{Auto: false, Value: 50, Percentage: true}, false},
+		{"50%", false, VMResource{}, true}, // nolint: exhaustruct
+		{"8 GB", true, VMResource{}, true}, // nolint: exhaustruct
+		{"-2", true, VMResource{}, true},   // nolint: exhaustruct
+	}
+
+	for _, testCase := range testCases {
+		resource, err := parseVMResource(testCase.value, testCase.allowPercentage)
+		if (err != nil) != testCase.expectedError || resource != testCase.expected {
+			t.Errorf("Parsing '%s' gave %+v, %v, expected %+v", testCase.value, resource, err, testCase.expected)
+		}
+	}
+}
+
+func TestResolveVMResource(t *testing.T) {
+	testCases := []struct {
+		resource VMResource
+		expected uint64
+	}{
+		{VMResource{Auto: true, Value: 0, Percentage: false}, 12000},
+		{VMResource{Auto: false, Value: 8192, Percentage: false}, 8192},
+		{VMResource{Auto: false, Value: 50, Percentage: true}, 8000},
+	}
+
+	for _, testCase := range testCases {
+		if resolved := testCase.resource.Resolve(16000, 12000); resolved != testCase.expected {
+			t.Errorf("Resolving %+v gave %d, expected %d", testCase.resource, resolved, testCase.expected)
+		}
+	}
+}
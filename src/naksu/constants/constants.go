@@ -31,10 +31,28 @@ const (
 	// See naksu/box
 	VBoxManageCacheTimeout = 30 * time.Second
 
+	// VBoxManageTimeout is the time a VBoxManage command may wait in the queue
+	// and the time it may run before it is killed. See naksu/box/vboxmanage
+	VBoxManageTimeout = 5 * time.Minute
+
+	// VBoxManageDiskTimeout is the VBoxManageTimeout for commands copying
+	// disk images (e.g. clonemedium) which can take a long time
+	VBoxManageDiskTimeout = 3 * time.Hour
+
 	// VBoxRunningCacheTimeout is a timeout for VM state cache
 	// See Running() at naksu/box
 	VBoxRunningCacheTimeout = 2 * time.Second
 
+	// VMShutdownTimeout is the default time the server has for shutting down
+	// after the ACPI power button before it is powered off
+	VMShutdownTimeout = 2 * time.Minute
+
+	// VMSaveStateTimeout is the time the server has for saving its state
+	VMSaveStateTimeout = 10 * time.Minute
+
+	// VMPowerOffTimeout is the time the server has for powering off
+	VMPowerOffTimeout = 1 * time.Minute
+
 	// CloudStatusTimeout is a timeout for cloud status cache
 	// See naksu/cloud
 	CloudStatusTimeout = 10 * time.Minute
@@ -56,6 +74,25 @@ const (
 	// VBoxMaxVersion c.f. VBoxMinVersion
 	VBoxMaxVersion = ""
 
+	// RequiredLinkSpeed is the required speed of the network device in Mbit/s
+	RequiredLinkSpeed = 1000
+
+	// QEMUImgTimeout is the time a qemu-img command converting or
+	// snapshotting a disk image may run before it is killed
+	QEMUImgTimeout = 3 * time.Hour
+
+	// QEMUStartTimeout is the time QEMU has for opening its QMP socket after
+	// it has been started
+	QEMUStartTimeout = 30 * time.Second
+
+	// QMPTimeout is the time a QMP command may take. Saving the state and
+	// taking a snapshot of a running VM are polled instead of waited for.
+	QMPTimeout = 10 * time.Second
+
+	// ConsoleAddress is the address the remote console (VRDE) of the VM
+	// listens to. Remote users connect over an SSH tunnel.
+	ConsoleAddress = "127.0.0.1"
+
 	// Define common file permissions
 	FilePermissionsOwnerRW  = 0600
 	FilePermissionsOwnerRWX = 0700
@@ -84,6 +121,52 @@ var AvailableLangs = []AvailableSelection{
 	},
 }
 
+// AvailableServers is an array of the servers which can be installed side by
+// side. There is one server for each box type. The first value is the default.
+var AvailableServers = []AvailableSelection{
+	{
+		ConfigValue: AbittiBoxType,
+		Legend:      "Abitti server",
+	},
+	{
+		ConfigValue: MatriculationExamBoxType,
+		Legend:      "Matric Exam server",
+	},
+}
+
+// The hypervisors which can run the VMs
+const (
+	HypervisorVirtualBox = "virtualbox"
+	HypervisorQEMU       = "qemu"
+)
+
+// AvailableHypervisors are the hypervisors which can run the VMs. QEMU/KVM
+// is available only on Linux. The first value is the default.
+var AvailableHypervisors = []AvailableSelection{
+	{
+		ConfigValue: HypervisorVirtualBox,
+		Legend:      "VirtualBox",
+	},
+	{
+		ConfigValue: HypervisorQEMU,
+		Legend:      "QEMU/KVM (Linux)",
+	},
+}
+
+// AvailableStartTypes are the ways to start the VM (VBoxManage startvm
+// --type). A headless VM has no window and can be used over the remote
+// console. The first value is the default.
+var AvailableStartTypes = []AvailableSelection{
+	{
+		ConfigValue: "gui",
+		Legend:      "Show server window",
+	},
+	{
+		ConfigValue: "headless",
+		Legend:      "Run without window (headless)",
+	},
+}
+
 // AvailableNics is an array of possible NIC selection values.
 // The first value is the default.
 var AvailableNics = []AvailableSelection{
@@ -135,7 +218,7 @@ func GetAvailableSelectionID(configValue string, choices []AvailableSelection, v
 
 // EnvironmentStatus is used by the UI to store the status of the system environment
 type EnvironmentStatus struct {
-	BoxInstalled bool
-	BoxRunning   bool
-	NetAvailable bool
+	BoxInstalled bool `json:"boxInstalled"`
+	BoxRunning   bool `json:"boxRunning"`
+	NetAvailable bool `json:"netAvailable"`
 }
@@ -0,0 +1,362 @@
+// Package controlapi implements an opt-in local HTTP API for controlling naksu
+// from scripts and management agents. The API exposes the same operations as
+// the GUI buttons. The operations stream their progress back to the caller as
+// newline-delimited JSON events.
+//
+// Every request must carry the token stored next to naksu.ini:
+//
+//	Authorization: Bearer <token>
+package controlapi
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"naksu/log"
+	"naksu/notifier"
+	"naksu/reporter"
+)
+
+const tokenLength = 32
+
+const readHeaderTimeout = 10 * time.Second
+
+// ErrNotLoopback is returned by Listen if the TCP address is not a loopback address
+var ErrNotLoopback = errors.New("control api address must be a loopback address")
+
+// Operations contains the server operations exposed by the API. The functions
+// are called one at a time.
+type Operations struct {
+	InstallAbitti func(n notifier.Notifier) error
+	InstallExam   func(passphrase string, n notifier.Notifier) error
+	Start         func(n notifier.Notifier) error
+	Shutdown      func(n notifier.Notifier) error
+	SaveState     func(n notifier.Notifier) error
+	PowerOff      func(n notifier.Notifier) error
+	Destroy       func(n notifier.Notifier) error
+	Backup        func(path string, n notifier.Notifier) error
+	DeliverLogs   func(n notifier.Notifier) (string, error)
+	Status        func() (interface{}, error)
+}
+
+type installExamRequest struct {
+	Passphrase string `json:"passphrase"`
+}
+
+type backupRequest struct {
+	Path string `json:"path"`
+}
+
+// Server handles the control API requests
+type Server struct {
+	token      string
+	operations Operations
+	// busy is held while an operation is running
+	busy sync.Mutex
+}
+
+// NewServer returns a new control API server accepting the given token
+func NewServer(token string, operations Operations) *Server {
+	return &Server{token: token, operations: operations, busy: sync.Mutex{}}
+}
+
+// LoadOrCreateToken returns the token stored in the given file. A new random
+// token is created if the file does not exist.
+func LoadOrCreateToken(tokenPath string) (string, error) {
+	content, err := os.ReadFile(filepath.Clean(tokenPath))
+	if err == nil {
+		token := strings.TrimSpace(string(content))
+		if token != "" {
+			return token, nil
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("could not read control api token from %s: %w", tokenPath, err)
+	}
+
+	tokenBytes := make([]byte, tokenLength)
+	_, err = rand.Read(tokenBytes)
+	if err != nil {
+		return "", fmt.Errorf("could not generate control api token: %w", err)
+	}
+
+	token := hex.EncodeToString(tokenBytes)
+
+	err = os.WriteFile(tokenPath, []byte(token+"\n"), 0600)
+	if err != nil {
+		return "", fmt.Errorf("could not write control api token to %s: %w", tokenPath, err)
+	}
+
+	log.Debug("Created a new control API token to %s", tokenPath)
+
+	return token, nil
+}
+
+// Listen opens the listener for the API. If socketPath is given the API
+// listens to a Unix socket, otherwise to the given loopback TCP address.
+func Listen(address string, socketPath string) (net.Listener, error) {
+	if socketPath != "" {
+		// Remove a stale socket left by a previous run
+		if err := os.Remove(socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("could not remove old socket %s: %w", socketPath, err)
+		}
+
+		listener, err := net.Listen("unix", socketPath)
+		if err != nil {
+			return nil, fmt.Errorf("could not listen to socket %s: %w", socketPath, err)
+		}
+
+		return listener, nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid control api address %s: %w", address, err)
+	}
+
+	ip := net.ParseIP(host)
+	if host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("%w: %s", ErrNotLoopback, address)
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("could not listen to %s: %w", address, err)
+	}
+
+	return listener, nil
+}
+
+// Serve serves the API requests from the listener until it is closed
+func (server *Server) Serve(listener net.Listener) error {
+	httpServer := &http.Server{ // nolint:exhaustruct
+		Handler:           server.Handler(),
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	return httpServer.Serve(listener)
+}
+
+// Handler returns the http.Handler of the API
+func (server *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/v1/status", server.handleStatus)
+	mux.HandleFunc("/v1/install/abitti", server.operationHandler(func(request *http.Request, n notifier.Notifier) error {
+		return server.operations.InstallAbitti(n)
+	}))
+	mux.HandleFunc("/v1/install/exam", server.operationHandler(func(request *http.Request, n notifier.Notifier) error {
+		var body installExamRequest
+		if err := decodeBody(request, &body); err != nil {
+			return err
+		}
+
+		if body.Passphrase == "" {
+			return errors.New("passphrase is missing")
+		}
+
+		return server.operations.InstallExam(body.Passphrase, n)
+	}))
+	mux.HandleFunc("/v1/start", server.operationHandler(func(request *http.Request, n notifier.Notifier) error {
+		return server.operations.Start(n)
+	}))
+	mux.HandleFunc("/v1/shutdown", server.operationHandler(func(request *http.Request, n notifier.Notifier) error {
+		return server.operations.Shutdown(n)
+	}))
+	mux.HandleFunc("/v1/save-state", server.operationHandler(func(request *http.Request, n notifier.Notifier) error {
+		return server.operations.SaveState(n)
+	}))
+	mux.HandleFunc("/v1/poweroff", server.operationHandler(func(request *http.Request, n notifier.Notifier) error {
+		return server.operations.PowerOff(n)
+	}))
+	mux.HandleFunc("/v1/destroy", server.operationHandler(func(request *http.Request, n notifier.Notifier) error {
+		return server.operations.Destroy(n)
+	}))
+	mux.HandleFunc("/v1/backup", server.operationHandler(func(request *http.Request, n notifier.Notifier) error {
+		var body backupRequest
+		if err := decodeBody(request, &body); err != nil {
+			return err
+		}
+
+		if body.Path == "" {
+			return errors.New("backup path is missing")
+		}
+
+		return server.operations.Backup(body.Path, n)
+	}))
+	mux.HandleFunc("/v1/deliver-logs", server.operationHandler(func(request *http.Request, n notifier.Notifier) error {
+		logFilename, err := server.operations.DeliverLogs(n)
+		if logFilename != "" {
+			n.Info(logFilename)
+		}
+
+		return err
+	}))
+
+	return server.authenticate(mux)
+}
+
+func (server *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		token, found := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(token), []byte(server.token)) != 1 {
+			log.Warning("Control API request %s %s with an invalid token", request.Method, request.URL.Path)
+			writeJSONError(writer, http.StatusUnauthorized, "invalid token")
+
+			return
+		}
+
+		next.ServeHTTP(writer, request)
+	})
+}
+
+func (server *Server) handleStatus(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		writeJSONError(writer, http.StatusMethodNotAllowed, "use GET")
+
+		return
+	}
+
+	status, err := server.operations.Status()
+	if err != nil {
+		log.Warning("Control API status is incomplete: %v", err)
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	err = json.NewEncoder(writer).Encode(status)
+	if err != nil {
+		log.Debug("Could not write control API status: %v", err)
+	}
+}
+
+// operationHandler runs the operation and streams its events to the caller.
+// Only one operation runs at a time. The operation is run to the end even if
+// the caller disconnects.
+func (server *Server) operationHandler(operation func(request *http.Request, n notifier.Notifier) error) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			writeJSONError(writer, http.StatusMethodNotAllowed, "use POST")
+
+			return
+		}
+
+		if !server.busy.TryLock() {
+			writeJSONError(writer, http.StatusConflict, "another operation is running")
+
+			return
+		}
+		defer server.busy.Unlock()
+
+		log.Action("Control API: %s", request.URL.Path)
+
+		writer.Header().Set("Content-Type", "application/x-ndjson")
+		writer.WriteHeader(http.StatusOK)
+
+		stream := newEventStream(writer)
+
+		err := operation(request, stream)
+		if err != nil {
+			log.Error("Control API operation %s failed: %v", request.URL.Path, err)
+			stream.send(reporter.Event{Type: "result", Message: "", Value: 0, OK: false, Error: err.Error()})
+
+			return
+		}
+
+		stream.send(reporter.Event{Type: "result", Message: "", Value: 0, OK: true, Error: ""})
+	}
+}
+
+func decodeBody(request *http.Request, body interface{}) error {
+	const maxBodySize = 64 * 1024
+
+	err := json.NewDecoder(io.LimitReader(request.Body, maxBodySize)).Decode(body)
+	if err != nil {
+		return fmt.Errorf("could not decode request: %w", err)
+	}
+
+	return nil
+}
+
+func writeJSONError(writer http.ResponseWriter, status int, message string) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+
+	err := json.NewEncoder(writer).Encode(map[string]string{"error": message})
+	if err != nil {
+		log.Debug("Could not write control API error: %v", err)
+	}
+}
+
+// eventStream is a notifier.Notifier writing the events as JSON lines
+type eventStream struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+	flusher http.Flusher
+	failed  bool
+}
+
+func newEventStream(writer http.ResponseWriter) *eventStream {
+	flusher, _ := writer.(http.Flusher)
+
+	return &eventStream{mutex: sync.Mutex{}, encoder: json.NewEncoder(writer), flusher: flusher, failed: false}
+}
+
+func (stream *eventStream) send(event reporter.Event) {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	// Keep running the operation even if the caller has gone away
+	if stream.failed {
+		return
+	}
+
+	err := stream.encoder.Encode(event)
+	if err != nil {
+		log.Debug("Could not send control API event, caller has probably disconnected: %v", err)
+		stream.failed = true
+
+		return
+	}
+
+	if stream.flusher != nil {
+		stream.flusher.Flush()
+	}
+}
+
+func (stream *eventStream) Error(message string) {
+	stream.send(reporter.Event{Type: "error", Message: message, Value: 0, OK: false, Error: ""})
+}
+
+func (stream *eventStream) Warning(message string) {
+	stream.send(reporter.Event{Type: "warning", Message: message, Value: 0, OK: false, Error: ""})
+}
+
+func (stream *eventStream) Info(message string) {
+	stream.send(reporter.Event{Type: "info", Message: message, Value: 0, OK: false, Error: ""})
+}
+
+func (stream *eventStream) Message(message string) {
+	if message != "" {
+		stream.send(reporter.Event{Type: "message", Message: message, Value: 0, OK: false, Error: ""})
+	}
+}
+
+func (stream *eventStream) Progress(message string, value int) {
+	stream.send(reporter.Event{Type: "progress", Message: message, Value: value, OK: false, Error: ""})
+}
+
+func (stream *eventStream) ProgressDone() {
+	stream.send(reporter.Event{Type: "progressDone", Message: "", Value: 0, OK: false, Error: ""})
+}
@@ -0,0 +1,205 @@
+package controlapi
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"naksu/notifier"
+	"naksu/reporter"
+)
+
+const testToken = "secret"
+
+func newTestServer(operations Operations) *httptest.Server {
+	return httptest.NewServer(NewServer(testToken, operations).Handler())
+}
+
+func doRequest(t *testing.T, method string, url string, token string, body string) *http.Response {
+	t.Helper()
+
+	request, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Could not create request: %v", err)
+	}
+
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("Request %s %s failed: %v", method, url, err)
+	}
+
+	return response
+}
+
+func readEvents(t *testing.T, response *http.Response) []reporter.Event {
+	t.Helper()
+
+	defer response.Body.Close()
+
+	events := []reporter.Event{}
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		var event reporter.Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("Could not decode event '%s': %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+
+	return events
+}
+
+func TestInvalidToken(t *testing.T) {
+	server := newTestServer(Operations{}) // nolint:exhaustruct
+	defer server.Close()
+
+	for _, token := range []string{"", "wrong"} {
+		response := doRequest(t, http.MethodPost, server.URL+"/v1/destroy", token, "")
+		response.Body.Close()
+
+		if response.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Token '%s' should give status %d, got %d", token, http.StatusUnauthorized, response.StatusCode)
+		}
+	}
+}
+
+func TestDestroyStreamsEvents(t *testing.T) {
+	server := newTestServer(Operations{ // nolint:exhaustruct
+		Destroy: func(n notifier.Notifier) error {
+			n.Message("Removing exams")
+			n.Progress("Restoring", 50)
+
+			return nil
+		},
+	})
+	defer server.Close()
+
+	response := doRequest(t, http.MethodPost, server.URL+"/v1/destroy", testToken, "")
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.StatusCode)
+	}
+
+	events := readEvents(t, response)
+	expectedTypes := []string{"message", "progress", "result"}
+	if len(events) != len(expectedTypes) {
+		t.Fatalf("Expected %d events, got %v", len(expectedTypes), events)
+	}
+
+	for i, expectedType := range expectedTypes {
+		if events[i].Type != expectedType {
+			t.Errorf("Event %d should be %s, got %s", i, expectedType, events[i].Type)
+		}
+	}
+
+	if events[1].Value != 50 {
+		t.Errorf("Progress value should be 50, got %d", events[1].Value)
+	}
+
+	if !events[2].OK {
+		t.Errorf("Result should be ok, got %v", events[2])
+	}
+}
+
+func TestOperationError(t *testing.T) {
+	server := newTestServer(Operations{ // nolint:exhaustruct
+		InstallExam: func(passphrase string, n notifier.Notifier) error {
+			if passphrase != "foo bar" {
+				t.Errorf("Unexpected passphrase '%s'", passphrase)
+			}
+
+			return errors.New("wrong passphrase")
+		},
+	})
+	defer server.Close()
+
+	events := readEvents(t, doRequest(t, http.MethodPost, server.URL+"/v1/install/exam", testToken, `{"passphrase":"foo bar"}`))
+	if len(events) != 1 || events[0].Type != "result" || events[0].OK || events[0].Error != "wrong passphrase" {
+		t.Errorf("Expected a failed result, got %v", events)
+	}
+}
+
+func TestOnlyOneOperationAtATime(t *testing.T) {
+	started := make(chan bool)
+	release := make(chan bool)
+
+	server := newTestServer(Operations{ // nolint:exhaustruct
+		Start: func(n notifier.Notifier) error {
+			started <- true
+			<-release
+
+			return nil
+		},
+	})
+	defer server.Close()
+
+	done := make(chan []reporter.Event)
+	go func() {
+		done <- readEvents(t, doRequest(t, http.MethodPost, server.URL+"/v1/start", testToken, ""))
+	}()
+
+	<-started
+
+	response := doRequest(t, http.MethodPost, server.URL+"/v1/destroy", testToken, "")
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusConflict {
+		t.Errorf("Expected status %d while busy, got %d", http.StatusConflict, response.StatusCode)
+	}
+
+	release <- true
+
+	events := <-done
+	if len(events) != 1 || !events[0].OK {
+		t.Errorf("Expected a successful result, got %v", events)
+	}
+}
+
+func TestLoadOrCreateToken(t *testing.T) {
+	tokenPath := filepath.Join(t.TempDir(), "naksu-api.token")
+
+	token, err := LoadOrCreateToken(tokenPath)
+	if err != nil {
+		t.Fatalf("Could not create token: %v", err)
+	}
+
+	if len(token) != 2*tokenLength {
+		t.Errorf("Token '%s' has unexpected length", token)
+	}
+
+	fileInfo, err := os.Stat(tokenPath)
+	if err != nil {
+		t.Fatalf("Token file was not created: %v", err)
+	}
+
+	if fileInfo.Mode().Perm()&0077 != 0 && os.PathSeparator == '/' {
+		t.Errorf("Token file should not be readable by others, mode is %v", fileInfo.Mode())
+	}
+
+	loadedToken, err := LoadOrCreateToken(tokenPath)
+	if err != nil || loadedToken != token {
+		t.Errorf("Expected the stored token %s, got %s (%v)", token, loadedToken, err)
+	}
+}
+
+func TestListenRequiresLoopback(t *testing.T) {
+	_, err := Listen("0.0.0.0:0", "")
+	if !errors.Is(err, ErrNotLoopback) {
+		t.Errorf("Listening to a public address should fail with ErrNotLoopback, got %v", err)
+	}
+
+	listener, err := Listen("127.0.0.1:0", "")
+	if err != nil {
+		t.Fatalf("Listening to loopback failed: %v", err)
+	}
+	listener.Close()
+}
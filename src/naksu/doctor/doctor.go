@@ -0,0 +1,369 @@
+// Package doctor collects the host and network checks into a single
+// preflight report. Each check has a verdict and a remediation hint so that
+// the problems can be fixed before the exam instead of during it.
+package doctor
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"naksu/box"
+	"naksu/box/vboxmanage"
+	"naksu/config"
+	"naksu/constants"
+	"naksu/host"
+	"naksu/log"
+	"naksu/mebroutines"
+	"naksu/network"
+	"naksu/xlate"
+
+	humanize "github.com/dustin/go-humanize"
+)
+
+// Verdict is the result of a single check
+type Verdict string
+
+const (
+	// Pass means there is no problem
+	Pass Verdict = "pass"
+	// Warn means the server may work but there may be problems
+	Warn Verdict = "warn"
+	// Fail means the server will not work properly
+	Fail Verdict = "fail"
+)
+
+// networkCheckPrefix is the ID prefix of the network checks
+const networkCheckPrefix = "network-"
+
+// virtualBoxVersionCheckID is the ID of the VirtualBox version check
+const virtualBoxVersionCheckID = "virtualbox-version"
+
+// Check is the result of a single preflight check
+type Check struct {
+	ID      string  `json:"id"`
+	Name    string  `json:"name"`
+	Verdict Verdict `json:"verdict"`
+	Message string  `json:"message"`
+	Hint    string  `json:"hint,omitempty"`
+}
+
+// IsNetworkCheck returns true if the check is about the exam network device
+// instead of the host computer
+func (check Check) IsNetworkCheck() bool {
+	return strings.HasPrefix(check.ID, networkCheckPrefix)
+}
+
+// IsShownAtStartup returns true if the check should open the doctor window
+// when Naksu starts. These are the host failures and the warning about an
+// unsupported VirtualBox version which used to be a popup of its own.
+func (check Check) IsShownAtStartup() bool {
+	switch {
+	case check.IsNetworkCheck():
+		return false
+	case check.Verdict == Fail:
+		return true
+	}
+
+	return check.ID == virtualBoxVersionCheckID && check.Verdict == Warn
+}
+
+func newCheck(id string, name string, verdict Verdict, message string, hint string) Check {
+	return Check{ID: id, Name: name, Verdict: verdict, Message: message, Hint: hint}
+}
+
+func checkHWVirtualisationCPU(isSupported bool) Check {
+	name := xlate.Get("CPU virtualisation support")
+
+	if isSupported {
+		return newCheck("cpu-virtualisation", name, Pass, xlate.Get("OK"), "")
+	}
+
+	return newCheck("cpu-virtualisation", name, Fail,
+		xlate.Get("It appears your CPU does not support hardware virtualisation (VT-x or AMD-V)."),
+		xlate.Get("Use a computer with a CPU supporting VT-x or AMD-V."))
+}
+
+func checkHWVirtualisation(isEnabled bool) Check {
+	name := xlate.Get("Hardware virtualisation")
+
+	if isEnabled {
+		return newCheck("hw-virtualisation", name, Pass, xlate.Get("OK"), "")
+	}
+
+	return newCheck("hw-virtualisation", name, Fail,
+		xlate.Get("Hardware virtualisation (VT-x or AMD-V) is disabled. Please enable it before continuing."),
+		xlate.Get("Enable VT-x or AMD-V in the BIOS/UEFI settings of the computer."))
+}
+
+func checkHyperV(isHyperV bool) Check {
+	name := xlate.Get("Windows Hypervisor")
+
+	if !isHyperV {
+		return newCheck("hyperv", name, Pass, xlate.Get("OK"), "")
+	}
+
+	return newCheck("hyperv", name, Fail,
+		xlate.Get("Please turn Windows Hypervisor off as it may cause problems. Before doing so the server cannot be used as the server for matriculation examination. We recommend using YTL Linux as the operating system for the server machine."),
+		xlate.Get("Turn off Hyper-V, Virtual Machine Platform and Windows Hypervisor Platform in Windows Features and restart the computer."))
+}
+
+func checkKVMConflict(conflictingModules []string) Check {
+	name := xlate.Get("KVM")
+
+	if len(conflictingModules) == 0 {
+		return newCheck("kvm", name, Pass, xlate.Get("OK"), "")
+	}
+
+	modules := strings.Join(conflictingModules, " ")
+
+	return newCheck("kvm", name, Fail,
+		xlate.Get("The KVM kernel modules (%s) have reserved the hardware virtualisation. VirtualBox cannot start the server (VERR_VMX_IN_VMX_ROOT_MODE) until they are unloaded.", modules),
+		xlate.Get("Close the other virtual machines and run 'sudo modprobe -r %s kvm'. To keep KVM unloaded after restarting, run \"printf 'blacklist %%s\\n' %s | sudo tee /etc/modprobe.d/naksu-kvm.conf\". Alternatively set hypervisor = qemu in naksu.ini.", modules, modules))
+}
+
+func checkVBoxDriver(status host.VBoxDriverStatus) Check {
+	name := xlate.Get("VirtualBox kernel driver")
+
+	switch status {
+	case host.VBoxDriverNotInstalled:
+		return newCheck("vboxdrv", name, Fail,
+			xlate.Get("The VirtualBox kernel driver (vboxdrv) has not been installed for the running kernel."),
+			xlate.Get("Install the kernel headers (e.g. 'sudo apt install linux-headers-$(uname -r)') and run 'sudo /sbin/vboxconfig'."))
+	case host.VBoxDriverBlockedBySecureBoot:
+		return newCheck("vboxdrv", name, Fail,
+			xlate.Get("Secure Boot prevents loading the VirtualBox kernel driver (vboxdrv) as it has not been signed with an enrolled key."),
+			xlate.Get("Run 'sudo /sbin/vboxconfig' to sign the modules and 'sudo mokutil --import /var/lib/shim-signed/mok/MOK.der' to enroll the signing key, then restart the computer and choose 'Enroll MOK'. Alternatively turn Secure Boot off in the UEFI settings."))
+	case host.VBoxDriverNotLoaded:
+		return newCheck("vboxdrv", name, Fail,
+			xlate.Get("The VirtualBox kernel driver (vboxdrv) is not loaded."),
+			xlate.Get("Run 'sudo modprobe vboxdrv' or restart the computer."))
+	}
+
+	return newCheck("vboxdrv", name, Pass, xlate.Get("OK"), "")
+}
+
+func checkVirtualBox(isInstalled bool) Check {
+	name := xlate.Get("VirtualBox")
+
+	if isInstalled {
+		return newCheck("virtualbox", name, Pass, xlate.Get("OK"), "")
+	}
+
+	return newCheck("virtualbox", name, Fail,
+		xlate.Get("Could not execute VBoxManage. Are you sure you have installed Oracle VirtualBox?"),
+		xlate.Get("Install Oracle VirtualBox %s or newer.", constants.VBoxMinVersion))
+}
+
+func checkQEMU(isInstalled bool) Check {
+	name := xlate.Get("QEMU/KVM")
+
+	if isInstalled {
+		return newCheck("qemu", name, Pass, xlate.Get("OK"), "")
+	}
+
+	return newCheck("qemu", name, Fail,
+		xlate.Get("Could not execute qemu-system-x86_64 or qemu-img. Are you sure you have installed QEMU?"),
+		xlate.Get("Install QEMU and the OVMF firmware (e.g. packages qemu-system-x86, qemu-utils and ovmf) or set hypervisor = virtualbox in naksu.ini."))
+}
+
+func checkVirtualBoxVersion(translatedMessage string, err error) Check {
+	name := xlate.Get("VirtualBox version")
+
+	switch {
+	case err != nil:
+		return newCheck(virtualBoxVersionCheckID, name, Warn, xlate.Get("Could not detect VirtualBox version: %v", err), "")
+	case translatedMessage != "":
+		return newCheck(virtualBoxVersionCheckID, name, Warn, translatedMessage, "")
+	}
+
+	return newCheck(virtualBoxVersionCheckID, name, Pass, xlate.Get("OK"), "")
+}
+
+func checkMemory(memory uint64, err error, lowMemoryLimit uint64) Check {
+	name := xlate.Get("Memory")
+
+	switch {
+	case err != nil:
+		return newCheck("memory", name, Warn, xlate.Get("Could not detect the amount of memory: %v", err), "")
+	case memory < lowMemoryLimit:
+		return newCheck("memory", name, Fail,
+			xlate.Get("The computer has %d MB of memory, the server requires at least %d MB", memory, lowMemoryLimit),
+			xlate.Get("Use a computer with more memory."))
+	}
+
+	return newCheck("memory", name, Pass, xlate.Get("%d MB", memory), "")
+}
+
+func checkFreeDisk(err error) Check {
+	name := xlate.Get("Free disk")
+
+	var lowDiskSizeError *host.LowDiskSizeError
+
+	switch {
+	case errors.As(err, &lowDiskSizeError):
+		return newCheck("disk", name, Warn,
+			xlate.Get("Your free disk size is getting low (%s)", humanize.Bytes(lowDiskSizeError.LowSize)),
+			xlate.Get("Free at least %s of disk space on %s.", humanize.Bytes(constants.LowDiskLimit), lowDiskSizeError.LowPath))
+	case err != nil:
+		return newCheck("disk", name, Warn, xlate.Get("Failed to calculate free disk space: %v", err), "")
+	}
+
+	return newCheck("disk", name, Pass, xlate.Get("OK"), "")
+}
+
+func checkPowerplan(isPowerSaving bool) Check {
+	name := xlate.Get("Power plan")
+
+	if !isPowerSaving {
+		return newCheck("powerplan", name, Pass, xlate.Get("OK"), "")
+	}
+
+	return newCheck("powerplan", name, Warn,
+		xlate.Get("The computer uses a power saving power plan which may slow down the server."),
+		xlate.Get("Select a balanced or high performance power plan and keep the computer plugged in."))
+}
+
+func checkNetworkDevice(extNic string, isAvailable bool) Check {
+	name := xlate.Get("Network device")
+
+	switch {
+	case extNic == "":
+		return newCheck(networkCheckPrefix+"device", name, Warn,
+			xlate.Get("Please select the network device which is connected to your exam network."),
+			xlate.Get("Select the network device in the main window."))
+	case !isAvailable:
+		return newCheck(networkCheckPrefix+"device", name, Fail,
+			xlate.Get("You have selected network device '%s' which is not available.", extNic),
+			xlate.Get("Connect the network device or select another one in the main window."))
+	}
+
+	return newCheck(networkCheckPrefix+"device", name, Pass, extNic, "")
+}
+
+func checkQEMUBridge(extNic string, status host.QEMUBridgeStatus) Check {
+	name := xlate.Get("QEMU network bridge")
+
+	switch status {
+	case host.QEMUBridgeNotBridge:
+		return newCheck(networkCheckPrefix+"qemu-bridge", name, Fail,
+			xlate.Get("QEMU can connect the server only to a network bridge, and network device '%s' is not a bridge.", extNic),
+			xlate.Get("Create a bridge for the exam network device (e.g. 'sudo nmcli connection add type bridge ifname br0 con-name br0' and 'sudo nmcli connection add type bridge-slave ifname %s master br0'), allow it with \"echo 'allow br0' | sudo tee -a %s\" and select br0 in the main window.", extNic, host.QEMUBridgeConfPath))
+	case host.QEMUBridgeNotAllowed:
+		return newCheck(networkCheckPrefix+"qemu-bridge", name, Fail,
+			xlate.Get("QEMU is not allowed to use network bridge '%s'.", extNic),
+			xlate.Get("Allow the bridge with \"echo 'allow %s' | sudo tee -a %s\".", extNic, host.QEMUBridgeConfPath))
+	}
+
+	return newCheck(networkCheckPrefix+"qemu-bridge", name, Pass, xlate.Get("OK"), "")
+}
+
+func checkWireless(isWireless bool) Check {
+	name := xlate.Get("Wireless connection")
+
+	if !isWireless {
+		return newCheck(networkCheckPrefix+"wireless", name, Pass, xlate.Get("OK"), "")
+	}
+
+	return newCheck(networkCheckPrefix+"wireless", name, Warn,
+		xlate.Get("The server is connected to the exam network with a wireless network device."),
+		xlate.Get("Use a wired network connection for the exam network."))
+}
+
+func checkLinkSpeed(linkSpeedMbit uint64) Check {
+	name := xlate.Get("Network speed")
+
+	switch {
+	case linkSpeedMbit == 0:
+		return newCheck(networkCheckPrefix+"link-speed", name, Warn,
+			xlate.Get("No network connection"),
+			xlate.Get("Check that the network cable is connected to the exam network switch."))
+	case linkSpeedMbit < constants.RequiredLinkSpeed:
+		return newCheck(networkCheckPrefix+"link-speed", name, Warn,
+			xlate.Get("Network speed is too low (%d Mbit/s)", linkSpeedMbit),
+			xlate.Get("Use a %d Mbit/s network device, cable and switch.", constants.RequiredLinkSpeed))
+	}
+
+	return newCheck(networkCheckPrefix+"link-speed", name, Pass, xlate.Get("%d Mbit/s", linkSpeedMbit), "")
+}
+
+// Run runs all checks. Some checks make Windows WMI calls so do not call
+// this from the UI thread.
+func Run() []Check {
+	checks := []Check{
+		checkHWVirtualisationCPU(host.IsHWVirtualisationCPU()),
+		checkHWVirtualisation(host.IsHWVirtualisation()),
+		checkHyperV(host.IsHyperV()),
+	}
+
+	if box.GetHypervisorName() == constants.HypervisorQEMU {
+		checks = append(checks, checkQEMU(box.IsHypervisorInstalled()))
+	} else {
+		isVBoxInstalled := vboxmanage.IsInstalled()
+		checks = append(checks, checkVirtualBox(isVBoxInstalled))
+		if isVBoxInstalled {
+			checks = append(checks, checkVirtualBoxVersion(host.IsVirtualBoxVersionOK()))
+		}
+
+		// On Linux the KVM modules and the VirtualBox kernel driver are
+		// the counterparts of the Windows Hypervisor
+		if runtime.GOOS == "linux" {
+			checks = append(checks, checkKVMConflict(host.GetConflictingKVMModules()))
+			if isVBoxInstalled {
+				checks = append(checks, checkVBoxDriver(host.GetVBoxDriverStatus()))
+			}
+		}
+	}
+
+	memory, err := host.GetMemory()
+	checks = append(checks, checkMemory(memory, err, box.GetLowMemoryLimit()))
+
+	checks = append(checks, checkFreeDisk(host.CheckFreeDisk(constants.LowDiskLimit, []string{mebroutines.GetKtpDirectory(), mebroutines.GetVirtualBoxHiddenDirectory(), mebroutines.GetVirtualBoxVMsDirectory()})))
+	checks = append(checks, checkPowerplan(host.IsPowerSaving()))
+
+	extNic := config.GetExtNic()
+	isExtNicAvailable := extNic != "" && network.IsExtInterface(extNic)
+	checks = append(checks, checkNetworkDevice(extNic, isExtNicAvailable))
+	if isExtNicAvailable && box.GetHypervisorName() == constants.HypervisorQEMU {
+		checks = append(checks, checkQEMUBridge(extNic, host.GetQEMUBridgeStatus(extNic)))
+	}
+	checks = append(checks, checkWireless(network.UsingWirelessInterface()))
+	checks = append(checks, checkLinkSpeed(network.CurrentLinkSpeed()))
+
+	for _, check := range checks {
+		log.Debug("Doctor: %s %s: %s", check.Verdict, check.ID, check.Message)
+	}
+
+	return checks
+}
+
+// Worst returns the worst verdict of the checks
+func Worst(checks []Check) Verdict {
+	worst := Pass
+
+	for _, check := range checks {
+		switch {
+		case check.Verdict == Fail:
+			return Fail
+		case check.Verdict == Warn:
+			worst = Warn
+		}
+	}
+
+	return worst
+}
+
+// Format returns the checks as human-readable text
+func Format(checks []Check) string {
+	var builder strings.Builder
+
+	for _, check := range checks {
+		fmt.Fprintf(&builder, "[%s] %s: %s\n", strings.ToUpper(string(check.Verdict)), check.Name, check.Message)
+		if check.Hint != "" {
+			fmt.Fprintf(&builder, "       %s\n", check.Hint)
+		}
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,116 @@
+package doctor
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"naksu/host"
+)
+
+func TestCheckVerdicts(t *testing.T) {
+	testCases := []struct {
+		description     string
+		check           Check
+		expectedVerdict Verdict
+	}{
+		{"cpu supports virtualisation", checkHWVirtualisationCPU(true), Pass},
+		{"cpu does not support virtualisation", checkHWVirtualisationCPU(false), Fail},
+		{"virtualisation disabled", checkHWVirtualisation(false), Fail},
+		{"hyper-v", checkHyperV(true), Fail},
+		{"kvm not in use", checkKVMConflict([]string{}), Pass},
+		{"kvm in use", checkKVMConflict([]string{"kvm_intel"}), Fail},
+		{"vboxdrv loaded", checkVBoxDriver(host.VBoxDriverLoaded), Pass},
+		{"vboxdrv not installed", checkVBoxDriver(host.VBoxDriverNotInstalled), Fail},
+		{"vboxdrv not loaded", checkVBoxDriver(host.VBoxDriverNotLoaded), Fail},
+		{"vboxdrv blocked by secure boot", checkVBoxDriver(host.VBoxDriverBlockedBySecureBoot), Fail},
+		{"virtualbox missing", checkVirtualBox(false), Fail},
+		{"virtualbox too old", checkVirtualBoxVersion("Your VirtualBox version is old.", nil), Warn},
+		{"virtualbox version unknown", checkVirtualBoxVersion("", errors.New("foo")), Warn},
+		{"virtualbox version ok", checkVirtualBoxVersion("", nil), Pass},
+		{"enough memory", checkMemory(16384, nil, 7168), Pass},
+		{"low memory", checkMemory(4096, nil, 7168), Fail},
+		{"memory unknown", checkMemory(0, errors.New("foo"), 7168), Warn},
+		{"enough disk", checkFreeDisk(nil), Pass},
+		{"low disk", checkFreeDisk(&host.LowDiskSizeError{Err: "disk size is too low", LowPath: "/home", LowSize: 1024}), Warn},
+		{"power saving", checkPowerplan(true), Warn},
+		{"network device not selected", checkNetworkDevice("", false), Warn},
+		{"network device missing", checkNetworkDevice("eth0", false), Fail},
+		{"network device ok", checkNetworkDevice("eth0", true), Pass},
+		{"qemu bridge allowed", checkQEMUBridge("br0", host.QEMUBridgeAllowed), Pass},
+		{"qemu with a physical network device", checkQEMUBridge("eth0", host.QEMUBridgeNotBridge), Fail},
+		{"qemu bridge not allowed", checkQEMUBridge("br0", host.QEMUBridgeNotAllowed), Fail},
+		{"wireless", checkWireless(true), Warn},
+		{"no link", checkLinkSpeed(0), Warn},
+		{"slow link", checkLinkSpeed(100), Warn},
+		{"gigabit link", checkLinkSpeed(1000), Pass},
+	}
+
+	for _, testCase := range testCases {
+		if testCase.check.Verdict != testCase.expectedVerdict {
+			t.Errorf("Check for %s gave %s, expected %s", testCase.description, testCase.check.Verdict, testCase.expectedVerdict)
+		}
+
+		if testCase.check.Verdict != Pass && testCase.check.Message == "" {
+			t.Errorf("Check for %s has no message", testCase.description)
+		}
+	}
+}
+
+func TestIsShownAtStartup(t *testing.T) {
+	testCases := []struct {
+		description   string
+		check         Check
+		expectedShown bool
+	}{
+		{"host failure", checkHyperV(true), true},
+		{"virtualbox too old", checkVirtualBoxVersion("Your VirtualBox version is old.", nil), true},
+		{"virtualbox version ok", checkVirtualBoxVersion("", nil), false},
+		{"other warning", checkPowerplan(true), false},
+		{"network failure", checkNetworkDevice("eth0", false), false},
+	}
+
+	for _, testCase := range testCases {
+		if shown := testCase.check.IsShownAtStartup(); shown != testCase.expectedShown {
+			t.Errorf("Check for %s is shown at startup: %t, expected %t", testCase.description, shown, testCase.expectedShown)
+		}
+	}
+}
+
+func TestWorst(t *testing.T) {
+	pass := checkPowerplan(false)
+	warn := checkPowerplan(true)
+	fail := checkHyperV(true)
+
+	testCases := []struct {
+		checks          []Check
+		expectedVerdict Verdict
+	}{
+		{[]Check{}, Pass},
+		{[]Check{pass, pass}, Pass},
+		{[]Check{pass, warn}, Warn},
+		{[]Check{warn, fail, pass}, Fail},
+	}
+
+	for _, testCase := range testCases {
+		if verdict := Worst(testCase.checks); verdict != testCase.expectedVerdict {
+			t.Errorf("Worst of %v is %s, expected %s", testCase.checks, verdict, testCase.expectedVerdict)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	text := Format([]Check{checkLinkSpeed(1000), checkLinkSpeed(100)})
+
+	if !strings.Contains(text, "[PASS] Network speed: 1000 Mbit/s\n") {
+		t.Errorf("Passed check is missing from the report:\n%s", text)
+	}
+
+	if !strings.Contains(text, "[WARN] Network speed: Network speed is too low (100 Mbit/s)\n       Use a 1000 Mbit/s") {
+		t.Errorf("Warning with a hint is missing from the report:\n%s", text)
+	}
+
+	if !checkLinkSpeed(100).IsNetworkCheck() || checkPowerplan(true).IsNetworkCheck() {
+		t.Error("IsNetworkCheck does not recognise the network checks")
+	}
+}
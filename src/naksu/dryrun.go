@@ -0,0 +1,217 @@
+package main
+
+// Dry-run prints the VBoxManage commands of the server operations instead of
+// running them. Support can use the plans to reproduce customer setups and to
+// audit what naksu does to a shared computer.
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"naksu/box"
+	"naksu/box/download"
+	"naksu/box/vboxmanage"
+	"naksu/config"
+	"naksu/constants"
+	"naksu/log"
+	"naksu/mebroutines"
+)
+
+// dryRunOption is embedded in the commands supporting --dry-run
+type dryRunOption struct {
+	DryRun bool `long:"dry-run" description:"Print the VBoxManage commands instead of running them" optional:"true"`
+}
+
+// ensureDryRunSupported returns an error if the VMs are not run with
+// VirtualBox. The plans consist of VBoxManage commands.
+func ensureDryRunSupported() error {
+	if box.GetHypervisorName() != constants.HypervisorVirtualBox {
+		return fmt.Errorf("dry-run is not supported with hypervisor %s", box.GetHypervisorName())
+	}
+
+	return nil
+}
+
+// dryRunPlan is a titled list of VBoxManage commands. The notes describe the
+// steps which are not VBoxManage commands.
+type dryRunPlan struct {
+	title    string
+	notes    []string
+	commands []vboxmanage.VBoxCommand
+}
+
+func getInstallDryRunPlan(boxType string, versionURL string) (dryRunPlan, error) {
+	if err := ensureDryRunSupported(); err != nil {
+		return dryRunPlan{}, err // nolint: exhaustruct
+	}
+
+	version, err := download.GetAvailableVersion(versionURL)
+	if err != nil {
+		log.Warning("Could not get the version of the new server: %v", err)
+		version = "unknown"
+	}
+
+	server := box.GetServerForBoxType(boxType)
+
+	imageNote := fmt.Sprintf("Download the server image to %s", mebroutines.GetImagePath())
+
+	cachePath := download.GetCachedServerImagePath(boxType, version)
+	if config.GetImageCacheCount() > 0 && mebroutines.ExistsFile(cachePath) {
+		imageNote = fmt.Sprintf("Write the server image from the cached %s to %s", cachePath, mebroutines.GetImagePath())
+	}
+
+	plan := dryRunPlan{
+		title:    fmt.Sprintf("Install a new %s server (version %s)", boxType, version),
+		notes:    []string{imageNote},
+		commands: []vboxmanage.VBoxCommand{},
+	}
+
+	installedServers, err := box.GetInstalledServers()
+	if err != nil {
+		return plan, fmt.Errorf("could not detect whether vm is installed: %w", err)
+	}
+
+	if slices.Contains(installedServers, server) {
+		plan.commands = append(plan.commands, box.GetRemoveServerCommands(server)...)
+	}
+
+	createCommands, err := box.GetCreateNewBoxCommands(boxType, version)
+	if err != nil {
+		return plan, fmt.Errorf("could not get vm creation commands: %w", err)
+	}
+	plan.commands = append(plan.commands, createCommands...)
+
+	return plan, nil
+}
+
+func getStartDryRunPlan(options box.StartOptions) (dryRunPlan, error) {
+	if err := ensureDryRunSupported(); err != nil {
+		return dryRunPlan{}, err // nolint: exhaustruct
+	}
+
+	plan := dryRunPlan{
+		title:    fmt.Sprintf("Start the %s server (network device %s, networking hardware %s, %s)", box.GetSelectedServer(), options.ExtNic, options.Nic, options.Type),
+		notes:    []string{},
+		commands: []vboxmanage.VBoxCommand{},
+	}
+
+	state, err := box.GetState()
+	if err != nil {
+		log.Warning("Could not get the state of the server: %v", err)
+	}
+
+	if box.IsResumable(state) {
+		plan.title = fmt.Sprintf("Resume the %s server from state %s", box.GetSelectedServer(), state)
+		plan.notes = append(plan.notes, "The network device and the VM resources cannot be changed before resuming")
+		plan.commands, err = box.GetResumeCommands(state)
+		if err != nil {
+			return plan, fmt.Errorf("could not get vm resume commands: %w", err)
+		}
+
+		return plan, nil
+	}
+
+	if options.ConsolePort != 0 {
+		plan.notes = append(plan.notes, fmt.Sprintf("The remote console listens to %s:%d and needs the VirtualBox Extension Pack", constants.ConsoleAddress, options.ConsolePort))
+	}
+
+	startCommands, err := box.GetStartCommands(options)
+	if err != nil {
+		return plan, fmt.Errorf("could not get vm start commands: %w", err)
+	}
+	plan.commands = startCommands
+
+	return plan, nil
+}
+
+func getShutdownDryRunPlan(timeout time.Duration) dryRunPlan {
+	return dryRunPlan{
+		title:    fmt.Sprintf("Shut down the %s server", box.GetSelectedServer()),
+		notes:    []string{fmt.Sprintf("Power off the server if it has not shut down in %v", timeout)},
+		commands: box.GetShutdownCommands(),
+	}
+}
+
+func getSaveStateDryRunPlan() dryRunPlan {
+	return dryRunPlan{
+		title:    fmt.Sprintf("Save the state of the %s server", box.GetSelectedServer()),
+		notes:    []string{},
+		commands: box.GetSaveStateCommands(),
+	}
+}
+
+func getPowerOffDryRunPlan() dryRunPlan {
+	return dryRunPlan{
+		title:    fmt.Sprintf("Power off the %s server", box.GetSelectedServer()),
+		notes:    []string{},
+		commands: box.GetPowerOffCommands(),
+	}
+}
+
+func getDestroyDryRunPlan() dryRunPlan {
+	return dryRunPlan{
+		title:    "Remove exams by restoring the server to its initial state",
+		notes:    []string{},
+		commands: box.GetRestoreSnapshotCommands(),
+	}
+}
+
+func getRemoveDryRunPlan() dryRunPlan {
+	return dryRunPlan{
+		title: fmt.Sprintf("Remove the %s server and all VirtualBox data", box.GetSelectedServer()),
+		notes: []string{
+			fmt.Sprintf("Delete %s if no other server is installed", mebroutines.GetVirtualBoxHiddenDirectory()),
+			fmt.Sprintf("Delete %s if no other server is installed", mebroutines.GetVirtualBoxVMsDirectory()),
+		},
+		commands: box.GetRemoveCommands(),
+	}
+}
+
+// getAllDryRunPlans returns the plans of all server operations except the exam
+// server install which needs the passphrase
+func getAllDryRunPlans() ([]dryRunPlan, error) {
+	installPlan, err := getInstallDryRunPlan(constants.AbittiBoxType, constants.AbittiVersionURL)
+	if err != nil {
+		return nil, err
+	}
+
+	startPlan, err := getStartDryRunPlan(box.GetConfiguredStartOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	return []dryRunPlan{
+		installPlan,
+		startPlan,
+		getShutdownDryRunPlan(constants.VMShutdownTimeout),
+		getSaveStateDryRunPlan(),
+		getPowerOffDryRunPlan(),
+		getDestroyDryRunPlan(),
+		getRemoveDryRunPlan(),
+	}, nil
+}
+
+func writeDryRunPlans(writer io.Writer, plans ...dryRunPlan) error {
+	if err := ensureDryRunSupported(); err != nil {
+		return err
+	}
+
+	for index, plan := range plans {
+		if index > 0 {
+			fmt.Fprintln(writer)
+		}
+
+		fmt.Fprintf(writer, "# %s\n", plan.title)
+		for _, note := range plan.notes {
+			fmt.Fprintf(writer, "# %s\n", note)
+		}
+
+		if err := vboxmanage.DryRunCommands(writer, plan.commands); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
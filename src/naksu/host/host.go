@@ -120,3 +120,42 @@ func IsVirtualBoxVersionOK() (string, error) {
 
 	return "", nil
 }
+
+// IsPowerSaving returns true if the host uses a power saving power plan which
+// may slow down the server
+func IsPowerSaving() bool {
+	return isPowerSavingPowerplan(getPowerplan())
+}
+
+// VBoxDriverStatus is the state of the VirtualBox kernel driver (vboxdrv) on
+// Linux. See GetVBoxDriverStatus().
+type VBoxDriverStatus string
+
+const (
+	// VBoxDriverLoaded means VirtualBox can start VMs
+	VBoxDriverLoaded VBoxDriverStatus = "loaded"
+	// VBoxDriverNotInstalled means vboxdrv has not been built for the running kernel
+	VBoxDriverNotInstalled VBoxDriverStatus = "not-installed"
+	// VBoxDriverNotLoaded means vboxdrv is installed but has not been loaded
+	VBoxDriverNotLoaded VBoxDriverStatus = "not-loaded"
+	// VBoxDriverBlockedBySecureBoot means vboxdrv is installed but not loaded
+	// and Secure Boot allows loading only signed kernel modules
+	VBoxDriverBlockedBySecureBoot VBoxDriverStatus = "blocked-by-secure-boot"
+)
+
+// QEMUBridgeStatus tells whether QEMU can connect the server to the network
+// device. See GetQEMUBridgeStatus().
+type QEMUBridgeStatus string
+
+const (
+	// QEMUBridgeAllowed means qemu-bridge-helper may add the server to the bridge
+	QEMUBridgeAllowed QEMUBridgeStatus = "allowed"
+	// QEMUBridgeNotBridge means the network device is not a bridge (e.g. a
+	// physical network card)
+	QEMUBridgeNotBridge QEMUBridgeStatus = "not-bridge"
+	// QEMUBridgeNotAllowed means the bridge is not allowed in QEMUBridgeConfPath
+	QEMUBridgeNotAllowed QEMUBridgeStatus = "not-allowed"
+)
+
+// QEMUBridgeConfPath is the access control list of qemu-bridge-helper
+const QEMUBridgeConfPath = "/etc/qemu/bridge.conf"
@@ -0,0 +1,11 @@
+package host
+
+// GetConflictingKVMModules returns always nil on Darwin
+func GetConflictingKVMModules() []string {
+	return nil
+}
+
+// GetVBoxDriverStatus returns always VBoxDriverLoaded on Darwin
+func GetVBoxDriverStatus() VBoxDriverStatus {
+	return VBoxDriverLoaded
+}
@@ -0,0 +1,185 @@
+package host
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"naksu/log"
+)
+
+const (
+	linuxModulesPath             = "/proc/modules"
+	linuxKernelReleasePath       = "/proc/sys/kernel/osrelease"
+	linuxKVMEnableVirtAtLoadPath = "/sys/module/kvm/parameters/enable_virt_at_load"
+	// The SecureBoot variable of the EFI global variable GUID
+	linuxSecureBootEFIVarPath = "/sys/firmware/efi/efivars/SecureBoot-8be4df61-93ca-11d2-aa0d-e0a94b2a4b8c"
+)
+
+// kvmVendorModules are the KVM modules which take the VT-x or AMD-V of the CPU
+var kvmVendorModules = []string{"kvm_intel", "kvm_amd"}
+
+// parseLoadedModules returns the use counts of the modules listed in
+// /proc/modules by the module names
+func parseLoadedModules(procModules string) map[string]int {
+	modules := map[string]int{}
+
+	for _, line := range strings.Split(procModules, "\n") {
+		// e.g. "kvm_intel 413696 2 - Live 0x0000000000000000"
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+
+		useCount, err := strconv.Atoi(fields[2])
+		if err != nil {
+			useCount = 0
+		}
+
+		modules[fields[0]] = useCount
+	}
+
+	return modules
+}
+
+func getLoadedModules() (map[string]int, error) {
+	procModules, err := os.ReadFile(linuxModulesPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", linuxModulesPath, err)
+	}
+
+	return parseLoadedModules(string(procModules)), nil
+}
+
+// getConflictingKVMModules returns the loaded KVM vendor modules which are
+// used by a process or which have enabled the hardware virtualisation when
+// they were loaded
+func getConflictingKVMModules(modules map[string]int, isVirtEnabledAtLoad bool) []string {
+	conflictingModules := []string{}
+
+	for _, module := range kvmVendorModules {
+		useCount, loaded := modules[module]
+		if loaded && (useCount > 0 || isVirtEnabledAtLoad) {
+			conflictingModules = append(conflictingModules, module)
+		}
+	}
+
+	return conflictingModules
+}
+
+// isKVMVirtEnabledAtLoad returns true if KVM enables the hardware
+// virtualisation already when the module is loaded (the default since Linux
+// 6.12) instead of when a VM is started
+func isKVMVirtEnabledAtLoad() bool {
+	value, err := os.ReadFile(linuxKVMEnableVirtAtLoadPath)
+	if err != nil {
+		return false
+	}
+
+	return strings.TrimSpace(string(value)) == "Y"
+}
+
+// GetConflictingKVMModules returns the KVM modules (kvm_intel or kvm_amd)
+// which have taken the hardware virtualisation so that VirtualBox cannot start
+// VMs (VERR_VMX_IN_VMX_ROOT_MODE or VERR_SVM_IN_USE)
+func GetConflictingKVMModules() []string {
+	modules, err := getLoadedModules()
+	if err != nil {
+		log.Error("Could not detect KVM modules: %v", err)
+
+		return nil
+	}
+
+	conflictingModules := getConflictingKVMModules(modules, isKVMVirtEnabledAtLoad())
+	if len(conflictingModules) > 0 {
+		log.Debug("KVM modules in use: %s", strings.Join(conflictingModules, ", "))
+	}
+
+	return conflictingModules
+}
+
+// isModuleInModulesDep returns true if the module is listed in the
+// modules.dep of the kernel
+func isModuleInModulesDep(modulesDep string, module string) bool {
+	for _, line := range strings.Split(modulesDep, "\n") {
+		// e.g. "updates/dkms/vboxdrv.ko.zst:"
+		modulePath, _, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+
+		if strings.HasPrefix(filepath.Base(modulePath), module+".ko") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isVBoxDrvInstalled returns true if vboxdrv has been installed for the
+// running kernel. If this cannot be detected vboxdrv is expected to be
+// installed.
+func isVBoxDrvInstalled() bool {
+	release, err := os.ReadFile(linuxKernelReleasePath)
+	if err != nil {
+		log.Error("Could not read kernel release from %s: %v", linuxKernelReleasePath, err)
+
+		return true
+	}
+
+	modulesDepPath := filepath.Join("/lib/modules", strings.TrimSpace(string(release)), "modules.dep")
+
+	modulesDep, err := os.ReadFile(modulesDepPath) // #nosec
+	if err != nil {
+		log.Error("Could not read kernel modules from %s: %v", modulesDepPath, err)
+
+		return true
+	}
+
+	return isModuleInModulesDep(string(modulesDep), "vboxdrv")
+}
+
+// isSecureBoot returns true if the computer was booted with UEFI Secure Boot
+func isSecureBoot() bool {
+	// The variable is 4 bytes of attributes followed by the 1 byte value
+	secureBoot, err := os.ReadFile(linuxSecureBootEFIVarPath)
+	if err != nil {
+		log.Debug("Secure Boot state is not available: %v", err)
+
+		return false
+	}
+
+	return len(secureBoot) == 5 && secureBoot[4] == 1
+}
+
+// GetVBoxDriverStatus returns the state of the VirtualBox kernel driver
+// (vboxdrv)
+func GetVBoxDriverStatus() VBoxDriverStatus {
+	modules, err := getLoadedModules()
+	if err != nil {
+		log.Error("Could not detect VirtualBox kernel driver: %v", err)
+
+		return VBoxDriverLoaded
+	}
+
+	var status VBoxDriverStatus
+
+	_, loaded := modules["vboxdrv"]
+
+	switch {
+	case loaded:
+		status = VBoxDriverLoaded
+	case !isVBoxDrvInstalled():
+		status = VBoxDriverNotInstalled
+	case isSecureBoot():
+		status = VBoxDriverBlockedBySecureBoot
+	default:
+		status = VBoxDriverNotLoaded
+	}
+
+	log.Debug("VirtualBox kernel driver: %s", status)
+
+	return status
+}
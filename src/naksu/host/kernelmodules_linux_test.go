@@ -0,0 +1,45 @@
+package host
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testProcModules = `vboxnetflt 32768 0 - Live 0x0000000000000000 (OE)
+kvm_intel 413696 2 - Live 0x0000000000000000
+kvm 1392640 1 kvm_intel, Live 0x0000000000000000
+irqbypass 12288 1 kvm, Live 0x0000000000000000
+`
+
+func TestGetConflictingKVMModules(t *testing.T) {
+	testCases := []struct {
+		description         string
+		procModules         string
+		isVirtEnabledAtLoad bool
+		expectedModules     []string
+	}{
+		{"kvm_intel in use", testProcModules, false, []string{"kvm_intel"}},
+		{"kvm_amd loaded but not used", "kvm_amd 217088 0 - Live 0x0000000000000000\n", false, []string{}},
+		{"kvm_amd enables virtualisation at load", "kvm_amd 217088 0 - Live 0x0000000000000000\n", true, []string{"kvm_amd"}},
+		{"kvm not loaded", "vboxdrv 696320 2 vboxnetflt, Live 0x0000000000000000 (OE)\n", true, []string{}},
+	}
+
+	for _, testCase := range testCases {
+		modules := getConflictingKVMModules(parseLoadedModules(testCase.procModules), testCase.isVirtEnabledAtLoad)
+		if !reflect.DeepEqual(modules, testCase.expectedModules) {
+			t.Errorf("Conflicting modules for %s are %v, expected %v", testCase.description, modules, testCase.expectedModules)
+		}
+	}
+}
+
+func TestIsModuleInModulesDep(t *testing.T) {
+	modulesDep := "kernel/arch/x86/kvm/kvm.ko.zst: kernel/virt/lib/irqbypass.ko.zst\nupdates/dkms/vboxdrv.ko.zst:\nupdates/dkms/vboxnetflt.ko.zst: updates/dkms/vboxdrv.ko.zst\n"
+
+	if !isModuleInModulesDep(modulesDep, "vboxdrv") {
+		t.Error("vboxdrv was not found in modules.dep")
+	}
+
+	if isModuleInModulesDep(modulesDep, "irqbypass") {
+		t.Error("A dependency was detected as an installed module")
+	}
+}
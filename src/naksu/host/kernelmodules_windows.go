@@ -0,0 +1,11 @@
+package host
+
+// GetConflictingKVMModules returns always nil on Windows
+func GetConflictingKVMModules() []string {
+	return nil
+}
+
+// GetVBoxDriverStatus returns always VBoxDriverLoaded on Windows
+func GetVBoxDriverStatus() VBoxDriverStatus {
+	return VBoxDriverLoaded
+}
@@ -4,3 +4,8 @@ package host
 func getPowerplan() string {
 	return "not implemented on Darwin"
 }
+
+// isPowerSavingPowerplan returns always false on Darwin
+func isPowerSavingPowerplan(powerplan string) bool {
+	return false
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"slices"
 	"strings"
 
 	"naksu/log"
@@ -11,14 +12,20 @@ import (
 
 const linuxCPUSettingsPath = "/sys/devices/system/cpu/"
 
-func getPowerplanFilenames() []string {
-	var powerplanFilenames []string
+// pstateDrivers are the scaling drivers which use the powersave governor by
+// default. They scale the frequency by the energy performance preference, so
+// the powersave governor does not slow the CPU down unless the preference is
+// "power".
+var pstateDrivers = []string{"intel_pstate", "amd-pstate-epp"}
+
+func getCPUFreqDirectories() []string {
+	var cpuFreqDirectories []string
 
 	dir, err := os.Open(linuxCPUSettingsPath)
 	if err != nil {
 		log.Error("Could not open path %s to read power plan filenames: %v", linuxCPUSettingsPath, err)
 
-		return powerplanFilenames
+		return cpuFreqDirectories
 	}
 
 	defer dir.Close()
@@ -27,18 +34,18 @@ func getPowerplanFilenames() []string {
 	if err != nil {
 		log.Error("Could not read filenames in directory %s to read power plan filenames: %v", linuxCPUSettingsPath, err)
 
-		return powerplanFilenames
+		return cpuFreqDirectories
 	}
 
 	re := regexp.MustCompile(`^cpu\d+`)
 
 	for _, file := range files {
 		if re.MatchString(file.Name()) {
-			powerplanFilenames = append(powerplanFilenames, fmt.Sprintf("%s%s/cpufreq/scaling_governor", linuxCPUSettingsPath, file.Name()))
+			cpuFreqDirectories = append(cpuFreqDirectories, fmt.Sprintf("%s%s/cpufreq/", linuxCPUSettingsPath, file.Name()))
 		}
 	}
 
-	return powerplanFilenames
+	return cpuFreqDirectories
 }
 
 func getPowerplanString(filename string) string {
@@ -54,15 +61,51 @@ func getPowerplanString(filename string) string {
 	return string(reOnlyletters.ReplaceAll(fileContent, []byte("")))
 }
 
-// getPowerplan returns a string describing current power plan
-func getPowerplan() string {
-	powerplanFilenames := getPowerplanFilenames()
+// getOptionalCPUFreqSetting returns the content of a cpufreq file which
+// exists only with some scaling drivers or an empty string
+func getOptionalCPUFreqSetting(filename string) string {
+	fileContent, err := os.ReadFile(filename) // #nosec
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(fileContent))
+}
 
+// getPowerplan returns a string describing current power plan. The power plan
+// of each CPU is the scaling driver, the scaling governor and the energy
+// performance preference separated by slashes (e.g.
+// "intel_pstate/powersave/balance_performance").
+func getPowerplan() string {
 	var powerplans []string
 
-	for _, powerplanFile := range powerplanFilenames {
-		powerplans = append(powerplans, getPowerplanString(powerplanFile))
+	for _, cpuFreqDirectory := range getCPUFreqDirectories() {
+		powerplans = append(powerplans, strings.Join([]string{
+			getOptionalCPUFreqSetting(cpuFreqDirectory + "scaling_driver"),
+			getPowerplanString(cpuFreqDirectory + "scaling_governor"),
+			getOptionalCPUFreqSetting(cpuFreqDirectory + "energy_performance_preference"),
+		}, "/"))
 	}
 
 	return strings.Join(powerplans, "+")
 }
+
+// isPowerSavingPowerplan returns true if any of the CPUs uses the powersave
+// scaling governor of a traditional scaling driver or prefers saving power
+// with the intel_pstate or amd-pstate driver
+func isPowerSavingPowerplan(powerplan string) bool {
+	for _, cpuPowerplan := range strings.Split(powerplan, "+") {
+		driver, rest, _ := strings.Cut(cpuPowerplan, "/")
+		governor, preference, _ := strings.Cut(rest, "/")
+
+		if slices.Contains(pstateDrivers, driver) {
+			if preference == "power" {
+				return true
+			}
+		} else if governor == "powersave" {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,24 @@
+package host
+
+import "testing"
+
+func TestIsPowerSavingPowerplan(t *testing.T) {
+	testCases := []struct {
+		powerplan string
+		expected  bool
+	}{
+		{"intel_pstate/powersave/balance_performance+intel_pstate/powersave/balance_performance", false},
+		{"intel_pstate/powersave/power+intel_pstate/powersave/power", true},
+		{"intel_pstate/performance/performance", false},
+		{"amd-pstate-epp/powersave/balance_power", false},
+		{"acpi-cpufreq/ondemand/+acpi-cpufreq/ondemand/", false},
+		{"acpi-cpufreq/powersave/+acpi-cpufreq/ondemand/", true},
+		{"", false},
+	}
+
+	for _, testCase := range testCases {
+		if isPowerSaving := isPowerSavingPowerplan(testCase.powerplan); isPowerSaving != testCase.expected {
+			t.Errorf("Power plan '%s' gave %t, expected %t", testCase.powerplan, isPowerSaving, testCase.expected)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package host
 
 import (
 	"fmt"
+	"strings"
 
 	"naksu/mebroutines"
 )
@@ -13,3 +14,19 @@ func getPowerplan() string {
 
 	return fmt.Sprintf("%s\n\n%s", powercfgListOutput, powercfgQueryOutput)
 }
+
+// powerSaverSchemeGUID is the GUID of the built-in "Power saver" scheme. The
+// GUID does not depend on the Windows display language.
+const powerSaverSchemeGUID = "a1841308-3334-4df9-9ddb-8f9f0d7e0e2a"
+
+// isPowerSavingPowerplan returns true if the active power scheme (marked with
+// an asterisk in "powercfg /list") is the power saver scheme
+func isPowerSavingPowerplan(powerplan string) bool {
+	for _, line := range strings.Split(powerplan, "\n") {
+		if strings.HasSuffix(strings.TrimSpace(line), "*") && strings.Contains(strings.ToLower(line), powerSaverSchemeGUID) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,83 @@
+package host
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"naksu/log"
+)
+
+const (
+	linuxNetworkDevicesPath = "/sys/class/net/"
+	// qemuBridgeConfMaxIncludes limits the include depth of bridge.conf
+	qemuBridgeConfMaxIncludes = 8
+)
+
+// readQEMUBridgeACL returns the allow and deny rules of the
+// qemu-bridge-helper configuration file with the included files expanded
+func readQEMUBridgeACL(path string, depth int) []string {
+	var rules []string
+
+	if depth > qemuBridgeConfMaxIncludes {
+		log.Warning("Too many nested includes in %s", path)
+
+		return rules
+	}
+
+	content, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		log.Debug("Could not read qemu bridge configuration %s: %v", path, err)
+
+		return rules
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		if fields[0] == "include" {
+			rules = append(rules, readQEMUBridgeACL(fields[1], depth+1)...)
+		} else {
+			rules = append(rules, fields[0]+" "+fields[1])
+		}
+	}
+
+	return rules
+}
+
+// isBridgeAllowed returns true if the rules allow the bridge. Like
+// qemu-bridge-helper the last matching rule wins and the bridges are denied
+// by default.
+func isBridgeAllowed(rules []string, bridge string) bool {
+	isAllowed := false
+
+	for _, rule := range rules {
+		switch rule {
+		case "allow all", "allow " + bridge:
+			isAllowed = true
+		case "deny all", "deny " + bridge:
+			isAllowed = false
+		}
+	}
+
+	return isAllowed
+}
+
+// GetQEMUBridgeStatus returns whether QEMU can add the server to the network
+// device. QEMU connects the server to a bridge with qemu-bridge-helper,
+// which adds only the bridges allowed in /etc/qemu/bridge.conf.
+func GetQEMUBridgeStatus(networkDevice string) QEMUBridgeStatus {
+	_, err := os.Stat(filepath.Join(linuxNetworkDevicesPath, networkDevice, "bridge"))
+	if err != nil {
+		return QEMUBridgeNotBridge
+	}
+
+	if !isBridgeAllowed(readQEMUBridgeACL(QEMUBridgeConfPath, 0), networkDevice) {
+		return QEMUBridgeNotAllowed
+	}
+
+	return QEMUBridgeAllowed
+}
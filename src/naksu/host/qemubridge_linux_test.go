@@ -0,0 +1,47 @@
+package host
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsBridgeAllowed(t *testing.T) {
+	testCases := []struct {
+		rules    []string
+		expected bool
+	}{
+		{[]string{}, false},
+		{[]string{"allow br0"}, true},
+		{[]string{"allow br1"}, false},
+		{[]string{"allow all"}, true},
+		{[]string{"allow all", "deny br0"}, false},
+		{[]string{"deny all", "allow br0"}, true},
+	}
+
+	for _, testCase := range testCases {
+		if isAllowed := isBridgeAllowed(testCase.rules, "br0"); isAllowed != testCase.expected {
+			t.Errorf("Rules %v gave %t for br0, expected %t", testCase.rules, isAllowed, testCase.expected)
+		}
+	}
+}
+
+func TestReadQEMUBridgeACLIncludes(t *testing.T) {
+	directory := t.TempDir()
+	includedPath := filepath.Join(directory, "naksu.conf")
+	confPath := filepath.Join(directory, "bridge.conf")
+
+	err := os.WriteFile(includedPath, []byte("allow br0\n"), 0o600)
+	if err != nil {
+		t.Fatalf("Could not write included file: %v", err)
+	}
+
+	err = os.WriteFile(confPath, []byte("# Exam network\ndeny all\ninclude "+includedPath+"\n"), 0o600)
+	if err != nil {
+		t.Fatalf("Could not write bridge.conf: %v", err)
+	}
+
+	if !isBridgeAllowed(readQEMUBridgeACL(confPath, 0), "br0") {
+		t.Errorf("br0 allowed in an included file was denied")
+	}
+}
@@ -0,0 +1,6 @@
+package host
+
+// GetQEMUBridgeStatus returns always QEMUBridgeAllowed on Windows
+func GetQEMUBridgeStatus(string) QEMUBridgeStatus {
+	return QEMUBridgeAllowed
+}
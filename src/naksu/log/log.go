@@ -17,6 +17,7 @@ var isDebug bool
 var debugFilename string
 var logger *log.Logger
 var loggerWriter io.WriteCloser
+var consoleWriter io.Writer = os.Stdout
 
 func appendLogFile(message string) {
 	if debugFilename != "" {
@@ -74,6 +75,12 @@ func SetDebugFilename(newFilename string) {
 	logger = log.New(loggerWriter, "", log.Ldate|log.Ltime)
 }
 
+// SetConsoleWriter sets the writer where the log messages are printed in addition
+// to the log file. The default is standard output.
+func SetConsoleWriter(newWriter io.Writer) {
+	consoleWriter = newWriter
+}
+
 // GetNewDebugFilename suggests a new debug log filename
 func GetNewDebugFilename() string {
 	newDebugFilename := ""
@@ -111,7 +118,7 @@ func IsDebug() bool {
 func writeLogMessage(prefix string, message string, vars ...interface{}) {
 	formattedMessage := fmt.Sprintf(message, vars...)
 	if (prefix == "DEBUG" && IsDebug()) || prefix != "DEBUG" {
-		fmt.Printf("%s: %s\n", prefix, formattedMessage)
+		fmt.Fprintf(consoleWriter, "%s: %s\n", prefix, formattedMessage)
 	}
 
 	appendLogFile(fmt.Sprintf("%s: %s", prefix, formattedMessage))
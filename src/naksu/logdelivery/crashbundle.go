@@ -0,0 +1,95 @@
+package logdelivery
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"naksu/box"
+	"naksu/constants"
+	"naksu/log"
+	"naksu/mebroutines"
+)
+
+const crashBundleTimeFormat = "2006-01-02_15-04-05"
+
+// GetCrashBundleDirectory returns the directory of the crash diagnostics bundles
+func GetCrashBundleDirectory() string {
+	return filepath.Join(mebroutines.GetKtpDirectory(), "crash_reports")
+}
+
+// CollectCrashBundle collects the VirtualBox logs of the crashed VM and the
+// naksu logs to a timestamped zip file. The logs are collected right away
+// so that a restart of the VM does not rotate VBox.log away. Returns the path
+// of the bundle.
+func CollectCrashBundle(crash box.Crash) (string, error) {
+	err := os.MkdirAll(GetCrashBundleDirectory(), constants.FilePermissionsOwnerRWX)
+	if err != nil {
+		return "", fmt.Errorf("could not create crash bundle directory: %w", err)
+	}
+
+	bundlePath := filepath.Join(GetCrashBundleDirectory(), fmt.Sprintf("naksu_crash_%s.zip", crash.DetectedAt.Format(crashBundleTimeFormat)))
+	log.Debug("Collecting crash diagnostics to %s", bundlePath)
+
+	bundleFile, err := os.Create(filepath.Clean(bundlePath))
+	if err != nil {
+		return "", fmt.Errorf("could not create crash bundle: %w", err)
+	}
+	defer bundleFile.Close()
+
+	logFiles := []string{}
+
+	logFiles, err = appendVirtualBoxLogs(logFiles)
+	if err != nil {
+		log.Warning("Error appending VirtualBox logs: %s", err)
+		// continue collecting logs after error in appending VirtualBox logs
+	}
+	logFiles, err = appendNaksuLastlogs(logFiles)
+	if err != nil {
+		log.Warning("Error appending naksu logs: %s", err)
+		// continue collecting logs after error in appending naksu logs
+	}
+
+	writer := zip.NewWriter(bundleFile)
+
+	err = writeCrashSummary(crash, writer)
+	if err != nil {
+		return "", err
+	}
+
+	for _, logFilepath := range logFiles {
+		err = addFileToZip(logFilepath, writer)
+		if err != nil {
+			return "", fmt.Errorf("could not add %s to crash bundle: %w", logFilepath, err)
+		}
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return "", fmt.Errorf("could not write crash bundle: %w", err)
+	}
+
+	return bundlePath, nil
+}
+
+// writeCrashSummary writes crash.txt describing the state transition
+func writeCrashSummary(crash box.Crash, zipWriter *zip.Writer) error {
+	summaryFile, err := zipWriter.CreateHeader(&zip.FileHeader{ // nolint: exhaustruct
+		Name:     "crash.txt",
+		Method:   zip.Deflate,
+		Modified: crash.DetectedAt,
+	})
+	if err != nil {
+		return fmt.Errorf("could not create crash summary: %w", err)
+	}
+
+	_, err = fmt.Fprintf(summaryFile, "VM: %s\nPrevious state: %s\nState: %s\nDetected at: %s\n",
+		crash.VMName, crash.PreviousState, crash.State, crash.DetectedAt.Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("could not write crash summary: %w", err)
+	}
+
+	return nil
+}
@@ -23,6 +23,9 @@ import (
 	"naksu/constants"
 	"naksu/log"
 	"naksu/mebroutines"
+	"naksu/network"
+	"naksu/notifier"
+	"naksu/xlate"
 )
 
 // DeleteLogCopyFiles deletes temporary files related to copying logs from the virtual machine guest
@@ -474,3 +477,79 @@ func setEnvAndLogError(key, value string) {
 		log.Error("Could not set environment variable %s", key)
 	}
 }
+
+// DeliverLogs requests the logs from the server, collects them to a zip file
+// in ktp-jako and sends the zip file if there is an Internet connection. The
+// progress is reported to the given notifier. Returns the filename of the zip
+// file.
+func DeliverLogs(n notifier.Notifier) (string, error) {
+	return DeliverLogsWithZipListener(n, func(string) {})
+}
+
+// DeliverLogsWithZipListener is DeliverLogs calling zipListener with the
+// filename of the zip file when the logs start to be collected to it. The
+// "Deliver logs" button uses it to show the filename while the logs are sent.
+func DeliverLogsWithZipListener(n notifier.Notifier, zipListener func(filename string)) (string, error) {
+	const zipProgressFinished = 100
+
+	copyDoneChannel, copyProgressChannel := RequestLogsFromServer()
+
+copyLoop:
+	for {
+		select {
+		case copyDone := <-copyDoneChannel:
+			if copyDone {
+				n.Message(xlate.Get("Done copying"))
+
+				break copyLoop
+			}
+		case copyProgress := <-copyProgressChannel:
+			if copyProgress != "0 %" {
+				n.Message(xlate.Get("Copying logs: %s", copyProgress))
+			}
+		}
+	}
+
+	logFilename, zipProgressChannel, zipErrorChannel := CollectLogsToZip()
+	zipListener(logFilename)
+
+zipLoop:
+	for {
+		select {
+		case zipProgress := <-zipProgressChannel:
+			if zipProgress > zipProgressFinished {
+				n.ProgressDone()
+				n.Message(xlate.Get("Done zipping"))
+
+				break zipLoop
+			}
+			n.Progress(xlate.Get("Zipping logs"), int(zipProgress))
+		case zipError := <-zipErrorChannel:
+			n.ProgressDone()
+			n.Error(xlate.Get("Error zipping logs: %s", zipError))
+
+			return logFilename, fmt.Errorf("zipping logs failed: %w", zipError)
+		}
+	}
+
+	if !network.CheckIfNetworkAvailable() {
+		n.Warning(xlate.Get("Cannot send logs because there is no Internet connection. Logs are in a zip archive in the ktp-jako folder."))
+
+		return logFilename, nil
+	}
+
+	err := SendLogs(logFilename, func(progress uint8) {
+		n.Progress(xlate.Get("Sending logs"), int(progress))
+	})
+	n.ProgressDone()
+
+	if err != nil {
+		n.Error(xlate.Get("Error sending logs: %s", err))
+
+		return logFilename, fmt.Errorf("sending logs failed: %w", err)
+	}
+
+	n.Message(xlate.Get("Logs sent!"))
+
+	return logFilename, nil
+}
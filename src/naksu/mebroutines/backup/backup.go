@@ -12,7 +12,7 @@ import (
 	"naksu/host"
 	"naksu/log"
 	"naksu/mebroutines"
-	"naksu/ui/progress"
+	"naksu/notifier"
 	"naksu/xlate"
 
 	humanize "github.com/dustin/go-humanize"
@@ -21,62 +21,63 @@ import (
 var generalErrorString = xlate.GetRaw("Backup failed: %v")
 
 // MakeBackup creates virtual machine backup to path
-func MakeBackup(backupPath string) error {
+func MakeBackup(backupPath string, n notifier.Notifier) error {
 	err := ensureBoxInstalledAndNotRunning()
 	if err != nil {
-		return mebroutines.ShowTranslatedErrorMessageAndPassError(generalErrorString, err)
+		return notifier.ShowTranslatedErrorAndPassError(n, generalErrorString, err)
 	}
 
 	err = host.CheckFreeDisk(constants.LowDiskLimit, []string{filepath.Dir(backupPath)})
 	var lowDiskSizeError *host.LowDiskSizeError
 	if errors.As(err, &lowDiskSizeError) {
-		mebroutines.ShowTranslatedWarningMessage("Your free disk size is getting low (%s). If backup process fails please consider freeing some disk space.", humanize.Bytes(lowDiskSizeError.LowSize))
+		n.Warning(xlate.Get("Your free disk size is getting low (%s). If backup process fails please consider freeing some disk space.", humanize.Bytes(lowDiskSizeError.LowSize)))
 	} else if err != nil {
-		mebroutines.ShowTranslatedErrorMessage("Failed to calculate free disk size: %v", err)
+		n.Error(xlate.Get("Failed to calculate free disk size: %v", err))
 	}
 
-	progress.TranslateAndSetMessage("Checking existing file...")
+	n.Message(xlate.Get("Checking existing file..."))
 	if mebroutines.ExistsFile(backupPath) {
-		mebroutines.ShowTranslatedErrorMessage("File %s already exists", backupPath)
+		n.Error(xlate.Get("File %s already exists", backupPath))
 
 		return errors.New("backup file already exists")
 	}
 
 	// Check if path_backup is writeable
-	progress.TranslateAndSetMessage("Checking backup path...")
+	n.Message(xlate.Get("Checking backup path..."))
 	err = mebroutines.CreateFile(backupPath)
 	if err != nil {
-		mebroutines.ShowTranslatedErrorMessage("Could not write test backup file %s. Try another location.", backupPath)
+		n.Error(xlate.Get("Could not write test backup file %s. Try another location.", backupPath))
 
 		return fmt.Errorf("could not write test backup file: %w", err)
 	}
 
 	err = os.Remove(backupPath)
 	if err != nil {
-		return mebroutines.ShowTranslatedErrorMessageAndPassError(generalErrorString, fmt.Errorf("removing test backup file returned error code: %w", err))
+		return notifier.ShowTranslatedErrorAndPassError(n, generalErrorString, fmt.Errorf("removing test backup file returned error code: %w", err))
 	}
 
 	// Get disk location
-	progress.TranslateAndSetMessage("Getting disk location...")
+	n.Message(xlate.Get("Getting disk location..."))
 	diskLocation := box.GetDiskLocation()
 	log.Debug("Disk location: %s", diskLocation)
 	if diskLocation == "" {
-		return mebroutines.ShowTranslatedErrorMessageAndPassError(generalErrorString, errors.New("could not get disk location"))
+		return notifier.ShowTranslatedErrorAndPassError(n, generalErrorString, errors.New("could not get disk location"))
 	}
 
-	progress.TranslateAndSetMessage("Checking for FAT32 filesystem...")
+	n.Message(xlate.Get("Checking for FAT32 filesystem..."))
 	err = checkForFATFilesystem(backupPath, diskLocation)
 	if err != nil {
-		mebroutines.ShowTranslatedErrorMessage("The backup file is too large for a FAT32 filesystem. Please reformat the backup disk as exFAT.")
+		n.Error(xlate.Get("The backup file is too large for a FAT32 filesystem. Please reformat the backup disk as exFAT."))
 
 		return fmt.Errorf("backup file too large for fat32 filesystem: %w", err)
 	}
 
 	// Make clone to path_backup
-	progress.TranslateAndSetMessage("Please wait, writing backup...")
-	err = box.WriteDiskClone(backupPath)
+	n.Progress(xlate.Get("Please wait, writing backup..."), 0)
+	err = box.WriteDiskClone(backupPath, n)
+	n.ProgressDone()
 	if err != nil {
-		return mebroutines.ShowTranslatedErrorMessageAndPassError(generalErrorString, fmt.Errorf("failed to make clone: %w", err))
+		return notifier.ShowTranslatedErrorAndPassError(n, generalErrorString, fmt.Errorf("failed to make clone: %w", err))
 	}
 
 	return nil
@@ -6,15 +6,19 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"regexp"
+	"strings"
 
 	"naksu/box"
 	"naksu/box/download"
+	"naksu/box/vboxmanage"
+	"naksu/config"
 	"naksu/constants"
 	"naksu/host"
 	"naksu/log"
 	"naksu/mebroutines"
-	"naksu/ui/progress"
+	"naksu/notifier"
 	"naksu/xlate"
 
 	humanize "github.com/dustin/go-humanize"
@@ -27,110 +31,242 @@ const (
 )
 
 // newServer downloads and creates new Abitti or Exam server using the given image URL
-func newServer(boxType string, imageURL string, versionURL string) error {
-	version, err := download.GetAvailableVersion(versionURL)
+func newServer(boxType string, imageURL string, versionURL string, n notifier.Notifier) error {
+	version, err := download.GetVerifiedAvailableVersion(versionURL)
+	if errors.Is(err, download.ErrDownloadSignatureInvalid) {
+		n.Error(xlate.Get("The signature of the server version is invalid. The server was not installed."))
+
+		return fmt.Errorf("version signature verification failed: %w", err)
+	}
+
 	switch fmt.Sprintf("%v", err) {
 	case "<nil>":
 	case "403", "404":
-		mebroutines.ShowTranslatedErrorMessage("Please check the install passphrase")
+		n.Error(xlate.Get("Please check the install passphrase"))
 
 		return fmt.Errorf("wrong passphrase entered (got %w)", err)
 	default:
-		mebroutines.ShowTranslatedErrorMessage("Could not get version string for a new server: %v", err)
+		n.Error(xlate.Get("Could not get version string for a new server: %v", err))
 
 		return fmt.Errorf("error from server: %w", err)
 	}
 
 	// Clean message
-	progress.SetMessage("")
+	n.Message("")
+
+	return installServer(boxType, version, func() error {
+		return getServerImage(boxType, version, imageURL, n)
+	}, n)
+}
+
+// getServerImage writes the server image of the version from the image cache
+// or downloads it to the cache. The cache is pruned to the limits of the
+// configuration afterwards.
+func getServerImage(boxType string, version string, imageURL string, n notifier.Notifier) error {
+	defer download.PruneImageCache(config.GetImageCacheCount(), config.GetImageCacheSize())
+
+	cachePath := ""
+
+	if config.GetImageCacheCount() > 0 {
+		cachePath = download.GetCachedServerImagePath(boxType, version)
+
+		if mebroutines.ExistsFile(cachePath) {
+			n.Progress(xlate.GetRaw("Using cached server image"), installProgressDownloadingImage)
+
+			err := download.GetServerImageFromCache(cachePath, n)
+			if err == nil {
+				return nil
+			}
+
+			log.Warning("Could not use cached image %s, downloading it again: %v", cachePath, err)
+		}
+	}
+
+	n.Progress(xlate.GetRaw("Getting Image from the Cloud"), installProgressDownloadingImage)
+
+	return download.GetServerImage(imageURL, cachePath, n)
+}
+
+// NewServerFromFile creates a new Abitti or Exam server from a local etcher
+// zip, e.g. on a USB stick. The version is read from the version file or, if
+// it is not given, from a .ver file next to the zip.
+func NewServerFromFile(boxType string, zipPath string, versionPath string, n notifier.Notifier) error {
+	if !mebroutines.ExistsFile(zipPath) {
+		n.Error(xlate.Get("Image file %s does not exist", zipPath))
+
+		return fmt.Errorf("image file %s does not exist", zipPath)
+	}
+
+	version, err := getLocalServerVersion(zipPath, versionPath)
+	if errors.Is(err, download.ErrDownloadSignatureInvalid) {
+		n.Error(xlate.Get("The signature of the server version is invalid. The server was not installed."))
+
+		return fmt.Errorf("version signature verification failed: %w", err)
+	} else if err != nil {
+		n.Error(xlate.Get("Could not get version string for a new server: %v", err))
+
+		return fmt.Errorf("could not read version file: %w", err)
+	}
+
+	// Clean message
+	n.Message("")
+
+	return installServer(boxType, version, func() error {
+		n.Progress(xlate.GetRaw("Reading image from file"), installProgressDownloadingImage)
+
+		return download.GetLocalServerImage(zipPath, n)
+	}, n)
+}
+
+// getLocalServerVersion returns the version of a local etcher zip. Without a
+// version file the version is made from the modification time of the zip.
+func getLocalServerVersion(zipPath string, versionPath string) (string, error) {
+	if versionPath == "" {
+		defaultVersionPath := strings.TrimSuffix(zipPath, filepath.Ext(zipPath)) + ".ver"
+		if mebroutines.ExistsFile(defaultVersionPath) {
+			versionPath = defaultVersionPath
+		}
+	}
+
+	if versionPath != "" {
+		return download.GetLocalVersion(versionPath)
+	}
+
+	fileInfo, err := os.Stat(zipPath)
+	if err != nil {
+		return "", fmt.Errorf("could not get file info of %s: %w", zipPath, err)
+	}
 
-	// Initialize dialog
-	progressDialog := progress.TranslateAndShowProgressDialog("Preparing...")
+	version := "local" + fileInfo.ModTime().Format("20060102")
+	log.Warning("No version file for %s, using version '%s'", zipPath, version)
+
+	return version, nil
+}
+
+// installServer gets the server image with getImage and creates the box
+func installServer(boxType string, version string, getImage func() error, n notifier.Notifier) error {
+	// Each box type is installed to its own server so that the server of the
+	// other type is kept
+	err := selectServerForBoxType(boxType)
+	if err != nil {
+		n.Error(xlate.Get("Could not select the server to install: %v", err))
+
+		return err
+	}
+
+	// Initialize progress
+	n.Progress(xlate.Get("Preparing..."), 0)
 
 	// Check prerequisites
-	if ensureServerIsNotRunningAndDoesNotExist() != nil || ensureDiskIsReady(&progressDialog) != nil {
-		progress.CloseProgressDialog(progressDialog)
+	if ensureServerIsNotRunningAndDoesNotExist(n) != nil || ensureDiskIsReady(n, download.GetCachedServerImagePath(boxType, version)) != nil {
+		n.ProgressDone()
 
 		return errors.New("server exists or disk is not ready")
 	}
 
-	err = downloadAndInstallVM(&progressDialog, imageURL, boxType, version)
+	err = getImageAndInstallVM(n, getImage, boxType, version)
 	if err != nil {
-		log.Error("Failed to download and install VM: %v", err)
+		log.Error("Failed to get image and install VM: %v", err)
 
 		return err
 	}
 
-	progressDialogMessage := xlate.GetRaw("Removing temporary raw image file")
-	progress.UpdateProgressDialog(progressDialog, installProgressFinished, &progressDialogMessage)
+	n.Progress(xlate.GetRaw("Removing temporary raw image file"), installProgressFinished)
 	err = os.Remove(mebroutines.GetImagePath())
 
 	if err != nil {
-		mebroutines.ShowTranslatedWarningMessage("Failed to remove raw image file %s: %v", mebroutines.GetImagePath(), err)
+		n.Warning(xlate.Get("Failed to remove raw image file %s: %v", mebroutines.GetImagePath(), err))
 	}
-	progress.CloseProgressDialog(progressDialog)
+	n.ProgressDone()
 
 	return nil
 }
 
 // NewAbittiServer downloads and installs a new Abitti server
-func NewAbittiServer() error {
-	return newServer(constants.AbittiBoxType, constants.AbittiEtcherURL, constants.AbittiVersionURL)
+func NewAbittiServer(n notifier.Notifier) error {
+	return newServer(constants.AbittiBoxType, constants.AbittiEtcherURL, constants.AbittiVersionURL, n)
 }
 
 // NewExamServer downloads and installs a new exam server
-func NewExamServer(passphrase string) error {
+func NewExamServer(passphrase string, n notifier.Notifier) error {
 	passphraseHash := getPassphraseHash(passphrase)
 	imageURL := getExamURL(constants.MatriculationExamEtcherURL, passphraseHash)
 	versionURL := getExamURL(constants.MatriculationExamVersionURL, passphraseHash)
 
-	return newServer(constants.MatriculationExamBoxType, imageURL, versionURL)
+	return newServer(constants.MatriculationExamBoxType, imageURL, versionURL, n)
+}
+
+// selectServerForBoxType selects and stores the server the box type is
+// installed to
+func selectServerForBoxType(boxType string) error {
+	server := box.GetServerForBoxType(boxType)
+
+	err := box.SelectServer(server)
+	if err != nil {
+		return fmt.Errorf("could not select server %s: %w", server, err)
+	}
+	config.SetServer(server)
+
+	return nil
 }
 
-func ensureServerIsNotRunningAndDoesNotExist() error {
+func ensureServerIsNotRunningAndDoesNotExist(n notifier.Notifier) error {
+	err := box.CheckSelectedServerVM()
+	if err != nil {
+		n.Error(xlate.Get("The server cannot be installed as its VM holds a server of another type installed by an older Naksu version. Remove the other server first."))
+
+		return err
+	}
+
 	isRunning, errRunning := box.Running()
 	if errRunning != nil {
-		mebroutines.ShowTranslatedErrorMessage("Could not install server as we could not detect whether existing VM is running: %v", errRunning)
+		n.Error(xlate.Get("Could not install server as we could not detect whether existing VM is running: %v", errRunning))
 
 		return errRunning
 	}
 
 	if isRunning {
-		mebroutines.ShowTranslatedErrorMessage("Please stop the current server before installing a new one")
+		n.Error(xlate.Get("Please stop the current server before installing a new one"))
 
 		return errors.New("please stop the current server before installing a new one")
 	}
 
 	isInstalled, errInstalled := box.Installed()
 	if errInstalled != nil {
-		mebroutines.ShowTranslatedErrorMessage("Could not install server as we could not detect whether existing VM is installed: %v", errInstalled)
+		n.Error(xlate.Get("Could not install server as we could not detect whether existing VM is installed: %v", errInstalled))
 
 		return errInstalled
 	}
 
 	if isInstalled {
 		errRemove := box.RemoveCurrentBox()
-		if errRemove != nil {
-			mebroutines.ShowTranslatedWarningMessage("Could not remove current VM before installing new one: %v", errRemove)
+		if errors.Is(errRemove, vboxmanage.ErrSessionLocked) {
+			// The VM is open in another program, so the new VM could not be
+			// created in its place
+			n.Error(xlate.Get("Could not remove current VM before installing new one: %v", errRemove))
+
+			return errRemove
+		} else if errRemove != nil {
+			n.Warning(xlate.Get("Could not remove current VM before installing new one: %v", errRemove))
 		}
 	}
 
 	return nil
 }
 
-func ensureDiskIsReady(dialog *progress.Dialog) error {
-	err := ensureNaksuDirectoriesExist(dialog)
+func ensureDiskIsReady(n notifier.Notifier, cachePath string) error {
+	err := ensureNaksuDirectoriesExist(n)
 	if err != nil {
 		log.Error("Failed to ensure Naksu directories exist: %v", err)
-		mebroutines.ShowTranslatedErrorMessage("Could not create directory: %v", err)
+		n.Error(xlate.Get("Could not create directory: %v", err))
 
 		return err
 	}
 
-	err = ensureFreeDisk()
+	err = ensureFreeDisk(n, cachePath)
 	if err != nil {
 		log.Error("Failed to ensure we have enough free disk: %v", err)
-		mebroutines.ShowTranslatedErrorMessage("Could not calculate free disk size: %v", err)
+		n.Error(xlate.Get("Could not calculate free disk size: %v", err))
 
 		return err
 	}
@@ -138,13 +274,9 @@ func ensureDiskIsReady(dialog *progress.Dialog) error {
 	return nil
 }
 
-func ensureNaksuDirectoriesExist(dialog *progress.Dialog) error {
+func ensureNaksuDirectoriesExist(n notifier.Notifier) error {
 	// Create ~/ktp if missing
-	if dialog != nil {
-		progress.TranslateAndUpdateProgressDialogWithMessage(*dialog, 1, "Creating ~/ktp")
-	} else {
-		progress.TranslateAndSetMessage("Creating ~/ktp")
-	}
+	n.Progress(xlate.Get("Creating ~/ktp"), 1)
 	ktpPath, errKtpPath := createKtpDir()
 
 	if errKtpPath != nil {
@@ -154,11 +286,7 @@ func ensureNaksuDirectoriesExist(dialog *progress.Dialog) error {
 	log.Debug("ktpPath is %s", ktpPath)
 
 	// Create ~/ktp-jako if missing
-	if dialog != nil {
-		progress.TranslateAndUpdateProgressDialogWithMessage(*dialog, 1, "Creating ~/ktp-jako")
-	} else {
-		progress.TranslateAndSetMessage("Creating ~/ktp-jako")
-	}
+	n.Progress(xlate.Get("Creating ~/ktp-jako"), 1)
 	ktpJakoPath, errKtpJakoPath := createKtpJakoDir()
 
 	if errKtpJakoPath != nil {
@@ -170,13 +298,28 @@ func ensureNaksuDirectoriesExist(dialog *progress.Dialog) error {
 	return nil
 }
 
-func ensureFreeDisk() error {
-	err := host.CheckFreeDisk(constants.LowDiskLimit, []string{mebroutines.GetKtpDirectory(), mebroutines.GetVirtualBoxHiddenDirectory(), mebroutines.GetVirtualBoxVMsDirectory()})
+// ensureFreeDisk warns if the free disk is low. The image cache is pruned
+// first, and emptied if the disk would be low otherwise, so that the cached
+// zips do not fill the disk. The cached zip of the version being installed
+// (cachePath) is kept.
+func ensureFreeDisk(n notifier.Notifier, cachePath string) error {
+	directories := []string{mebroutines.GetKtpDirectory(), mebroutines.GetVirtualBoxHiddenDirectory(), mebroutines.GetVirtualBoxVMsDirectory()}
+
+	download.PruneImageCache(config.GetImageCacheCount(), config.GetImageCacheSize(), cachePath)
+
+	err := host.CheckFreeDisk(constants.LowDiskLimit, directories)
 	var lowDiskSizeError *host.LowDiskSizeError
 	if errors.As(err, &lowDiskSizeError) {
-		mebroutines.ShowTranslatedWarningMessage("Your free disk size is getting low (%s)", humanize.Bytes(lowDiskSizeError.LowSize))
+		log.Warning("Free disk is low (%s), emptying the image cache", humanize.Bytes(lowDiskSizeError.LowSize))
+		download.PruneImageCache(0, 0, cachePath)
+
+		err = host.CheckFreeDisk(constants.LowDiskLimit, directories)
+	}
+
+	if errors.As(err, &lowDiskSizeError) {
+		n.Warning(xlate.Get("Your free disk size is getting low (%s)", humanize.Bytes(lowDiskSizeError.LowSize)))
 	} else if err != nil {
-		mebroutines.ShowTranslatedErrorMessage("Failed to calculate free disk space: %v", err)
+		n.Error(xlate.Get("Failed to calculate free disk space: %v", err))
 
 		return err
 	}
@@ -184,39 +327,42 @@ func ensureFreeDisk() error {
 	return nil
 }
 
-func downloadAndInstallVM(progressDialog *progress.Dialog, imageURL string, boxType string, version string) error {
-	updateProgressFunc := func(message string, value int) {
-		progress.UpdateProgressDialog(*progressDialog, value, &message)
-	}
+func getImageAndInstallVM(n notifier.Notifier, getImage func() error, boxType string, version string) error {
+	err := getImage()
 
-	updateProgressFunc(xlate.GetRaw("Getting Image from the Cloud"), installProgressDownloadingImage)
-	err := download.GetServerImage(imageURL, updateProgressFunc)
+	if errors.Is(err, download.ErrDownloadSignatureInvalid) {
+		// Do not leave an untrusted image on the disk
+		removeErr := os.Remove(mebroutines.GetImagePath())
+		if removeErr != nil {
+			log.Debug("Failed to remove image file %s: %v", mebroutines.GetImagePath(), removeErr)
+		}
+		n.ProgressDone()
+		n.Error(xlate.Get("The signature of the downloaded image is invalid. The image may have been tampered with and was not installed."))
 
-	if errors.Is(err, download.ErrDownloadedDiskImageCorrupted) {
-		progress.CloseProgressDialog(*progressDialog)
-		mebroutines.ShowTranslatedErrorMessage("Downloaded image is corrupted. Try again.")
+		return fmt.Errorf("downloading image failed (invalid signature): %w", err)
+	} else if errors.Is(err, download.ErrDownloadedDiskImageCorrupted) {
+		n.ProgressDone()
+		n.Error(xlate.Get("Downloaded image is corrupted. Try again."))
 
 		return fmt.Errorf("downloading image failed (image corrupted): %w", err)
 	} else if err != nil {
-		progress.CloseProgressDialog(*progressDialog)
-		mebroutines.ShowTranslatedErrorMessage("Failed to get new VM image: %v", err)
+		n.ProgressDone()
+		n.Error(xlate.Get("Failed to get new VM image: %v", err))
 
 		return fmt.Errorf("downloading image failed: %w", err)
 	}
 
-	updateProgressFunc(xlate.GetRaw("Creating New VM"), installProgressCreatingVM)
+	n.Progress(xlate.GetRaw("Creating New VM"), installProgressCreatingVM)
 	err = box.CreateNewBox(boxType, version)
 
 	if err != nil {
-		mebroutines.ShowTranslatedErrorMessage("Failed to create new VM: %v", err)
-
 		removeErr := os.Remove(mebroutines.GetImagePath())
 		if removeErr != nil {
 			log.Debug("Failed to remove image file %s: %v", mebroutines.GetImagePath(), removeErr)
 		}
-		progress.CloseProgressDialog(*progressDialog)
+		n.ProgressDone()
 
-		return fmt.Errorf("failed to create new vm: %w", err)
+		return fmt.Errorf("failed to create new vm: %w", notifier.ShowTranslatedErrorAndPassError(n, "Failed to create new VM: %v", err))
 	}
 
 	box.ResetCache()
@@ -259,6 +405,12 @@ func getPassphraseHash(passphrase string) string {
 	return fmt.Sprintf("%x", hashCalculator.Sum(nil))
 }
 
+// GetExamVersionURL returns the URL of the version of the exam server which
+// NewExamServer installs with the given passphrase
+func GetExamVersionURL(passphrase string) string {
+	return getExamURL(constants.MatriculationExamVersionURL, getPassphraseHash(passphrase))
+}
+
 func getExamURL(url string, passphraseHash string) string {
 	re := regexp.MustCompile(`###PASSPHRASEHASH###`)
 
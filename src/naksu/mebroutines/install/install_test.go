@@ -0,0 +1,58 @@
+package install
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"naksu/constants"
+)
+
+type recordingNotifier struct {
+	errors   []string
+	warnings []string
+	messages []string
+	progress []string
+}
+
+func (notifier *recordingNotifier) Error(message string) {
+	notifier.errors = append(notifier.errors, message)
+}
+
+func (notifier *recordingNotifier) Warning(message string) {
+	notifier.warnings = append(notifier.warnings, message)
+}
+
+func (notifier *recordingNotifier) Info(message string) {}
+
+func (notifier *recordingNotifier) Message(message string) {
+	notifier.messages = append(notifier.messages, message)
+}
+
+func (notifier *recordingNotifier) Progress(message string, value int) {
+	notifier.progress = append(notifier.progress, message)
+}
+
+func (notifier *recordingNotifier) ProgressDone() {}
+
+func TestNewServerWithWrongPassphrase(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		http.NotFound(writer, request)
+	}))
+	defer server.Close()
+
+	notifier := &recordingNotifier{}
+
+	err := newServer(constants.MatriculationExamBoxType, server.URL+"/image.zip", server.URL+"/version.txt", notifier)
+	if err == nil {
+		t.Fatal("newServer should fail when the version URL gives 404")
+	}
+
+	if len(notifier.errors) != 1 || notifier.errors[0] != "Please check the install passphrase" {
+		t.Errorf("Expected a single passphrase error, got %v", notifier.errors)
+	}
+
+	if len(notifier.progress) != 0 {
+		t.Errorf("Progress should not be shown before the version has been checked, got %v", notifier.progress)
+	}
+}
@@ -227,6 +227,12 @@ func GetZipImagePath() string {
 	return filepath.Join(GetKtpDirectory(), "naksu_last_image.zip")
 }
 
+// GetImageCacheDirectory returns the path of the downloaded server image zips
+// kept for reinstalling
+func GetImageCacheDirectory() string {
+	return filepath.Join(GetKtpDirectory(), "naksu_image_cache")
+}
+
 func GetVDIImagePath() string {
 	return filepath.Join(GetKtpDirectory(), "naksu_ktp_disk.vdi")
 }
@@ -259,10 +265,8 @@ func SetMainWindow(win *ui.Window) {
 	mainWindow = win
 }
 
-// ShowTranslatedErrorMessage translates given error message and shows it with ShowErrorMessage()
-func ShowTranslatedErrorMessage(str string, vars ...interface{}) {
-	message := xlate.Get(str, vars...)
-
+// ShowErrorMessage shows an error message popup to the user
+func ShowErrorMessage(message string) {
 	log.Error(message)
 
 	// Show libui box if main window has been set with Set_main_window
@@ -273,6 +277,11 @@ func ShowTranslatedErrorMessage(str string, vars ...interface{}) {
 	}
 }
 
+// ShowTranslatedErrorMessage translates given error message and shows it with ShowErrorMessage()
+func ShowTranslatedErrorMessage(str string, vars ...interface{}) {
+	ShowErrorMessage(xlate.Get(str, vars...))
+}
+
 // ShowTranslatedErrorMessageAndPassError can be used to show a general error popup
 // and return the given error upstream:
 // return mebroutines.ShowTranslatedErrorMessageAndPassError("General error: %v", errors.New("Shit happened"))
@@ -282,10 +291,8 @@ func ShowTranslatedErrorMessageAndPassError(str string, err error) error {
 	return err
 }
 
-// ShowTranslatedWarningMessage translates given warning message and shows it with ShowWarningMessage()
-func ShowTranslatedWarningMessage(str string, vars ...interface{}) {
-	message := xlate.Get(str, vars...)
-
+// ShowWarningMessage shows a warning message popup to the user
+func ShowWarningMessage(message string) {
 	log.Warning(message)
 
 	// Show libui box if main window has been set with Set_main_window
@@ -296,10 +303,13 @@ func ShowTranslatedWarningMessage(str string, vars ...interface{}) {
 	}
 }
 
-// ShowTranslatedInfoMessage translates given info message and shows it with ShowInfoMessage()
-func ShowTranslatedInfoMessage(str string, vars ...interface{}) {
-	message := xlate.Get(str, vars...)
+// ShowTranslatedWarningMessage translates given warning message and shows it with ShowWarningMessage()
+func ShowTranslatedWarningMessage(str string, vars ...interface{}) {
+	ShowWarningMessage(xlate.Get(str, vars...))
+}
 
+// ShowInfoMessage shows an info message popup to the user
+func ShowInfoMessage(message string) {
 	log.Info(message)
 
 	// Show libui box if main window has been set with Set_main_window
@@ -309,3 +319,8 @@ func ShowTranslatedInfoMessage(str string, vars ...interface{}) {
 		})
 	}
 }
+
+// ShowTranslatedInfoMessage translates given info message and shows it with ShowInfoMessage()
+func ShowTranslatedInfoMessage(str string, vars ...interface{}) {
+	ShowInfoMessage(xlate.Get(str, vars...))
+}
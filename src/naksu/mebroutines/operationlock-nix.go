@@ -0,0 +1,29 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package mebroutines
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func tryLockFile(file *os.File) error {
+	err := unix.Flock(int(file.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if errors.Is(err, unix.EWOULDBLOCK) {
+		return ErrOperationRunning
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not lock %s: %w", file.Name(), err)
+	}
+
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return unix.Flock(int(file.Fd()), unix.LOCK_UN)
+}
@@ -0,0 +1,32 @@
+//go:build windows
+// +build windows
+
+package mebroutines
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+func tryLockFile(file *os.File) error {
+	overlapped := windows.Overlapped{} // nolint: exhaustruct
+	err := windows.LockFileEx(windows.Handle(file.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, &overlapped)
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return ErrOperationRunning
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not lock %s: %w", file.Name(), err)
+	}
+
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	overlapped := windows.Overlapped{} // nolint: exhaustruct
+
+	return windows.UnlockFileEx(windows.Handle(file.Fd()), 0, 1, 0, &overlapped)
+}
@@ -0,0 +1,60 @@
+package mebroutines
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"naksu/constants"
+	"naksu/log"
+)
+
+// ErrOperationRunning is returned by TryLockOperation if another naksu
+// process is running a server operation
+var ErrOperationRunning = errors.New("another naksu process is running a server operation")
+
+// GetOperationLockPath returns the path of the lock file held while a server
+// operation is running
+func GetOperationLockPath() string {
+	return filepath.Join(GetKtpDirectory(), "naksu.lock")
+}
+
+// TryLockOperation locks the server operations of all naksu processes (e.g.
+// naksu serve and a headless command) without waiting. The lock is released
+// by calling the returned function or when the process exits.
+func TryLockOperation() (func(), error) {
+	err := os.MkdirAll(GetKtpDirectory(), constants.FilePermissionsOwnerRWX)
+	if err != nil {
+		return nil, fmt.Errorf("could not create directory for the lock file: %w", err)
+	}
+
+	lockPath := GetOperationLockPath()
+
+	lockFile, err := os.OpenFile(filepath.Clean(lockPath), os.O_CREATE|os.O_RDWR, constants.FilePermissionsOwnerRW)
+	if err != nil {
+		return nil, fmt.Errorf("could not open lock file %s: %w", lockPath, err)
+	}
+
+	err = tryLockFile(lockFile)
+	if err != nil {
+		closeErr := lockFile.Close()
+		if closeErr != nil {
+			log.Debug("Could not close lock file %s: %v", lockPath, closeErr)
+		}
+
+		return nil, err
+	}
+
+	return func() {
+		err := unlockFile(lockFile)
+		if err != nil {
+			log.Debug("Could not unlock lock file %s: %v", lockPath, err)
+		}
+
+		err = lockFile.Close()
+		if err != nil {
+			log.Debug("Could not close lock file %s: %v", lockPath, err)
+		}
+	}, nil
+}
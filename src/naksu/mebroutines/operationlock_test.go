@@ -0,0 +1,41 @@
+package mebroutines
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTryLockFile(t *testing.T) {
+	lockPath := filepath.Join(t.TempDir(), "naksu.lock")
+
+	openLockFile := func() *os.File {
+		file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0o600)
+		if err != nil {
+			t.Fatalf("Could not open lock file: %v", err)
+		}
+		t.Cleanup(func() { _ = file.Close() })
+
+		return file
+	}
+
+	first := openLockFile()
+	second := openLockFile()
+
+	if err := tryLockFile(first); err != nil {
+		t.Fatalf("Could not take a free lock: %v", err)
+	}
+
+	if err := tryLockFile(second); !errors.Is(err, ErrOperationRunning) {
+		t.Errorf("Taking a held lock returned %v, expected ErrOperationRunning", err)
+	}
+
+	if err := unlockFile(first); err != nil {
+		t.Fatalf("Could not release the lock: %v", err)
+	}
+
+	if err := tryLockFile(second); err != nil {
+		t.Errorf("Could not take a released lock: %v", err)
+	}
+}
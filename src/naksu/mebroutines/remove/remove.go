@@ -4,23 +4,26 @@ import (
 	"errors"
 
 	"naksu/box"
+	"naksu/constants"
 	"naksu/log"
 	"naksu/mebroutines"
-	"naksu/ui/progress"
+	"naksu/notifier"
 	"naksu/xlate"
 )
 
 var generalErrorString = xlate.GetRaw("Error while removing server: %v")
 
-// Server removes all directories related to VirtualBox
-func Server() error {
+// Server removes the selected server. If no other server is installed, all
+// directories related to VirtualBox are removed as well when the server was
+// run with VirtualBox.
+func Server(n notifier.Notifier) error {
 	isRunning, err := box.Running()
 
 	switch {
 	case err != nil:
-		mebroutines.ShowTranslatedWarningMessage("We could not detect whether existing VM is running: %v, but continued removing the server as you requested.", err)
+		n.Warning(xlate.Get("We could not detect whether existing VM is running: %v, but continued removing the server as you requested.", err))
 	case isRunning:
-		mebroutines.ShowTranslatedWarningMessage("The server appears to be running but we remove it as you requested.")
+		n.Warning(xlate.Get("The server appears to be running but we remove it as you requested."))
 	}
 
 	// Remove current box to syncronise running VirtualBox GUI
@@ -29,20 +32,36 @@ func Server() error {
 		log.Debug("Got error when removed current box before removing server: %v", err)
 	}
 
+	box.ResetCache()
+
+	otherServers, err := box.GetInstalledServers()
+	switch {
+	case err != nil:
+		log.Warning("Could not detect whether other servers are installed, removing VirtualBox directories: %v", err)
+	case len(otherServers) > 0:
+		log.Debug("Keeping VirtualBox directories for the other installed servers: %v", otherServers)
+
+		return nil
+	case box.GetHypervisorName() != constants.HypervisorVirtualBox:
+		log.Debug("Keeping VirtualBox directories as the server was run with %s", box.GetHypervisorName())
+
+		return nil
+	}
+
 	// Chdir to home directory to avoid problems with Windows where deleting
 	// a directory where the process is running
-	progress.TranslateAndSetMessage("Chdir ~")
+	n.Message(xlate.Get("Chdir ~"))
 	if !mebroutines.ChdirHomeDirectory() {
-		return mebroutines.ShowTranslatedErrorMessageAndPassError(generalErrorString, errors.New("could not chdir to home directory"))
+		return notifier.ShowTranslatedErrorAndPassError(n, generalErrorString, errors.New("could not chdir to home directory"))
 	}
 
-	progress.TranslateAndSetMessage("Deleting ~/.VirtualBox")
+	n.Message(xlate.Get("Deleting ~/.VirtualBox"))
 	mebroutines.RemoveDirAndLogErrors(mebroutines.GetVirtualBoxHiddenDirectory())
 
-	progress.TranslateAndSetMessage("Deleting ~/VirtualBox VMs")
+	n.Message(xlate.Get("Deleting ~/VirtualBox VMs"))
 	err = mebroutines.RemoveDir(mebroutines.GetVirtualBoxVMsDirectory())
 	if err != nil {
-		mebroutines.ShowTranslatedWarningMessage("Failed to remove directory %s: %v", mebroutines.GetVirtualBoxVMsDirectory(), err)
+		n.Warning(xlate.Get("Failed to remove directory %s: %v", mebroutines.GetVirtualBoxVMsDirectory(), err))
 
 		return err
 	}
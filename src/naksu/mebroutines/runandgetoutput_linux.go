@@ -1,6 +1,7 @@
 package mebroutines
 
 import (
+	"context"
 	"os/exec"
 	"strings"
 
@@ -9,12 +10,19 @@ import (
 
 // RunAndGetOutput runs command with arguments and returns output as a string
 func RunAndGetOutput(commandArgs []string, logAction bool) (string, error) {
+	return RunAndGetOutputContext(context.Background(), commandArgs, logAction)
+}
+
+// RunAndGetOutputContext runs command with arguments and returns output as a
+// string. The command is killed if the context is done before the command
+// exits.
+func RunAndGetOutputContext(ctx context.Context, commandArgs []string, logAction bool) (string, error) {
 	if logAction {
 		log.Debug("RunAndGetOutput: %s", strings.Join(commandArgs, " "))
 	}
 
 	/* #nosec */
-	cmd := exec.Command(commandArgs[0], commandArgs[1:]...)
+	cmd := exec.CommandContext(ctx, commandArgs[0], commandArgs[1:]...)
 
 	out, err := cmd.CombinedOutput()
 
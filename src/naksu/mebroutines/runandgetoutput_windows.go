@@ -1,11 +1,15 @@
 package mebroutines
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"naksu/log"
 )
@@ -20,8 +24,39 @@ func quoteWindowsCommandArgs(args []string) []string {
 	return escapedArgs
 }
 
+const killedCommandWaitDelay = 5 * time.Second
+
+// killProcessTree kills the process and its child processes. Killing only
+// cmd.exe would leave the actual command (e.g. VBoxManage) running.
+func killProcessTree(process *os.Process) error {
+	windowsRoot := os.Getenv("SystemRoot")
+	if windowsRoot == "" {
+		windowsRoot = "C:\\Windows"
+	}
+
+	/* #nosec */
+	cmd := exec.Command(filepath.Join(windowsRoot, "System32", "taskkill.exe"), "/T", "/F", "/PID", strconv.Itoa(process.Pid))
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true} // nolint: exhaustruct
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		log.Warning("Could not kill process tree of %d, killing the process: %v: %s", process.Pid, err, strings.TrimSpace(string(out)))
+
+		return process.Kill()
+	}
+
+	return nil
+}
+
 // RunAndGetOutput runs command with arguments and returns output as a string
 func RunAndGetOutput(origCommandArgs []string, logAction bool) (string, error) {
+	return RunAndGetOutputContext(context.Background(), origCommandArgs, logAction)
+}
+
+// RunAndGetOutputContext runs command with arguments and returns output as a
+// string. The command is killed with its child processes if the context is
+// done before the command exits.
+func RunAndGetOutputContext(ctx context.Context, origCommandArgs []string, logAction bool) (string, error) {
 	windowsComSpec := os.Getenv("ComSpec")
 	if windowsComSpec == "" {
 		windowsComSpec = "C:\\Windows\\system32\\cmd.exe"
@@ -35,11 +70,18 @@ func RunAndGetOutput(origCommandArgs []string, logAction bool) (string, error) {
 		log.Debug("RunAndGetOutput: %s", strings.Join(escapedCommandArgs, " "))
 	}
 
-	cmd := exec.Command(windowsComSpec)
+	cmd := exec.CommandContext(ctx, windowsComSpec)
 	cmd.SysProcAttr = &syscall.SysProcAttr{ // nolint: exhaustruct
 		CmdLine:    strings.Join(escapedCommandArgs, " "),
 		HideWindow: true,
 	}
+	// cmd.exe runs the actual command, so kill the whole process tree
+	cmd.Cancel = func() error {
+		return killProcessTree(cmd.Process)
+	}
+	// If the actual command survives, it keeps our output pipes open, so do
+	// not wait for them after the context is done
+	cmd.WaitDelay = killedCommandWaitDelay
 
 	out, err := cmd.CombinedOutput()
 
@@ -0,0 +1,111 @@
+package snapshot
+
+// snapshot takes, restores and deletes the named snapshots of the exam
+// server. A teacher can recover from a botched exam setup by restoring a
+// snapshot instead of removing all exams.
+
+import (
+	"errors"
+	"fmt"
+
+	"naksu/box"
+	"naksu/log"
+	"naksu/notifier"
+	"naksu/xlate"
+)
+
+var takeErrorString = xlate.GetRaw("Failed to take snapshot: %v")
+var restoreErrorString = xlate.GetRaw("Failed to restore snapshot: %v")
+var deleteErrorString = xlate.GetRaw("Failed to delete snapshot: %v")
+
+// ensureInstalled returns an error if there is no server. If mustBeStopped
+// is true, the server must not be running either.
+func ensureInstalled(mustBeStopped bool) error {
+	isInstalled, err := box.Installed()
+	if err != nil {
+		return errors.New("could not detect whether there is an existing vm installed")
+	}
+
+	if !isInstalled {
+		return errors.New("there is no vm installed")
+	}
+
+	if !mustBeStopped {
+		return nil
+	}
+
+	isRunning, err := box.Running()
+	if err != nil {
+		return errors.New("could not detect whether there is existing vm running")
+	}
+
+	if isRunning {
+		return errors.New("the vm is running, please stop it first")
+	}
+
+	return nil
+}
+
+// Take takes a named snapshot of the server. The server may be running.
+func Take(name string, description string, n notifier.Notifier) error {
+	err := ensureInstalled(false)
+	if err != nil {
+		return notifier.ShowTranslatedErrorAndPassError(n, takeErrorString, err)
+	}
+
+	n.Message(xlate.Get("Taking snapshot %s. This takes a while.", name))
+
+	err = box.TakeSnapshot(name, description)
+	if err != nil {
+		log.Debug("Could not take snapshot %s: %v", name, err)
+
+		return notifier.ShowTranslatedErrorAndPassError(n, takeErrorString, err)
+	}
+
+	n.Message("")
+
+	return nil
+}
+
+// Restore returns the server to the state of the given snapshot. The changes
+// made after the current snapshot are lost.
+func Restore(name string, n notifier.Notifier) error {
+	err := ensureInstalled(true)
+	if err != nil {
+		return notifier.ShowTranslatedErrorAndPassError(n, restoreErrorString, err)
+	}
+
+	n.Message(xlate.Get("Restoring snapshot %s. This takes a while.", name))
+
+	err = box.RestoreNamedSnapshot(name)
+	if err != nil {
+		log.Debug("Could not restore snapshot %s: %v", name, err)
+
+		return notifier.ShowTranslatedErrorAndPassError(n, restoreErrorString, fmt.Errorf("could not restore snapshot: %w", err))
+	}
+
+	n.Message("")
+
+	return nil
+}
+
+// Delete deletes the given snapshot
+func Delete(name string, n notifier.Notifier) error {
+	err := ensureInstalled(false)
+	if err != nil {
+		return notifier.ShowTranslatedErrorAndPassError(n, deleteErrorString, err)
+	}
+
+	n.Message(xlate.Get("Deleting snapshot %s. This takes a while.", name))
+
+	err = box.DeleteSnapshot(name)
+	if err != nil {
+		log.Debug("Could not delete snapshot %s: %v", name, err)
+
+		return notifier.ShowTranslatedErrorAndPassError(n, deleteErrorString, fmt.Errorf("could not delete snapshot: %w", err))
+	}
+
+	n.Message("")
+
+	return nil
+}
@@ -6,46 +6,86 @@ import (
 
 	"naksu/box"
 	"naksu/box/vboxmanage"
-	"naksu/mebroutines"
+	"naksu/log"
+	"naksu/notifier"
 	"naksu/xlate"
 )
 
 var generalErrorString = xlate.GetRaw("Failed to start server: %v")
 
 // Server starts the exam server
-func Server() error {
+func Server(n notifier.Notifier) error {
 	vboxmanage.CleanUpTrashVMDirectories()
 
 	isInstalled, err := box.Installed()
 	if err != nil {
-		mebroutines.ShowTranslatedErrorMessage("Could not start server as we could not detect whether existing VM is installed: %v", err)
+		n.Error(xlate.Get("Could not start server as we could not detect whether existing VM is installed: %v", err))
 
 		return fmt.Errorf("could not detect whether an existing vm is installed: %w", err)
 	}
 
 	if !isInstalled {
-		mebroutines.ShowTranslatedErrorMessage("No server has been installed.")
+		n.Error(xlate.Get("No server has been installed."))
 
 		return errors.New("no server has been installed")
 	}
 
 	isRunning, err := box.Running()
 	if err != nil {
-		mebroutines.ShowTranslatedErrorMessage("Could not start server as we could not detect whether existing VM is running: %v", err)
+		n.Error(xlate.Get("Could not start server as we could not detect whether existing VM is running: %v", err))
 
 		return fmt.Errorf("could not detect whether the server is running: %w", err)
 	}
 
 	if isRunning {
-		mebroutines.ShowTranslatedErrorMessage("The server is already running.")
+		n.Error(xlate.Get("The server is already running."))
 
 		return errors.New("the server is already running")
 	}
 
-	err = box.StartCurrentBox()
+	otherServer, err := box.GetOtherRunningServer()
 	if err != nil {
-		return mebroutines.ShowTranslatedErrorMessageAndPassError(generalErrorString, err)
+		log.Warning("Could not detect whether the other servers are running: %v", err)
+	}
+
+	if otherServer != "" {
+		n.Error(xlate.Get("The other server (%s) is running. Stop it before starting this server.", otherServer))
+
+		return fmt.Errorf("server %s is already running", otherServer)
+	}
+
+	state, err := box.GetState()
+	if err != nil {
+		log.Warning("Could not get the state of the server: %v", err)
+	}
+
+	if box.IsResumable(state) {
+		log.Debug("Resuming the server from state '%s'", state)
+		err = box.ResumeCurrentBox()
+	} else {
+		err = box.StartCurrentBox()
+	}
+
+	if errors.Is(err, vboxmanage.ErrSessionLocked) && isStartedByOtherProgram() {
+		n.Error(xlate.Get("The server is already running."))
+
+		return fmt.Errorf("the server was started by another program: %w", err)
+	} else if err != nil {
+		return notifier.ShowTranslatedErrorAndPassError(n, generalErrorString, err)
 	}
 
 	return nil
 }
+
+// isStartedByOtherProgram returns true if the server is running after the
+// start failed because another program held the VM session
+func isStartedByOtherProgram() bool {
+	box.ResetCache()
+
+	isRunning, err := box.Running()
+	if err != nil {
+		log.Warning("Could not detect whether the server is running: %v", err)
+	}
+
+	return isRunning
+}
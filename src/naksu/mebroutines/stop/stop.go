@@ -0,0 +1,115 @@
+package stop
+
+import (
+	"errors"
+	"time"
+
+	"naksu/box"
+	"naksu/log"
+	"naksu/notifier"
+	"naksu/xlate"
+)
+
+var (
+	shutdownErrorString  = xlate.GetRaw("Failed to shut down server: %v")
+	saveStateErrorString = xlate.GetRaw("Failed to save server state: %v")
+	powerOffErrorString  = xlate.GetRaw("Failed to power off server: %v")
+)
+
+// ensureInstalled returns an error if there is no server installed
+func ensureInstalled() error {
+	isInstalled, err := box.Installed()
+	if err != nil {
+		return errors.New("could not detect whether there is an existing vm installed")
+	}
+
+	if !isInstalled {
+		return errors.New("there is no vm installed")
+	}
+
+	return nil
+}
+
+// stateListener shows the state of the server while it is stopping. The
+// message function returns the translated message of the state.
+func stateListener(n notifier.Notifier, message func(state string) string) box.StateListener {
+	return func(state string) {
+		n.Message(message(state))
+	}
+}
+
+// Shutdown shuts down the server cleanly by pressing its power button. If the
+// server has not shut down after the timeout it is powered off.
+func Shutdown(timeout time.Duration, n notifier.Notifier) error {
+	err := ensureInstalled()
+	if err != nil {
+		return notifier.ShowTranslatedErrorAndPassError(n, shutdownErrorString, err)
+	}
+
+	n.Message(xlate.Get("Shutting down server. This takes a while."))
+
+	poweredOff, err := box.ShutdownCurrentBox(timeout, stateListener(n, func(state string) string {
+		return xlate.Get("Shutting down server (%s)", state)
+	}))
+	if err != nil {
+		log.Debug("Could not shut down server: %v", err)
+
+		return notifier.ShowTranslatedErrorAndPassError(n, shutdownErrorString, err)
+	}
+
+	if poweredOff {
+		n.Warning(xlate.Get("The server did not shut down in %v and it was powered off.", timeout))
+	}
+
+	n.Message("")
+
+	return nil
+}
+
+// SaveState saves the state of the server and stops it. The server continues
+// from the saved state when it is started again.
+func SaveState(n notifier.Notifier) error {
+	err := ensureInstalled()
+	if err != nil {
+		return notifier.ShowTranslatedErrorAndPassError(n, saveStateErrorString, err)
+	}
+
+	n.Message(xlate.Get("Saving server state. This takes a while."))
+
+	err = box.SaveStateCurrentBox(stateListener(n, func(state string) string {
+		return xlate.Get("Saving server state (%s)", state)
+	}))
+	if err != nil {
+		log.Debug("Could not save server state: %v", err)
+
+		return notifier.ShowTranslatedErrorAndPassError(n, saveStateErrorString, err)
+	}
+
+	n.Message("")
+
+	return nil
+}
+
+// PowerOff powers off the server without shutting it down. The exams in
+// progress may be lost.
+func PowerOff(n notifier.Notifier) error {
+	err := ensureInstalled()
+	if err != nil {
+		return notifier.ShowTranslatedErrorAndPassError(n, powerOffErrorString, err)
+	}
+
+	n.Message(xlate.Get("Powering off server."))
+
+	err = box.PowerOffCurrentBox(stateListener(n, func(state string) string {
+		return xlate.Get("Powering off server (%s)", state)
+	}))
+	if err != nil {
+		log.Debug("Could not power off server: %v", err)
+
+		return notifier.ShowTranslatedErrorAndPassError(n, powerOffErrorString, err)
+	}
+
+	n.Message("")
+
+	return nil
+}
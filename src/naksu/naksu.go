@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 
+	"naksu/box"
 	"naksu/config"
 	"naksu/host"
 	"naksu/log"
@@ -24,6 +25,21 @@ type Options struct {
 	IsDebug    bool   `short:"D" long:"debug" description:"Turn debugging on" optional:"true"`
 	Version    bool   `short:"v" long:"version" description:"Print naksu version" optional:"true"`
 	SelfUpdate string `long:"self-update" choice:"enabled" choice:"disabled" description:"Control self-update behaviour. Naksu will always warn if your version is out-of-date. This flag will store the setting to ini-file." optional:"true"`
+	Server     string `long:"server" choice:"abitti" choice:"exam" description:"Server to operate on when both an Abitti and a matriculation exam server are installed. The GUI and the install commands store the selected server to ini-file."`
+	Progress   string `long:"progress" choice:"auto" choice:"bar" choice:"lines" choice:"json" default:"auto" description:"How the headless commands report the progress of long-running operations. auto draws a progress bar on a terminal and prints lines otherwise."`
+
+	// Headless commands, see cli.go. Without a command naksu starts the GUI.
+	Install   installCommand   `command:"install" description:"Download and install a new server"`
+	Start     startCommand     `command:"start" description:"Start or resume the installed server"`
+	Stop      stopCommand      `command:"stop" description:"Shut down, save the state of or power off the server"`
+	Backup    backupCommand    `command:"backup" description:"Make a backup of the installed server"`
+	Destroy   destroyCommand   `command:"destroy" description:"Remove exams by restoring the server to its initial state"`
+	Snapshot  snapshotCommand  `command:"snapshot" description:"Take, list, restore and delete named snapshots of the server"`
+	Remove    removeCommand    `command:"remove" description:"Remove the server and all VirtualBox data"`
+	Status    statusCommand    `command:"status" description:"Print the status of the server"`
+	Serve     serveCommand     `command:"serve" description:"Serve the local control API for scripts and management agents"`
+	Provision provisionCommand `command:"provision" description:"Apply a provisioning file and install a new server"`
+	Doctor    doctorCommand    `command:"doctor" description:"Check the computer and the network for problems"`
 }
 
 var options Options
@@ -60,22 +76,9 @@ func logHardwareDetails() {
 	log.Debug("---Hardware data dump (start)\n%s\n---Hardware data dump (end)", host.GetHwLog())
 }
 
-func main() {
-	// Load configuration if it exists
-	config.Load()
-
-	// Set default UI language
-	xlate.SetLanguage(config.GetLanguage())
-
-	var parser = flags.NewParser(&options, flags.Default)
-	_, parseErr := parser.Parse()
-
-	if flags.WroteHelp(parseErr) {
-		os.Exit(0)
-	} else if parseErr != nil {
-		panic(parseErr)
-	}
-
+// handleGlobalOptions processes the options common to the GUI and the headless
+// commands and sets up logging
+func handleGlobalOptions(parser *flags.Parser) {
 	handleOptionalArgument("debug", parser, func(opt *flags.Option) {
 		isDebug = true
 	})
@@ -94,6 +97,16 @@ func main() {
 		}
 	})
 
+	handleOptionalArgument("progress", parser, func(opt *flags.Option) {
+		terminalNotifier = newTerminalNotifier(options.Progress)
+	})
+
+	handleOptionalArgument("server", parser, func(opt *flags.Option) {
+		if err := box.SelectServer(options.Server); err != nil {
+			log.Error("Could not select server: %v", err)
+		}
+	})
+
 	log.SetDebug(isDebug)
 
 	// Determine/set path for debug log
@@ -102,6 +115,66 @@ func main() {
 	log.Action("This is Naksu %s. Hello world!", thisNaksuVersion)
 
 	logDirectoryPaths()
+}
+
+func main() {
+	// Load configuration if it exists
+	config.Load()
+
+	// Set default UI language
+	xlate.SetLanguage(config.GetLanguage())
+
+	// Select the server stored by the previous run. The --server option
+	// overrides this.
+	if err := box.SelectServer(config.GetServer()); err != nil {
+		log.Error("Could not select server: %v", err)
+	}
+
+	var parser = flags.NewParser(&options, flags.Default)
+	parser.SubcommandsOptional = true
+
+	// The handler is called after the options have been parsed, both with and
+	// without a command
+	parser.CommandHandler = func(command flags.Commander, args []string) error {
+		// Keep the standard output of the headless commands for their own output
+		if command != nil {
+			log.SetConsoleWriter(os.Stderr)
+		}
+
+		handleGlobalOptions(parser)
+
+		if command == nil {
+			return nil
+		}
+
+		// The server operations of a headless command and naksu serve must
+		// not run at the same time
+		if isServerOperation(command) {
+			unlock, err := mebroutines.TryLockOperation()
+			if err != nil {
+				return err
+			}
+			defer unlock()
+		}
+
+		return command.Execute(args)
+	}
+
+	_, parseErr := parser.Parse()
+
+	switch {
+	case flags.WroteHelp(parseErr):
+		os.Exit(0)
+	case parseErr != nil && parser.Active != nil:
+		// The error has already been printed by the parser
+		os.Exit(1)
+	case parseErr != nil:
+		panic(parseErr)
+	case parser.Active != nil:
+		log.Action("Exiting after command '%s'", parser.Active.Name)
+
+		return
+	}
 
 	logHardwareDetails()
 
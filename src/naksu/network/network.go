@@ -2,8 +2,11 @@ package network
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"regexp"
+	"strings"
 	"time"
 
 	"naksu/constants"
@@ -93,6 +96,59 @@ func isIgnoredExtInterface(interfaceName string, ignoredExtNics []string) bool {
 	return false
 }
 
+// ExtInterfaceRuleFirstWired and ExtInterfaceRuleFirstWireless are the
+// special rules accepted by MatchExtInterface
+const (
+	ExtInterfaceRuleFirstWired    = "first-wired"
+	ExtInterfaceRuleFirstWireless = "first-wireless"
+	extInterfaceRuleRegexpPrefix  = "regexp:"
+)
+
+// ErrNoMatchingExtInterface is returned by MatchExtInterface if no network
+// device matches the rule
+var ErrNoMatchingExtInterface = errors.New("no matching network device")
+
+// MatchExtInterface returns the name of the first external network device
+// matching the given rule. The rule is "first-wired", "first-wireless", a
+// regular expression prefixed with "regexp:" or the name of the device.
+func MatchExtInterface(rule string) (string, error) {
+	names := []string{}
+	for _, extInterface := range GetExtInterfaces() {
+		if extInterface.ConfigValue != "" {
+			names = append(names, extInterface.ConfigValue)
+		}
+	}
+
+	return matchExtInterface(rule, names, isWirelessExtInterface)
+}
+
+func matchExtInterface(rule string, names []string, isWireless func(string) bool) (string, error) {
+	var matches func(name string) bool
+
+	switch {
+	case rule == ExtInterfaceRuleFirstWired:
+		matches = func(name string) bool { return !isWireless(name) }
+	case rule == ExtInterfaceRuleFirstWireless:
+		matches = isWireless
+	case strings.HasPrefix(rule, extInterfaceRuleRegexpPrefix):
+		re, err := regexp.Compile(strings.TrimPrefix(rule, extInterfaceRuleRegexpPrefix))
+		if err != nil {
+			return "", fmt.Errorf("invalid network device rule '%s': %w", rule, err)
+		}
+		matches = re.MatchString
+	default:
+		matches = func(name string) bool { return name == rule }
+	}
+
+	for _, name := range names {
+		if matches(name) {
+			return name, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrNoMatchingExtInterface, rule)
+}
+
 func isIgnoredExtInterfaceLinux(interfaceName string) bool {
 	return isIgnoredExtInterface(interfaceName, []string{
 		"^lo$",
@@ -121,6 +177,25 @@ func isIgnoredExtInterfaceDarwin(interfaceName string) bool {
 	})
 }
 
+// wirelessNicRegexLinux matches the names of the wireless devices on Linux
+const wirelessNicRegexLinux = "^w"
+
+func isWirelessExtInterfaceLinux(interfaceName string) bool {
+	isWireless, err := regexp.MatchString(wirelessNicRegexLinux, interfaceName)
+
+	return err == nil && isWireless
+}
+
+func isWirelessExtInterfaceWindows(interfaceName string) bool {
+	return strings.Contains(interfaceName, "Wireless")
+}
+
+// isWirelessExtInterfaceDarwin always returns false as the wireless devices
+// cannot be told apart by their names on MacOS
+func isWirelessExtInterfaceDarwin(interfaceName string) bool {
+	return false
+}
+
 func bpsToMbps(bps uint64) uint64 {
 	return bps / 1000000 // nolint:gomnd
 }
@@ -7,6 +7,9 @@ import (
 	"naksu/log"
 )
 
+// isWirelessExtInterface is used by MatchExtInterface
+var isWirelessExtInterface = isWirelessExtInterfaceDarwin
+
 // GetExtInterfaces returns map of network interfaces. It returns array of
 // constants.AvailableSelection where the ConfigValue is system's internal value
 // (e.g. "eno1") and Legend human-readable legend (e.g. "Wireless Network 802.11abc")
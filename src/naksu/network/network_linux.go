@@ -23,8 +23,11 @@ import (
 
 type nicType = int
 
+// isWirelessExtInterface is used by MatchExtInterface
+var isWirelessExtInterface = isWirelessExtInterfaceLinux
+
 const (
-	nicRegexWireless = "^w"
+	nicRegexWireless = wirelessNicRegexLinux
 	nicRegexEthernet = "^(en)|(em)|(eth)"
 	nicTypeUnknown   = iota
 	nicTypePCI
@@ -312,14 +315,7 @@ func interfaceNames() []string {
 // interface is not wireless, returns false.
 func UsingWirelessInterface() bool {
 	if selectedInterface := config.GetExtNic(); selectedInterface != "" {
-		isWireless, err := regexp.MatchString(nicRegexWireless, selectedInterface)
-		if err != nil {
-			log.Debug("Could not check if the current interface is wireless")
-
-			return false
-		}
-
-		return isWireless
+		return isWirelessExtInterfaceLinux(selectedInterface)
 	}
 
 	return false
@@ -1,6 +1,7 @@
 package network
 
 import (
+	"errors"
 	"testing"
 )
 
@@ -45,3 +46,45 @@ func testIgnoreExtInterface(t *testing.T, testData IgnoredExtInterfaceTestData,
 		}
 	}
 }
+
+func TestMatchExtInterface(t *testing.T) {
+	names := []string{"wlp2s0", "enp0s31f6", "enx00e04c680001"}
+
+	testCases := []struct {
+		rule         string
+		expectedName string
+	}{
+		{ExtInterfaceRuleFirstWired, "enp0s31f6"},
+		{ExtInterfaceRuleFirstWireless, "wlp2s0"},
+		{"regexp:^enx", "enx00e04c680001"},
+		{"enx00e04c680001", "enx00e04c680001"},
+		{"eth0", ""},
+		{"regexp:^eth", ""},
+	}
+
+	for _, testCase := range testCases {
+		name, err := matchExtInterface(testCase.rule, names, isWirelessExtInterfaceLinux)
+		if name != testCase.expectedName {
+			t.Errorf("matchExtInterface with rule '%s' returned '%s', expected '%s'", testCase.rule, name, testCase.expectedName)
+		}
+
+		if testCase.expectedName == "" && !errors.Is(err, ErrNoMatchingExtInterface) {
+			t.Errorf("matchExtInterface with rule '%s' should return ErrNoMatchingExtInterface, got %v", testCase.rule, err)
+		}
+	}
+
+	_, err := matchExtInterface("regexp:(", names, isWirelessExtInterfaceLinux)
+	if err == nil || errors.Is(err, ErrNoMatchingExtInterface) {
+		t.Errorf("An invalid regular expression should give an error, got %v", err)
+	}
+}
+
+func TestIsWirelessExtInterfaceWindows(t *testing.T) {
+	if !isWirelessExtInterfaceWindows("Intel(R) Wireless-AC 9560 160MHz") {
+		t.Error("Wireless adapter was not detected as wireless")
+	}
+
+	if isWirelessExtInterfaceWindows("Intel(R) Ethernet Connection (4) I219-LM") {
+		t.Error("Ethernet adapter was detected as wireless")
+	}
+}
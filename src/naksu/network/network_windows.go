@@ -3,7 +3,6 @@ package network
 import (
 	"fmt"
 	"math"
-	"regexp"
 
 	humanize "github.com/dustin/go-humanize"
 	"github.com/yusufpapurcu/wmi"
@@ -22,6 +21,9 @@ type Win32_NetworkAdapter struct { //nolint
 	NetEnabled      *bool
 }
 
+// isWirelessExtInterface is used by MatchExtInterface
+var isWirelessExtInterface = isWirelessExtInterfaceWindows
+
 func queryInterfaces(filter string) []Win32_NetworkAdapter {
 	result := make(chan []Win32_NetworkAdapter)
 
@@ -83,14 +85,7 @@ func GetExtInterfaces() []constants.AvailableSelection {
 // interface is not wireless, returns false.
 func UsingWirelessInterface() bool {
 	if selectedInterfaceName := config.GetExtNic(); selectedInterfaceName != "" {
-		nameContainsWireless, err := regexp.MatchString("Wireless", selectedInterfaceName)
-		if err != nil {
-			log.Debug("Could not check if the current interface is wireless")
-
-			return false
-		}
-
-		return nameContainsWireless
+		return isWirelessExtInterfaceWindows(selectedInterfaceName)
 	}
 
 	return false
@@ -0,0 +1,103 @@
+// Package notifier decouples the server operations (install, start, backup etc.)
+// from the user interface showing their messages and progress. The GUI, the
+// command line and the tests each provide their own Notifier.
+package notifier
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"naksu/log"
+	"naksu/reporter"
+	"naksu/xlate"
+)
+
+// Notifier shows messages and progress of the server operations to the user.
+// All messages passed to a Notifier have already been translated.
+type Notifier interface {
+	// Error shows an error message
+	Error(message string)
+	// Warning shows a warning message
+	Warning(message string)
+	// Info shows an info message
+	Info(message string)
+	// Message sets the status message. An empty message clears the status.
+	Message(message string)
+	// Progress and ProgressDone show the progress of long-running operations
+	reporter.Reporter
+}
+
+// guidedError is an error which knows how the user can fix it (e.g. a
+// classified VBoxManage failure)
+type guidedError interface {
+	error
+	TranslatedGuidance() string
+}
+
+// ShowTranslatedErrorAndPassError can be used to show a general error message
+// and return the given error upstream:
+// return notifier.ShowTranslatedErrorAndPassError(n, "General error: %v", errors.New("Shit happened"))
+// If the error knows how the user can fix it, the guidance is shown as well.
+func ShowTranslatedErrorAndPassError(n Notifier, str string, err error) error {
+	message := xlate.Get(str, err)
+
+	var guided guidedError
+	if errors.As(err, &guided) && guided.TranslatedGuidance() != "" {
+		message += "\n\n" + guided.TranslatedGuidance()
+	}
+
+	n.Error(message)
+
+	return err
+}
+
+// Terminal is a Notifier which prints the messages for the command line user
+type Terminal struct {
+	reporter.Reporter
+
+	writer io.Writer
+	mutex  sync.Mutex
+}
+
+// NewTerminal returns a Terminal notifier printing status messages to the given
+// writer and reporting progress with the given reporter. Errors, warnings and
+// infos are printed by the log.
+func NewTerminal(writer io.Writer, progressReporter reporter.Reporter) *Terminal {
+	return &Terminal{Reporter: progressReporter, writer: writer, mutex: sync.Mutex{}}
+}
+
+// Error logs an error message
+func (terminal *Terminal) Error(message string) {
+	log.Error(message)
+}
+
+// Warning logs a warning message
+func (terminal *Terminal) Warning(message string) {
+	log.Warning(message)
+}
+
+// Info logs an info message
+func (terminal *Terminal) Info(message string) {
+	log.Info(message)
+}
+
+// Message prints a non-empty status message
+func (terminal *Terminal) Message(message string) {
+	log.Debug("Progress message: %s", message)
+
+	if message != "" {
+		terminal.println(message)
+	}
+}
+
+func (terminal *Terminal) println(message string) {
+	terminal.mutex.Lock()
+	defer terminal.mutex.Unlock()
+
+	_, err := fmt.Fprintln(terminal.writer, message)
+	if err != nil {
+		log.Debug("Could not print message '%s': %v", message, err)
+	}
+}
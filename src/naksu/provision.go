@@ -0,0 +1,205 @@
+package main
+
+// Unattended provisioning applies a declarative provisioning file end-to-end:
+// the settings are stored to naksu.ini and a new server is installed. This
+// makes it possible to prepare laptops with a single command on the first boot.
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"naksu/config"
+	"naksu/constants"
+	"naksu/log"
+	"naksu/mebroutines/install"
+	"naksu/network"
+	"naksu/xlate"
+
+	"github.com/go-ini/ini"
+)
+
+const (
+	selfUpdateEnabled  = "enabled"
+	selfUpdateDisabled = "disabled"
+)
+
+type provisionCommand struct {
+	Args struct {
+		File string `positional-arg-name:"file" description:"Provisioning file"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// provisioning is the content of a provisioning file:
+//
+//	box_type = exam
+//	passphrase_file = passphrase.txt
+//	nic = virtio
+//	ext_nic = first-wired
+//	self_update = disabled
+//	language = fi
+//
+// ext_nic is a rule for network.MatchExtInterface. Relative passphrase_file
+// paths are relative to the provisioning file.
+type provisioning struct {
+	BoxType        string `ini:"box_type"`
+	PassphraseFile string `ini:"passphrase_file"`
+	Nic            string `ini:"nic"`
+	ExtNic         string `ini:"ext_nic"`
+	SelfUpdate     string `ini:"self_update"`
+	Language       string `ini:"language"`
+}
+
+var provisioningKeys = []string{"box_type", "passphrase_file", "nic", "ext_nic", "self_update", "language"}
+
+func isProvisioningKey(key string) bool {
+	for _, provisioningKey := range provisioningKeys {
+		if key == provisioningKey {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseProvisioning reads and validates the provisioning file. Unknown keys
+// are errors so that typos do not go unnoticed in unattended installs.
+func parseProvisioning(provisioningPath string) (provisioning, error) {
+	var result provisioning
+
+	file, err := ini.Load(provisioningPath)
+	if err != nil {
+		return result, fmt.Errorf("could not read provisioning file %s: %w", provisioningPath, err)
+	}
+
+	section := file.Section(ini.DefaultSection)
+	for _, key := range section.KeyStrings() {
+		if !isProvisioningKey(key) {
+			return result, fmt.Errorf("unknown key '%s' in provisioning file %s", key, provisioningPath)
+		}
+	}
+
+	err = section.MapTo(&result)
+	if err != nil {
+		return result, fmt.Errorf("could not parse provisioning file %s: %w", provisioningPath, err)
+	}
+
+	if result.PassphraseFile != "" && result.PassphraseFile != "-" && !filepath.IsAbs(result.PassphraseFile) {
+		result.PassphraseFile = filepath.Join(filepath.Dir(provisioningPath), result.PassphraseFile)
+	}
+
+	return result, result.validate()
+}
+
+func (plan provisioning) validate() error {
+	switch plan.BoxType {
+	case constants.AbittiBoxType:
+	case constants.MatriculationExamBoxType:
+		if plan.PassphraseFile == "" {
+			return errors.New("passphrase_file is required for an exam server")
+		}
+	default:
+		return fmt.Errorf("box_type must be %s or %s, got '%s'", constants.AbittiBoxType, constants.MatriculationExamBoxType, plan.BoxType)
+	}
+
+	if plan.Nic != "" && constants.GetAvailableSelectionID(plan.Nic, constants.AvailableNics, -1) < 0 {
+		return fmt.Errorf("unknown server networking hardware '%s'", plan.Nic)
+	}
+
+	if plan.ExtNic == "" {
+		return errors.New("ext_nic is required")
+	}
+
+	if plan.SelfUpdate != "" && plan.SelfUpdate != selfUpdateEnabled && plan.SelfUpdate != selfUpdateDisabled {
+		return fmt.Errorf("self_update must be %s or %s, got '%s'", selfUpdateEnabled, selfUpdateDisabled, plan.SelfUpdate)
+	}
+
+	if plan.Language != "" && constants.GetAvailableSelectionID(plan.Language, constants.AvailableLangs, -1) < 0 {
+		return fmt.Errorf("unknown language '%s'", plan.Language)
+	}
+
+	return nil
+}
+
+// resolveExtNic returns the network device matching the ext_nic rule
+func (plan provisioning) resolveExtNic() (string, error) {
+	extNic, err := network.MatchExtInterface(plan.ExtNic)
+	if err != nil {
+		return "", fmt.Errorf("could not find network device for rule '%s': %w", plan.ExtNic, err)
+	}
+
+	if !network.IsExtInterface(extNic) {
+		return "", fmt.Errorf("network device '%s' is not available", extNic)
+	}
+
+	return extNic, nil
+}
+
+// applySettings stores the settings of the provisioning file to naksu.ini
+func (plan provisioning) applySettings(extNic string) {
+	if plan.Language != "" {
+		log.Action("Provisioning language %s", plan.Language)
+		config.SetLanguage(plan.Language)
+		xlate.SetLanguage(plan.Language)
+	}
+
+	if plan.SelfUpdate != "" {
+		log.Action("Provisioning self-update %s", plan.SelfUpdate)
+		config.SetSelfUpdateDisabled(plan.SelfUpdate == selfUpdateDisabled)
+	}
+
+	if plan.Nic != "" {
+		log.Action("Provisioning server networking hardware %s", plan.Nic)
+		config.SetNic(plan.Nic)
+	}
+
+	log.Action("Provisioning network device %s (rule '%s')", extNic, plan.ExtNic)
+	config.SetExtNic(extNic)
+}
+
+func (command *provisionCommand) Execute(args []string) error {
+	log.Action("Starting provisioning from %s", command.Args.File)
+
+	plan, err := parseProvisioning(command.Args.File)
+	if err != nil {
+		return err
+	}
+
+	// Check everything before changing the settings so that a failing
+	// provisioning does not leave half of the settings behind
+	var passphrase string
+	if plan.BoxType == constants.MatriculationExamBoxType {
+		passphrase, err = readPassphrase(plan.PassphraseFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	extNic, err := plan.resolveExtNic()
+	if err != nil {
+		terminalNotifier.Error(xlate.Get("You have selected network device '%s' which is not available.", plan.ExtNic))
+
+		return err
+	}
+
+	if err := ensureHypervisor(terminalNotifier); err != nil {
+		return err
+	}
+
+	plan.applySettings(extNic)
+
+	switch plan.BoxType {
+	case constants.MatriculationExamBoxType:
+		err = install.NewExamServer(passphrase, terminalNotifier)
+	default:
+		err = install.NewAbittiServer(terminalNotifier)
+	}
+
+	if err != nil {
+		return fmt.Errorf("provisioning failed to install a server: %w", err)
+	}
+
+	log.Action("Provisioning from %s finished", command.Args.File)
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeProvisioningFile(t *testing.T, content string) string {
+	t.Helper()
+
+	provisioningPath := filepath.Join(t.TempDir(), "naksu-provision.ini")
+
+	err := os.WriteFile(provisioningPath, []byte(content), 0600)
+	if err != nil {
+		t.Fatalf("Could not write provisioning file: %v", err)
+	}
+
+	return provisioningPath
+}
+
+func TestParseProvisioning(t *testing.T) {
+	provisioningPath := writeProvisioningFile(t, `
+; Exam laptops
+box_type = exam
+passphrase_file = passphrase.txt
+nic = virtio
+ext_nic = first-wired
+self_update = disabled
+language = sv
+`)
+
+	plan, err := parseProvisioning(provisioningPath)
+	if err != nil {
+		t.Fatalf("Parsing a valid provisioning file failed: %v", err)
+	}
+
+	expectedPlan := provisioning{
+		BoxType:        "exam",
+		PassphraseFile: filepath.Join(filepath.Dir(provisioningPath), "passphrase.txt"),
+		Nic:            "virtio",
+		ExtNic:         "first-wired",
+		SelfUpdate:     "disabled",
+		Language:       "sv",
+	}
+
+	if plan != expectedPlan {
+		t.Errorf("Parsed provisioning %+v, expected %+v", plan, expectedPlan)
+	}
+}
+
+func TestParseProvisioningErrors(t *testing.T) {
+	testCases := []struct {
+		description string
+		content     string
+	}{
+		{"unknown key", "box_type = abitti\next_nic = eth0\nextnic = eth0\n"},
+		{"unknown box type", "box_type = foo\next_nic = eth0\n"},
+		{"exam without passphrase", "box_type = exam\next_nic = eth0\n"},
+		{"missing ext nic", "box_type = abitti\n"},
+		{"unknown nic", "box_type = abitti\next_nic = eth0\nnic = foo\n"},
+		{"unknown self-update policy", "box_type = abitti\next_nic = eth0\nself_update = sometimes\n"},
+		{"unknown language", "box_type = abitti\next_nic = eth0\nlanguage = de\n"},
+	}
+
+	for _, testCase := range testCases {
+		_, err := parseProvisioning(writeProvisioningFile(t, testCase.content))
+		if err == nil {
+			t.Errorf("Provisioning file with %s should give an error", testCase.description)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+// Package reporter reports the progress of long-running operations such as
+// downloading the server image or writing a backup. The same operations run
+// in the GUI (progress dialog), in a terminal (progress bar) and under
+// scripts (newline-delimited JSON), each with its own Reporter.
+package reporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"naksu/log"
+)
+
+const (
+	barWidth         = 30
+	progressFinished = 100
+)
+
+// Reporter shows the progress of a long-running operation
+type Reporter interface {
+	// Progress reports the progress (0-100) of a long-running operation
+	Progress(message string, value int)
+	// ProgressDone ends reporting the progress of a long-running operation
+	ProgressDone()
+}
+
+// Func adapts a progress callback function to a Reporter
+type Func func(message string, value int)
+
+// Progress calls the function
+func (function Func) Progress(message string, value int) {
+	function(message, value)
+}
+
+// ProgressDone does nothing
+func (function Func) ProgressDone() {}
+
+// Lines is a Reporter printing each progress update on its own line. It is
+// used when the output is not a terminal.
+type Lines struct {
+	writer io.Writer
+	mutex  sync.Mutex
+
+	lastMessage string
+}
+
+// NewLines returns a new Lines reporter
+func NewLines(writer io.Writer) *Lines {
+	return &Lines{writer: writer, mutex: sync.Mutex{}, lastMessage: ""}
+}
+
+// Progress prints the message and the percentage. An empty message repeats
+// the previous one.
+func (lines *Lines) Progress(message string, value int) {
+	lines.mutex.Lock()
+	defer lines.mutex.Unlock()
+
+	if message == "" {
+		message = lines.lastMessage
+	}
+	lines.lastMessage = message
+
+	write(lines.writer, fmt.Sprintf("%s (%d%%)\n", message, value))
+}
+
+// ProgressDone forgets the last message
+func (lines *Lines) ProgressDone() {
+	lines.mutex.Lock()
+	defer lines.mutex.Unlock()
+
+	lines.lastMessage = ""
+}
+
+// Bar is a Reporter drawing a progress bar on a single terminal line
+type Bar struct {
+	writer io.Writer
+	mutex  sync.Mutex
+
+	lastMessage string
+	active      bool
+}
+
+// NewBar returns a new Bar reporter
+func NewBar(writer io.Writer) *Bar {
+	return &Bar{writer: writer, mutex: sync.Mutex{}, lastMessage: "", active: false}
+}
+
+// Progress redraws the progress bar. An empty message keeps the previous one.
+func (bar *Bar) Progress(message string, value int) {
+	bar.mutex.Lock()
+	defer bar.mutex.Unlock()
+
+	if message == "" {
+		message = bar.lastMessage
+	}
+	bar.lastMessage = message
+	bar.active = true
+
+	write(bar.writer, "\r\033[K"+formatBar(message, value))
+}
+
+// ProgressDone ends the progress bar line
+func (bar *Bar) ProgressDone() {
+	bar.mutex.Lock()
+	defer bar.mutex.Unlock()
+
+	if bar.active {
+		write(bar.writer, "\n")
+	}
+
+	bar.lastMessage = ""
+	bar.active = false
+}
+
+// formatBar returns the progress bar without line control characters, e.g.
+// "[#########.....................]  30% Downloading server image"
+func formatBar(message string, value int) string {
+	switch {
+	case value < 0:
+		value = 0
+	case value > progressFinished:
+		value = progressFinished
+	}
+
+	filled := barWidth * value / progressFinished
+
+	return fmt.Sprintf("[%s%s] %3d%% %s", strings.Repeat("#", filled), strings.Repeat(".", barWidth-filled), value, message)
+}
+
+// Event is a single line in the newline-delimited JSON written by the
+// JSONLines reporter and streamed by the control API
+type Event struct {
+	// Type is one of error, warning, info, message, progress, progressDone and
+	// result. The JSONLines reporter writes only progress and progressDone.
+	Type    string `json:"type"`
+	Message string `json:"message,omitempty"`
+	Value   int    `json:"value"`
+	// OK and Error are set for the final result event
+	OK    bool   `json:"ok,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// JSONLines is a Reporter writing the progress as newline-delimited JSON
+// for scripts
+type JSONLines struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewJSONLines returns a new JSONLines reporter
+func NewJSONLines(writer io.Writer) *JSONLines {
+	return &JSONLines{mutex: sync.Mutex{}, encoder: json.NewEncoder(writer)}
+}
+
+// Progress writes a progress event
+func (jsonLines *JSONLines) Progress(message string, value int) {
+	jsonLines.encode(Event{Type: "progress", Message: message, Value: value, OK: false, Error: ""})
+}
+
+// ProgressDone writes a progressDone event
+func (jsonLines *JSONLines) ProgressDone() {
+	jsonLines.encode(Event{Type: "progressDone", Message: "", Value: progressFinished, OK: false, Error: ""})
+}
+
+func (jsonLines *JSONLines) encode(event Event) {
+	jsonLines.mutex.Lock()
+	defer jsonLines.mutex.Unlock()
+
+	err := jsonLines.encoder.Encode(event)
+	if err != nil {
+		log.Debug("Could not write progress event: %v", err)
+	}
+}
+
+// IsTerminal returns true if the file is a terminal (character device)
+func IsTerminal(file *os.File) bool {
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return false
+	}
+
+	return fileInfo.Mode()&os.ModeCharDevice != 0
+}
+
+// NewForFile returns a Bar reporter if the file is a terminal which can draw
+// the progress bar and a Lines reporter otherwise
+func NewForFile(file *os.File) Reporter {
+	if IsTerminal(file) && enableLineControl(file) {
+		return NewBar(file)
+	}
+
+	return NewLines(file)
+}
+
+func write(writer io.Writer, text string) {
+	_, err := io.WriteString(writer, text)
+	if err != nil {
+		log.Debug("Could not write progress: %v", err)
+	}
+}
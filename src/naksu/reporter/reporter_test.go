@@ -0,0 +1,67 @@
+package reporter
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFormatBar(t *testing.T) {
+	testCases := []struct {
+		message     string
+		value       int
+		expectedBar string
+	}{
+		{"Downloading", 0, "[..............................]   0% Downloading"},
+		{"Downloading", 30, "[#########.....................]  30% Downloading"},
+		{"Downloading", 100, "[##############################] 100% Downloading"},
+		{"Downloading", 150, "[##############################] 100% Downloading"},
+		{"Downloading", -5, "[..............................]   0% Downloading"},
+	}
+
+	for _, testCase := range testCases {
+		if bar := formatBar(testCase.message, testCase.value); bar != testCase.expectedBar {
+			t.Errorf("Progress bar for %d%% was '%s', expected '%s'", testCase.value, bar, testCase.expectedBar)
+		}
+	}
+}
+
+func TestLinesRepeatsMessage(t *testing.T) {
+	var buffer bytes.Buffer
+
+	lines := NewLines(&buffer)
+	lines.Progress("Downloading", 10)
+	lines.Progress("", 20)
+	lines.ProgressDone()
+
+	expected := "Downloading (10%)\nDownloading (20%)\n"
+	if buffer.String() != expected {
+		t.Errorf("Lines reporter wrote '%s', expected '%s'", buffer.String(), expected)
+	}
+}
+
+func TestBarEndsLine(t *testing.T) {
+	var buffer bytes.Buffer
+
+	bar := NewBar(&buffer)
+	bar.ProgressDone()
+	bar.Progress("Writing backup", 50)
+	bar.ProgressDone()
+
+	expected := "\r\033[K[###############...............]  50% Writing backup\n"
+	if buffer.String() != expected {
+		t.Errorf("Bar reporter wrote %q, expected %q", buffer.String(), expected)
+	}
+}
+
+func TestJSONLines(t *testing.T) {
+	var buffer bytes.Buffer
+
+	jsonLines := NewJSONLines(&buffer)
+	jsonLines.Progress("Downloading", 42)
+	jsonLines.ProgressDone()
+
+	expected := `{"type":"progress","message":"Downloading","value":42}` + "\n" + `{"type":"progressDone","value":100}` + "\n"
+	if buffer.String() != expected {
+		t.Errorf("JSON lines reporter wrote '%s', expected '%s'", buffer.String(), expected)
+	}
+}
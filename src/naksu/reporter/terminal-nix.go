@@ -0,0 +1,12 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package reporter
+
+import "os"
+
+// enableLineControl returns true as the terminals understand the ANSI
+// escape sequences drawing the progress bar
+func enableLineControl(file *os.File) bool {
+	return true
+}
@@ -0,0 +1,40 @@
+//go:build windows
+// +build windows
+
+package reporter
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+
+	"naksu/log"
+)
+
+// enableLineControl turns on the processing of the ANSI escape sequences
+// drawing the progress bar. The older Windows consoles do not support it.
+func enableLineControl(file *os.File) bool {
+	handle := windows.Handle(file.Fd())
+
+	var mode uint32
+
+	err := windows.GetConsoleMode(handle, &mode)
+	if err != nil {
+		log.Debug("Could not get the console mode, not drawing a progress bar: %v", err)
+
+		return false
+	}
+
+	if mode&windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING != 0 {
+		return true
+	}
+
+	err = windows.SetConsoleMode(handle, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING)
+	if err != nil {
+		log.Debug("Could not enable the virtual terminal processing, not drawing a progress bar: %v", err)
+
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"naksu/box"
+	"naksu/config"
+	"naksu/constants"
+	"naksu/controlapi"
+	"naksu/log"
+	"naksu/logdelivery"
+	"naksu/mebroutines"
+	"naksu/mebroutines/backup"
+	"naksu/mebroutines/destroy"
+	"naksu/mebroutines/install"
+	"naksu/mebroutines/start"
+	"naksu/mebroutines/stop"
+	"naksu/notifier"
+)
+
+type serveCommand struct {
+	Listen string `long:"listen" description:"Loopback address and port for the control API" default:"127.0.0.1:8766"`
+	Socket string `long:"socket" description:"Listen to this Unix socket instead of the TCP address" optional:"true"`
+}
+
+// withVBoxManage wraps a server operation to fail early if VBoxManage is
+// missing. The operation takes the lock shared with the other naksu
+// processes as the control API busy lock covers only this process.
+func withVBoxManage(operation func(n notifier.Notifier) error) func(n notifier.Notifier) error {
+	return func(n notifier.Notifier) error {
+		if err := ensureHypervisor(n); err != nil {
+			return err
+		}
+
+		unlock, err := mebroutines.TryLockOperation()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		return operation(n)
+	}
+}
+
+// getControlAPIOperations maps the control API operations to the same
+// functions the GUI buttons and the headless commands use
+func getControlAPIOperations() controlapi.Operations {
+	return controlapi.Operations{
+		InstallAbitti: withVBoxManage(install.NewAbittiServer),
+		InstallExam: func(passphrase string, n notifier.Notifier) error {
+			return withVBoxManage(func(n notifier.Notifier) error {
+				return install.NewExamServer(passphrase, n)
+			})(n)
+		},
+		Start: withVBoxManage(start.Server),
+		Shutdown: withVBoxManage(func(n notifier.Notifier) error {
+			return stop.Shutdown(constants.VMShutdownTimeout, n)
+		}),
+		SaveState: withVBoxManage(stop.SaveState),
+		PowerOff:  withVBoxManage(stop.PowerOff),
+		Destroy:   withVBoxManage(destroy.Server),
+		Backup: func(path string, n notifier.Notifier) error {
+			return withVBoxManage(func(n notifier.Notifier) error {
+				return backup.MakeBackup(path, n)
+			})(n)
+		},
+		DeliverLogs: logdelivery.DeliverLogs,
+		Status: func() (interface{}, error) {
+			report := getStatusReport()
+			if len(report.Errors) > 0 {
+				return report, errors.New("could not get complete status")
+			}
+
+			return report, nil
+		},
+	}
+}
+
+// logCrash collects the diagnostics of a crashed server while serving the
+// control API. The management agent decides whether to restart the server.
+func logCrash(crash box.Crash) {
+	bundlePath, err := logdelivery.CollectCrashBundle(crash)
+	if err != nil {
+		log.Error("Could not collect crash diagnostics: %v", err)
+
+		return
+	}
+
+	log.Error("Server %s stopped unexpectedly (%s), diagnostics were saved to %s", crash.VMName, crash.State, bundlePath)
+}
+
+func (command *serveCommand) Execute(args []string) error {
+	token, err := controlapi.LoadOrCreateToken(config.GetControlAPITokenPath())
+	if err != nil {
+		return err
+	}
+
+	listener, err := controlapi.Listen(command.Listen, command.Socket)
+	if err != nil {
+		return err
+	}
+
+	log.Action("Serving control API at %s, token is in %s", listener.Addr(), config.GetControlAPITokenPath())
+
+	box.SetCrashHandler(logCrash)
+	box.StartWatchdog(constants.EnvironmentStatusUpdateDuration)
+
+	err = controlapi.NewServer(token, getControlAPIOperations()).Serve(listener)
+	if err != nil {
+		return fmt.Errorf("control api stopped: %w", err)
+	}
+
+	return nil
+}
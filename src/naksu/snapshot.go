@@ -0,0 +1,161 @@
+package main
+
+// "naksu snapshot" manages the named snapshots of the server, e.g.
+//
+//	naksu snapshot take "before exam 2026-10-18"
+//	naksu snapshot list
+//	naksu snapshot restore "before exam 2026-10-18"
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	humanize "github.com/dustin/go-humanize"
+
+	"naksu/box"
+	"naksu/log"
+	"naksu/mebroutines/snapshot"
+	"naksu/xlate"
+)
+
+const snapshotTimeFormat = "2006-01-02 15:04"
+
+type snapshotCommand struct {
+	List    snapshotListCommand    `command:"list" description:"List the snapshots with their times and sizes"`
+	Take    snapshotTakeCommand    `command:"take" description:"Take a named snapshot of the server"`
+	Restore snapshotRestoreCommand `command:"restore" description:"Restore the server to a snapshot"`
+	Delete  snapshotDeleteCommand  `command:"delete" description:"Delete a snapshot"`
+}
+
+type snapshotListCommand struct {
+	JSON bool `long:"json" description:"Print the snapshots as a JSON document" optional:"true"`
+}
+
+type snapshotNameArgs struct {
+	Name string `positional-arg-name:"name" description:"Name of the snapshot"`
+}
+
+type snapshotTakeCommand struct {
+	Description string           `long:"description" description:"Description of the snapshot"`
+	Args        snapshotNameArgs `positional-args:"yes" required:"yes"`
+}
+
+type snapshotRestoreCommand struct {
+	Args snapshotNameArgs `positional-args:"yes" required:"yes"`
+}
+
+type snapshotDeleteCommand struct {
+	Args snapshotNameArgs `positional-args:"yes" required:"yes"`
+}
+
+// writeSnapshots writes the snapshots as a table. The current snapshot is
+// marked with an asterisk.
+func writeSnapshots(writer io.Writer, snapshots []box.SnapshotInfo) error {
+	tableWriter := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0) // nolint: gomnd
+
+	for _, snapshotInfo := range snapshots {
+		current := " "
+		if snapshotInfo.Current {
+			current = "*"
+		}
+
+		takenAt := "-"
+		if !snapshotInfo.TakenAt.IsZero() {
+			takenAt = snapshotInfo.TakenAt.Local().Format(snapshotTimeFormat)
+		}
+
+		notes := []string{}
+		if snapshotInfo.Protected {
+			notes = append(notes, xlate.Get("used by Remove Exams"))
+		}
+		if snapshotInfo.Description != "" {
+			notes = append(notes, strings.ReplaceAll(snapshotInfo.Description, "\n", " "))
+		}
+
+		fmt.Fprintf(tableWriter, "%s %s\t%s\t%s\t%s\n", current, snapshotInfo.Name, takenAt, humanize.Bytes(snapshotInfo.SizeBytes), strings.Join(notes, ", "))
+	}
+
+	if err := tableWriter.Flush(); err != nil {
+		return fmt.Errorf("could not write snapshots: %w", err)
+	}
+
+	return nil
+}
+
+func (command *snapshotListCommand) Execute(args []string) error {
+	if err := ensureHypervisor(terminalNotifier); err != nil {
+		return err
+	}
+
+	snapshots, err := box.GetSnapshots()
+	if err != nil {
+		return fmt.Errorf("could not list snapshots: %w", err)
+	}
+
+	if command.JSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(snapshots); err != nil {
+			return fmt.Errorf("could not encode snapshots: %w", err)
+		}
+
+		return nil
+	}
+
+	return writeSnapshots(os.Stdout, snapshots)
+}
+
+func (command *snapshotTakeCommand) Execute(args []string) error {
+	log.Action("Taking snapshot '%s' from the command line", command.Args.Name)
+
+	if err := ensureHypervisor(terminalNotifier); err != nil {
+		return err
+	}
+
+	err := snapshot.Take(command.Args.Name, command.Description, terminalNotifier)
+	if err != nil {
+		return fmt.Errorf("failed to take snapshot: %w", err)
+	}
+
+	terminalNotifier.Message(xlate.Get("Snapshot %s was taken.", command.Args.Name))
+
+	return nil
+}
+
+func (command *snapshotRestoreCommand) Execute(args []string) error {
+	log.Action("Restoring snapshot '%s' from the command line", command.Args.Name)
+
+	if err := ensureHypervisor(terminalNotifier); err != nil {
+		return err
+	}
+
+	err := snapshot.Restore(command.Args.Name, terminalNotifier)
+	if err != nil {
+		return fmt.Errorf("failed to restore snapshot: %w", err)
+	}
+
+	terminalNotifier.Message(xlate.Get("Snapshot %s was restored.", command.Args.Name))
+
+	return nil
+}
+
+func (command *snapshotDeleteCommand) Execute(args []string) error {
+	log.Action("Deleting snapshot '%s' from the command line", command.Args.Name)
+
+	if err := ensureHypervisor(terminalNotifier); err != nil {
+		return err
+	}
+
+	err := snapshot.Delete(command.Args.Name, terminalNotifier)
+	if err != nil {
+		return fmt.Errorf("failed to delete snapshot: %w", err)
+	}
+
+	terminalNotifier.Message(xlate.Get("Snapshot %s was deleted.", command.Args.Name))
+
+	return nil
+}
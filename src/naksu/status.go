@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"naksu/box"
+	"naksu/box/download"
+	"naksu/box/vboxmanage"
+	"naksu/config"
+	"naksu/constants"
+	"naksu/host"
+	"naksu/mebroutines"
+	"naksu/network"
+)
+
+// statusReport is printed by "naksu status". Monitoring scripts read the
+// JSON version of it, so do not rename the existing fields.
+type statusReport struct {
+	constants.EnvironmentStatus
+	NaksuVersion           string             `json:"naksuVersion"`
+	Server                 string             `json:"server"`
+	VMName                 string             `json:"vmName"`
+	InstalledServers       []string           `json:"installedServers"`
+	BoxType                string             `json:"boxType"`
+	BoxVersion             string             `json:"boxVersion"`
+	BoxState               string             `json:"boxState"`
+	StartType              string             `json:"startType"`
+	ConsoleURL             string             `json:"consoleUrl"`
+	Hypervisor             string             `json:"hypervisor"`
+	VirtualBoxVersion      string             `json:"virtualBoxVersion"`
+	FreeDisk               map[string]uint64  `json:"freeDisk"`
+	LowDisk                bool               `json:"lowDisk"`
+	Nic                    string             `json:"nic"`
+	ExtNic                 string             `json:"extNic"`
+	AvailableAbittiVersion string             `json:"availableAbittiVersion"`
+	VBoxManage             vboxmanage.Metrics `json:"vboxManage"`
+	Errors                 []string           `json:"errors"`
+}
+
+func (report *statusReport) addError(err error) {
+	report.Errors = append(report.Errors, err.Error())
+}
+
+func getStatusDirectories() []string {
+	return []string{mebroutines.GetKtpDirectory(), mebroutines.GetVirtualBoxHiddenDirectory(), mebroutines.GetVirtualBoxVMsDirectory()}
+}
+
+func collectBoxStatus(report *statusReport) {
+	installedServers, err := box.GetInstalledServers()
+	if err != nil {
+		report.addError(fmt.Errorf("could not list installed servers: %w", err))
+	} else {
+		report.InstalledServers = installedServers
+	}
+
+	boxInstalled, err := box.Installed()
+	if err != nil {
+		report.addError(fmt.Errorf("could not detect whether vm is installed: %w", err))
+
+		return
+	}
+	report.BoxInstalled = boxInstalled
+
+	if !boxInstalled {
+		return
+	}
+
+	boxRunning, err := box.Running()
+	if err != nil {
+		report.addError(fmt.Errorf("could not detect whether vm is running: %w", err))
+	}
+	report.BoxRunning = boxRunning
+
+	boxState, err := box.GetState()
+	if err != nil {
+		report.addError(fmt.Errorf("could not get vm state: %w", err))
+	}
+	report.BoxState = boxState
+
+	if boxRunning {
+		consoleURL, err := box.GetConsoleURL()
+		if err != nil {
+			report.addError(fmt.Errorf("could not get remote console address: %w", err))
+		}
+		report.ConsoleURL = consoleURL
+	}
+
+	report.BoxType = box.GetType()
+	report.BoxVersion = box.GetVersion()
+}
+
+func collectDiskStatus(report *statusReport) {
+	for _, directory := range getStatusDirectories() {
+		if !mebroutines.ExistsDir(directory) {
+			continue
+		}
+
+		freeDisk, err := mebroutines.GetDiskFree(directory)
+		if err != nil {
+			report.addError(fmt.Errorf("could not get free disk for %s: %w", directory, err))
+		} else {
+			report.FreeDisk[directory] = freeDisk
+		}
+	}
+
+	err := host.CheckFreeDisk(constants.LowDiskLimit, getStatusDirectories())
+	var lowDiskSizeError *host.LowDiskSizeError
+	if errors.As(err, &lowDiskSizeError) {
+		report.LowDisk = true
+	} else if err != nil {
+		report.addError(fmt.Errorf("could not check free disk: %w", err))
+	}
+}
+
+// getStatusReport collects the status of the server and the host. Errors are
+// stored to the report instead of stopping the collection.
+func getStatusReport() statusReport {
+	report := statusReport{
+		EnvironmentStatus:      constants.EnvironmentStatus{BoxInstalled: false, BoxRunning: false, NetAvailable: false},
+		NaksuVersion:           thisNaksuVersion,
+		Server:                 box.GetSelectedServer(),
+		VMName:                 box.GetVMName(),
+		InstalledServers:       []string{},
+		BoxType:                "",
+		BoxVersion:             "",
+		BoxState:               "",
+		StartType:              config.GetStartType(),
+		ConsoleURL:             "",
+		Hypervisor:             box.GetHypervisorName(),
+		VirtualBoxVersion:      "",
+		FreeDisk:               map[string]uint64{},
+		LowDisk:                false,
+		Nic:                    config.GetNic(),
+		ExtNic:                 config.GetExtNic(),
+		AvailableAbittiVersion: "",
+		VBoxManage:             vboxmanage.Metrics{}, // nolint: exhaustruct
+		Errors:                 []string{},
+	}
+
+	if vboxmanage.IsInstalled() {
+		vBoxVersion, err := vboxmanage.GetVBoxManageVersion()
+		if err != nil {
+			report.addError(fmt.Errorf("could not get virtualbox version: %w", err))
+		} else {
+			report.VirtualBoxVersion = vBoxVersion.String()
+		}
+	}
+
+	if box.IsHypervisorInstalled() {
+		collectBoxStatus(&report)
+	} else {
+		report.addError(fmt.Errorf("could not execute hypervisor %s", report.Hypervisor))
+	}
+
+	collectDiskStatus(&report)
+
+	report.NetAvailable = network.CheckIfNetworkAvailable()
+	if report.NetAvailable {
+		availableVersion, err := download.GetAvailableVersion(constants.AbittiVersionURL)
+		if err != nil {
+			report.addError(fmt.Errorf("could not get available abitti version: %w", err))
+		} else {
+			report.AvailableAbittiVersion = availableVersion
+		}
+	}
+
+	report.VBoxManage = vboxmanage.GetMetrics()
+
+	return report
+}
+
+func printStatusReport(report statusReport) {
+	fmt.Printf("Naksu version: %s\n", report.NaksuVersion)
+	fmt.Printf("Hypervisor: %s\n", report.Hypervisor)
+	fmt.Printf("VirtualBox version: %s\n", report.VirtualBoxVersion)
+	fmt.Printf("Server: %s (%s)\n", report.Server, report.VMName)
+	fmt.Printf("Installed servers: %s\n", strings.Join(report.InstalledServers, ", "))
+	fmt.Printf("Installed: %t\n", report.BoxInstalled)
+	fmt.Printf("Running: %t (%s)\n", report.BoxRunning, report.BoxState)
+	fmt.Printf("Start type: %s\n", report.StartType)
+	fmt.Printf("Remote console: %s\n", report.ConsoleURL)
+	fmt.Printf("Type: %s\n", report.BoxType)
+	fmt.Printf("Version: %s\n", report.BoxVersion)
+	fmt.Printf("Available Abitti version: %s\n", report.AvailableAbittiVersion)
+	fmt.Printf("Network available: %t\n", report.NetAvailable)
+	fmt.Printf("Server networking hardware: %s\n", report.Nic)
+	fmt.Printf("Network device: %s\n", report.ExtNic)
+
+	for directory, freeDisk := range report.FreeDisk {
+		fmt.Printf("Free disk: %s %d\n", directory, freeDisk)
+	}
+
+	fmt.Printf("Low disk: %t\n", report.LowDisk)
+
+	for _, reportError := range report.Errors {
+		fmt.Printf("Error: %s\n", reportError)
+	}
+}
+
+func (command *statusCommand) Execute(args []string) error {
+	report := getStatusReport()
+
+	if command.JSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return fmt.Errorf("could not encode status: %w", err)
+		}
+	} else {
+		printStatusReport(report)
+	}
+
+	if len(report.Errors) > 0 {
+		return errors.New("could not get complete status")
+	}
+
+	return nil
+}
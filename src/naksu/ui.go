@@ -3,14 +3,14 @@ package main
 import (
 	"fmt"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"naksu/box"
 	"naksu/box/download"
-	"naksu/box/vboxmanage"
 	"naksu/config"
 	"naksu/constants"
-	"naksu/host"
+	"naksu/doctor"
 	"naksu/log"
 	"naksu/logdelivery"
 	"naksu/mebroutines"
@@ -18,7 +18,9 @@ import (
 	"naksu/mebroutines/destroy"
 	"naksu/mebroutines/install"
 	"naksu/mebroutines/remove"
+	"naksu/mebroutines/snapshot"
 	"naksu/mebroutines/start"
+	"naksu/mebroutines/stop"
 	"naksu/network"
 	"naksu/ui/networkstatus"
 	"naksu/ui/progress"
@@ -35,27 +37,41 @@ const mainUIStatusDisabled mainUIStatusType = "disable"
 
 var window *ui.Window
 
+// guiNotifier shows the messages and progress of the server operations
+var guiNotifier *progress.GUINotifier
+
 var environmentStatus constants.EnvironmentStatus
 
 var buttonSelfUpdateOn *ui.Button
 var buttonStartServer *ui.Button
+var buttonShutdownServer *ui.Button
+var buttonSaveServerState *ui.Button
+var buttonPowerOffServer *ui.Button
 var buttonInstallAbittiServer *ui.Button
 var buttonInstallExamServer *ui.Button
+var buttonInstallFromFile *ui.Button
 var buttonDestroyServer *ui.Button
 var buttonRemoveServer *ui.Button
 var buttonMakeBackup *ui.Button
 var buttonDeliverLogs *ui.Button
+var buttonDoctor *ui.Button
+var buttonDryRun *ui.Button
+var buttonSnapshots *ui.Button
 var buttonMebShare *ui.Button
 
 var comboboxLang *ui.Combobox
+var comboboxServer *ui.Combobox
 var comboboxExtNic *ui.Combobox
 var comboboxNic *ui.Combobox
+var comboboxStartType *ui.Combobox
 
 var labelBox *ui.Label
 var labelBoxAvailable *ui.Label
+var labelServerState *ui.Label
 var labelStatus *ui.Label
 var labelExtNic *ui.Label
 var labelAdvancedNic *ui.Label
+var labelAdvancedStartType *ui.Label
 var labelAdvancedUpdate *ui.Label
 var labelAdvancedAnnihilate *ui.Label
 
@@ -64,6 +80,7 @@ var checkboxAdvanced *ui.Checkbox
 var boxVersions *ui.Box
 var boxBasicUpper *ui.Box
 var boxBasic *ui.Box
+var boxAdvancedPower *ui.Box
 var boxAdvancedUpdate *ui.Box
 var boxAdvancedAnnihilate *ui.Box
 var boxAdvanced *ui.Box
@@ -104,6 +121,23 @@ var examInstallPassphraseEntry *ui.Entry
 var examInstallButtonInstall *ui.Button
 var examInstallButtonCancel *ui.Button
 
+// Install From File Window
+var fileInstallWindow *ui.Window
+
+var fileInstallBox *ui.Box
+var fileInstallImageBox *ui.Box
+var fileInstallVersionBox *ui.Box
+var fileInstallImageLabel *ui.Label
+var fileInstallImageEntry *ui.Entry
+var fileInstallImageButtonBrowse *ui.Button
+var fileInstallVersionLabel *ui.Label
+var fileInstallVersionEntry *ui.Entry
+var fileInstallVersionButtonBrowse *ui.Button
+var fileInstallTypeLabel *ui.Label
+var fileInstallTypeCombobox *ui.Combobox
+var fileInstallButtonInstall *ui.Button
+var fileInstallButtonCancel *ui.Button
+
 // Destroy Confirmation Window
 var destroyWindow *ui.Window
 
@@ -124,18 +158,78 @@ var removeBox *ui.Box
 
 var removeInfoLabel [5]*ui.Label
 
+// Power Off Confirmation Window
+var powerOffWindow *ui.Window
+
+var powerOffButtonPowerOff *ui.Button
+var powerOffButtonCancel *ui.Button
+
+var powerOffBox *ui.Box
+
+var powerOffInfoLabel [3]*ui.Label
+
+// Crash Alert Window
+var crashWindow *ui.Window
+
+var crashButtonRestart *ui.Button
+var crashButtonClose *ui.Button
+
+var crashBox *ui.Box
+
+var crashInfoLabel [3]*ui.Label
+
+// Doctor Window
+var doctorWindow *ui.Window
+
+var doctorBox *ui.Box
+var doctorEntry *ui.MultilineEntry
+var doctorButtonRefresh *ui.Button
+var doctorButtonClose *ui.Button
+
+// Snapshot Window
+var snapshotWindow *ui.Window
+
+var snapshotBox *ui.Box
+var snapshotEntry *ui.MultilineEntry
+var snapshotTakeBox *ui.Box
+var snapshotNameEntry *ui.Entry
+var snapshotButtonTake *ui.Button
+var snapshotSelectBox *ui.Box
+var snapshotComboboxBox *ui.Box
+var snapshotCombobox *ui.Combobox
+var snapshotButtonRestore *ui.Button
+var snapshotButtonDelete *ui.Button
+var snapshotButtonClose *ui.Button
+
+// snapshotNames are the names in snapshotCombobox
+var snapshotNames []string
+
+// Dry-run Window
+var dryRunWindow *ui.Window
+
+var dryRunBox *ui.Box
+var dryRunEntry *ui.MultilineEntry
+var dryRunButtonClose *ui.Button
+
 var extInterfaces []constants.AvailableSelection
 
 func createMainWindowElements() {
 	// Define main window
 	buttonSelfUpdateOn = ui.NewButton("Turn Naksu self updates back on")
 	buttonStartServer = ui.NewButton("Start Exam Server")
+	buttonShutdownServer = ui.NewButton("Shut Down Server")
+	buttonSaveServerState = ui.NewButton("Save Server State")
+	buttonPowerOffServer = ui.NewButton("Power Off Server")
 	buttonInstallAbittiServer = ui.NewButton("Abitti Exam")
 	buttonInstallExamServer = ui.NewButton("Matriculation Exam")
+	buttonInstallFromFile = ui.NewButton("From file...")
 	buttonDestroyServer = ui.NewButton("Remove Exams")
 	buttonRemoveServer = ui.NewButton("Remove Server")
 	buttonMakeBackup = ui.NewButton("Make Exam Server Backup")
 	buttonDeliverLogs = ui.NewButton("Send logs to Abitti support")
+	buttonDoctor = ui.NewButton("Check computer and network")
+	buttonDryRun = ui.NewButton("Show VBoxManage commands")
+	buttonSnapshots = ui.NewButton("Manage snapshots")
 	buttonMebShare = ui.NewButton("Open virtual USB stick (ktp-jako)")
 
 	// Define language setting combobox
@@ -145,6 +239,13 @@ func createMainWindowElements() {
 	}
 	comboboxLang.SetSelected(constants.GetAvailableSelectionID(config.GetLanguage(), constants.AvailableLangs, 0))
 
+	// Define server selection combobox
+	comboboxServer = ui.NewCombobox()
+	for _, thisSelection := range constants.AvailableServers {
+		comboboxServer.Append(xlate.Get(thisSelection.Legend))
+	}
+	comboboxServer.SetSelected(constants.GetAvailableSelectionID(box.GetSelectedServer(), constants.AvailableServers, 0))
+
 	// Define EXTNIC setting combobox
 	comboboxExtNic = ui.NewCombobox()
 	for _, thisSelection := range extInterfaces {
@@ -159,11 +260,20 @@ func createMainWindowElements() {
 	}
 	comboboxNic.SetSelected(constants.GetAvailableSelectionID(config.GetNic(), constants.AvailableNics, 0))
 
+	// Define start type setting combobox
+	comboboxStartType = ui.NewCombobox()
+	for _, thisSelection := range constants.AvailableStartTypes {
+		comboboxStartType.Append(xlate.Get(thisSelection.Legend))
+	}
+	comboboxStartType.SetSelected(constants.GetAvailableSelectionID(config.GetStartType(), constants.AvailableStartTypes, 0))
+
 	labelBox = ui.NewLabel("")
 	labelBoxAvailable = ui.NewLabel("")
+	labelServerState = ui.NewLabel("")
 	labelStatus = ui.NewLabel("")
 	labelExtNic = ui.NewLabel("")
 	labelAdvancedNic = ui.NewLabel("")
+	labelAdvancedStartType = ui.NewLabel("")
 	labelAdvancedUpdate = ui.NewLabel("")
 	labelAdvancedAnnihilate = ui.NewLabel("")
 
@@ -176,11 +286,13 @@ func createMainWindowElements() {
 	boxVersions.SetPadded(true)
 	boxVersions.Append(labelBox, true)
 	boxVersions.Append(labelBoxAvailable, true)
+	boxVersions.Append(labelServerState, true)
 
 	// Box version and language selection dropdown
 	boxBasicUpper = ui.NewHorizontalBox()
 	boxBasicUpper.SetPadded(true)
 	boxBasicUpper.Append(boxVersions, true)
+	boxBasicUpper.Append(comboboxServer, false)
 	boxBasicUpper.Append(comboboxLang, false)
 
 	boxBasic = ui.NewVerticalBox()
@@ -189,15 +301,22 @@ func createMainWindowElements() {
 	boxBasic.Append(labelStatus, true)
 	boxBasic.Append(buttonSelfUpdateOn, false)
 	boxBasic.Append(buttonStartServer, false)
+	boxBasic.Append(buttonShutdownServer, false)
 	boxBasic.Append(buttonMebShare, false)
 	boxBasic.Append(labelExtNic, false)
 	boxBasic.Append(comboboxExtNic, false)
 	boxBasic.Append(checkboxAdvanced, true)
 
+	boxAdvancedPower = ui.NewHorizontalBox()
+	boxAdvancedPower.SetPadded(true)
+	boxAdvancedPower.Append(buttonSaveServerState, true)
+	boxAdvancedPower.Append(buttonPowerOffServer, true)
+
 	boxAdvancedUpdate = ui.NewHorizontalBox()
 	boxAdvancedUpdate.SetPadded(true)
 	boxAdvancedUpdate.Append(buttonInstallAbittiServer, true)
 	boxAdvancedUpdate.Append(buttonInstallExamServer, true)
+	boxAdvancedUpdate.Append(buttonInstallFromFile, true)
 
 	boxAdvancedAnnihilate = ui.NewHorizontalBox()
 	boxAdvancedAnnihilate.SetPadded(true)
@@ -209,9 +328,15 @@ func createMainWindowElements() {
 	boxAdvanced.Append(ui.NewHorizontalSeparator(), false)
 	boxAdvanced.Append(labelAdvancedNic, false)
 	boxAdvanced.Append(comboboxNic, false)
+	boxAdvanced.Append(labelAdvancedStartType, false)
+	boxAdvanced.Append(comboboxStartType, false)
 	boxAdvanced.Append(ui.NewHorizontalSeparator(), false)
 	boxAdvanced.Append(buttonMakeBackup, true)
 	boxAdvanced.Append(buttonDeliverLogs, true)
+	boxAdvanced.Append(buttonDoctor, true)
+	boxAdvanced.Append(buttonSnapshots, true)
+	boxAdvanced.Append(buttonDryRun, true)
+	boxAdvanced.Append(boxAdvancedPower, true)
 	boxAdvanced.Append(ui.NewHorizontalSeparator(), false)
 	boxAdvanced.Append(labelAdvancedUpdate, false)
 	boxAdvanced.Append(boxAdvancedUpdate, true)
@@ -324,6 +449,135 @@ func createExamInstallElements() {
 	examInstallWindow.SetChild(examInstallBox)
 }
 
+func createFileInstallElements() {
+	const fileInstallWindowDefaultWidth = 500
+
+	// Define install from file dialog window
+	fileInstallImageLabel = ui.NewLabel(xlate.Get("Image file (ktp-etcher.zip):"))
+	fileInstallImageEntry = ui.NewEntry()
+	fileInstallImageButtonBrowse = ui.NewButton(xlate.Get("Browse..."))
+	fileInstallVersionLabel = ui.NewLabel(xlate.Get("Version file (optional):"))
+	fileInstallVersionEntry = ui.NewEntry()
+	fileInstallVersionButtonBrowse = ui.NewButton(xlate.Get("Browse..."))
+	fileInstallTypeLabel = ui.NewLabel(xlate.Get("Server type:"))
+	fileInstallButtonCancel = ui.NewButton(xlate.Get("Cancel"))
+	fileInstallButtonInstall = ui.NewButton(xlate.Get("Install"))
+
+	fileInstallTypeCombobox = ui.NewCombobox()
+	for _, thisSelection := range constants.AvailableServers {
+		fileInstallTypeCombobox.Append(xlate.Get(thisSelection.Legend))
+	}
+	fileInstallTypeCombobox.SetSelected(0)
+
+	fileInstallImageBox = ui.NewHorizontalBox()
+	fileInstallImageBox.SetPadded(true)
+	fileInstallImageBox.Append(fileInstallImageEntry, true)
+	fileInstallImageBox.Append(fileInstallImageButtonBrowse, false)
+
+	fileInstallVersionBox = ui.NewHorizontalBox()
+	fileInstallVersionBox.SetPadded(true)
+	fileInstallVersionBox.Append(fileInstallVersionEntry, true)
+	fileInstallVersionBox.Append(fileInstallVersionButtonBrowse, false)
+
+	fileInstallBox = ui.NewVerticalBox()
+	fileInstallBox.SetPadded(true)
+
+	fileInstallBox.Append(fileInstallImageLabel, false)
+	fileInstallBox.Append(fileInstallImageBox, false)
+	fileInstallBox.Append(fileInstallVersionLabel, false)
+	fileInstallBox.Append(fileInstallVersionBox, false)
+	fileInstallBox.Append(fileInstallTypeLabel, false)
+	fileInstallBox.Append(fileInstallTypeCombobox, false)
+	fileInstallBox.Append(fileInstallButtonInstall, false)
+	fileInstallBox.Append(fileInstallButtonCancel, false)
+
+	fileInstallWindow = ui.NewWindow("", fileInstallWindowDefaultWidth, 1, false)
+	fileInstallWindow.SetMargined(true)
+	fileInstallWindow.SetChild(fileInstallBox)
+}
+
+func createDoctorElements() {
+	const doctorWindowDefaultWidth = 600
+	const doctorWindowDefaultHeight = 400
+
+	doctorEntry = ui.NewMultilineEntry()
+	doctorEntry.SetReadOnly(true)
+	doctorButtonRefresh = ui.NewButton(xlate.Get("Check again"))
+	doctorButtonClose = ui.NewButton(xlate.Get("Close"))
+
+	doctorBox = ui.NewVerticalBox()
+	doctorBox.SetPadded(true)
+	doctorBox.Append(doctorEntry, true)
+	doctorBox.Append(doctorButtonRefresh, false)
+	doctorBox.Append(doctorButtonClose, false)
+
+	doctorWindow = ui.NewWindow("", doctorWindowDefaultWidth, doctorWindowDefaultHeight, false)
+	doctorWindow.SetMargined(true)
+	doctorWindow.SetChild(doctorBox)
+}
+
+func createSnapshotElements() {
+	const snapshotWindowDefaultWidth = 600
+	const snapshotWindowDefaultHeight = 400
+
+	snapshotEntry = ui.NewMultilineEntry()
+	snapshotEntry.SetReadOnly(true)
+
+	snapshotNameEntry = ui.NewEntry()
+	snapshotButtonTake = ui.NewButton(xlate.Get("Take snapshot"))
+
+	snapshotTakeBox = ui.NewHorizontalBox()
+	snapshotTakeBox.SetPadded(true)
+	snapshotTakeBox.Append(snapshotNameEntry, true)
+	snapshotTakeBox.Append(snapshotButtonTake, false)
+
+	// The combobox is replaced when the snapshots change since the combobox
+	// items cannot be removed
+	snapshotCombobox = ui.NewCombobox()
+	snapshotComboboxBox = ui.NewHorizontalBox()
+	snapshotComboboxBox.Append(snapshotCombobox, true)
+
+	snapshotButtonRestore = ui.NewButton(xlate.Get("Restore"))
+	snapshotButtonDelete = ui.NewButton(xlate.Get("Delete"))
+
+	snapshotSelectBox = ui.NewHorizontalBox()
+	snapshotSelectBox.SetPadded(true)
+	snapshotSelectBox.Append(snapshotComboboxBox, true)
+	snapshotSelectBox.Append(snapshotButtonRestore, false)
+	snapshotSelectBox.Append(snapshotButtonDelete, false)
+
+	snapshotButtonClose = ui.NewButton(xlate.Get("Close"))
+
+	snapshotBox = ui.NewVerticalBox()
+	snapshotBox.SetPadded(true)
+	snapshotBox.Append(snapshotEntry, true)
+	snapshotBox.Append(snapshotTakeBox, false)
+	snapshotBox.Append(snapshotSelectBox, false)
+	snapshotBox.Append(snapshotButtonClose, false)
+
+	snapshotWindow = ui.NewWindow("", snapshotWindowDefaultWidth, snapshotWindowDefaultHeight, false)
+	snapshotWindow.SetMargined(true)
+	snapshotWindow.SetChild(snapshotBox)
+}
+
+func createDryRunElements() {
+	const dryRunWindowDefaultWidth = 800
+	const dryRunWindowDefaultHeight = 400
+
+	dryRunEntry = ui.NewMultilineEntry()
+	dryRunEntry.SetReadOnly(true)
+	dryRunButtonClose = ui.NewButton(xlate.Get("Close"))
+
+	dryRunBox = ui.NewVerticalBox()
+	dryRunBox.SetPadded(true)
+	dryRunBox.Append(dryRunEntry, true)
+	dryRunBox.Append(dryRunButtonClose, false)
+
+	dryRunWindow = ui.NewWindow("", dryRunWindowDefaultWidth, dryRunWindowDefaultHeight, false)
+	dryRunWindow.SetMargined(true)
+	dryRunWindow.SetChild(dryRunBox)
+}
+
 func createDestroyElements() {
 	// Define Destroy Confirmation window/dialog
 	for i := 0; i <= 4; i++ {
@@ -370,6 +624,52 @@ func createRemoveElements() {
 	removeWindow.SetChild(removeBox)
 }
 
+func createPowerOffElements() {
+	// Define Power Off Confirmation window/dialog
+	for i := range powerOffInfoLabel {
+		powerOffInfoLabel[i] = ui.NewLabel("powerOffInfoLabel")
+	}
+
+	powerOffButtonPowerOff = ui.NewButton("Yes, Power Off")
+	powerOffButtonCancel = ui.NewButton("Cancel")
+
+	powerOffBox = ui.NewVerticalBox()
+	powerOffBox.SetPadded(true)
+	for i := range powerOffInfoLabel {
+		powerOffBox.Append(powerOffInfoLabel[i], false)
+	}
+	powerOffBox.Append(powerOffButtonPowerOff, false)
+	powerOffBox.Append(powerOffButtonCancel, false)
+
+	powerOffWindow = ui.NewWindow("", 1, 1, false)
+
+	powerOffWindow.SetMargined(true)
+	powerOffWindow.SetChild(powerOffBox)
+}
+
+func createCrashElements() {
+	// Define Crash Alert window/dialog
+	for i := range crashInfoLabel {
+		crashInfoLabel[i] = ui.NewLabel("crashInfoLabel")
+	}
+
+	crashButtonRestart = ui.NewButton("Restart Server")
+	crashButtonClose = ui.NewButton("Close")
+
+	crashBox = ui.NewVerticalBox()
+	crashBox.SetPadded(true)
+	for i := range crashInfoLabel {
+		crashBox.Append(crashInfoLabel[i], false)
+	}
+	crashBox.Append(crashButtonRestart, false)
+	crashBox.Append(crashButtonClose, false)
+
+	crashWindow = ui.NewWindow("", 1, 1, false)
+
+	crashWindow.SetMargined(true)
+	crashWindow.SetChild(crashBox)
+}
+
 func populateBackupCombobox(backupMedia map[string]string, combobox *ui.Combobox) []string {
 	// Collect all paths to this slice
 	mediaPath := make([]string, len(backupMedia))
@@ -423,11 +723,18 @@ func mainUIStatusHandler(currentMainUIStatus mainUIStatusType) { //nolint:gocycl
 	}{
 		{buttonSelfUpdateOn, config.IsSelfUpdateDisabled()},
 		{buttonStartServer, mainUIEnabled && boxInstalled && !boxRunning},
+		{buttonShutdownServer, mainUIEnabled && boxRunning},
+		{buttonSaveServerState, mainUIEnabled && boxRunning},
+		{buttonPowerOffServer, mainUIEnabled && boxRunning},
 		{buttonMebShare, true},
 		{buttonMakeBackup, mainUIEnabled && boxInstalled && !boxRunning},
 		{buttonDeliverLogs, mainUIEnabled && true},
+		{buttonDoctor, true},
+		{buttonDryRun, true},
+		{buttonSnapshots, mainUIEnabled && boxInstalled},
 		{buttonInstallAbittiServer, mainUIEnabled && !boxRunning && netAvailable},
 		{buttonInstallExamServer, mainUIEnabled && !boxRunning && netAvailable},
+		{buttonInstallFromFile, mainUIEnabled && !boxRunning},
 		{buttonDestroyServer, mainUIEnabled && boxInstalled && !boxRunning},
 		{buttonRemoveServer, true},
 	}
@@ -437,7 +744,9 @@ func mainUIStatusHandler(currentMainUIStatus mainUIStatusType) { //nolint:gocycl
 		enable  bool
 	}{
 		{comboboxLang, mainUIEnabled && !boxRunning},
+		{comboboxServer, mainUIEnabled},
 		{comboboxNic, mainUIEnabled && !boxRunning},
+		{comboboxStartType, mainUIEnabled && !boxRunning},
 		{comboboxExtNic, mainUIEnabled && !boxRunning},
 	}
 
@@ -486,13 +795,22 @@ func checkAbittiUpdate() (bool, string) {
 
 // updateStartButtonLabel updates label for start button depending on the
 // installed VM style. If there is no box installed the default label is set.
+// A saved or paused VM is resumed instead of started.
 func updateStartButtonLabel() {
 	go func() {
 		boxTypeString := box.GetTypeLegend()
+		state, err := box.GetState()
+		if err != nil {
+			log.Debug("Could not get the state of the server: %v", err)
+		}
+
 		ui.QueueMain(func() {
-			if boxTypeString == "-" {
+			switch {
+			case boxTypeString == "-":
 				buttonStartServer.SetText(xlate.Get("Start Exam Server"))
-			} else {
+			case box.IsResumable(state):
+				buttonStartServer.SetText(xlate.Get("Resume %s", boxTypeString))
+			default:
 				buttonStartServer.SetText(xlate.Get("Start %s", boxTypeString))
 			}
 		})
@@ -546,12 +864,21 @@ func translateUILabels() {
 	ui.QueueMain(func() {
 		updateStartButtonLabel()
 		updateGetServerButtonLabel()
+		// The install commands select the server they install
+		comboboxServer.SetSelected(constants.GetAvailableSelectionID(box.GetSelectedServer(), constants.AvailableServers, 0))
 		buttonSelfUpdateOn.SetText(xlate.Get("Turn Naksu self updates back on"))
 		buttonInstallExamServer.SetText(xlate.Get("Matriculation Exam"))
+		buttonInstallFromFile.SetText(xlate.Get("From file..."))
+		buttonShutdownServer.SetText(xlate.Get("Shut Down Server"))
+		buttonSaveServerState.SetText(xlate.Get("Save Server State"))
+		buttonPowerOffServer.SetText(xlate.Get("Power Off Server"))
 		buttonDestroyServer.SetText(xlate.Get("Remove Exams"))
 		buttonRemoveServer.SetText(xlate.Get("Remove Server"))
 		buttonMakeBackup.SetText(xlate.Get("Make Exam Server Backup"))
 		buttonDeliverLogs.SetText(xlate.Get("Send logs to Abitti support"))
+		buttonDoctor.SetText(xlate.Get("Check computer and network"))
+		buttonDryRun.SetText(xlate.Get("Show VBoxManage commands"))
+		buttonSnapshots.SetText(xlate.Get("Manage snapshots"))
 		buttonMebShare.SetText(xlate.Get("Open virtual USB stick (ktp-jako)"))
 		labelExtNic.SetText(xlate.Get("Network device:"))
 
@@ -567,6 +894,7 @@ func translateUILabels() {
 
 		checkboxAdvanced.SetText(xlate.Get("Show management features"))
 		labelAdvancedNic.SetText(xlate.Get("Server networking hardware:"))
+		labelAdvancedStartType.SetText(xlate.Get("Server window:"))
 		labelAdvancedUpdate.SetText(xlate.Get("Install/update server for:"))
 		labelAdvancedAnnihilate.SetText(xlate.Get("DANGER! Annihilate your server:"))
 
@@ -580,11 +908,33 @@ func translateUILabels() {
 		logDeliveryFilenameCopyButton.SetText(xlate.Get("Copy to clipboard"))
 		logDeliveryButtonClose.SetText(xlate.Get("Close"))
 
+		doctorWindow.SetTitle(xlate.Get("naksu: Check computer and network"))
+		doctorButtonRefresh.SetText(xlate.Get("Check again"))
+		doctorButtonClose.SetText(xlate.Get("Close"))
+
+		snapshotWindow.SetTitle(xlate.Get("naksu: Snapshots"))
+		snapshotButtonTake.SetText(xlate.Get("Take snapshot"))
+		snapshotButtonRestore.SetText(xlate.Get("Restore"))
+		snapshotButtonDelete.SetText(xlate.Get("Delete"))
+		snapshotButtonClose.SetText(xlate.Get("Close"))
+
+		dryRunWindow.SetTitle(xlate.Get("naksu: VBoxManage commands"))
+		dryRunButtonClose.SetText(xlate.Get("Close"))
+
 		examInstallWindow.SetTitle(xlate.Get("naksu: Install Exam Server"))
 		examInstallPassphraseLabel.SetText(xlate.Get("Enter Exam Server install passphrase:"))
 		examInstallButtonInstall.SetText(xlate.Get("Install"))
 		examInstallButtonCancel.SetText(xlate.Get("Cancel"))
 
+		fileInstallWindow.SetTitle(xlate.Get("naksu: Install Server From File"))
+		fileInstallImageLabel.SetText(xlate.Get("Image file (ktp-etcher.zip):"))
+		fileInstallImageButtonBrowse.SetText(xlate.Get("Browse..."))
+		fileInstallVersionLabel.SetText(xlate.Get("Version file (optional):"))
+		fileInstallVersionButtonBrowse.SetText(xlate.Get("Browse..."))
+		fileInstallTypeLabel.SetText(xlate.Get("Server type:"))
+		fileInstallButtonInstall.SetText(xlate.Get("Install"))
+		fileInstallButtonCancel.SetText(xlate.Get("Cancel"))
+
 		destroyWindow.SetTitle(xlate.Get("naksu: Remove Exams"))
 		destroyInfoLabel[0].SetText(xlate.Get("Remove Exams restores server to its initial status."))
 		destroyInfoLabel[1].SetText(xlate.Get("Exams, responses and logs in the server will be irreversibly deleted."))
@@ -594,6 +944,18 @@ func translateUILabels() {
 		destroyButtonDestroy.SetText(xlate.Get("Yes, Remove"))
 		destroyButtonCancel.SetText(xlate.Get("Cancel"))
 
+		crashWindow.SetTitle(xlate.Get("naksu: Server Stopped"))
+		crashInfoLabel[2].SetText(xlate.Get("Do you wish to restart the server?"))
+		crashButtonRestart.SetText(xlate.Get("Restart Server"))
+		crashButtonClose.SetText(xlate.Get("Close"))
+
+		powerOffWindow.SetTitle(xlate.Get("naksu: Power Off Server"))
+		powerOffInfoLabel[0].SetText(xlate.Get("Powering off stops the server without shutting it down."))
+		powerOffInfoLabel[1].SetText(xlate.Get("The exams in progress may be lost and the server database may be corrupted."))
+		powerOffInfoLabel[2].SetText(xlate.Get("Do you wish to power off the server?"))
+		powerOffButtonPowerOff.SetText(xlate.Get("Yes, Power Off"))
+		powerOffButtonCancel.SetText(xlate.Get("Cancel"))
+
 		removeWindow.SetTitle(xlate.Get("naksu: Remove Server"))
 		removeInfoLabel[0].SetText(xlate.Get("Removing server destroys it and all downloaded disk images."))
 		removeInfoLabel[1].SetText(xlate.Get("Exams, responses and logs in the server will be irreversibly deleted."))
@@ -628,6 +990,21 @@ func bindLanguageSwitching() {
 	})
 }
 
+func bindServerSwitching() {
+	// Define server selection action main window
+	comboboxServer.OnSelected(func(*ui.Combobox) {
+		newValue := constants.AvailableServers[comboboxServer.Selected()].ConfigValue
+		log.Action("Changing server to %s", newValue)
+		config.SetServer(newValue)
+
+		if err := box.SelectServer(config.GetServer()); err != nil {
+			log.Error("Could not select server: %v", err)
+		}
+		progress.SetMessage("")
+		translateUILabels()
+	})
+}
+
 func bindAdvancedToggle() {
 	// Show/hide advanced features
 	checkboxAdvanced.OnToggled(func(*ui.Checkbox) {
@@ -660,6 +1037,53 @@ func bindAdvancedNicSwitching() {
 	})
 }
 
+func bindAdvancedStartTypeSwitching() {
+	// Define start type selection action main window (advanced view)
+	comboboxStartType.OnSelected(func(*ui.Combobox) {
+		newValue := constants.AvailableStartTypes[comboboxStartType.Selected()].ConfigValue
+		log.Action("Changing server start type to %s", newValue)
+		config.SetStartType(newValue)
+	})
+}
+
+// getServerStateText returns the state of the server and the address of its
+// remote console for the main window
+func getServerStateText() string {
+	state, err := box.GetState()
+	if err != nil || state == "" {
+		return ""
+	}
+
+	text := xlate.Get("Server state: %s", state)
+
+	if state == box.VMStateRunning {
+		consoleURL, err := box.GetConsoleURL()
+		if err != nil {
+			log.Debug("Could not get the remote console address: %v", err)
+		} else if consoleURL != "" {
+			text += "\n" + xlate.Get("Remote console: %s", consoleURL)
+		}
+	}
+
+	return text
+}
+
+// startServerStateUpdate starts periodically updating the server state label
+func startServerStateUpdate(tickerDuration time.Duration) {
+	ticker := time.NewTicker(tickerDuration)
+
+	go func() {
+		for {
+			<-ticker.C
+
+			text := getServerStateText()
+			ui.QueueMain(func() {
+				labelServerState.SetText(text)
+			})
+		}
+	}()
+}
+
 func bindUIDisableOnStart(mainUIStatus chan string) {
 	// Define actions for main window
 
@@ -709,7 +1133,7 @@ func startServerButtonClicked(mainUIStatus chan string) {
 		// Disable UI to prevent multiple simultaneous server starts
 		disableUI(mainUIStatus)
 
-		err := start.Server()
+		err := start.Server(guiNotifier)
 		if err != nil {
 			log.Debug("Failed to start server: %v", err)
 			progress.SetMessage("")
@@ -727,6 +1151,126 @@ func startServerButtonClicked(mainUIStatus chan string) {
 	}()
 }
 
+// runServerControlInGoroutine shuts down, saves the state of or powers off
+// the server with the UI disabled
+func runServerControlInGoroutine(mainUIStatus chan string, successMessage string, control func() error) {
+	go func() {
+		disableUI(mainUIStatus)
+
+		err := control()
+		if err != nil {
+			log.Debug("Failed to stop server: %v", err)
+			progress.SetMessage("")
+		} else {
+			progress.TranslateAndSetMessage(successMessage)
+		}
+
+		// Update start button label (the saved server is resumed)
+		translateUILabels()
+
+		enableUI(mainUIStatus)
+	}()
+}
+
+func bindOnServerControl(mainUIStatus chan string) {
+	buttonShutdownServer.OnClicked(func(*ui.Button) {
+		log.Action("Shutting down server")
+		runServerControlInGoroutine(mainUIStatus, "Server was shut down.", func() error {
+			return stop.Shutdown(constants.VMShutdownTimeout, guiNotifier)
+		})
+	})
+
+	buttonSaveServerState.OnClicked(func(*ui.Button) {
+		log.Action("Saving server state")
+		runServerControlInGoroutine(mainUIStatus, "Server state was saved.", func() error {
+			return stop.SaveState(guiNotifier)
+		})
+	})
+
+	buttonPowerOffServer.OnClicked(func(*ui.Button) {
+		log.Action("Opening PowerOff dialog")
+		disableUI(mainUIStatus)
+		powerOffWindow.Show()
+	})
+
+	powerOffButtonPowerOff.OnClicked(func(*ui.Button) {
+		log.Action("Powering off server")
+		powerOffWindow.Hide()
+		runServerControlInGoroutine(mainUIStatus, "Server was powered off.", func() error {
+			return stop.PowerOff(guiNotifier)
+		})
+	})
+
+	powerOffButtonCancel.OnClicked(func(*ui.Button) {
+		log.Action("Cancelling PowerOff dialog")
+		powerOffWindow.Hide()
+		enableUI(mainUIStatus)
+	})
+
+	powerOffWindow.OnClosing(func(*ui.Window) bool {
+		log.Action("Closing PowerOff dialog")
+		powerOffWindow.Hide()
+		enableUI(mainUIStatus)
+
+		return false
+	})
+}
+
+// showCrashAlert collects the diagnostics of the crashed server and offers
+// to restart it
+func showCrashAlert(crash box.Crash) {
+	var bundleText string
+
+	bundlePath, err := logdelivery.CollectCrashBundle(crash)
+	if err != nil {
+		log.Error("Could not collect crash diagnostics: %v", err)
+		bundleText = xlate.Get("Could not collect diagnostics: %v", err)
+	} else {
+		log.Debug("Crash diagnostics were saved to %s", bundlePath)
+		bundleText = xlate.Get("Diagnostics were saved to %s", bundlePath)
+	}
+
+	ui.QueueMain(func() {
+		crashInfoLabel[0].SetText(xlate.Get("The server stopped unexpectedly (state: %s).", crash.State))
+		crashInfoLabel[1].SetText(bundleText)
+		crashWindow.Show()
+	})
+}
+
+func bindOnCrash(mainUIStatus chan string) {
+	box.SetCrashHandler(showCrashAlert)
+
+	crashButtonRestart.OnClicked(func(*ui.Button) {
+		log.Action("Restarting server after a crash")
+		crashWindow.Hide()
+
+		go func() {
+			// A server in a guru meditation is still running and must be powered off first
+			state, err := box.GetState()
+			if err == nil && state == box.VMStateGuruMeditation {
+				err = stop.PowerOff(guiNotifier)
+				if err != nil {
+					return
+				}
+			}
+
+			startServerButtonClicked(mainUIStatus)
+		}()
+	})
+
+	crashButtonClose.OnClicked(func(*ui.Button) {
+		log.Action("Closing Crash dialog")
+		crashWindow.Hide()
+	})
+
+	crashWindow.OnClosing(func(*ui.Window) bool {
+		log.Action("Closing Crash dialog")
+		crashWindow.Hide()
+
+		return false
+	})
+}
+
 func bindOnInstallAbittiServer(mainUIStatus chan string) {
 	buttonInstallAbittiServer.OnClicked(func(*ui.Button) {
 		go func() {
@@ -734,7 +1278,7 @@ func bindOnInstallAbittiServer(mainUIStatus chan string) {
 
 			disableUI(mainUIStatus)
 
-			err := install.NewAbittiServer()
+			err := install.NewAbittiServer(guiNotifier)
 			if err != nil {
 				log.Debug("Failed to install an Abitti server: %v", err)
 				progress.SetMessage("")
@@ -768,7 +1312,7 @@ func bindOnInstallExamServer(mainUIStatus chan string) {
 				log.Action("InstallExamServer passhrase entered - Starting Exam box update")
 				examInstallWindow.Hide()
 
-				err := install.NewExamServer(passphrase)
+				err := install.NewExamServer(passphrase, guiNotifier)
 				if err != nil {
 					log.Debug("Failed to install an exam server: %v", err)
 					progress.SetMessage("")
@@ -802,6 +1346,75 @@ func bindOnInstallExamServer(mainUIStatus chan string) {
 	})
 }
 
+func bindOnInstallFromFile(mainUIStatus chan string) {
+	buttonInstallFromFile.OnClicked(func(*ui.Button) {
+		log.Action("Opening InstallFromFile dialog")
+		disableUI(mainUIStatus)
+		fileInstallTypeCombobox.SetSelected(constants.GetAvailableSelectionID(box.GetSelectedServer(), constants.AvailableServers, 0))
+		fileInstallWindow.Show()
+	})
+
+	fileInstallImageButtonBrowse.OnClicked(func(*ui.Button) {
+		path := ui.OpenFile(fileInstallWindow)
+		if path != "" {
+			fileInstallImageEntry.SetText(path)
+		}
+	})
+
+	fileInstallVersionButtonBrowse.OnClicked(func(*ui.Button) {
+		path := ui.OpenFile(fileInstallWindow)
+		if path != "" {
+			fileInstallVersionEntry.SetText(path)
+		}
+	})
+
+	fileInstallButtonInstall.OnClicked(func(*ui.Button) {
+		imagePath := fileInstallImageEntry.Text()
+		versionPath := fileInstallVersionEntry.Text()
+		boxType := constants.AvailableServers[fileInstallTypeCombobox.Selected()].ConfigValue
+
+		if imagePath == "" {
+			mebroutines.ShowTranslatedErrorMessage("Please select the image file to install")
+
+			return
+		}
+
+		log.Action("InstallFromFile image selected - Starting %s box update from %s", boxType, imagePath)
+		fileInstallWindow.Hide()
+		fileInstallImageEntry.SetText("")
+		fileInstallVersionEntry.SetText("")
+
+		go func() {
+			err := install.NewServerFromFile(boxType, imagePath, versionPath, guiNotifier)
+			if err != nil {
+				log.Debug("Failed to install a server from file: %v", err)
+				progress.SetMessage("")
+			} else {
+				progress.TranslateAndSetMessage("A new server was created from file %s", imagePath)
+			}
+
+			translateUILabels()
+			enableUI(mainUIStatus)
+
+			log.Debug("Finished box update from file, version is: %s", box.GetVersion())
+		}()
+	})
+
+	fileInstallButtonCancel.OnClicked(func(*ui.Button) {
+		log.Action("Cancelling InstallFromFile dialog")
+		fileInstallWindow.Hide()
+		enableUI(mainUIStatus)
+	})
+
+	fileInstallWindow.OnClosing(func(*ui.Window) bool {
+		log.Action("Closing InstallFromFile dialog")
+		fileInstallWindow.Hide()
+		enableUI(mainUIStatus)
+
+		return false
+	})
+}
+
 func bindOnDestroyServer(mainUIStatus chan string) {
 	// Define actions for Destroy popup/window
 	buttonDestroyServer.OnClicked(func(*ui.Button) {
@@ -835,6 +1448,32 @@ func setLogDeliveryLabelTextInGoroutine(text string) {
 	})
 }
 
+// logDeliveryNotifier shows the messages and the progress of the log delivery
+// in the status label of the log delivery window
+type logDeliveryNotifier struct{}
+
+func (logDeliveryNotifier) Error(message string) {
+	setLogDeliveryLabelTextInGoroutine(message)
+}
+
+func (logDeliveryNotifier) Warning(message string) {
+	setLogDeliveryLabelTextInGoroutine(message)
+}
+
+func (logDeliveryNotifier) Info(message string) {
+	setLogDeliveryLabelTextInGoroutine(message)
+}
+
+func (logDeliveryNotifier) Message(message string) {
+	setLogDeliveryLabelTextInGoroutine(message)
+}
+
+func (logDeliveryNotifier) Progress(message string, value int) {
+	setLogDeliveryLabelTextInGoroutine(fmt.Sprintf("%s: %d %%", message, value))
+}
+
+func (logDeliveryNotifier) ProgressDone() {}
+
 func bindOnDeliverLogs(mainUIStatus chan string) {
 	buttonDeliverLogs.OnClicked(func(*ui.Button) {
 		log.Action("Starting log delivery")
@@ -847,72 +1486,235 @@ func bindOnDeliverLogs(mainUIStatus chan string) {
 		logDeliveryWindow.Show()
 
 		go func() {
-			copyDoneChannel, copyProgressChannel := logdelivery.RequestLogsFromServer()
-			followLogCopyProgress(copyDoneChannel, copyProgressChannel)
+			_, err := logdelivery.DeliverLogsWithZipListener(logDeliveryNotifier{}, func(filename string) {
+				ui.QueueMain(func() {
+					logDeliveryFilenameLabel.SetText(filename)
+				})
+			})
+			if err != nil {
+				log.Error("Log delivery failed: %v", err)
+			}
+		}()
+	})
+}
 
-			logFilename, zipProgressChannel, zipErrorChannel := logdelivery.CollectLogsToZip()
+// showDoctorChecks shows the checks in the doctor window. Call this in the UI thread.
+func showDoctorChecks(checks []doctor.Check) {
+	doctorEntry.SetText(xlate.Get("Result: %s", strings.ToUpper(string(doctor.Worst(checks)))) + "\n\n" + doctor.Format(checks))
+}
 
-			ui.QueueMain(func() {
-				logDeliveryFilenameLabel.SetText(logFilename)
-			})
+// runDoctorInGoroutine runs the checks and shows them in the doctor window
+func runDoctorInGoroutine() {
+	doctorEntry.SetText(xlate.Get("Wait..."))
+	doctorButtonRefresh.Disable()
 
-			if followLogDeliveryZippingProgress(zipProgressChannel, zipErrorChannel) != nil {
-				return
-			}
+	go func() {
+		checks := doctor.Run()
 
-			if network.CheckIfNetworkAvailable() {
-				setLogDeliveryLabelTextInGoroutine(xlate.Get("Sending logs"))
-				err := logdelivery.SendLogs(logFilename, func(progress uint8) {
-					setLogDeliveryLabelTextInGoroutine(xlate.Get("Sending logs: %d %%", progress))
-				})
-				if err != nil {
-					setLogDeliveryLabelTextInGoroutine(xlate.Get("Error sending logs: %s", err))
-				} else {
-					setLogDeliveryLabelTextInGoroutine(xlate.Get("Logs sent!"))
-				}
-			} else {
-				setLogDeliveryLabelTextInGoroutine(xlate.Get("Cannot send logs because there is no Internet connection. Logs are in a zip archive in the ktp-jako folder."))
-			}
-		}()
+		ui.QueueMain(func() {
+			showDoctorChecks(checks)
+			doctorButtonRefresh.Enable()
+		})
+	}()
+}
+
+func bindOnDoctor() {
+	buttonDoctor.OnClicked(func(*ui.Button) {
+		log.Action("Opening Doctor dialog")
+		doctorWindow.Show()
+		runDoctorInGoroutine()
+	})
+
+	doctorButtonRefresh.OnClicked(func(*ui.Button) {
+		log.Action("Running doctor checks again")
+		runDoctorInGoroutine()
 	})
+
+	doctorButtonClose.OnClicked(func(*ui.Button) {
+		log.Action("Closing Doctor dialog")
+		doctorWindow.Hide()
+	})
+
+	doctorWindow.OnClosing(func(*ui.Window) bool {
+		log.Action("Closing Doctor dialog")
+		doctorWindow.Hide()
+
+		return false
+	})
+}
+
+func setSnapshotButtonsEnabled(enabled bool) {
+	for _, button := range []*ui.Button{snapshotButtonTake, snapshotButtonRestore, snapshotButtonDelete, snapshotButtonClose} {
+		if enabled {
+			button.Enable()
+		} else {
+			button.Disable()
+		}
+	}
 }
 
-func followLogCopyProgress(copyDoneChannel chan bool, copyProgressChannel chan string) {
-	for {
-		select {
-		case copyDone := <-copyDoneChannel:
-			if copyDone {
-				setLogDeliveryLabelTextInGoroutine(xlate.Get("Done copying"))
+// showSnapshots shows the snapshots in the snapshot window. Call this in the
+// UI thread.
+func showSnapshots(snapshots []box.SnapshotInfo, err error) {
+	snapshotNames = []string{}
 
-				return
-			}
-		case copyProgress := <-copyProgressChannel:
-			if copyProgress != "0 %" {
-				setLogDeliveryLabelTextInGoroutine(xlate.Get("Copying logs: %s", copyProgress))
+	snapshotComboboxBox.Delete(0)
+	snapshotCombobox = ui.NewCombobox()
+	snapshotComboboxBox.Append(snapshotCombobox, true)
+
+	if err != nil {
+		snapshotEntry.SetText(xlate.Get("Could not list snapshots: %v", err))
+
+		return
+	}
+
+	var snapshotsText strings.Builder
+	if writeErr := writeSnapshots(&snapshotsText, snapshots); writeErr != nil {
+		log.Error("Could not show snapshots: %v", writeErr)
+	}
+	snapshotEntry.SetText(snapshotsText.String())
+
+	for _, snapshotInfo := range snapshots {
+		snapshotNames = append(snapshotNames, snapshotInfo.Name)
+		snapshotCombobox.Append(snapshotInfo.Name)
+		if snapshotInfo.Current {
+			snapshotCombobox.SetSelected(len(snapshotNames) - 1)
+		}
+	}
+}
+
+// runSnapshotActionInGoroutine runs the action and updates the snapshot list.
+// A nil action just updates the list.
+func runSnapshotActionInGoroutine(action func() error) {
+	snapshotEntry.SetText(xlate.Get("Wait..."))
+	setSnapshotButtonsEnabled(false)
+
+	go func() {
+		if action != nil {
+			err := action()
+			if err != nil {
+				log.Debug("Snapshot action failed: %v", err)
+				progress.SetMessage("")
 			}
 		}
+
+		snapshots, err := box.GetSnapshots()
+
+		ui.QueueMain(func() {
+			showSnapshots(snapshots, err)
+			setSnapshotButtonsEnabled(true)
+		})
+	}()
+}
+
+// getSelectedSnapshotName returns the name selected in snapshotCombobox or
+// an empty string
+func getSelectedSnapshotName() string {
+	selected := snapshotCombobox.Selected()
+	if selected < 0 || selected >= len(snapshotNames) {
+		return ""
 	}
+
+	return snapshotNames[selected]
 }
 
-func followLogDeliveryZippingProgress(zipProgressChannel chan uint8, zipErrorChannel chan error) error {
-	const zipProgressFinished = 100
+func bindOnSnapshots(mainUIStatus chan string) {
+	buttonSnapshots.OnClicked(func(*ui.Button) {
+		log.Action("Opening Snapshots dialog")
+		disableUI(mainUIStatus)
+		snapshotNameEntry.SetText(xlate.Get("Before exam %s", time.Now().Format("2006-01-02")))
+		snapshotWindow.Show()
+		runSnapshotActionInGoroutine(nil)
+	})
 
-	for {
-		select {
-		case zipProgress := <-zipProgressChannel:
-			if zipProgress <= zipProgressFinished {
-				setLogDeliveryLabelTextInGoroutine(xlate.Get("Zipping logs: %d %%", zipProgress))
-			} else {
-				setLogDeliveryLabelTextInGoroutine(xlate.Get("Done zipping"))
+	snapshotButtonTake.OnClicked(func(*ui.Button) {
+		name := snapshotNameEntry.Text()
+		log.Action("Taking snapshot '%s'", name)
+		runSnapshotActionInGoroutine(func() error {
+			return snapshot.Take(name, "", guiNotifier)
+		})
+	})
 
-				return nil
-			}
-		case zipError := <-zipErrorChannel:
-			setLogDeliveryLabelTextInGoroutine(xlate.Get("Error zipping logs: %s", zipError))
+	snapshotButtonRestore.OnClicked(func(*ui.Button) {
+		name := getSelectedSnapshotName()
+		if name == "" {
+			return
+		}
+
+		log.Action("Restoring snapshot '%s'", name)
+		runSnapshotActionInGoroutine(func() error {
+			return snapshot.Restore(name, guiNotifier)
+		})
+	})
 
-			return zipError
+	snapshotButtonDelete.OnClicked(func(*ui.Button) {
+		name := getSelectedSnapshotName()
+		if name == "" {
+			return
 		}
-	}
+
+		log.Action("Deleting snapshot '%s'", name)
+		runSnapshotActionInGoroutine(func() error {
+			return snapshot.Delete(name, guiNotifier)
+		})
+	})
+
+	snapshotButtonClose.OnClicked(func(*ui.Button) {
+		log.Action("Closing Snapshots dialog")
+		snapshotWindow.Hide()
+		enableUI(mainUIStatus)
+	})
+
+	snapshotWindow.OnClosing(func(*ui.Window) bool {
+		log.Action("Closing Snapshots dialog")
+		snapshotWindow.Hide()
+		enableUI(mainUIStatus)
+
+		return false
+	})
+}
+
+// showDryRunPlansInGoroutine collects the VBoxManage commands of the server
+// operations and shows them in the dry-run window
+func showDryRunPlansInGoroutine() {
+	dryRunEntry.SetText(xlate.Get("Wait..."))
+
+	go func() {
+		var plansText strings.Builder
+
+		plans, err := getAllDryRunPlans()
+		if err == nil {
+			err = writeDryRunPlans(&plansText, plans...)
+		}
+		if err != nil {
+			log.Error("Could not get the VBoxManage commands: %v", err)
+			plansText.WriteString(xlate.Get("Could not get the VBoxManage commands: %v", err))
+		}
+
+		ui.QueueMain(func() {
+			dryRunEntry.SetText(plansText.String())
+		})
+	}()
+}
+
+func bindOnDryRun() {
+	buttonDryRun.OnClicked(func(*ui.Button) {
+		log.Action("Opening VBoxManage commands dialog")
+		dryRunWindow.Show()
+		showDryRunPlansInGoroutine()
+	})
+
+	dryRunButtonClose.OnClicked(func(*ui.Button) {
+		log.Action("Closing VBoxManage commands dialog")
+		dryRunWindow.Hide()
+	})
+
+	dryRunWindow.OnClosing(func(*ui.Window) bool {
+		log.Action("Closing VBoxManage commands dialog")
+		dryRunWindow.Hide()
+
+		return false
+	})
 }
 
 func bindOnMebShare() {
@@ -930,7 +1732,7 @@ func bindOnBackup(mainUIStatus chan string) {
 			log.Action(fmt.Sprintf("Starting backup to: %s", pathBackup))
 
 			backupWindow.Hide()
-			err := backup.MakeBackup(pathBackup)
+			err := backup.MakeBackup(pathBackup, guiNotifier)
 			if err != nil {
 				// Failure has been reported to the user by backup.MakeBackup()
 				log.Debug("Backup failed: %v", err)
@@ -997,7 +1799,7 @@ func bindOnDestroy(mainUIStatus chan string) {
 
 			destroyWindow.Hide()
 
-			err := destroy.Server()
+			err := destroy.Server(guiNotifier)
 			if err != nil {
 				log.Debug("Failed to remove exams: %v", err)
 				progress.SetMessage("")
@@ -1040,7 +1842,7 @@ func bindOnRemove(mainUIStatus chan string) {
 
 			removeWindow.Hide()
 
-			err := remove.Server()
+			err := remove.Server(guiNotifier)
 			if err != nil {
 				log.Debug("Failed to remove server: %v", err)
 				progress.SetMessage("")
@@ -1089,11 +1891,18 @@ func RunUI() error { // nolint:whitespace
 		createBackupElements(backupMedia)
 		createLogDeliveryElements()
 		createExamInstallElements()
+		createFileInstallElements()
 		createDestroyElements()
 		createRemoveElements()
+		createPowerOffElements()
+		createCrashElements()
+		createDoctorElements()
+		createSnapshotElements()
+		createDryRunElements()
 
 		mebroutines.SetMainWindow(window)
 		progress.SetProgressLabel(labelStatus)
+		guiNotifier = progress.NewGUINotifier()
 
 		// Initialise environment status variables before starting tickers
 		environmentStatus = constants.EnvironmentStatus{BoxInstalled: false, BoxRunning: false, NetAvailable: false}
@@ -1111,6 +1920,9 @@ func RunUI() error { // nolint:whitespace
 		// Start updating box status
 		box.StartEnvironmentStatusUpdate(&environmentStatus, constants.EnvironmentStatusUpdateDuration)
 
+		// Start updating server state and console address
+		startServerStateUpdate(constants.EnvironmentStatusUpdateDuration)
+
 		enableUI(mainUIStatus)
 
 		window.SetMargined(true)
@@ -1123,20 +1935,28 @@ func RunUI() error { // nolint:whitespace
 		translateUILabels()
 
 		bindLanguageSwitching()
+		bindServerSwitching()
 		bindAdvancedToggle()
 		bindAdvancedExtNicSwitching()
 		bindAdvancedNicSwitching()
+		bindAdvancedStartTypeSwitching()
 
 		bindUIDisableOnStart(mainUIStatus)
 
 		// Bind buttons
+		bindOnServerControl(mainUIStatus)
+		bindOnCrash(mainUIStatus)
 		bindOnInstallAbittiServer(mainUIStatus)
 		bindOnInstallExamServer(mainUIStatus)
+		bindOnInstallFromFile(mainUIStatus)
 		bindOnMakeBackup(mainUIStatus)
 		bindOnDeliverLogs(mainUIStatus)
 		bindOnDestroyServer(mainUIStatus)
 		bindOnRemoveServer(mainUIStatus)
 		bindOnMebShare()
+		bindOnDoctor()
+		bindOnDryRun()
+		bindOnSnapshots(mainUIStatus)
 
 		bindOnBackup(mainUIStatus)
 		bindOnLogDelivery(mainUIStatus)
@@ -1159,44 +1979,31 @@ func RunUI() error { // nolint:whitespace
 			RunSelfUpdate(thisNaksuVersion)
 		}()
 
-		// Make sure we have VBoxManage
-		if !vboxmanage.IsInstalled() {
-			mebroutines.ShowTranslatedErrorMessage("Could not execute VBoxManage. Are you sure you have installed Oracle VirtualBox?")
-			log.Debug("VBoxManage is missing, disabling UI")
+		// Make sure we have the hypervisor
+		if !box.IsHypervisorInstalled() {
+			mebroutines.ShowErrorMessage(box.GetTranslatedHypervisorMissingMessage())
+			log.Debug("Hypervisor %s is missing, disabling UI", box.GetHypervisorName())
 			disableUI(mainUIStatus)
 		}
 
-		// Check VBoxManage version
-		go func() {
-			translatedMessage, err := host.IsVirtualBoxVersionOK()
-			if err != nil {
-				log.Debug("Could not detect whether VirtualBox is too old or too new: %v. User was not notified.", err)
-			} else if translatedMessage != "" {
-				mebroutines.ShowTranslatedWarningMessage(translatedMessage)
-			}
-		}()
-
-		// Make sure Hyper-V is not running
+		// Show all host problems (Hyper-V, hardware virtualisation, VirtualBox
+		// version, memory etc.) in a single doctor window instead of separate popups.
+		// Other warnings are shown only when the user opens the doctor window.
 		// Do this in Goroutine to avoid "cannot change thread mode" in Windows WMI call
-		isHyperV := make(chan bool)
 		go func() {
-			// IsHyperV() uses Windows WMI call
-			isHyperV <- host.IsHyperV()
-		}()
+			checks := doctor.Run()
 
-		if <-isHyperV {
-			mebroutines.ShowTranslatedWarningMessage("Please turn Windows Hypervisor off as it may cause problems. Before doing so the server cannot be used as the server for matriculation examination. We recommend using YTL Linux as the operating system for the server machine.")
-		} else {
-			// Does CPU support hardware virtualisation?
-			if !host.IsHWVirtualisationCPU() {
-				mebroutines.ShowTranslatedWarningMessage("It appears your CPU does not support hardware virtualisation (VT-x or AMD-V).")
-			}
+			for _, check := range checks {
+				if check.IsShownAtStartup() {
+					ui.QueueMain(func() {
+						showDoctorChecks(checks)
+						doctorWindow.Show()
+					})
 
-			// Make sure the hardware virtualisation is present
-			if !host.IsHWVirtualisation() {
-				mebroutines.ShowTranslatedWarningMessage("Hardware virtualisation (VT-x or AMD-V) is disabled. Please enable it before continuing.")
+					return
+				}
 			}
-		}
+		}()
 
 		boxInstalled, err := box.Installed()
 
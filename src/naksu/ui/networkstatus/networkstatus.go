@@ -4,13 +4,13 @@ import (
 	"github.com/andlabs/ui"
 
 	"naksu/config"
+	"naksu/constants"
 	"naksu/log"
 	"naksu/network"
 	naksuUi "naksu/ui"
 	"naksu/xlate"
 )
 
-const requiredLinkSpeed = 1000 // Required network device speed in Mbit/s
 var lastSelectedExtInterfaceName string
 var lastDetectedLinkSpeedMbit = ^uint64(0)
 
@@ -88,7 +88,7 @@ func Update() {
 	switch {
 	case linkSpeedMbit == 0:
 		showNetworkStatus(xlate.Get("No network connection"), true)
-	case linkSpeedMbit < requiredLinkSpeed:
+	case linkSpeedMbit < constants.RequiredLinkSpeed:
 		statusText := xlate.Get("Network speed is too low (%d Mbit/s)", linkSpeedMbit)
 		showNetworkStatus(statusText, true)
 	default:
@@ -0,0 +1,80 @@
+package progress
+
+import (
+	"sync"
+
+	"naksu/mebroutines"
+)
+
+// DialogReporter implements reporter.Reporter with the progress dialog of the GUI
+type DialogReporter struct {
+	mutex  sync.Mutex
+	dialog *Dialog
+}
+
+// NewDialogReporter returns a new DialogReporter. Make sure the main window
+// has been set before using it.
+func NewDialogReporter() *DialogReporter {
+	return &DialogReporter{mutex: sync.Mutex{}, dialog: nil}
+}
+
+// Progress updates the progress dialog. The dialog is opened if it is not
+// already shown. An empty message keeps the previous one.
+func (dialogReporter *DialogReporter) Progress(message string, value int) {
+	dialogReporter.mutex.Lock()
+	defer dialogReporter.mutex.Unlock()
+
+	if dialogReporter.dialog == nil {
+		dialog := ShowProgressDialog(message)
+		dialogReporter.dialog = &dialog
+	}
+
+	if message == "" {
+		UpdateProgressDialog(*dialogReporter.dialog, value, nil)
+	} else {
+		UpdateProgressDialog(*dialogReporter.dialog, value, &message)
+	}
+}
+
+// ProgressDone closes the progress dialog
+func (dialogReporter *DialogReporter) ProgressDone() {
+	dialogReporter.mutex.Lock()
+	defer dialogReporter.mutex.Unlock()
+
+	if dialogReporter.dialog != nil {
+		CloseProgressDialog(*dialogReporter.dialog)
+		dialogReporter.dialog = nil
+	}
+}
+
+// GUINotifier implements notifier.Notifier with the message boxes, the
+// progress label and the progress dialog of the GUI
+type GUINotifier struct {
+	*DialogReporter
+}
+
+// NewGUINotifier returns a new GUINotifier. Make sure the main window and the
+// progress label have been set before using it.
+func NewGUINotifier() *GUINotifier {
+	return &GUINotifier{DialogReporter: NewDialogReporter()}
+}
+
+// Error shows an error message box
+func (notifier *GUINotifier) Error(message string) {
+	mebroutines.ShowErrorMessage(message)
+}
+
+// Warning shows a warning message box
+func (notifier *GUINotifier) Warning(message string) {
+	mebroutines.ShowWarningMessage(message)
+}
+
+// Info shows an info message box
+func (notifier *GUINotifier) Info(message string) {
+	mebroutines.ShowInfoMessage(message)
+}
+
+// Message sets the progress label text
+func (notifier *GUINotifier) Message(message string) {
+	SetMessage(message)
+}